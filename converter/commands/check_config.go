@@ -2,11 +2,16 @@ package commands
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/activecm/ipfix-rita/converter/config"
 	"github.com/activecm/ipfix-rita/converter/config/yaml"
+	"github.com/activecm/ipfix-rita/converter/database"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
 	"github.com/activecm/ipfix-rita/converter/input/logstash/mongodb"
+	"github.com/activecm/ipfix-rita/converter/input/native"
 	"github.com/activecm/ipfix-rita/converter/output/rita"
+	"github.com/activecm/ipfix-rita/converter/stitching"
 	"github.com/urfave/cli"
 )
 
@@ -35,23 +40,153 @@ func init() {
 			}
 			fmt.Printf("Loaded Configuration:\n%s\n", confStr)
 
-			db, err := mongodb.NewLogstashMongoInputDB(conf.GetInputConfig().GetLogstashMongoDBConfig())
-			if err != nil {
-				return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+			collectorConf := conf.GetInputConfig().GetCollectorConfig()
+			ipfixFilesConf := conf.GetInputConfig().GetIPFIXFilesConfig()
+			nfcapdFilesConf := conf.GetInputConfig().GetNfcapdFilesConfig()
+			pcapFilesConf := conf.GetInputConfig().GetPCAPFilesConfig()
+			if ipfixFilesConf.IsEnabled() {
+				files, err := native.ExpandPaths(ipfixFilesConf.GetPaths())
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				fmt.Printf("IPFIX File Input Enabled. Found %d IPFIX Files Ready For Processing\n", len(files))
+			} else if nfcapdFilesConf.IsEnabled() {
+				files, err := native.ExpandPaths(nfcapdFilesConf.GetPaths())
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				fmt.Printf("Nfcapd File Input Enabled. Found %d Nfcapd Files Ready For Processing\n", len(files))
+			} else if pcapFilesConf.IsEnabled() {
+				files, err := native.ExpandPaths(pcapFilesConf.GetPaths())
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				fmt.Printf("PCAP File Input Enabled. Found %d Capture Files Ready For Processing\n", len(files))
+			} else if collectorConf.IsEnabled() {
+				//the native collector doesn't use the input database
+				fmt.Printf("Native Collector Enabled. Listening on UDP address: %s\n", collectorConf.GetUDPAddress())
+				if collectorConf.GetTCPAddress() != "" {
+					fmt.Printf("Listening on TCP address: %s\n", collectorConf.GetTCPAddress())
+				}
+				if collectorConf.GetSFlowConfig().GetUDPAddress() != "" {
+					fmt.Printf("Listening on sFlow address: %s\n", collectorConf.GetSFlowConfig().GetUDPAddress())
+				}
+				if collectorConf.GetTLS().IsEnabled() {
+					_, err = native.NewServerTLSConfig(collectorConf.GetTLS())
+					if err != nil {
+						return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+					}
+					fmt.Printf("TLS Certificates Loaded Successfully\n")
+				}
+			} else {
+				db, err := mongodb.NewLogstashMongoInputDB(conf.GetInputConfig().GetLogstashMongoDBConfig())
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				err = db.Ping()
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				fmt.Printf("Input Database Connection Successful\n")
+
+				coll := db.NewInputConnection()
+				count, err := coll.Count()
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				fmt.Printf("Found %d Flow Records Ready For Processing\n", count)
+				coll.Database.Session.Close()
+
+				quarantineConf := conf.GetInputConfig().GetLogstashMongoDBConfig().GetQuarantineConfig()
+				if quarantineConf.GetCollection() != "" && quarantineConf.GetFile() != "" {
+					return cli.NewExitError("only one of the quarantine collection and the quarantine file may be set", 1)
+				}
+				if quarantineConf.GetCollection() != "" {
+					coll = db.NewHelperCollection(quarantineConf.GetCollection())
+					count, err = coll.Count()
+					if err != nil {
+						return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+					}
+					fmt.Printf("Found %d Quarantined Records\n", count)
+					coll.Database.Session.Close()
+				} else if quarantineConf.GetFile() != "" {
+					fmt.Printf("Quarantining Records In: %s\n", quarantineConf.GetFile())
+				}
+
+				mappingConf := conf.GetInputConfig().GetLogstashMongoDBConfig().GetFieldMappingConfig()
+				_, err = data.NewFieldMapping(mappingConf)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				if len(mappingConf.GetDefault()) != 0 || len(mappingConf.GetExporters()) != 0 {
+					fmt.Printf("Field Mapping Loaded. Found %d Exporter Overrides\n", len(mappingConf.GetExporters()))
+				}
+
+				nselConf := conf.GetInputConfig().GetLogstashMongoDBConfig().GetNSELConfig()
+				if nselConf.ShouldPairEvents() {
+					fmt.Printf("Pairing NSEL Flow Creation and Teardown Events\n")
+				}
+				if nselConf.ShouldDropDenied() {
+					fmt.Printf("Dropping NSEL Flow Denied Events\n")
+				}
+
+				//check the rest of the deserializer settings
+				_, err = data.NewConfiguredFlowDeserializer(conf.GetInputConfig().GetLogstashMongoDBConfig())
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				samplingConf := conf.GetInputConfig().GetLogstashMongoDBConfig().GetSamplingConfig()
+				if len(samplingConf.GetExporters()) != 0 {
+					fmt.Printf("Found %d Configured Sampling Rates\n", len(samplingConf.GetExporters()))
+				}
 			}
-			err = db.Ping()
-			if err != nil {
-				return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+
+			clockSkewConf := conf.GetInputConfig().GetClockSkewConfig()
+			if clockSkewConf.IsEnabled() {
+				if clockSkewConf.GetWarnThreshold() <= 0 {
+					return cli.NewExitError("the clock skew warning threshold must be positive", 1)
+				}
+				fmt.Printf("Warning About Exporter Clock Skew Over: %s\n", clockSkewConf.GetWarnThreshold())
+				if clockSkewConf.ShouldCorrect() {
+					fmt.Printf("Correcting Exporter Clock Skew Over: %s\n", clockSkewConf.GetCorrectionThreshold())
+				}
 			}
-			fmt.Printf("Input Database Connection Successful\n")
 
-			coll := db.NewInputConnection()
-			count, err := coll.Count()
+			matcherConf := conf.GetStitchingConfig().GetMatcherConfig()
+			_, err = stitching.NewMatcherFactory(matcherConf)
 			if err != nil {
 				return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
 			}
-			fmt.Printf("Found %d Flow Records Ready For Processing\n", count)
-			coll.Database.Session.Close()
+			if matcherConf.GetMaxSize() <= 0 {
+				return cli.NewExitError("the matcher size must be positive", 1)
+			}
+			fmt.Printf("Using %s Matcher Holding Up To %d Sessions\n", matcherConf.GetType(), matcherConf.GetMaxSize())
+			if strings.EqualFold(matcherConf.GetType(), "Bolt") {
+				fmt.Printf("Matcher Path: %s\n", matcherConf.GetPath())
+			} else if strings.EqualFold(matcherConf.GetType(), "MongoDB") {
+				ssn, err := database.Dial(matcherConf.GetMongoDBConfig().GetConnectionConfig())
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				err = ssn.Ping()
+				ssn.Close()
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%+v\n", err), 1)
+				}
+				fmt.Printf("Matcher Database Connection Successful\n")
+			} else {
+				snapshotConf := matcherConf.GetSnapshotConfig()
+				if snapshotConf.GetPath() != "" {
+					fmt.Printf("Matcher Snapshot Path: %s (Restored For %s)\n", snapshotConf.GetPath(), snapshotConf.GetMaxAge())
+				}
+				fmt.Printf("Matcher Eviction Policy: %s\n", matcherConf.GetEvictionPolicy())
+				idleConf := matcherConf.GetIdleTimeoutConfig()
+				if idleConf.GetTCPTimeout() < 0 || idleConf.GetUDPTimeout() < 0 || idleConf.GetOtherTimeout() < 0 {
+					return cli.NewExitError("the matcher idle timeouts may not be negative", 1)
+				}
+				fmt.Printf("Matcher Idle Timeouts: TCP %s, UDP %s, Other %s\n",
+					idleConf.GetTCPTimeout(), idleConf.GetUDPTimeout(), idleConf.GetOtherTimeout())
+			}
 
 			outDB, err := rita.NewOutputDB(conf.GetOutputConfig().GetRITAConfig())
 			if err != nil {
@@ -2,16 +2,28 @@ package commands
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/activecm/ipfix-rita/converter/environment"
 	"github.com/activecm/ipfix-rita/converter/filter"
-	input "github.com/activecm/ipfix-rita/converter/input/logstash/mongodb"
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/mongodb"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/quarantine"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/input/native/ipfix"
+	"github.com/activecm/ipfix-rita/converter/input/native/netflow5"
+	"github.com/activecm/ipfix-rita/converter/input/native/netflow9"
+	"github.com/activecm/ipfix-rita/converter/input/native/nfcapd"
+	"github.com/activecm/ipfix-rita/converter/input/native/pcap"
+	"github.com/activecm/ipfix-rita/converter/input/native/sflow"
 	"github.com/activecm/ipfix-rita/converter/logging"
 	"github.com/activecm/ipfix-rita/converter/output"
 	batchRITAOutput "github.com/activecm/ipfix-rita/converter/output/rita/batch/dates"
@@ -58,32 +70,221 @@ func convert(env environment.Environment, noRotate bool) error {
 
 	//-------------------------------Input setup-------------------------------
 
-	//pollWait is how long to wait before checking if the input buffer has
-	//more data
-	pollWait := 30 * time.Second
+	//minPollWait is how long to wait before checking if the input buffer has
+	//more data while data is arriving. The wait doubles each time the
+	//buffer is found empty, up to maxPollWait. This keeps the latency
+	//low without polling MongoDB continuously while the input is idle.
+	minPollWait := 10 * time.Millisecond
+	maxPollWait := 1 * time.Second
 
 	//inputBufferSize is how much data is stored in RAM at a time
 	//for IDBulkBuffer this is also how much data is transferred in a single request
 	inputBufferSize := int64(10000)
 
-	//Readers read from Buffers
-	//reader will poll the MongoDB IDBulkBuffer which fetches records
-	//in order of the ID field (usually insertion order)
-	inputDB, err := input.NewLogstashMongoInputDB(
-		env.GetInputConfig().GetLogstashMongoDBConfig(),
-	)
-	if err != nil {
-		return err
+	//checkpointCollection holds the _id of the last input record which has
+	//been stitched and written out. Input records are only deleted once
+	//they are covered by the checkpoint, and reading resumes from the
+	//checkpoint on restart.
+	checkpointCollection := "checkpoints"
+
+	var reader input.Reader
+	//checkpointer and rejected are only used with the MongoDB input buffer
+	var checkpointer *mongodb.Checkpointer
+	var rejected quarantine.Quarantine
+	collectorConf := env.GetInputConfig().GetCollectorConfig()
+	ipfixFilesConf := env.GetInputConfig().GetIPFIXFilesConfig()
+	nfcapdFilesConf := env.GetInputConfig().GetNfcapdFilesConfig()
+	pcapFilesConf := env.GetInputConfig().GetPCAPFilesConfig()
+	enabledInputs := 0
+	for _, enabled := range []bool{
+		collectorConf.IsEnabled(),
+		ipfixFilesConf.IsEnabled(),
+		nfcapdFilesConf.IsEnabled(),
+		pcapFilesConf.IsEnabled(),
+	} {
+		if enabled {
+			enabledInputs++
+		}
+	}
+	if enabledInputs > 1 {
+		return errors.New("only one of the native collector and the file inputs may be enabled at a time")
 	}
-	reader := input.NewReader(
-		input.NewIDBulkBuffer(
+	if ipfixFilesConf.IsEnabled() {
+		//reader will decode IPFIX files archived by other tools.
+		//The input channels close once each file has been read,
+		//which causes the converter to finish and exit.
+		if len(ipfixFilesConf.GetPaths()) == 0 {
+			return errors.New("no IPFIX file paths were given")
+		}
+		//the templates are held in memory since the files
+		//are expected to carry their own templates
+		reader = native.NewIPFIXFileReader(
+			ipfixFilesConf.GetPaths(),
+			ipfixFilesConf.GetExporter(),
+			ipfix.NewDecoder(native.NewTemplateCache()),
+			env.Logger,
+		)
+	} else if nfcapdFilesConf.IsEnabled() {
+		//reader will decode nfcapd files. As with IPFIX files,
+		//the converter exits once each file has been read.
+		if len(nfcapdFilesConf.GetPaths()) == 0 {
+			return errors.New("no nfcapd file paths were given")
+		}
+		reader = nfcapd.NewReader(nfcapdFilesConf.GetPaths(), env.Logger)
+	} else if pcapFilesConf.IsEnabled() {
+		//reader will replay the IPFIX/ Netflow packets held in
+		//capture files. The converter exits once each file has been read.
+		if len(pcapFilesConf.GetPaths()) == 0 {
+			return errors.New("no pcap file paths were given")
+		}
+		templates := native.NewTemplateCache()
+		reader = pcap.NewReader(
+			pcapFilesConf.GetPaths(),
+			pcapFilesConf.GetPorts(),
+			native.NewVersionDecoder(map[uint16]native.Decoder{
+				5:  netflow5.NewDecoder(),
+				9:  netflow9.NewDecoder(templates),
+				10: ipfix.NewDecoder(templates),
+			}),
+			pcapFilesConf.ShouldAlignToToday(),
+			clock.New(), time.Local,
+			env.Logger,
+		)
+	} else if collectorConf.IsEnabled() {
+		//reader will receive IPFIX/ Netflow packets directly from the
+		//exporters and decode them without the help of Logstash and MongoDB
+
+		//templates are saved to disk so data records aren't lost
+		//between a restart and the exporters resending their templates
+		templates := native.NewTemplateCache()
+		if collectorConf.GetTemplateCachePath() != "" {
+			var err error
+			templates, err = native.LoadTemplateCache(collectorConf.GetTemplateCachePath())
+			if err != nil {
+				return err
+			}
+		}
+		decoder := native.NewVersionDecoder(map[uint16]native.Decoder{
+			5:  netflow5.NewDecoder(),
+			9:  netflow9.NewDecoder(templates),
+			10: ipfix.NewDecoder(templates),
+		})
+
+		var readers input.MultiReader
+		if collectorConf.GetUDPAddress() != "" {
+			readers = append(readers, native.NewUDPReader(
+				collectorConf.GetUDPAddress(),
+				decoder,
+				env.Logger,
+			))
+		}
+		if collectorConf.GetTCPAddress() != "" {
+			//IPFIX is the only protocol which may be sent over TCP.
+			//The templates sent over a connection are only valid for that
+			//connection, so they are kept apart from the UDP templates
+			//and aren't saved to disk.
+			var tlsConf *tls.Config
+			if collectorConf.GetTLS().IsEnabled() {
+				var err error
+				tlsConf, err = native.NewServerTLSConfig(collectorConf.GetTLS())
+				if err != nil {
+					return err
+				}
+			}
+			readers = append(readers, native.NewTCPReader(
+				collectorConf.GetTCPAddress(),
+				tlsConf,
+				func() native.Decoder {
+					return ipfix.NewDecoder(native.NewTemplateCache())
+				},
+				env.Logger,
+			))
+		}
+		sflowConf := collectorConf.GetSFlowConfig()
+		if sflowConf.GetUDPAddress() != "" {
+			//sFlow can't share the UDP listener since its version
+			//number can't be distinguished from the other protocols'
+			var sflowReader input.Reader = native.NewUDPReader(
+				sflowConf.GetUDPAddress(),
+				sflow.NewDecoder(clock.New()),
+				env.Logger,
+			)
+			if sflowConf.GetAggregationWindow() > 0 {
+				sflowReader = native.NewAggregatingReader(
+					sflowReader, sflowConf.GetAggregationWindow(), clock.New(),
+				)
+			}
+			readers = append(readers, sflowReader)
+		}
+		if len(readers) == 0 {
+			return errors.New("the native collector must listen on a UDP, TCP, or sFlow address")
+		}
+		reader = readers
+	} else {
+		//Readers read from Buffers
+		//reader will poll the MongoDB CheckpointBuffer which fetches records
+		//in order of the ID field (usually insertion order). The records
+		//are deleted once the output has written them.
+		inputDB, err := mongodb.NewLogstashMongoInputDB(
+			env.GetInputConfig().GetLogstashMongoDBConfig(),
+		)
+		if err != nil {
+			return err
+		}
+		//records which can't be decoded are set aside for reprocessing
+		quarantineConf := env.GetInputConfig().GetLogstashMongoDBConfig().GetQuarantineConfig()
+		if quarantineConf.GetCollection() != "" && quarantineConf.GetFile() != "" {
+			return errors.New("only one of the quarantine collection and the quarantine file may be set")
+		}
+		if quarantineConf.GetCollection() != "" {
+			rejected = quarantine.NewCollectionQuarantine(
+				inputDB.NewHelperCollection(quarantineConf.GetCollection()),
+			)
+		} else if quarantineConf.GetFile() != "" {
+			rejected, err = quarantine.NewFileQuarantine(quarantineConf.GetFile())
+			if err != nil {
+				return err
+			}
+		} else {
+			rejected = quarantine.NewNullQuarantine()
+		}
+		//vendor specific fields may be mapped onto the flow attributes
+		//and NSEL events may be paired up
+		deserializer, err := data.NewConfiguredFlowDeserializer(
+			env.GetInputConfig().GetLogstashMongoDBConfig(),
+		)
+		if err != nil {
+			return err
+		}
+		checkpointer, err = mongodb.NewCheckpointer(
 			inputDB.NewInputConnection(),
-			inputBufferSize,
+			inputDB.NewHelperCollection(checkpointCollection),
 			env.Logger,
-		),
-		pollWait,
-		env.Logger,
-	)
+		)
+		if err != nil {
+			return err
+		}
+		reader = mongodb.NewAdaptiveReader(
+			mongodb.NewCheckpointBuffer(
+				inputDB.NewInputConnection(),
+				checkpointer,
+				deserializer,
+				rejected,
+				inputBufferSize,
+				env.Logger,
+			),
+			minPollWait,
+			maxPollWait,
+			env.Logger,
+		)
+	}
+
+	//watch for exporters with skewed clocks. Only flows which
+	//record when they were received are checked.
+	clockSkewConf := env.GetInputConfig().GetClockSkewConfig()
+	if clockSkewConf.IsEnabled() {
+		reader = input.NewClockSkewReader(reader, clockSkewConf, env.Logger)
+	}
 
 	//-------------------------------Filter setup-------------------------------
 
@@ -142,7 +343,8 @@ func convert(env environment.Environment, noRotate bool) error {
 	//Increasing this value will likely increase the accuracy
 	//of the results. However, a larger matcher likely takes
 	//more resources (RAM/ CPU) to run at the same level of performance.
-	matcherSize := int64(5000)
+	matcherConf := env.GetStitchingConfig().GetMatcherConfig()
+	matcherSize := matcherConf.GetMaxSize()
 	//when the matcher must flush connection records out,
 	//the matcher will flush to matcherFlushToPercent * matcherSize
 	matcherFlushToPercent := 0.9
@@ -153,11 +355,36 @@ func convert(env environment.Environment, noRotate bool) error {
 	outputBufferSize := inputBufferSize
 	//if more data could come out of the matcher via flushing
 	//than the input buffer, use that to guide the output buffer size
-	//Divide by 2 is a rough estimate of how many flows may be flushed at once
-	if outputBufferSize < matcherSize/2 {
+	//Divide by 2 is a rough estimate of how many flows may be flushed at once.
+	//The disk backed matchers are meant to hold far more sessions than
+	//fit in RAM, so their flushes wait on the output rather than
+	//growing the buffer with the matcher.
+	if outputBufferSize < matcherSize/2 && strings.EqualFold(matcherConf.GetType(), "ram") {
 		outputBufferSize = matcherSize / 2
 	}
 
+	//newMatcher creates the matcher from the configuration.
+	//The RAM matcher is flushed on shutdown while the Bolt and MongoDB
+	//matchers keep unmatched sessions across restarts.
+	newMatcher, err := stitching.NewMatcherFactory(matcherConf)
+	if err != nil {
+		return err
+	}
+
+	//the RAM matcher is saved to a snapshot when the converter is
+	//asked to stop and restored when it starts again. The file inputs
+	//are read from the beginning after a restart, so there is nothing
+	//to restore for them.
+	var matcherSnapshot stitching.SnapshotOptions
+	fileInput := ipfixFilesConf.IsEnabled() || nfcapdFilesConf.IsEnabled() || pcapFilesConf.IsEnabled()
+	if !fileInput {
+		matcherSnapshot = stitching.SnapshotOptions{
+			Path:   matcherConf.GetSnapshotConfig().GetPath(),
+			MaxAge: matcherConf.GetSnapshotConfig().GetMaxAge(),
+			Stop:   ctx.Done(),
+		}
+	}
+
 	//the stitchingManager reads input from the input channel
 	//and assigns the input flows to a pool stitcher workers.
 	//Additionally, it manages the Matcher which is responsible
@@ -170,6 +397,8 @@ func convert(env environment.Environment, noRotate bool) error {
 		outputBufferSize,
 		matcherSize,
 		matcherFlushToPercent,
+		newMatcher,
+		matcherSnapshot,
 		flowFilter,
 		env.Logger,
 	)
@@ -255,6 +484,21 @@ func convert(env environment.Environment, noRotate bool) error {
 			break
 		}
 	}
+
+	//the output has finished writing, so the checkpoint
+	//covers everything which has been written out
+	if checkpointer != nil {
+		err := checkpointer.Close()
+		if err != nil {
+			env.Error(err, logging.Fields{"component": "input"})
+		}
+	}
+	if rejected != nil {
+		err := rejected.Close()
+		if err != nil {
+			env.Error(err, logging.Fields{"component": "input"})
+		}
+	}
 	env.Info("main thread exiting", nil)
 	return nil
 }
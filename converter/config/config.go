@@ -2,6 +2,7 @@ package config
 
 import (
 	"net"
+	"time"
 
 	"github.com/activecm/mgosec"
 )
@@ -28,6 +29,7 @@ type Config interface {
 	GetInputConfig() Input
 	GetFilteringConfig() Filtering
 	GetOutputConfig() Output
+	GetStitchingConfig() Stitching
 }
 
 //Serializable represents application configuration data
@@ -55,6 +57,11 @@ type TLS interface {
 //Input contains configuration for ingesting IPFIX/ Netflow data
 type Input interface {
 	GetLogstashMongoDBConfig() LogstashMongoDB
+	GetCollectorConfig() Collector
+	GetIPFIXFilesConfig() IPFIXFiles
+	GetNfcapdFilesConfig() NfcapdFiles
+	GetPCAPFilesConfig() PCAPFiles
+	GetClockSkewConfig() ClockSkew
 }
 
 //LogstashMongoDB contains configuration for ingesting Logstash
@@ -63,6 +70,126 @@ type LogstashMongoDB interface {
 	GetConnectionConfig() MongoDBConnection
 	GetDatabase() string
 	GetCollection() string
+	GetQuarantineConfig() Quarantine
+	GetFieldMappingConfig() FieldMapping
+	GetNSELConfig() NSEL
+	GetSamplingConfig() Sampling
+}
+
+//Quarantine contains configuration for setting aside the Logstash
+//records which could not be decoded. The records are written to
+//Collection in the input database, or appended to File as BSON.
+//The records are only logged if both are empty.
+type Quarantine interface {
+	GetCollection() string
+	GetFile() string
+}
+
+//FieldMapping declares which fields of the Logstash decoded IPFIX
+//records feed each flow attribute. The attributes are named after the
+//IPFIX information elements which are read by default. Each attribute
+//is filled from the first of its sources present in a record.
+//The sources configured for an exporter replace the default sources
+//for the same attribute.
+type FieldMapping interface {
+	GetDefault() map[string][]FieldSource
+	GetExporters() map[string]map[string][]FieldSource
+}
+
+//FieldSource names a field in a Logstash decoded record. Timestamps
+//may be read as Unix times given in Unit (seconds, milliseconds,
+//microseconds, or nanoseconds). Counters are multiplied by Scale.
+type FieldSource interface {
+	GetField() string
+	GetUnit() string
+	GetScale() int64
+}
+
+//NSEL contains configuration for reading Cisco ASA NSEL event records.
+//If PairEvents is set, flow creation and update events are held
+//until the connection is torn down. Otherwise, only the teardown
+//events are read. Denied connection attempts are written out marked
+//as rejected unless DropDenied is set.
+type NSEL interface {
+	ShouldPairEvents() bool
+	ShouldDropDenied() bool
+}
+
+//Sampling contains configuration for compensating for packet sampling.
+//Exporters maps exporting hosts to the 1-in-N sampling rate used
+//when the exporter does not announce its rate.
+type Sampling interface {
+	GetExporters() map[string]int64
+}
+
+//ClockSkew contains configuration for detecting exporters with
+//skewed clocks. The skew of each exporter is estimated by comparing
+//its flow end times with the times its records were received. A warning
+//is logged when an estimate passes WarnThreshold. If Correct is set,
+//the flows from exporters whose estimates pass CorrectionThreshold
+//are shifted by the estimated skew.
+type ClockSkew interface {
+	IsEnabled() bool
+	GetWarnThreshold() time.Duration
+	ShouldCorrect() bool
+	GetCorrectionThreshold() time.Duration
+}
+
+//Collector contains configuration for receiving IPFIX/ Netflow
+//records directly from the exporters, bypassing Logstash and MongoDB
+type Collector interface {
+	IsEnabled() bool
+	GetUDPAddress() string
+	GetTCPAddress() string
+	GetTLS() CollectorTLS
+	GetTemplateCachePath() string
+	GetSFlowConfig() SFlow
+}
+
+//SFlow contains configuration for receiving sFlow v5 datagrams.
+//Since each sFlow sample describes a single packet, the samples
+//may be aggregated into flows over a short window.
+type SFlow interface {
+	GetUDPAddress() string
+	GetAggregationWindow() time.Duration
+}
+
+//IPFIXFiles contains configuration for converting IPFIX files
+//(RFC 5655) which were archived by other tools. Each path may name
+//a file, a directory, or a glob pattern. The flows are attributed
+//to Exporter, or to a fixed default name if it is empty.
+type IPFIXFiles interface {
+	IsEnabled() bool
+	GetPaths() []string
+	GetExporter() string
+}
+
+//NfcapdFiles contains configuration for converting the binary
+//files written by nfcapd. Each path may name a file, a directory,
+//or a glob pattern.
+type NfcapdFiles interface {
+	IsEnabled() bool
+	GetPaths() []string
+}
+
+//PCAPFiles contains configuration for replaying IPFIX/ Netflow
+//packets held in pcap and pcapng files. The UDP datagrams sent
+//to one of the given ports are decoded. If AlignToToday is set,
+//the flows are shifted such that the capture starts at midnight today.
+type PCAPFiles interface {
+	IsEnabled() bool
+	GetPaths() []string
+	GetPorts() []uint16
+	ShouldAlignToToday() bool
+}
+
+//CollectorTLS contains configuration for accepting IPFIX
+//over TLS. If VerifyCertificate is set, the exporters must present
+//a client certificate signed by the certificate authority in CAFile.
+type CollectorTLS interface {
+	TLS
+	GetCertFile() string
+	GetKeyFile() string
 }
 
 //Output contains configuration for writing out the
@@ -86,3 +213,62 @@ type Filtering interface {
 	GetNeverIncludeSubnets() ([]net.IPNet, []error)
 	GetInternalSubnets() ([]net.IPNet, []error)
 }
+
+//Stitching contains configuration for stitching flows
+//together into sessions
+type Stitching interface {
+	GetMatcherConfig() Matcher
+}
+
+//Matcher contains configuration for the store holding the
+//session aggregates waiting to be stitched with their other halves
+type Matcher interface {
+	//GetType returns the kind of store to use: RAM, Bolt, or MongoDB
+	GetType() string
+	//GetMaxSize returns how many session aggregates may be held
+	//before the oldest and smallest are written out
+	GetMaxSize() int64
+	//GetEvictionPolicy returns how the RAM store chooses which
+	//sessions to write out once it is full: Default, Oldest,
+	//LRU, Smallest, or Scans
+	GetEvictionPolicy() string
+	//GetPath returns the file holding the Bolt store
+	GetPath() string
+	//GetMongoDBConfig returns the database holding the MongoDB store
+	GetMongoDBConfig() MatcherMongoDB
+	//GetSnapshotConfig returns where the RAM store is saved
+	//when the converter is restarted
+	GetSnapshotConfig() MatcherSnapshot
+	//GetIdleTimeoutConfig returns how long sessions may wait in
+	//the RAM store without being matched
+	GetIdleTimeoutConfig() MatcherIdleTimeout
+}
+
+//MatcherIdleTimeout contains configuration for evicting sessions
+//from the RAM store once they have gone idle. Idleness is measured
+//against the end time of the newest flow seen from the same exporter.
+//A timeout of 0 disables idle eviction for the protocol. The Other
+//timeout applies to every protocol besides TCP and UDP.
+type MatcherIdleTimeout interface {
+	GetTCPTimeout() time.Duration
+	GetUDPTimeout() time.Duration
+	GetOtherTimeout() time.Duration
+}
+
+//MatcherSnapshot contains configuration for carrying the RAM store
+//across graceful restarts of the converter
+type MatcherSnapshot interface {
+	//GetPath returns the file the snapshot is saved to.
+	//Snapshots are disabled if the path is empty.
+	GetPath() string
+	//GetMaxAge returns how old a snapshot may be and still be restored
+	GetMaxAge() time.Duration
+}
+
+//MatcherMongoDB contains configuration for holding the session
+//aggregates in a MongoDB database which may be shared by
+//several converters
+type MatcherMongoDB interface {
+	GetConnectionConfig() MongoDBConnection
+	GetDatabase() string
+}
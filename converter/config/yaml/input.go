@@ -1,21 +1,78 @@
 package yaml
 
-import "github.com/activecm/ipfix-rita/converter/config"
+import (
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/config"
+)
 
 //input implements config.Input
 type input struct {
 	LogstashMongoDB logstashMongoDB `yaml:"Logstash-MongoDB"`
+	Collector       collector       `yaml:"Collector"`
+	IPFIXFiles      ipfixFiles      `yaml:"IPFIX-Files"`
+	NfcapdFiles     nfcapdFiles     `yaml:"Nfcapd-Files"`
+	PCAPFiles       pcapFiles       `yaml:"PCAP-Files"`
+	ClockSkew       clockSkew       `yaml:"Clock-Skew"`
 }
 
 func (i *input) GetLogstashMongoDBConfig() config.LogstashMongoDB {
 	return &i.LogstashMongoDB
 }
 
+func (i *input) GetCollectorConfig() config.Collector {
+	return &i.Collector
+}
+
+func (i *input) GetIPFIXFilesConfig() config.IPFIXFiles {
+	return &i.IPFIXFiles
+}
+
+func (i *input) GetNfcapdFilesConfig() config.NfcapdFiles {
+	return &i.NfcapdFiles
+}
+
+func (i *input) GetPCAPFilesConfig() config.PCAPFiles {
+	return &i.PCAPFiles
+}
+
+func (i *input) GetClockSkewConfig() config.ClockSkew {
+	return &i.ClockSkew
+}
+
+//clockSkew implements config.ClockSkew
+type clockSkew struct {
+	Enabled                    bool `yaml:"Enable"`
+	WarnThresholdSeconds       int  `yaml:"WarnThresholdSeconds"`
+	Correct                    bool `yaml:"Correct"`
+	CorrectionThresholdSeconds int  `yaml:"CorrectionThresholdSeconds"`
+}
+
+func (c *clockSkew) IsEnabled() bool {
+	return c.Enabled
+}
+
+func (c *clockSkew) GetWarnThreshold() time.Duration {
+	return time.Duration(c.WarnThresholdSeconds) * time.Second
+}
+
+func (c *clockSkew) ShouldCorrect() bool {
+	return c.Correct
+}
+
+func (c *clockSkew) GetCorrectionThreshold() time.Duration {
+	return time.Duration(c.CorrectionThresholdSeconds) * time.Second
+}
+
 //logstashMongoDB implements config.LogstashMongoDB
 type logstashMongoDB struct {
-	MongoDB    mongoDBConnection `yaml:"MongoDB-Connection"`
-	Database   string            `yaml:"Database"`
-	Collection string            `yaml:"Collection"`
+	MongoDB      mongoDBConnection `yaml:"MongoDB-Connection"`
+	Database     string            `yaml:"Database"`
+	Collection   string            `yaml:"Collection"`
+	Quarantine   quarantine        `yaml:"Quarantine"`
+	FieldMapping fieldMapping      `yaml:"Field-Mapping"`
+	NSEL         nsel              `yaml:"NSEL"`
+	Sampling     sampling          `yaml:"Sampling"`
 }
 
 func (l *logstashMongoDB) GetConnectionConfig() config.MongoDBConnection {
@@ -29,3 +86,224 @@ func (l *logstashMongoDB) GetDatabase() string {
 func (l *logstashMongoDB) GetCollection() string {
 	return l.Collection
 }
+
+func (l *logstashMongoDB) GetQuarantineConfig() config.Quarantine {
+	return &l.Quarantine
+}
+
+func (l *logstashMongoDB) GetFieldMappingConfig() config.FieldMapping {
+	return &l.FieldMapping
+}
+
+func (l *logstashMongoDB) GetNSELConfig() config.NSEL {
+	return &l.NSEL
+}
+
+func (l *logstashMongoDB) GetSamplingConfig() config.Sampling {
+	return &l.Sampling
+}
+
+//quarantine implements config.Quarantine
+type quarantine struct {
+	Collection string `yaml:"Collection"`
+	File       string `yaml:"File"`
+}
+
+func (q *quarantine) GetCollection() string {
+	return q.Collection
+}
+
+func (q *quarantine) GetFile() string {
+	return q.File
+}
+
+//fieldMapping implements config.FieldMapping
+type fieldMapping struct {
+	Default   map[string][]fieldSource            `yaml:"Default"`
+	Exporters map[string]map[string][]fieldSource `yaml:"Exporters"`
+}
+
+func (f *fieldMapping) GetDefault() map[string][]config.FieldSource {
+	return convertFieldSources(f.Default)
+}
+
+func (f *fieldMapping) GetExporters() map[string]map[string][]config.FieldSource {
+	exporters := make(map[string]map[string][]config.FieldSource, len(f.Exporters))
+	for exporter, sources := range f.Exporters {
+		exporters[exporter] = convertFieldSources(sources)
+	}
+	return exporters
+}
+
+//convertFieldSources exposes the parsed sources as config.FieldSources
+func convertFieldSources(sources map[string][]fieldSource) map[string][]config.FieldSource {
+	converted := make(map[string][]config.FieldSource, len(sources))
+	for attribute := range sources {
+		for i := range sources[attribute] {
+			converted[attribute] = append(converted[attribute], &sources[attribute][i])
+		}
+	}
+	return converted
+}
+
+//fieldSource implements config.FieldSource
+type fieldSource struct {
+	Field string `yaml:"Field"`
+	Unit  string `yaml:"Unit"`
+	Scale int64  `yaml:"Scale"`
+}
+
+func (f *fieldSource) GetField() string {
+	return f.Field
+}
+
+func (f *fieldSource) GetUnit() string {
+	return f.Unit
+}
+
+func (f *fieldSource) GetScale() int64 {
+	return f.Scale
+}
+
+//nsel implements config.NSEL
+type nsel struct {
+	PairEvents bool `yaml:"PairEvents"`
+	DropDenied bool `yaml:"DropDenied"`
+}
+
+func (n *nsel) ShouldPairEvents() bool {
+	return n.PairEvents
+}
+
+func (n *nsel) ShouldDropDenied() bool {
+	return n.DropDenied
+}
+
+//sampling implements config.Sampling
+type sampling struct {
+	Exporters map[string]int64 `yaml:"Exporters"`
+}
+
+func (s *sampling) GetExporters() map[string]int64 {
+	return s.Exporters
+}
+
+//collector implements config.Collector
+type collector struct {
+	Enabled           bool         `yaml:"Enable"`
+	UDPAddress        string       `yaml:"UDPAddress"`
+	TCPAddress        string       `yaml:"TCPAddress"`
+	TLS               collectorTLS `yaml:"TLS"`
+	TemplateCachePath string       `yaml:"TemplateCachePath"`
+	SFlow             sFlow        `yaml:"SFlow"`
+}
+
+func (c *collector) IsEnabled() bool {
+	return c.Enabled
+}
+
+func (c *collector) GetUDPAddress() string {
+	return c.UDPAddress
+}
+
+func (c *collector) GetTCPAddress() string {
+	return c.TCPAddress
+}
+
+func (c *collector) GetTLS() config.CollectorTLS {
+	return &c.TLS
+}
+
+func (c *collector) GetTemplateCachePath() string {
+	return c.TemplateCachePath
+}
+
+func (c *collector) GetSFlowConfig() config.SFlow {
+	return &c.SFlow
+}
+
+//collectorTLS implements config.CollectorTLS
+type collectorTLS struct {
+	tls      `yaml:",inline"`
+	CertFile string `yaml:"CertFile"`
+	KeyFile  string `yaml:"KeyFile"`
+}
+
+func (c *collectorTLS) GetCertFile() string {
+	return c.CertFile
+}
+
+func (c *collectorTLS) GetKeyFile() string {
+	return c.KeyFile
+}
+
+//sFlow implements config.SFlow
+type sFlow struct {
+	UDPAddress         string `yaml:"UDPAddress"`
+	AggregationSeconds int    `yaml:"AggregationSeconds"`
+}
+
+func (s *sFlow) GetUDPAddress() string {
+	return s.UDPAddress
+}
+
+func (s *sFlow) GetAggregationWindow() time.Duration {
+	return time.Duration(s.AggregationSeconds) * time.Second
+}
+
+//ipfixFiles implements config.IPFIXFiles
+type ipfixFiles struct {
+	Enabled  bool     `yaml:"Enable"`
+	Paths    []string `yaml:"Paths"`
+	Exporter string   `yaml:"Exporter"`
+}
+
+func (i *ipfixFiles) IsEnabled() bool {
+	return i.Enabled
+}
+
+func (i *ipfixFiles) GetPaths() []string {
+	return i.Paths
+}
+
+func (i *ipfixFiles) GetExporter() string {
+	return i.Exporter
+}
+
+//nfcapdFiles implements config.NfcapdFiles
+type nfcapdFiles struct {
+	Enabled bool     `yaml:"Enable"`
+	Paths   []string `yaml:"Paths"`
+}
+
+func (n *nfcapdFiles) IsEnabled() bool {
+	return n.Enabled
+}
+
+func (n *nfcapdFiles) GetPaths() []string {
+	return n.Paths
+}
+
+//pcapFiles implements config.PCAPFiles
+type pcapFiles struct {
+	Enabled      bool     `yaml:"Enable"`
+	Paths        []string `yaml:"Paths"`
+	Ports        []uint16 `yaml:"Ports"`
+	AlignToToday bool     `yaml:"AlignToToday"`
+}
+
+func (p *pcapFiles) IsEnabled() bool {
+	return p.Enabled
+}
+
+func (p *pcapFiles) GetPaths() []string {
+	return p.Paths
+}
+
+func (p *pcapFiles) GetPorts() []uint16 {
+	return p.Ports
+}
+
+func (p *pcapFiles) ShouldAlignToToday() bool {
+	return p.AlignToToday
+}
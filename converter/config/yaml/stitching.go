@@ -0,0 +1,124 @@
+package yaml
+
+import (
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/config"
+)
+
+//stitching implements config.Stitching
+type stitching struct {
+	Matcher matcher `yaml:"Matcher"`
+}
+
+func (s *stitching) GetMatcherConfig() config.Matcher {
+	return &s.Matcher
+}
+
+//matcher implements config.Matcher
+type matcher struct {
+	Type           string             `yaml:"Type"`
+	MaxSize        int64              `yaml:"MaxSize"`
+	EvictionPolicy string             `yaml:"EvictionPolicy"`
+	Path           string             `yaml:"Path"`
+	MongoDB        matcherMongoDB     `yaml:"MongoDB"`
+	Snapshot       matcherSnapshot    `yaml:"Snapshot"`
+	IdleTimeout    matcherIdleTimeout `yaml:"IdleTimeout"`
+}
+
+//GetType returns the kind of store to use. Configuration files
+//written before the store could be chosen use the RAM store.
+func (m *matcher) GetType() string {
+	if m.Type == "" {
+		return "RAM"
+	}
+	return m.Type
+}
+
+//GetMaxSize returns how many session aggregates may be held.
+//Configuration files written before the size could be chosen
+//use the old fixed size.
+func (m *matcher) GetMaxSize() int64 {
+	if m.MaxSize == 0 {
+		return 5000
+	}
+	return m.MaxSize
+}
+
+//GetEvictionPolicy returns how sessions are chosen for eviction.
+//Configuration files written before the policy could be chosen
+//use the original policy.
+func (m *matcher) GetEvictionPolicy() string {
+	if m.EvictionPolicy == "" {
+		return "Default"
+	}
+	return m.EvictionPolicy
+}
+
+func (m *matcher) GetPath() string {
+	return m.Path
+}
+
+func (m *matcher) GetMongoDBConfig() config.MatcherMongoDB {
+	return &m.MongoDB
+}
+
+func (m *matcher) GetSnapshotConfig() config.MatcherSnapshot {
+	return &m.Snapshot
+}
+
+func (m *matcher) GetIdleTimeoutConfig() config.MatcherIdleTimeout {
+	return &m.IdleTimeout
+}
+
+//matcherMongoDB implements config.MatcherMongoDB
+type matcherMongoDB struct {
+	MongoDB  mongoDBConnection `yaml:"MongoDB-Connection"`
+	Database string            `yaml:"Database"`
+}
+
+func (m *matcherMongoDB) GetConnectionConfig() config.MongoDBConnection {
+	return &m.MongoDB
+}
+
+func (m *matcherMongoDB) GetDatabase() string {
+	return m.Database
+}
+
+//matcherSnapshot implements config.MatcherSnapshot
+type matcherSnapshot struct {
+	Path          string `yaml:"Path"`
+	MaxAgeSeconds int    `yaml:"MaxAgeSeconds"`
+}
+
+func (m *matcherSnapshot) GetPath() string {
+	return m.Path
+}
+
+//GetMaxAge returns how old a snapshot may be and still be restored.
+//Snapshots are restored for up to ten minutes if no age is given.
+func (m *matcherSnapshot) GetMaxAge() time.Duration {
+	if m.MaxAgeSeconds == 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(m.MaxAgeSeconds) * time.Second
+}
+
+//matcherIdleTimeout implements config.MatcherIdleTimeout
+type matcherIdleTimeout struct {
+	TCPSeconds   int `yaml:"TCPSeconds"`
+	UDPSeconds   int `yaml:"UDPSeconds"`
+	OtherSeconds int `yaml:"OtherSeconds"`
+}
+
+func (m *matcherIdleTimeout) GetTCPTimeout() time.Duration {
+	return time.Duration(m.TCPSeconds) * time.Second
+}
+
+func (m *matcherIdleTimeout) GetUDPTimeout() time.Duration {
+	return time.Duration(m.UDPSeconds) * time.Second
+}
+
+func (m *matcherIdleTimeout) GetOtherTimeout() time.Duration {
+	return time.Duration(m.OtherSeconds) * time.Second
+}
@@ -35,6 +35,7 @@ type yamlConfig struct {
 	Input     input     `yaml:"Input"`
 	Output    output    `yaml:"Output"`
 	Filtering filtering `yaml:"Filtering"`
+	Stitching stitching `yaml:"Stitching"`
 }
 
 func (y *yamlConfig) GetInputConfig() config.Input {
@@ -49,6 +50,10 @@ func (y *yamlConfig) GetFilteringConfig() config.Filtering {
 	return &y.Filtering
 }
 
+func (y *yamlConfig) GetStitchingConfig() config.Stitching {
+	return &y.Stitching
+}
+
 //NewYAMLConfig creates a new yamlConfig from
 //a yaml string
 func NewYAMLConfig(data []byte) (config.Config, error) {
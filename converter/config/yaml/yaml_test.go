@@ -2,6 +2,7 @@ package yaml
 
 import (
 	"testing"
+	"time"
 
 	"github.com/activecm/ipfix-rita/converter/config"
 	"github.com/activecm/mgosec"
@@ -24,6 +25,66 @@ func TestNewYAMLConfig(t *testing.T) {
     # The database and collection holding records produced by the collector
     Database: IPFIX
     Collection: in
+    Quarantine:
+      Collection: quarantine
+      File: /var/lib/ipfix-rita/converter/quarantine.bson
+    Field-Mapping:
+      Default:
+        octetTotalCount:
+          - Field: octetTotalCount
+          - Field: octetDeltaCount
+            Scale: 2
+      Exporters:
+        10.0.0.1:
+          flowStartMilliseconds:
+            - Field: flowStartSeconds
+              Unit: seconds
+    NSEL:
+      PairEvents: true
+      DropDenied: true
+    Sampling:
+      Exporters:
+        10.0.0.2: 100
+
+  Collector:
+    Enable: true
+    UDPAddress: 0.0.0.0:2055
+    TCPAddress: 0.0.0.0:4739
+    TLS:
+      Enable: true
+      VerifyCertificate: true
+      CAFile: /path/to/exporter/CAFile
+      CertFile: /path/to/CertFile
+      KeyFile: /path/to/KeyFile
+    TemplateCachePath: /var/lib/ipfix-rita/templates.json
+    SFlow:
+      UDPAddress: 0.0.0.0:6343
+      AggregationSeconds: 10
+
+  IPFIX-Files:
+    Enable: true
+    Paths:
+      - /var/archive/ipfix/2018-05-04
+      - /var/archive/ipfix/*.ipfix
+    Exporter: archive
+
+  Nfcapd-Files:
+    Enable: true
+    Paths:
+      - /var/cache/nfdump
+
+  PCAP-Files:
+    Enable: true
+    Paths:
+      - /tmp/support-ticket.pcapng
+    Ports: [2055, 4739]
+    AlignToToday: true
+
+  Clock-Skew:
+    Enable: true
+    WarnThresholdSeconds: 600
+    Correct: true
+    CorrectionThresholdSeconds: 3600
 
 Output:
   RITA-MongoDB:
@@ -43,6 +104,25 @@ Output:
     # This database holds information about RITA managed databases.
     MetaDB: MetaDatabase
 
+Stitching:
+  Matcher:
+    Type: Bolt
+    MaxSize: 1000000
+    EvictionPolicy: Scans
+    Path: /var/lib/ipfix-rita/converter/sessions.db
+    MongoDB:
+      MongoDB-Connection:
+        ConnectionString: mongodb://mongodb:27019
+        AuthenticationMechanism: null
+      Database: IPFIX-Sessions
+    Snapshot:
+      Path: /var/lib/ipfix-rita/converter/matcher-snapshot.bson
+      MaxAgeSeconds: 300
+    IdleTimeout:
+      TCPSeconds: 3600
+      UDPSeconds: 90
+      OtherSeconds: 30
+
 Filtering:
     # These are filters that affect which flows are processed and which
     # are dropped.
@@ -85,11 +165,43 @@ Filtering:
 	logstashConf := testConfig.GetInputConfig().GetLogstashMongoDBConfig()
 	testLogstashConfig(t, logstashConf)
 
+	collectorConf := testConfig.GetInputConfig().GetCollectorConfig()
+	testCollectorConfig(t, collectorConf)
+
+	ipfixFilesConf := testConfig.GetInputConfig().GetIPFIXFilesConfig()
+	testIPFIXFilesConfig(t, ipfixFilesConf)
+
+	nfcapdFilesConf := testConfig.GetInputConfig().GetNfcapdFilesConfig()
+	testNfcapdFilesConfig(t, nfcapdFilesConf)
+
+	pcapFilesConf := testConfig.GetInputConfig().GetPCAPFilesConfig()
+	testPCAPFilesConfig(t, pcapFilesConf)
+
+	clockSkewConf := testConfig.GetInputConfig().GetClockSkewConfig()
+	testClockSkewConfig(t, clockSkewConf)
+
 	ritaConf := testConfig.GetOutputConfig().GetRITAConfig()
 	testRITAConfig(t, ritaConf)
 
 	filteringConf := testConfig.GetFilteringConfig()
 	testFilteringConfig(t, filteringConf)
+
+	stitchingConf := testConfig.GetStitchingConfig()
+	testStitchingConfig(t, stitchingConf)
+}
+
+func TestStitchingDefaults(t *testing.T) {
+	testConfig, err := NewYAMLConfig([]byte("Input: {}"))
+	require.Nil(t, err)
+	matcherConf := testConfig.GetStitchingConfig().GetMatcherConfig()
+	require.Equal(t, "RAM", matcherConf.GetType())
+	require.Equal(t, int64(5000), matcherConf.GetMaxSize())
+	require.Equal(t, "Default", matcherConf.GetEvictionPolicy())
+	require.Equal(t, "", matcherConf.GetSnapshotConfig().GetPath())
+	require.Equal(t, 10*time.Minute, matcherConf.GetSnapshotConfig().GetMaxAge())
+	require.Equal(t, time.Duration(0), matcherConf.GetIdleTimeoutConfig().GetTCPTimeout())
+	require.Equal(t, time.Duration(0), matcherConf.GetIdleTimeoutConfig().GetUDPTimeout())
+	require.Equal(t, time.Duration(0), matcherConf.GetIdleTimeoutConfig().GetOtherTimeout())
 }
 
 func testLogstashConfig(t *testing.T, logstashConf config.LogstashMongoDB) {
@@ -103,6 +215,72 @@ func testLogstashConfig(t *testing.T, logstashConf config.LogstashMongoDB) {
 		require.Equal(t, "", logstashConf.GetConnectionConfig().GetTLS().GetCAFile())
 		require.Equal(t, "IPFIX", logstashConf.GetDatabase())
 		require.Equal(t, "in", logstashConf.GetCollection())
+		require.Equal(t, "quarantine", logstashConf.GetQuarantineConfig().GetCollection())
+		require.Equal(t, "/var/lib/ipfix-rita/converter/quarantine.bson", logstashConf.GetQuarantineConfig().GetFile())
+
+		mapping := logstashConf.GetFieldMappingConfig()
+		octetSources := mapping.GetDefault()["octetTotalCount"]
+		require.Len(t, octetSources, 2)
+		require.Equal(t, "octetTotalCount", octetSources[0].GetField())
+		require.Equal(t, int64(0), octetSources[0].GetScale())
+		require.Equal(t, "octetDeltaCount", octetSources[1].GetField())
+		require.Equal(t, int64(2), octetSources[1].GetScale())
+		startSources := mapping.GetExporters()["10.0.0.1"]["flowStartMilliseconds"]
+		require.Len(t, startSources, 1)
+		require.Equal(t, "flowStartSeconds", startSources[0].GetField())
+		require.Equal(t, "seconds", startSources[0].GetUnit())
+		require.True(t, logstashConf.GetNSELConfig().ShouldPairEvents())
+		require.True(t, logstashConf.GetNSELConfig().ShouldDropDenied())
+		require.Equal(t, int64(100), logstashConf.GetSamplingConfig().GetExporters()["10.0.0.2"])
+	})
+}
+
+func testCollectorConfig(t *testing.T, collectorConf config.Collector) {
+	t.Run("Collector Config", func(t *testing.T) {
+		require.True(t, collectorConf.IsEnabled())
+		require.Equal(t, "0.0.0.0:2055", collectorConf.GetUDPAddress())
+		require.Equal(t, "0.0.0.0:4739", collectorConf.GetTCPAddress())
+		require.True(t, collectorConf.GetTLS().IsEnabled())
+		require.True(t, collectorConf.GetTLS().ShouldVerifyCertificate())
+		require.Equal(t, "/path/to/exporter/CAFile", collectorConf.GetTLS().GetCAFile())
+		require.Equal(t, "/path/to/CertFile", collectorConf.GetTLS().GetCertFile())
+		require.Equal(t, "/path/to/KeyFile", collectorConf.GetTLS().GetKeyFile())
+		require.Equal(t, "/var/lib/ipfix-rita/templates.json", collectorConf.GetTemplateCachePath())
+		require.Equal(t, "0.0.0.0:6343", collectorConf.GetSFlowConfig().GetUDPAddress())
+		require.Equal(t, 10*time.Second, collectorConf.GetSFlowConfig().GetAggregationWindow())
+	})
+}
+
+func testIPFIXFilesConfig(t *testing.T, ipfixFilesConf config.IPFIXFiles) {
+	t.Run("IPFIX-Files Config", func(t *testing.T) {
+		require.True(t, ipfixFilesConf.IsEnabled())
+		require.Equal(t, []string{"/var/archive/ipfix/2018-05-04", "/var/archive/ipfix/*.ipfix"}, ipfixFilesConf.GetPaths())
+		require.Equal(t, "archive", ipfixFilesConf.GetExporter())
+	})
+}
+
+func testNfcapdFilesConfig(t *testing.T, nfcapdFilesConf config.NfcapdFiles) {
+	t.Run("Nfcapd-Files Config", func(t *testing.T) {
+		require.True(t, nfcapdFilesConf.IsEnabled())
+		require.Equal(t, []string{"/var/cache/nfdump"}, nfcapdFilesConf.GetPaths())
+	})
+}
+
+func testPCAPFilesConfig(t *testing.T, pcapFilesConf config.PCAPFiles) {
+	t.Run("PCAP-Files Config", func(t *testing.T) {
+		require.True(t, pcapFilesConf.IsEnabled())
+		require.Equal(t, []string{"/tmp/support-ticket.pcapng"}, pcapFilesConf.GetPaths())
+		require.Equal(t, []uint16{2055, 4739}, pcapFilesConf.GetPorts())
+		require.True(t, pcapFilesConf.ShouldAlignToToday())
+	})
+}
+
+func testClockSkewConfig(t *testing.T, clockSkewConf config.ClockSkew) {
+	t.Run("Clock-Skew Config", func(t *testing.T) {
+		require.True(t, clockSkewConf.IsEnabled())
+		require.Equal(t, 10*time.Minute, clockSkewConf.GetWarnThreshold())
+		require.True(t, clockSkewConf.ShouldCorrect())
+		require.Equal(t, time.Hour, clockSkewConf.GetCorrectionThreshold())
 	})
 }
 
@@ -144,3 +322,23 @@ func testFilteringConfig(t *testing.T, filteringConf config.Filtering) {
 		require.Len(t, errors3, 0)
 	})
 }
+
+func testStitchingConfig(t *testing.T, stitchingConf config.Stitching) {
+	t.Run("Stitching Config", func(t *testing.T) {
+		matcherConf := stitchingConf.GetMatcherConfig()
+		require.Equal(t, "Bolt", matcherConf.GetType())
+		require.Equal(t, int64(1000000), matcherConf.GetMaxSize())
+		require.Equal(t, "Scans", matcherConf.GetEvictionPolicy())
+		require.Equal(t, "/var/lib/ipfix-rita/converter/sessions.db", matcherConf.GetPath())
+		mongoConf := matcherConf.GetMongoDBConfig()
+		require.Equal(t, "mongodb://mongodb:27019", mongoConf.GetConnectionConfig().GetConnectionString())
+		require.Equal(t, "IPFIX-Sessions", mongoConf.GetDatabase())
+		snapshotConf := matcherConf.GetSnapshotConfig()
+		require.Equal(t, "/var/lib/ipfix-rita/converter/matcher-snapshot.bson", snapshotConf.GetPath())
+		require.Equal(t, 5*time.Minute, snapshotConf.GetMaxAge())
+		idleConf := matcherConf.GetIdleTimeoutConfig()
+		require.Equal(t, time.Hour, idleConf.GetTCPTimeout())
+		require.Equal(t, 90*time.Second, idleConf.GetUDPTimeout())
+		require.Equal(t, 30*time.Second, idleConf.GetOtherTimeout())
+	})
+}
@@ -0,0 +1,157 @@
+package input
+
+import (
+	"context"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/config"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/pkg/errors"
+)
+
+//skewSampleWindow is the number of flows each exporter's
+//rolling skew estimate is averaged over
+const skewSampleWindow = 1000
+
+//minSkewSamples is the number of flows which must be seen from an
+//exporter before its skew estimate is acted on
+const minSkewSamples = 100
+
+//clockSkew holds the rolling estimate of an exporter's clock skew
+type clockSkew struct {
+	//estimate is how many milliseconds the exporter's records
+	//arrive after the flows they describe end. The estimate includes
+	//the time the exporter held each flow before exporting it.
+	estimate float64
+	samples  int64
+	warned   bool
+}
+
+//update adds an observed offset to the rolling estimate. The estimate
+//is the mean of the offsets until skewSampleWindow offsets have been
+//seen. Afterwards, it is an exponentially weighted moving average.
+func (c *clockSkew) update(offsetMillis int64) {
+	if c.samples < skewSampleWindow {
+		c.samples++
+	}
+	c.estimate += (float64(offsetMillis) - c.estimate) / float64(c.samples)
+}
+
+//ClockSkewReader implements Reader by estimating the clock skew of the
+//exporters behind the flows produced by another Reader. Only flows which
+//implement ReceivedFlow are measured. A warning is logged when an
+//exporter's estimate passes the warning threshold. If correction is
+//enabled, flows which implement ShiftableFlow are shifted by the
+//estimate when the estimate passes the correction threshold.
+type ClockSkewReader struct {
+	reader              Reader
+	warnThreshold       int64
+	correct             bool
+	correctionThreshold int64
+	log                 logging.Logger
+}
+
+//NewClockSkewReader returns a new Reader which watches the clock skew
+//of the exporters behind the flows produced by the given Reader
+func NewClockSkewReader(reader Reader, conf config.ClockSkew, log logging.Logger) Reader {
+	return ClockSkewReader{
+		reader:              reader,
+		warnThreshold:       int64(conf.GetWarnThreshold() / time.Millisecond),
+		correct:             conf.ShouldCorrect(),
+		correctionThreshold: int64(conf.GetCorrectionThreshold() / time.Millisecond),
+		log:                 log,
+	}
+}
+
+//Drain asynchronously drains the underlying Reader, updating
+//the skew estimates as the flows pass through
+func (c ClockSkewReader) Drain(ctx context.Context) (<-chan Flow, <-chan error) {
+	out := make(chan Flow)
+	errs := make(chan error)
+
+	go func(out chan<- Flow, errs chan<- error) {
+		flows, readerErrs := c.reader.Drain(ctx)
+		skews := make(map[string]*clockSkew)
+
+		for flows != nil || readerErrs != nil {
+			select {
+			case flow, ok := <-flows:
+				if !ok {
+					flows = nil
+					continue
+				}
+				err := c.check(skews, flow)
+				if err != nil {
+					errs <- err
+				}
+				out <- flow
+			case err, ok := <-readerErrs:
+				if !ok {
+					readerErrs = nil
+					continue
+				}
+				errs <- err
+			}
+		}
+
+		close(errs)
+		close(out)
+	}(out, errs)
+
+	return out, errs
+}
+
+//check updates the skew estimate for the flow's exporter
+//and corrects the flow if needed
+func (c ClockSkewReader) check(skews map[string]*clockSkew, flow Flow) error {
+	receivedFlow, ok := flow.(ReceivedFlow)
+	if !ok || receivedFlow.ReceivedMilliseconds() == 0 {
+		return nil
+	}
+	flowEnd, err := flow.FlowEndMilliseconds()
+	if err != nil {
+		//the stitcher reports flows with bad timestamps
+		return nil
+	}
+
+	skew, ok := skews[flow.Exporter()]
+	if !ok {
+		skew = &clockSkew{}
+		skews[flow.Exporter()] = skew
+	}
+	skew.update(receivedFlow.ReceivedMilliseconds() - flowEnd)
+	if skew.samples < minSkewSamples {
+		return nil
+	}
+
+	estimate := int64(skew.estimate)
+	magnitude := estimate
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+
+	if magnitude > c.warnThreshold && !skew.warned {
+		skew.warned = true
+		c.log.Warn("exporter clock appears to be skewed", logging.Fields{
+			"exporter":   flow.Exporter(),
+			"skew":       (time.Duration(estimate) * time.Millisecond).String(),
+			"correcting": c.correct && magnitude > c.correctionThreshold,
+		})
+	} else if magnitude <= c.warnThreshold && skew.warned {
+		skew.warned = false
+		c.log.Info("exporter clock skew resolved", logging.Fields{
+			"exporter": flow.Exporter(),
+			"skew":     (time.Duration(estimate) * time.Millisecond).String(),
+		})
+	}
+
+	if !c.correct || magnitude <= c.correctionThreshold {
+		return nil
+	}
+	shiftableFlow, ok := flow.(ShiftableFlow)
+	if !ok {
+		return nil
+	}
+	err = shiftableFlow.ShiftTimestamps(estimate)
+	return errors.Wrapf(err, "could not correct the clock skew of a flow from %s", flow.Exporter())
+}
@@ -0,0 +1,101 @@
+package input_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/stretchr/testify/require"
+)
+
+//chanReader implements input.Reader by passing along the flows
+//sent on a channel
+type chanReader chan input.Flow
+
+func (c chanReader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	errs := make(chan error)
+	close(errs)
+	return c, errs
+}
+
+//testClockSkew implements config.ClockSkew
+type testClockSkew struct {
+	correct bool
+}
+
+func (t testClockSkew) IsEnabled() bool                       { return true }
+func (t testClockSkew) GetWarnThreshold() time.Duration       { return 10 * time.Minute }
+func (t testClockSkew) ShouldCorrect() bool                   { return t.correct }
+func (t testClockSkew) GetCorrectionThreshold() time.Duration { return time.Hour }
+
+//newSkewedFlow creates a flow which ended skew milliseconds
+//before it was received
+func newSkewedFlow(exporter string, received int64, skew int64) *input.FlowMock {
+	flow := input.NewFlowMock()
+	flow.MockExporter = exporter
+	flow.MockReceivedMilliseconds = received
+	flow.MockFlowEndMilliseconds = received - skew
+	flow.MockFlowStartMilliseconds = flow.MockFlowEndMilliseconds - 1000
+	return flow
+}
+
+//readSkewedFlows sends count flows through the reader and
+//returns the last flow read
+func readSkewedFlows(t *testing.T, upstream chanReader, flows <-chan input.Flow,
+	exporter string, skew int64, count int) *input.FlowMock {
+	var flow input.Flow
+	received := int64(1540364984000)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			upstream <- newSkewedFlow(exporter, received+int64(i)*1000, skew)
+		}(i)
+		select {
+		case flow = <-flows:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no flows received")
+		}
+	}
+	return flow.(*input.FlowMock)
+}
+
+func TestClockSkewReaderCorrects(t *testing.T) {
+	upstream := make(chanReader)
+	reader := input.NewClockSkewReader(upstream, testClockSkew{correct: true}, logging.NewTestLogger(t))
+	flows, _ := reader.Drain(context.Background())
+
+	//an exporter whose clock runs two hours behind
+	skew := int64(2 * time.Hour / time.Millisecond)
+	flow := readSkewedFlows(t, upstream, flows, "A", skew, 200)
+	require.Equal(t, flow.MockReceivedMilliseconds, flow.MockFlowEndMilliseconds)
+
+	//an exporter within the correction threshold is left alone
+	skew = int64(20 * time.Minute / time.Millisecond)
+	flow = readSkewedFlows(t, upstream, flows, "B", skew, 200)
+	require.Equal(t, flow.MockReceivedMilliseconds-skew, flow.MockFlowEndMilliseconds)
+
+	//an exporter whose clock runs ahead
+	skew = -int64(3 * time.Hour / time.Millisecond)
+	flow = readSkewedFlows(t, upstream, flows, "C", skew, 200)
+	require.Equal(t, flow.MockReceivedMilliseconds, flow.MockFlowEndMilliseconds)
+	close(upstream)
+}
+
+func TestClockSkewReaderWarnsOnly(t *testing.T) {
+	upstream := make(chanReader)
+	reader := input.NewClockSkewReader(upstream, testClockSkew{correct: false}, logging.NewTestLogger(t))
+	flows, _ := reader.Drain(context.Background())
+
+	skew := int64(2 * time.Hour / time.Millisecond)
+	flow := readSkewedFlows(t, upstream, flows, "A", skew, 200)
+	require.Equal(t, flow.MockReceivedMilliseconds-skew, flow.MockFlowEndMilliseconds)
+
+	//flows without a receive time are passed along as is
+	go func() {
+		upstream <- newSkewedFlow("A", 0, skew)
+	}()
+	flow = (<-flows).(*input.FlowMock)
+	require.Equal(t, -skew, flow.MockFlowEndMilliseconds)
+	close(upstream)
+}
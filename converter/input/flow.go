@@ -30,6 +30,89 @@ type Flow interface {
 	Exporter() string
 }
 
+//BidirectionalFlow is implemented by flows which may describe both
+//directions of a connection, such as RFC 5103 biflows. The forward
+//direction runs from the source to the destination, and the source is
+//the initiator of the connection. The reverse direction runs from the
+//destination back to the source. Both directions share the flow end
+//time and FlowEndReason.
+type BidirectionalFlow interface {
+	Flow
+	//IsBidirectional returns true if the flow holds the reverse direction
+	IsBidirectional() bool
+	//ReverseFlowStartMilliseconds is the time the reverse direction
+	//started as a Unix timestamp
+	ReverseFlowStartMilliseconds() (int64, error)
+	//ReverseOctetTotalCount returns the total amount of bytes sent
+	//from the destination to the source
+	ReverseOctetTotalCount() int64
+	//ReversePacketTotalCount returns the number of packets sent
+	//from the destination to the source
+	ReversePacketTotalCount() int64
+}
+
+//IsBidirectional returns true if the flow describes
+//both directions of a connection
+func IsBidirectional(flow Flow) bool {
+	biflow, ok := flow.(BidirectionalFlow)
+	return ok && biflow.IsBidirectional()
+}
+
+//ExtendedFlow is implemented by flows which may carry attributes
+//beyond the flow key, counters, and timestamps. Each method returns 0
+//if the exporter did not send the attribute.
+type ExtendedFlow interface {
+	Flow
+	//TCPControlBits returns the union of the TCP flags seen in the flow
+	TCPControlBits() uint16
+	//ICMPTypeCode returns the ICMP type (high byte) and code (low byte)
+	ICMPTypeCode() uint16
+	//IngressInterface returns the index of the interface
+	//the flow entered the exporter on
+	IngressInterface() uint32
+	//EgressInterface returns the index of the interface
+	//the flow left the exporter on
+	EgressInterface() uint32
+	//VLANID returns the VLAN the flow was seen on
+	VLANID() uint16
+	//SourceAS returns the autonomous system of the source address
+	SourceAS() uint32
+	//DestinationAS returns the autonomous system of the destination address
+	DestinationAS() uint32
+}
+
+//DeniedFlow is implemented by flows which may describe connection
+//attempts blocked by a firewall, such as Cisco ASA NSEL flow denied events.
+type DeniedFlow interface {
+	Flow
+	//IsDenied returns true if the connection was blocked
+	IsDenied() bool
+}
+
+//ReceivedFlow is implemented by flows which record when their
+//records reached the collector
+type ReceivedFlow interface {
+	Flow
+	//ReceivedMilliseconds is the time the flow's record was received
+	//as a Unix timestamp. ReceivedMilliseconds returns 0 if the time is unknown.
+	ReceivedMilliseconds() int64
+}
+
+//ShiftableFlow is implemented by flows whose timestamps may be
+//corrected, e.g. when the exporter's clock is known to be wrong
+type ShiftableFlow interface {
+	Flow
+	//ShiftTimestamps moves each of the flow's timestamps
+	//by the given number of milliseconds
+	ShiftTimestamps(offsetMillis int64) error
+}
+
+//IsDenied returns true if the flow describes a blocked connection attempt
+func IsDenied(flow Flow) bool {
+	deniedFlow, ok := flow.(DeniedFlow)
+	return ok && deniedFlow.IsDenied()
+}
+
 //FlowEndReason Represents IPFIX Information Export #136
 type FlowEndReason uint8
 
@@ -52,3 +135,19 @@ const (
 	//NilEndReason is used to represent the absence of a FlowEndReason.
 	NilEndReason FlowEndReason = 255
 )
+
+//Acknowledger is implemented by flows which must be acknowledged once
+//they have been written to the output or dropped from the pipeline.
+//Inputs which hold onto their data until it has been fully processed
+//use the acknowledgements to decide when it is safe to discard it.
+type Acknowledger interface {
+	//Acknowledge marks the flow as fully processed
+	Acknowledge()
+}
+
+//Acknowledge acknowledges a flow if the flow implements Acknowledger
+func Acknowledge(flow Flow) {
+	if acknowledger, ok := flow.(Acknowledger); ok {
+		acknowledger.Acknowledge()
+	}
+}
@@ -26,6 +26,23 @@ type FlowMock struct {
 	MockProtocolIdentifier protocols.Identifier
 	MockFlowEndReason      FlowEndReason
 	MockVersion            uint8
+
+	MockBidirectional                bool
+	MockReverseFlowStartMilliseconds int64
+	MockReverseOctetTotalCount       int64
+	MockReversePacketTotalCount      int64
+
+	MockDenied bool
+
+	MockReceivedMilliseconds int64
+
+	MockTCPControlBits   uint16
+	MockICMPTypeCode     uint16
+	MockIngressInterface uint32
+	MockEgressInterface  uint32
+	MockVLANID           uint16
+	MockSourceAS         uint32
+	MockDestinationAS    uint32
 }
 
 //NewFlowMock returns a ipfix.Flow with random data
@@ -139,3 +156,100 @@ func (f *FlowMock) Version() uint8 {
 func (f *FlowMock) Exporter() string {
 	return f.MockExporter
 }
+
+//IsBidirectional returns true if the flow holds the reverse direction
+func (f *FlowMock) IsBidirectional() bool {
+	return f.MockBidirectional
+}
+
+//ReverseFlowStartMilliseconds is the time the reverse direction
+//started as a Unix timestamp
+func (f *FlowMock) ReverseFlowStartMilliseconds() (int64, error) {
+	return f.MockReverseFlowStartMilliseconds, nil
+}
+
+//ReverseOctetTotalCount returns the total amount of bytes sent
+//from the destination to the source
+func (f *FlowMock) ReverseOctetTotalCount() int64 {
+	return f.MockReverseOctetTotalCount
+}
+
+//ReversePacketTotalCount returns the number of packets sent
+//from the destination to the source
+func (f *FlowMock) ReversePacketTotalCount() int64 {
+	return f.MockReversePacketTotalCount
+}
+
+//IsDenied returns true if the connection was blocked
+func (f *FlowMock) IsDenied() bool {
+	return f.MockDenied
+}
+
+//ReceivedMilliseconds is the time the flow's record was received
+//as a Unix timestamp
+func (f *FlowMock) ReceivedMilliseconds() int64 {
+	return f.MockReceivedMilliseconds
+}
+
+//ShiftTimestamps moves each of the flow's timestamps
+//by the given number of milliseconds
+func (f *FlowMock) ShiftTimestamps(offsetMillis int64) error {
+	f.MockFlowStartMilliseconds += offsetMillis
+	f.MockFlowEndMilliseconds += offsetMillis
+	f.MockReverseFlowStartMilliseconds += offsetMillis
+	return nil
+}
+
+//TCPControlBits returns the union of the TCP flags seen in the flow
+func (f *FlowMock) TCPControlBits() uint16 {
+	return f.MockTCPControlBits
+}
+
+//ICMPTypeCode returns the ICMP type (high byte) and code (low byte)
+func (f *FlowMock) ICMPTypeCode() uint16 {
+	return f.MockICMPTypeCode
+}
+
+//IngressInterface returns the index of the interface
+//the flow entered the exporter on
+func (f *FlowMock) IngressInterface() uint32 {
+	return f.MockIngressInterface
+}
+
+//EgressInterface returns the index of the interface
+//the flow left the exporter on
+func (f *FlowMock) EgressInterface() uint32 {
+	return f.MockEgressInterface
+}
+
+//VLANID returns the VLAN the flow was seen on
+func (f *FlowMock) VLANID() uint16 {
+	return f.MockVLANID
+}
+
+//SourceAS returns the autonomous system of the source address
+func (f *FlowMock) SourceAS() uint32 {
+	return f.MockSourceAS
+}
+
+//DestinationAS returns the autonomous system of the destination address
+func (f *FlowMock) DestinationAS() uint32 {
+	return f.MockDestinationAS
+}
+
+//AcknowledgedFlowMock is a FlowMock which counts how
+//many times it has been acknowledged
+type AcknowledgedFlowMock struct {
+	*FlowMock
+	Acks int
+}
+
+//NewAcknowledgedFlowMock returns an AcknowledgedFlowMock with random data
+func NewAcknowledgedFlowMock() *AcknowledgedFlowMock {
+	return &AcknowledgedFlowMock{FlowMock: NewFlowMock()}
+}
+
+//Acknowledge records that the flow has been acknowledged
+func (a *AcknowledgedFlowMock) Acknowledge() {
+	a.Acks++
+}
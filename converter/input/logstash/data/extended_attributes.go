@@ -0,0 +1,91 @@
+package data
+
+import (
+	"github.com/globalsign/mgo/bson"
+)
+
+//extendedFields names the fields holding the extended flow attributes
+//in each Netflow version. Each attribute is read from the first of
+//its fields present in a record.
+type extendedFields struct {
+	tcpControlBits   []string
+	icmpTypeCode     []string
+	ingressInterface []string
+	egressInterface  []string
+	vlanID           []string
+	sourceAS         []string
+	destinationAS    []string
+}
+
+//ipfixExtendedFields are the IPFIX information element names
+var ipfixExtendedFields = extendedFields{
+	tcpControlBits:   []string{"tcpControlBits"},
+	icmpTypeCode:     []string{"icmpTypeCodeIPv4", "icmpTypeCodeIPv6"},
+	ingressInterface: []string{"ingressInterface"},
+	egressInterface:  []string{"egressInterface"},
+	vlanID:           []string{"vlanId", "dot1qVlanId"},
+	sourceAS:         []string{"bgpSourceAsNumber"},
+	destinationAS:    []string{"bgpDestinationAsNumber"},
+}
+
+//netflowv9ExtendedFields are the names Logstash gives the Netflow v9 fields
+var netflowv9ExtendedFields = extendedFields{
+	tcpControlBits:   []string{"tcp_flags"},
+	icmpTypeCode:     []string{"icmp_type", "icmp_type_ipv6"},
+	ingressInterface: []string{"input_snmp"},
+	egressInterface:  []string{"output_snmp"},
+	vlanID:           []string{"src_vlan", "dst_vlan"},
+	sourceAS:         []string{"src_as"},
+	destinationAS:    []string{"dst_as"},
+}
+
+//netflowv5ExtendedFields are the names Logstash gives the Netflow v5 fields
+var netflowv5ExtendedFields = extendedFields{
+	tcpControlBits:   []string{"tcp_flags"},
+	ingressInterface: []string{"input_snmp"},
+	egressInterface:  []string{"output_snmp"},
+	sourceAS:         []string{"src_as"},
+	destinationAS:    []string{"dst_as"},
+}
+
+//fillExtendedAttributes reads the extended flow attributes from a
+//record into the output flow. Attributes which are not present are set to 0.
+func fillExtendedAttributes(netflowMap bson.M, fields extendedFields, outputFlow *Flow) error {
+	tcpControlBits, err := firstInt64Field(netflowMap, fields.tcpControlBits, 0)
+	if err != nil {
+		return err
+	}
+	icmpTypeCode, err := firstInt64Field(netflowMap, fields.icmpTypeCode, 0)
+	if err != nil {
+		return err
+	}
+	ingressInterface, err := firstInt64Field(netflowMap, fields.ingressInterface, 0)
+	if err != nil {
+		return err
+	}
+	egressInterface, err := firstInt64Field(netflowMap, fields.egressInterface, 0)
+	if err != nil {
+		return err
+	}
+	vlanID, err := firstInt64Field(netflowMap, fields.vlanID, 0)
+	if err != nil {
+		return err
+	}
+	sourceAS, err := firstInt64Field(netflowMap, fields.sourceAS, 0)
+	if err != nil {
+		return err
+	}
+	destinationAS, err := firstInt64Field(netflowMap, fields.destinationAS, 0)
+	if err != nil {
+		return err
+	}
+
+	outputFlow.Netflow.TCPControlBits = uint16(tcpControlBits)
+	outputFlow.Netflow.ICMPTypeCode = uint16(icmpTypeCode)
+	outputFlow.Netflow.IngressInterface = uint32(ingressInterface)
+	outputFlow.Netflow.EgressInterface = uint32(egressInterface)
+	outputFlow.Netflow.VLANID = uint16(vlanID)
+	outputFlow.Netflow.SourceAS = uint32(sourceAS)
+	outputFlow.Netflow.DestinationAS = uint32(destinationAS)
+	return nil
+}
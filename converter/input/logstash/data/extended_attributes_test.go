@@ -0,0 +1,60 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedAttributesIPFIX(t *testing.T) {
+	record := newVendorRecord("10.0.0.2")
+	netflowMap := record["netflow"].(bson.M)
+	netflowMap["flowStartMilliseconds"] = "2018-05-04T22:36:40.000Z"
+	netflowMap["octetTotalCount"] = int64(50)
+	netflowMap["tcpControlBits"] = 0x12
+	netflowMap["ingressInterface"] = 3
+	netflowMap["egressInterface"] = 4
+	netflowMap["dot1qVlanId"] = 20
+	netflowMap["bgpSourceAsNumber"] = int64(64512)
+	netflowMap["bgpDestinationAsNumber"] = int64(15169)
+
+	var flow Flow
+	err := NewFlowDeserializer().DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, uint16(0x12), flow.TCPControlBits())
+	require.Equal(t, uint16(0), flow.ICMPTypeCode())
+	require.Equal(t, uint32(3), flow.IngressInterface())
+	require.Equal(t, uint32(4), flow.EgressInterface())
+	require.Equal(t, uint16(20), flow.VLANID())
+	require.Equal(t, uint32(64512), flow.SourceAS())
+	require.Equal(t, uint32(15169), flow.DestinationAS())
+}
+
+func TestExtendedAttributesNetflowv9(t *testing.T) {
+	flowDeserializer := NewFlowDeserializer()
+	record := newNetflowv9Record()
+	netflowMap := record["netflow"].(bson.M)
+	netflowMap["icmp_type"] = 0x0303
+	netflowMap["input_snmp"] = 1
+	netflowMap["output_snmp"] = 2
+	netflowMap["src_as"] = 100
+	netflowMap["dst_as"] = 200
+
+	var flow Flow
+	err := flowDeserializer.DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, uint16(0), flow.TCPControlBits())
+	require.Equal(t, uint16(0x0303), flow.ICMPTypeCode())
+	require.Equal(t, uint32(1), flow.IngressInterface())
+	require.Equal(t, uint32(2), flow.EgressInterface())
+	require.Equal(t, uint32(100), flow.SourceAS())
+	require.Equal(t, uint32(200), flow.DestinationAS())
+
+	//attributes missing from the next record don't carry over
+	err = flowDeserializer.DeserializeNextBSONMap(newNetflowv9Record(), &flow)
+	require.Nil(t, err)
+	require.Equal(t, uint16(0), flow.ICMPTypeCode())
+	require.Equal(t, uint32(0), flow.IngressInterface())
+	require.Equal(t, uint32(0), flow.SourceAS())
+}
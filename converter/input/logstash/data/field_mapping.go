@@ -0,0 +1,235 @@
+package data
+
+import (
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/config"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//attributeKind determines how a mapped field is converted
+//into the form fillFromIPFIXBSONMap expects
+type attributeKind int
+
+const (
+	//addressAttribute fields hold IP address strings
+	addressAttribute attributeKind = iota
+	//integerAttribute fields hold small integers such as ports
+	integerAttribute
+	//counterAttribute fields hold 64 bit counters which may be scaled
+	counterAttribute
+	//timestampAttribute fields hold RFC3339 strings or Unix times
+	timestampAttribute
+)
+
+//mappableAttributes lists the flow attributes which may be remapped.
+//The attributes are named after the IPFIX information elements
+//read by fillFromIPFIXBSONMap.
+var mappableAttributes = map[string]attributeKind{
+	"sourceIPv4Address":        addressAttribute,
+	"sourceIPv6Address":        addressAttribute,
+	"sourceTransportPort":      integerAttribute,
+	"destinationIPv4Address":   addressAttribute,
+	"destinationIPv6Address":   addressAttribute,
+	"destinationTransportPort": integerAttribute,
+	"flowStartMilliseconds":    timestampAttribute,
+	"flowEndMilliseconds":      timestampAttribute,
+	"octetTotalCount":          counterAttribute,
+	"packetTotalCount":         counterAttribute,
+	"protocolIdentifier":       integerAttribute,
+	"flowEndReason":            integerAttribute,
+
+	"reverseOctetTotalCount":       counterAttribute,
+	"reversePacketTotalCount":      counterAttribute,
+	"reverseFlowDeltaMilliseconds": integerAttribute,
+}
+
+//timestampUnits maps the units a Unix time may be given in
+//to the number of nanoseconds in each unit
+var timestampUnits = map[string]int64{
+	"seconds":      int64(time.Second),
+	"milliseconds": int64(time.Millisecond),
+	"microseconds": int64(time.Microsecond),
+	"nanoseconds":  int64(time.Nanosecond),
+}
+
+//fieldSource is a field which may feed a flow attribute
+type fieldSource struct {
+	field string
+	//unit is the length of a timestamp unit in nanoseconds.
+	//unit is 0 if the timestamp is an RFC3339 string.
+	unit int64
+	//scale multiplies counters
+	scale int64
+}
+
+//attributeSources maps flow attributes to their sources in fallback order
+type attributeSources map[string][]fieldSource
+
+//FieldMapping rewrites Logstash decoded IPFIX records so vendor
+//specific fields feed the flow attributes. Each mapped attribute is
+//filled from the first of its sources present in a record. If none of
+//the sources are present, the attribute is read as usual.
+type FieldMapping struct {
+	defaults  attributeSources
+	exporters map[string]attributeSources
+}
+
+//NewFieldMapping validates the field mapping configuration and
+//creates a FieldMapping. The sources configured for an exporter
+//replace the default sources for the same attribute.
+func NewFieldMapping(conf config.FieldMapping) (*FieldMapping, error) {
+	defaults, err := newAttributeSources(conf.GetDefault())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid default field mapping")
+	}
+
+	exporters := make(map[string]attributeSources)
+	for exporter, exporterConf := range conf.GetExporters() {
+		overrides, err := newAttributeSources(exporterConf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid field mapping for exporter %s", exporter)
+		}
+		merged := make(attributeSources, len(defaults)+len(overrides))
+		for attribute, sources := range defaults {
+			merged[attribute] = sources
+		}
+		for attribute, sources := range overrides {
+			merged[attribute] = sources
+		}
+		exporters[exporter] = merged
+	}
+
+	return &FieldMapping{
+		defaults:  defaults,
+		exporters: exporters,
+	}, nil
+}
+
+//newAttributeSources checks the configured sources against
+//the attributes they feed
+func newAttributeSources(conf map[string][]config.FieldSource) (attributeSources, error) {
+	sources := make(attributeSources, len(conf))
+	for attribute, sourceConfs := range conf {
+		kind, ok := mappableAttributes[attribute]
+		if !ok {
+			return nil, errors.Errorf("unknown flow attribute %s", attribute)
+		}
+		for _, sourceConf := range sourceConfs {
+			if sourceConf.GetField() == "" {
+				return nil, errors.Errorf("a source for %s does not name a field", attribute)
+			}
+			source := fieldSource{
+				field: sourceConf.GetField(),
+				scale: 1,
+			}
+
+			if sourceConf.GetUnit() != "" && sourceConf.GetUnit() != "rfc3339" {
+				if kind != timestampAttribute {
+					return nil, errors.Errorf("%s does not accept a unit", attribute)
+				}
+				unit, ok := timestampUnits[sourceConf.GetUnit()]
+				if !ok {
+					return nil, errors.Errorf("unknown timestamp unit %s for %s", sourceConf.GetUnit(), attribute)
+				}
+				source.unit = unit
+			}
+
+			if sourceConf.GetScale() != 0 {
+				if kind != counterAttribute {
+					return nil, errors.Errorf("%s does not accept a scale", attribute)
+				}
+				if sourceConf.GetScale() < 0 {
+					return nil, errors.Errorf("the scale for %s must be positive", attribute)
+				}
+				source.scale = sourceConf.GetScale()
+			}
+
+			sources[attribute] = append(sources[attribute], source)
+		}
+	}
+	return sources, nil
+}
+
+//apply returns a copy of the ipfixMap with the mapped fields copied
+//into the fields read by fillFromIPFIXBSONMap. The ipfixMap is returned
+//as is if no attributes are mapped for the exporting host.
+func (m *FieldMapping) apply(ipfixMap bson.M, host string) (bson.M, error) {
+	sources, ok := m.exporters[host]
+	if !ok {
+		sources = m.defaults
+	}
+	if len(sources) == 0 {
+		return ipfixMap, nil
+	}
+
+	mapped := make(bson.M, len(ipfixMap)+len(sources))
+	for key, value := range ipfixMap {
+		mapped[key] = value
+	}
+
+	for attribute, candidates := range sources {
+		for _, source := range candidates {
+			valueIface, ok := ipfixMap[source.field]
+			if !ok {
+				continue
+			}
+			value, err := source.convert(mappableAttributes[attribute], valueIface)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not map %s to %s", source.field, attribute)
+			}
+			mapped[attribute] = value
+			break
+		}
+	}
+	return mapped, nil
+}
+
+//convert converts a source field's value into the
+//type fillFromIPFIXBSONMap expects for the given kind of attribute
+func (s fieldSource) convert(kind attributeKind, valueIface interface{}) (interface{}, error) {
+	switch kind {
+	case addressAttribute:
+		value, ok := valueIface.(string)
+		if !ok {
+			return nil, errors.Errorf("could not convert %+v to string", valueIface)
+		}
+		return value, nil
+	case integerAttribute:
+		value, err := numberToInt64(valueIface)
+		if err != nil {
+			return nil, err
+		}
+		return int(value), nil
+	case counterAttribute:
+		value, err := numberToInt64(valueIface)
+		if err != nil {
+			return nil, err
+		}
+		return value * s.scale, nil
+	case timestampAttribute:
+		if s.unit == 0 {
+			value, ok := valueIface.(string)
+			if !ok {
+				return nil, errors.Errorf("could not convert %+v to string", valueIface)
+			}
+			return value, nil
+		}
+		value, err := numberToInt64(valueIface)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(0, value*s.unit).UTC().Format(time.RFC3339Nano), nil
+	}
+	return nil, errors.Errorf("unknown attribute kind %d", kind)
+}
+
+//numberToInt64 extends iFaceToInt64 to accept the floating
+//point numbers Logstash may produce for vendor specific fields
+func numberToInt64(iFaceNum interface{}) (int64, error) {
+	if floatNum, ok := iFaceNum.(float64); ok {
+		return int64(floatNum), nil
+	}
+	return iFaceToInt64(iFaceNum)
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/config"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/globalsign/mgo/bson"
+	"github.com/stretchr/testify/require"
+)
+
+//testFieldMapping implements config.FieldMapping
+type testFieldMapping struct {
+	defaults  map[string][]config.FieldSource
+	exporters map[string]map[string][]config.FieldSource
+}
+
+func (t testFieldMapping) GetDefault() map[string][]config.FieldSource { return t.defaults }
+func (t testFieldMapping) GetExporters() map[string]map[string][]config.FieldSource {
+	return t.exporters
+}
+
+//testFieldSource implements config.FieldSource
+type testFieldSource struct {
+	field string
+	unit  string
+	scale int64
+}
+
+func (t testFieldSource) GetField() string { return t.field }
+func (t testFieldSource) GetUnit() string  { return t.unit }
+func (t testFieldSource) GetScale() int64  { return t.scale }
+
+//newVendorRecord creates a Logstash record which only carries
+//the octet count and start time in vendor specific fields
+func newVendorRecord(host string) bson.M {
+	return bson.M{
+		"_id":  bson.ObjectId("5b72d69af6a43336c6004e07"),
+		"host": host,
+		"netflow": bson.M{
+			"sourceIPv4Address":        "1.1.1.1",
+			"sourceTransportPort":      24846,
+			"destinationIPv4Address":   "2.2.2.2",
+			"destinationTransportPort": 53,
+			"flowStartSeconds":         int64(1525473400),
+			"flowEndMilliseconds":      "2018-05-04T22:36:40.960Z",
+			"vendorKilobytes":          5,
+			"packetDeltaCount":         int64(10),
+			"protocolIdentifier":       int(protocols.UDP),
+			"version":                  10,
+		},
+	}
+}
+
+func TestFieldMapping(t *testing.T) {
+	mapping, err := NewFieldMapping(testFieldMapping{
+		defaults: map[string][]config.FieldSource{
+			"octetTotalCount": {
+				testFieldSource{field: "octetTotalCount"},
+				testFieldSource{field: "vendorKilobytes", scale: 1000},
+			},
+		},
+		exporters: map[string]map[string][]config.FieldSource{
+			"B": {
+				"flowStartMilliseconds": {testFieldSource{field: "flowStartSeconds", unit: "seconds"}},
+			},
+		},
+	})
+	require.Nil(t, err)
+	flowDeserializer := NewFlowDeserializer()
+	flowDeserializer.fieldMapping = mapping
+
+	//the default mapping doesn't cover the start time
+	var flow Flow
+	err = flowDeserializer.DeserializeNextBSONMap(newVendorRecord("A"), &flow)
+	require.NotNil(t, err)
+
+	//the exporter override adds the start time and keeps the default octet mapping
+	err = flowDeserializer.DeserializeNextBSONMap(newVendorRecord("B"), &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(5000), flow.OctetTotalCount())
+	require.Equal(t, int64(10), flow.PacketTotalCount())
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473400000), flowStart)
+
+	//the first source present in the record wins
+	record := newVendorRecord("B")
+	record["netflow"].(bson.M)["octetTotalCount"] = int64(42)
+	err = flowDeserializer.DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(42), flow.OctetTotalCount())
+}
+
+func TestFieldMappingInvalid(t *testing.T) {
+	tests := map[string]map[string][]config.FieldSource{
+		"unknownAttribute": {
+			"vendorBytes": {testFieldSource{field: "vendorBytes"}},
+		},
+		"missingField": {
+			"octetTotalCount": {testFieldSource{}},
+		},
+		"unknownUnit": {
+			"flowEndMilliseconds": {testFieldSource{field: "flowEndSeconds", unit: "minutes"}},
+		},
+		"unitOnCounter": {
+			"octetTotalCount": {testFieldSource{field: "vendorBytes", unit: "seconds"}},
+		},
+		"scaleOnPort": {
+			"sourceTransportPort": {testFieldSource{field: "vendorPort", scale: 2}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Logf("Running test case: %s", name)
+		_, err := NewFieldMapping(testFieldMapping{defaults: test})
+		require.NotNil(t, err)
+	}
+}
@@ -33,7 +33,30 @@ type Flow struct {
 		ProtocolIdentifier protocols.Identifier `bson:"protocolIdentifier"`
 		FlowEndReason      input.FlowEndReason  `bson:"flowEndReason"`
 		Version            uint8                `bson:"version"`
+
+		//Bidirectional is set if the record is an RFC 5103 biflow.
+		//The reverse fields are only filled for biflows.
+		Bidirectional                bool  `bson:"-"`
+		ReverseOctetTotalCount       int64 `bson:"reverseOctetTotalCount,omitempty"`
+		ReversePacketTotalCount      int64 `bson:"reversePacketTotalCount,omitempty"`
+		ReverseFlowDeltaMilliseconds int64 `bson:"reverseFlowDeltaMilliseconds,omitempty"`
+
+		//Denied is set if the record is a Cisco ASA NSEL flow denied event
+		Denied bool `bson:"-"`
+
+		//Extended attributes are 0 if the exporter did not send them
+		TCPControlBits   uint16 `bson:"tcpControlBits,omitempty"`
+		ICMPTypeCode     uint16 `bson:"icmpTypeCodeIPv4,omitempty"`
+		IngressInterface uint32 `bson:"ingressInterface,omitempty"`
+		EgressInterface  uint32 `bson:"egressInterface,omitempty"`
+		VLANID           uint16 `bson:"vlanId,omitempty"`
+		SourceAS         uint32 `bson:"bgpSourceAsNumber,omitempty"`
+		DestinationAS    uint32 `bson:"bgpDestinationAsNumber,omitempty"`
 	} `bson:"netflow"`
+
+	//Received is the Logstash @timestamp of the record as a Unix timestamp
+	//in milliseconds. Received is 0 if the record doesn't have an @timestamp.
+	Received int64 `bson:"-"`
 }
 
 //SourceIPAddress returns the source IPv4 or IPv6 address
@@ -111,3 +134,94 @@ func (i *Flow) Version() uint8 {
 func (i *Flow) Exporter() string {
 	return i.Host
 }
+
+//IsBidirectional returns true if the flow holds the reverse direction
+func (i *Flow) IsBidirectional() bool {
+	return i.Netflow.Bidirectional
+}
+
+//ReverseFlowStartMilliseconds is the time the reverse direction
+//started as a Unix timestamp
+func (i *Flow) ReverseFlowStartMilliseconds() (int64, error) {
+	flowStart, err := i.FlowStartMilliseconds()
+	if err != nil {
+		return 0, err
+	}
+	return flowStart + i.Netflow.ReverseFlowDeltaMilliseconds, nil
+}
+
+//ReverseOctetTotalCount returns the total amount of bytes sent
+//from the destination to the source
+func (i *Flow) ReverseOctetTotalCount() int64 {
+	return i.Netflow.ReverseOctetTotalCount
+}
+
+//ReversePacketTotalCount returns the number of packets sent
+//from the destination to the source
+func (i *Flow) ReversePacketTotalCount() int64 {
+	return i.Netflow.ReversePacketTotalCount
+}
+
+//IsDenied returns true if the connection was blocked
+func (i *Flow) IsDenied() bool {
+	return i.Netflow.Denied
+}
+
+//TCPControlBits returns the union of the TCP flags seen in the flow
+func (i *Flow) TCPControlBits() uint16 {
+	return i.Netflow.TCPControlBits
+}
+
+//ICMPTypeCode returns the ICMP type (high byte) and code (low byte)
+func (i *Flow) ICMPTypeCode() uint16 {
+	return i.Netflow.ICMPTypeCode
+}
+
+//IngressInterface returns the index of the interface
+//the flow entered the exporter on
+func (i *Flow) IngressInterface() uint32 {
+	return i.Netflow.IngressInterface
+}
+
+//EgressInterface returns the index of the interface
+//the flow left the exporter on
+func (i *Flow) EgressInterface() uint32 {
+	return i.Netflow.EgressInterface
+}
+
+//VLANID returns the VLAN the flow was seen on
+func (i *Flow) VLANID() uint16 {
+	return i.Netflow.VLANID
+}
+
+//SourceAS returns the autonomous system of the source address
+func (i *Flow) SourceAS() uint32 {
+	return i.Netflow.SourceAS
+}
+
+//DestinationAS returns the autonomous system of the destination address
+func (i *Flow) DestinationAS() uint32 {
+	return i.Netflow.DestinationAS
+}
+
+//ReceivedMilliseconds is the time Logstash received
+//the flow's record as a Unix timestamp
+func (i *Flow) ReceivedMilliseconds() int64 {
+	return i.Received
+}
+
+//ShiftTimestamps moves each of the flow's timestamps
+//by the given number of milliseconds
+func (i *Flow) ShiftTimestamps(offsetMillis int64) error {
+	flowStart, err := i.FlowStartMilliseconds()
+	if err != nil {
+		return err
+	}
+	flowEnd, err := i.FlowEndMilliseconds()
+	if err != nil {
+		return err
+	}
+	i.Netflow.FlowStartMilliseconds = formatMilliseconds(flowStart + offsetMillis)
+	i.Netflow.FlowEndMilliseconds = formatMilliseconds(flowEnd + offsetMillis)
+	return nil
+}
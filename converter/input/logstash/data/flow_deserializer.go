@@ -1,9 +1,11 @@
 package data
 
 import (
+	"strings"
 	"time"
 	// "fmt"
 
+	"github.com/activecm/ipfix-rita/converter/config"
 	"github.com/activecm/ipfix-rita/converter/input"
 	"github.com/activecm/ipfix-rita/converter/protocols"
 	"github.com/globalsign/mgo/bson"
@@ -15,6 +17,30 @@ type ipfixRelTime struct {
 	firstFlowTime  time.Time
 }
 
+//observationDomain identifies an IPFIX observation domain on an
+//exporting host. Each observation domain may keep its own
+//system uptime, e.g. each line card in a chassis.
+type observationDomain struct {
+	host                string
+	observationDomainID int64
+}
+
+//newObservationDomain reads the observationDomainId from an IPFIX
+//record. Records without an observationDomainId belong to domain 0.
+func newObservationDomain(ipfixMap bson.M, host string) (observationDomain, error) {
+	domain := observationDomain{host: host}
+	domainIDIface, ok := ipfixMap["observationDomainId"]
+	if !ok {
+		return domain, nil
+	}
+	domainID, err := iFaceToInt64(domainIDIface)
+	if err != nil {
+		return domain, err
+	}
+	domain.observationDomainID = domainID
+	return domain, nil
+}
+
 //FlowDeserializer converts a sequence of IPFIX/Netflow v5/v9 ,
 //Logstash created, BSON maps into mgologstash.Flow objects.
 //The deserializer encapsulates the deserialization methods
@@ -28,25 +54,58 @@ type ipfixRelTime struct {
 //The system boot time (systemInitTimeMilliseconds) may be sent in a
 //IPFIX record other than the record to be processed. As such,
 //the system boot time for each exporting host must be held as state
-//while sequences of IPFIX records are deserialized.
+//while sequences of IPFIX records are deserialized. Since each observation
+//domain on a host may boot separately, the boot times are held
+//for each observation domain.
 type FlowDeserializer struct {
-	ipfixExporterAbsUptimes map[string]int64        //map from exporting host to systemInitTimeMilliseconds values
-	ipfixExporterRelUptimes map[string]ipfixRelTime //map from exporting host to relative system uptime values
+	ipfixExporterAbsUptimes map[observationDomain]int64        //map from observation domain to systemInitTimeMilliseconds values
+	ipfixExporterRelUptimes map[observationDomain]ipfixRelTime //map from observation domain to relative system uptime values
+	fieldMapping            *FieldMapping                      //remaps vendor specific IPFIX fields, may be nil
+	nselOptions             NSELOptions                        //determines how Cisco ASA NSEL events are read
+	nselConnections         map[nselKey]*nselConnection        //NSEL connections waiting for their teardown events
+	samplingRates           map[samplerKey]int64               //map from exporting host and sampler to announced sampling rates
+	samplingOverrides       map[string]int64                   //map from exporting host to configured sampling rates
 }
 
 //NewFlowDeserializer creates a new FlowDeserializer
 func NewFlowDeserializer() *FlowDeserializer {
 	return &FlowDeserializer{
-		ipfixExporterAbsUptimes: make(map[string]int64),
-		ipfixExporterRelUptimes: make(map[string]ipfixRelTime),
+		ipfixExporterAbsUptimes: make(map[observationDomain]int64),
+		ipfixExporterRelUptimes: make(map[observationDomain]ipfixRelTime),
+		nselConnections:         make(map[nselKey]*nselConnection),
+		samplingRates:           make(map[samplerKey]int64),
+		samplingOverrides:       make(map[string]int64),
 	}
 }
 
-//updateExporterAbsUptimes updates the host entry in the ipfixExporterAbsUptimes map
-//if the ipfixMap contains a systemInitTimeMilliseconds field.
+//NewConfiguredFlowDeserializer creates a new FlowDeserializer which
+//applies the configured field mapping to IPFIX records, reads
+//NSEL records as configured, and falls back to the configured
+//sampling rates for exporters which don't announce their rates
+func NewConfiguredFlowDeserializer(conf config.LogstashMongoDB) (*FlowDeserializer, error) {
+	fieldMapping, err := NewFieldMapping(conf.GetFieldMappingConfig())
+	if err != nil {
+		return nil, err
+	}
+	samplingOverrides, err := newSamplingOverrides(conf.GetSamplingConfig())
+	if err != nil {
+		return nil, err
+	}
+	f := NewFlowDeserializer()
+	f.fieldMapping = fieldMapping
+	f.samplingOverrides = samplingOverrides
+	f.nselOptions = NSELOptions{
+		PairEvents: conf.GetNSELConfig().ShouldPairEvents(),
+		DropDenied: conf.GetNSELConfig().ShouldDropDenied(),
+	}
+	return f, nil
+}
+
+//updateExporterAbsUptimes updates the observation domain's entry in the
+//ipfixExporterAbsUptimes map if the ipfixMap contains a systemInitTimeMilliseconds field.
 //If the update is successful, the function returns true. Otherwise
 //the function returns false.
-func (f *FlowDeserializer) updateExporterAbsUptimes(ipfixMap bson.M, host string) bool {
+func (f *FlowDeserializer) updateExporterAbsUptimes(ipfixMap bson.M, domain observationDomain) bool {
 	//update the ipfixExporterAbsUptimes map if the data is available
 	exporterUptimeIface, exporterUptimeOk := ipfixMap["systemInitTimeMilliseconds"]
 	if exporterUptimeOk {
@@ -64,19 +123,19 @@ func (f *FlowDeserializer) updateExporterAbsUptimes(ipfixMap bson.M, host string
 
 		if exporterUptimeOk {
 			//update the map
-			f.ipfixExporterAbsUptimes[host] = exporterUptime
+			f.ipfixExporterAbsUptimes[domain] = exporterUptime
 			return true
 		}
 	}
 	return false
 }
 
-//updateExporterRelUptimes will update the relative timestamps for each host
-//relative to the daily first flow, so if we don't have an instance of the
+//updateExporterRelUptimes will update the relative timestamps for each observation
+//domain relative to the daily first flow, so if we don't have an instance of the
 //system init time we can still get results from RITA
-func (f *FlowDeserializer) updateExporterRelUptimes(ipfixMap bson.M, host string) (bool, error) {
+func (f *FlowDeserializer) updateExporterRelUptimes(ipfixMap bson.M, domain observationDomain) (bool, error) {
 	//if we have a inital set value see if we need to update the value
-	relUptime, ok := f.ipfixExporterRelUptimes[host]
+	relUptime, ok := f.ipfixExporterRelUptimes[domain]
 	if ok {
 		//if the system has reinitialized then the relative timestamps will be off
 		//  as a result check if there is a change and update it if needed
@@ -101,19 +160,21 @@ func (f *FlowDeserializer) updateExporterRelUptimes(ipfixMap bson.M, host string
 				return false, err
 			}
 
-			f.ipfixExporterRelUptimes[host] = newExporter
+			f.ipfixExporterRelUptimes[domain] = newExporter
 			return true, nil
 		}
+		//the observation domain has not been reinitialized
+		return false, nil
 	}
 
-	// If we haven't found the host in the Relative uptime map, create it
+	// If we haven't found the observation domain in the Relative uptime map, create it
 	newExporter, err := getNewExporterUptime(ipfixMap)
 	if err != nil {
 		return false, err
 	}
 
-	//assign a new rel uptime for the host
-	f.ipfixExporterRelUptimes[host] = newExporter
+	//assign a new rel uptime for the observation domain
+	f.ipfixExporterRelUptimes[domain] = newExporter
 
 	return true, nil
 }
@@ -161,6 +222,24 @@ func getNewExporterUptime(ipfixMap bson.M) (ipfixRelTime, error) {
 	return ipfixRelTime{endMills, flowDate}, nil
 }
 
+//readLogstashTimestamp returns the time Logstash received a record as a
+//Unix timestamp in milliseconds. The Logstash MongoDB output stores
+//@timestamp as a quoted string unless it is configured to store dates.
+//0 is returned if the record doesn't have a readable @timestamp.
+func readLogstashTimestamp(inputMap bson.M) int64 {
+	switch timestamp := inputMap["@timestamp"].(type) {
+	case time.Time:
+		return timestamp.UnixNano() / int64(time.Millisecond)
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, strings.Trim(timestamp, "\""))
+		if err != nil {
+			return 0
+		}
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return 0
+}
+
 //iFaceToInt64 will take a interface value and attempt to convert to an int64
 func iFaceToInt64(iFaceInt interface{}) (int64, error) {
 	convertedInt64, convertedInt64Ok := (iFaceInt).(int64)
@@ -182,7 +261,7 @@ func iFaceToInt64(iFaceInt interface{}) (int64, error) {
 //returning nil if the conversion was successful.
 //The exporting host must be provided in order to resolve flowStartSysUpTime and
 //flowEndSysUpTime timestamps.
-func (f *FlowDeserializer) fillFromIPFIXBSONMap(ipfixMap bson.M, outputFlow *Flow, host string) error {
+func (f *FlowDeserializer) fillFromIPFIXBSONMap(ipfixMap bson.M, outputFlow *Flow, domain observationDomain) error {
 	//First grab all the data making sure it exists in the map
 	//All of these pieces of data come out as interface{}, we have
 	//to recast the data back into a typed form :(
@@ -282,9 +361,9 @@ func (f *FlowDeserializer) fillFromIPFIXBSONMap(ipfixMap bson.M, outputFlow *Flo
 	flowEndUptimeMillisIface, flowEndUptimeMillisOk := ipfixMap["flowEndSysUpTime"]
 
 	//get the system init time if possible
-	systemInitTimeMillis, systemInitTimeMillisecondsOk := f.ipfixExporterAbsUptimes[host]
+	systemInitTimeMillis, systemInitTimeMillisecondsOk := f.ipfixExporterAbsUptimes[domain]
 	//If the system init time isn't present or stored use a relative uptime approach
-	systemRelativeMillis, systemRelativeOk := f.ipfixExporterRelUptimes[host]
+	systemRelativeMillis, systemRelativeOk := f.ipfixExporterRelUptimes[domain]
 
 	if flowStartMillisOk && flowEndMillisOk {
 		//Case 1: We have an absolute start and end time (this is ideal)
@@ -411,6 +490,12 @@ func (f *FlowDeserializer) fillFromIPFIXBSONMap(ipfixMap bson.M, outputFlow *Flo
 		flowEndReason = input.FlowEndReason(flowEndReasonInt)
 	}
 
+	//RFC 5103 biflows carry the reverse direction in the same record
+	reverseOctetTotal, reversePacketTotal, reverseDelta, bidirectional, err := readIPFIXReverseFields(ipfixMap)
+	if err != nil {
+		return err
+	}
+
 	//Fill in the flow now that we know we have all the data
 	if sourceIPv4Ok {
 		outputFlow.Netflow.SourceIPv4 = sourceIPv4
@@ -436,7 +521,53 @@ func (f *FlowDeserializer) fillFromIPFIXBSONMap(ipfixMap bson.M, outputFlow *Flo
 	outputFlow.Netflow.PacketTotalCount = packetTotal
 	outputFlow.Netflow.ProtocolIdentifier = protocols.Identifier(protocolID)
 	outputFlow.Netflow.FlowEndReason = flowEndReason
-	return nil
+	outputFlow.Netflow.Bidirectional = bidirectional
+	outputFlow.Netflow.ReverseOctetTotalCount = reverseOctetTotal
+	outputFlow.Netflow.ReversePacketTotalCount = reversePacketTotal
+	outputFlow.Netflow.ReverseFlowDeltaMilliseconds = reverseDelta
+	return fillExtendedAttributes(ipfixMap, ipfixExtendedFields, outputFlow)
+}
+
+//readIPFIXReverseFields reads the reverse direction of an RFC 5103
+//biflow from a bson map representing the Netflow field of Flow.
+//bidirectional is false if the record doesn't hold reverse counters.
+//A missing reverse counter is treated as zero.
+//The reverse direction is assumed to start with the forward direction
+//if reverseFlowDeltaMilliseconds is not present.
+func readIPFIXReverseFields(ipfixMap bson.M) (octetTotal, packetTotal, delta int64, bidirectional bool, err error) {
+	octetTotalIface, octetTotalOk := ipfixMap["reverseOctetTotalCount"]
+	if !octetTotalOk {
+		octetTotalIface, octetTotalOk = ipfixMap["reverseOctetDeltaCount"]
+	}
+	packetTotalIface, packetTotalOk := ipfixMap["reversePacketTotalCount"]
+	if !packetTotalOk {
+		packetTotalIface, packetTotalOk = ipfixMap["reversePacketDeltaCount"]
+	}
+	if !octetTotalOk && !packetTotalOk {
+		return 0, 0, 0, false, nil
+	}
+
+	if octetTotalOk {
+		octetTotal, err = iFaceToInt64(octetTotalIface)
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+	}
+	if packetTotalOk {
+		packetTotal, err = iFaceToInt64(packetTotalIface)
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+	}
+
+	deltaIface, ok := ipfixMap["reverseFlowDeltaMilliseconds"]
+	if ok {
+		delta, err = iFaceToInt64(deltaIface)
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+	}
+	return octetTotal, packetTotal, delta, true, nil
 }
 
 //fillFromNetflowv9BSONMap reads the data from a bson map representing
@@ -602,7 +733,7 @@ func (f *FlowDeserializer) fillFromNetflowv9BSONMap(netflowMap bson.M, outputFlo
 	outputFlow.Netflow.ProtocolIdentifier = protocols.Identifier(protocolID)
 	//assume end of flow since we don't have the data
 	outputFlow.Netflow.FlowEndReason = input.EndOfFlow
-	return nil
+	return fillExtendedAttributes(netflowMap, netflowv9ExtendedFields, outputFlow)
 }
 
 //fillFromNetflowv5BSONMap reads the data from a bson map representing
@@ -715,7 +846,7 @@ func (f *FlowDeserializer) fillFromNetflowv5BSONMap(netflowMap bson.M, outputFlo
 	outputFlow.Netflow.ProtocolIdentifier = protocols.Identifier(protocolID)
 	//assume end of flow since we don't have the data
 	outputFlow.Netflow.FlowEndReason = input.EndOfFlow
-	return nil
+	return fillExtendedAttributes(netflowMap, netflowv5ExtendedFields, outputFlow)
 }
 
 //DeserializeNextBSONMap reads the data from a bson map and inserts
@@ -766,21 +897,76 @@ func (f *FlowDeserializer) DeserializeNextBSONMap(inputMap bson.M, outputFlow *F
 	outputFlow.ID = id
 	outputFlow.Host = host
 	outputFlow.Netflow.Version = uint8(version)
+	outputFlow.Received = readLogstashTimestamp(inputMap)
+	outputFlow.Netflow.Bidirectional = false
+	outputFlow.Netflow.ReverseOctetTotalCount = 0
+	outputFlow.Netflow.ReversePacketTotalCount = 0
+	outputFlow.Netflow.ReverseFlowDeltaMilliseconds = 0
+	outputFlow.Netflow.Denied = false
 
 	//Version must be 10 or 9 or 5
+	var isOptionsRecord bool
+	var err error
 	if outputFlow.Netflow.Version == 10 {
+		//uptimes are tracked for each observation domain
+		var domain observationDomain
+		domain, err = newObservationDomain(netflowMap, host)
+		if err != nil {
+			return err
+		}
 		//handle recording systemInitTimeMilliseconds
-		f.updateExporterAbsUptimes(netflowMap, host)
+		f.updateExporterAbsUptimes(netflowMap, domain)
 		//theres a chance that systemInitTimeMilliseconds
 		//came inside a flow record, parse the rest out just in case...
 		//unfortunately, we can't tell option records from flow records
-		f.updateExporterRelUptimes(netflowMap, host)
+		f.updateExporterRelUptimes(netflowMap, domain)
+
+		//copy vendor specific fields into the standard fields
+		if f.fieldMapping != nil {
+			netflowMap, err = f.fieldMapping.apply(netflowMap, host)
+			if err != nil {
+				return err
+			}
+		}
+
+		isOptionsRecord, err = f.updateSamplingRates(netflowMap, host)
+		if err != nil {
+			return err
+		}
+		if isOptionsRecord {
+			return ErrSkippedRecord
+		}
 
-		return f.fillFromIPFIXBSONMap(netflowMap, outputFlow, host)
+		err = f.fillFromIPFIXBSONMap(netflowMap, outputFlow, domain)
 	} else if outputFlow.Netflow.Version == 9 {
-		return f.fillFromNetflowv9BSONMap(netflowMap, outputFlow)
+		isOptionsRecord, err = f.updateSamplingRates(netflowMap, host)
+		if err != nil {
+			return err
+		}
+		if isOptionsRecord {
+			return ErrSkippedRecord
+		}
+
+		//Cisco ASA NSEL records are Netflow v9 records
+		//which report firewall events. Firewalls don't sample packets.
+		if isNSELRecord(netflowMap) {
+			return f.fillFromNSELBSONMap(netflowMap, outputFlow, host)
+		}
+		err = f.fillFromNetflowv9BSONMap(netflowMap, outputFlow)
 	} else if outputFlow.Netflow.Version == 5 {
-		return f.fillFromNetflowv5BSONMap(netflowMap, outputFlow)
+		err = f.fillFromNetflowv5BSONMap(netflowMap, outputFlow)
+	} else {
+		return errors.Errorf("unsupported netflow version: %d", outputFlow.Netflow.Version)
 	}
-	return errors.Errorf("unsupported netflow version: %d", outputFlow.Netflow.Version)
+	if err != nil {
+		return err
+	}
+
+	//scale the counts of sampled flows before they are stitched
+	samplingRate, err := f.samplingRate(netflowMap, host)
+	if err != nil {
+		return err
+	}
+	outputFlow.scaleCounts(samplingRate)
+	return nil
 }
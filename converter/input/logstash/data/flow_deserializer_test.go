@@ -121,6 +121,47 @@ func TestFillFromIPFIXBSONMap(t *testing.T) {
 	require.Equal(t, uint16(57), flow2.DestinationPort())
 }
 
+func TestFillFromIPFIXBiflowBSONMap(t *testing.T) {
+	var flow = new(Flow)
+	var flowDeserializer = NewFlowDeserializer()
+	var testData = bson.M{
+		"_id":  bson.ObjectId("5b72d69af6a43336c6004e07"),
+		"host": "A",
+		"netflow": bson.M{
+			"sourceIPv4Address":            "1.1.1.1",
+			"sourceTransportPort":          24846,
+			"destinationIPv4Address":       "2.2.2.2",
+			"destinationTransportPort":     53,
+			"flowStartMilliseconds":        "2018-05-04T22:36:40.766Z",
+			"flowEndMilliseconds":          "2018-05-04T22:36:40.960Z",
+			"octetTotalCount":              int64(100),
+			"packetTotalCount":             int64(1),
+			"reverseOctetTotalCount":       int64(400),
+			"reversePacketTotalCount":      int64(2),
+			"reverseFlowDeltaMilliseconds": 30,
+			"protocolIdentifier":           int(protocols.UDP),
+			"version":                      10,
+		},
+	}
+
+	err := flowDeserializer.DeserializeNextBSONMap(testData, flow)
+	require.Nil(t, err)
+	require.True(t, flow.IsBidirectional())
+	require.Equal(t, int64(400), flow.ReverseOctetTotalCount())
+	require.Equal(t, int64(2), flow.ReversePacketTotalCount())
+	reverseStart, err := flow.ReverseFlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473400766+30), reverseStart)
+
+	//uniflow records aren't marked as biflows
+	delete(testData["netflow"].(bson.M), "reverseOctetTotalCount")
+	delete(testData["netflow"].(bson.M), "reversePacketTotalCount")
+	flow = new(Flow)
+	err = flowDeserializer.DeserializeNextBSONMap(testData, flow)
+	require.Nil(t, err)
+	require.False(t, flow.IsBidirectional())
+}
+
 func TestUptimeRelativeTimestamps(t *testing.T) {
 	initTimeMap := bson.M{
 		"_id": bson.ObjectId("5c06f8a7fe8088957d0000c5"),
@@ -170,7 +211,7 @@ func TestUptimeRelativeTimestamps(t *testing.T) {
 	//an error will be returned as flow should not have been filled.
 	require.NotNil(t, error1)
 
-	initTime, initTimeOk := flowDeserializer.ipfixExporterAbsUptimes["172.22.0.1"]
+	initTime, initTimeOk := flowDeserializer.ipfixExporterAbsUptimes[observationDomain{host: "172.22.0.1"}]
 	require.True(t, initTimeOk)
 	require.Equal(t, int64(1539907077250), initTime)
 
@@ -184,6 +225,106 @@ func TestUptimeRelativeTimestamps(t *testing.T) {
 	require.Equal(t, int64(1539907077250)+int64(457240959), flowEnd)
 }
 
+//newDomainFlow creates a Logstash record holding an IPFIX flow
+//timed relative to the uptime of an observation domain
+func newDomainFlow(host string, domainID int, startUptime, endUptime int64) bson.M {
+	return bson.M{
+		"_id": bson.NewObjectId(),
+		"netflow": bson.M{
+			"version":                  10,
+			"observationDomainId":      domainID,
+			"sourceIPv4Address":        "23.74.25.192",
+			"sourceTransportPort":      53,
+			"destinationIPv4Address":   "10.55.200.10",
+			"destinationTransportPort": 55539,
+			"protocolIdentifier":       17,
+			"flowStartSysUpTime":       startUptime,
+			"flowEndSysUpTime":         endUptime,
+			"octetDeltaCount":          384,
+			"packetDeltaCount":         1,
+			"timestamp":                "2018-10-24T07:09:44Z",
+		},
+		"host": host,
+	}
+}
+
+func TestUptimeObservationDomains(t *testing.T) {
+	newInitTimeMap := func(domainID int, initTime int64) bson.M {
+		return bson.M{
+			"_id": bson.NewObjectId(),
+			"netflow": bson.M{
+				"version":                    10,
+				"observationDomainId":        domainID,
+				"systemInitTimeMilliseconds": initTime,
+			},
+			"host": "172.22.0.1",
+		}
+	}
+
+	flow := Flow{}
+	flowDeserializer := NewFlowDeserializer()
+
+	//two line cards in the same chassis booted at different times
+	require.NotNil(t, flowDeserializer.DeserializeNextBSONMap(newInitTimeMap(1, 1539907077250), &flow))
+	require.NotNil(t, flowDeserializer.DeserializeNextBSONMap(newInitTimeMap(2, 1539907000000), &flow))
+
+	err := flowDeserializer.DeserializeNextBSONMap(newDomainFlow("172.22.0.1", 1, 1000, 2000), &flow)
+	require.Nil(t, err)
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1539907077250)+1000, flowStart)
+
+	err = flowDeserializer.DeserializeNextBSONMap(newDomainFlow("172.22.0.1", 2, 1000, 2000), &flow)
+	require.Nil(t, err)
+	flowStart, err = flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1539907000000)+1000, flowStart)
+
+	//a domain without a known init time falls back to relative timestamps
+	err = flowDeserializer.DeserializeNextBSONMap(newDomainFlow("172.22.0.1", 3, 1000, 2000), &flow)
+	require.Nil(t, err)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1540364984000), flowEnd)
+}
+
+func TestUptimeRelativeRebootPerDomain(t *testing.T) {
+	flow := Flow{}
+	flowDeserializer := NewFlowDeserializer()
+	firstFlowTime := int64(1540364984000)
+
+	for _, domainID := range []int{1, 2} {
+		err := flowDeserializer.DeserializeNextBSONMap(newDomainFlow("172.22.0.1", domainID, 500000, 600000), &flow)
+		require.Nil(t, err)
+		flowEnd, err := flow.FlowEndMilliseconds()
+		require.Nil(t, err)
+		require.Equal(t, firstFlowTime, flowEnd)
+	}
+
+	//later flows are timed against the first flow of their domain
+	err := flowDeserializer.DeserializeNextBSONMap(newDomainFlow("172.22.0.1", 1, 600000, 700000), &flow)
+	require.Nil(t, err)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, firstFlowTime+100000, flowEnd)
+
+	//domain 2 reboots, its uptimes start over
+	reboot := newDomainFlow("172.22.0.1", 2, 1000, 2000)
+	reboot["netflow"].(bson.M)["timestamp"] = "2018-10-24T08:09:44Z"
+	err = flowDeserializer.DeserializeNextBSONMap(reboot, &flow)
+	require.Nil(t, err)
+	flowEnd, err = flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, firstFlowTime+3600000, flowEnd)
+
+	//domain 1 did not reboot
+	err = flowDeserializer.DeserializeNextBSONMap(newDomainFlow("172.22.0.1", 1, 700000, 800000), &flow)
+	require.Nil(t, err)
+	flowEnd, err = flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, firstFlowTime+200000, flowEnd)
+}
+
 func TestFillFromNetflowv9BSONMap(t *testing.T) {
 	inputMap := bson.M{
 		"_id":        bson.ObjectId("5b6b4e2e10a0cf244f0180aa"),
@@ -233,6 +374,13 @@ func TestFillFromNetflowv9BSONMap(t *testing.T) {
 	require.Equal(t, netflowMap["last_switched"], flow.Netflow.FlowEndMilliseconds)
 	//assume end of flow since we don't have the data
 	require.Equal(t, input.EndOfFlow, flow.FlowEndReason())
+	//the quoted @timestamp written by the Logstash MongoDB output
+	require.Equal(t, int64(1533759020000), flow.ReceivedMilliseconds())
+
+	require.Nil(t, flow.ShiftTimestamps(-1000))
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1533759020000), flowStart)
 
 	flow2 := &Flow{}
 	inputMap2 := bson.M{
@@ -0,0 +1,290 @@
+package data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//ErrSkippedRecord is returned by DeserializeNextBSONMap when a record
+//was read successfully but does not produce a flow. For example,
+//NSEL flow creation events don't produce flows on their own.
+var ErrSkippedRecord = errors.New("record does not produce a flow")
+
+//NSEL firewall events as given by the firewallEvent
+//information element (NetFlow v9 fields 233 and 40005)
+const (
+	nselFlowCreated = 1
+	nselFlowDeleted = 2
+	nselFlowDenied  = 3
+	nselFlowUpdate  = 5
+)
+
+//maxPendingNSELConnections is the number of connections which may be
+//held waiting for their teardown events before stale connections
+//are discarded
+const maxPendingNSELConnections = 1 << 16
+
+//nselEvictToConnections is the number of connections left waiting
+//for their teardown events if too many connections are held and
+//none of them are stale. Evicting down to less than the maximum keeps
+//the connections from being sorted each time a new connection arrives.
+const nselEvictToConnections = maxPendingNSELConnections * 3 / 4
+
+//nselPendingTimeout is how long a connection may go without an event
+//before it is considered stale
+const nselPendingTimeout = int64(24 * time.Hour / time.Millisecond)
+
+//The names Logstash may give the NSEL fields. The first name
+//present in a record is used.
+var (
+	nselEventFields            = []string{"fw_event", "firewallEvent"}
+	nselConnIDFields           = []string{"conn_id", "connectionId", "flowId"}
+	nselEventTimeFields        = []string{"event_time_msec", "observationTimeMilliseconds", "eventTimeMilliseconds"}
+	nselFlowStartFields        = []string{"flow_start_msec", "flowStartMilliseconds"}
+	nselInitiatorOctetsFields  = []string{"initiatorOctets", "fwd_flow_delta_bytes"}
+	nselResponderOctetsFields  = []string{"responderOctets", "rev_flow_delta_bytes"}
+	nselInitiatorPacketsFields = []string{"initiatorPackets"}
+	nselResponderPacketsFields = []string{"responderPackets"}
+)
+
+//NSELOptions determines how Cisco ASA NSEL event records are read
+type NSELOptions struct {
+	//PairEvents holds flow creation and update events until the
+	//connection is torn down. Otherwise, only teardown events are read.
+	PairEvents bool
+	//DropDenied skips denied connection events. Otherwise, denied
+	//connection events produce flows marked as denied.
+	DropDenied bool
+}
+
+//nselKey identifies a connection reported by an ASA
+type nselKey struct {
+	host   string
+	connID int64
+}
+
+//nselConnection holds the events seen for a connection which
+//has not been torn down
+type nselConnection struct {
+	flowStart        int64
+	lastEvent        int64
+	initiatorOctets  int64
+	responderOctets  int64
+	initiatorPackets int64
+	responderPackets int64
+}
+
+//isNSELRecord returns true if the NetFlow v9 record is an NSEL event
+func isNSELRecord(netflowMap bson.M) bool {
+	_, ok := firstField(netflowMap, nselEventFields)
+	return ok
+}
+
+//firstField returns the first of the given fields present in the map
+func firstField(netflowMap bson.M, fields []string) (interface{}, bool) {
+	for _, field := range fields {
+		value, ok := netflowMap[field]
+		if ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+//firstInt64Field converts the first of the given fields present in the map
+//to an int64. The default value is returned if none of the fields are present.
+func firstInt64Field(netflowMap bson.M, fields []string, defaultValue int64) (int64, error) {
+	valueIface, ok := firstField(netflowMap, fields)
+	if !ok {
+		return defaultValue, nil
+	}
+	return iFaceToInt64(valueIface)
+}
+
+//firstTimeField converts the first of the given fields present in the map
+//to a Unix timestamp in milliseconds. Logstash may give the times as
+//integer milliseconds or RFC3339 strings. ok is false if none of the
+//fields are present.
+func firstTimeField(netflowMap bson.M, fields []string) (millis int64, ok bool, err error) {
+	valueIface, ok := firstField(netflowMap, fields)
+	if !ok {
+		return 0, false, nil
+	}
+	if timeStr, isStr := valueIface.(string); isStr {
+		t, err := time.Parse(time.RFC3339Nano, timeStr)
+		if err != nil {
+			return 0, true, errors.WithStack(err)
+		}
+		return t.UnixNano() / int64(time.Millisecond), true, nil
+	}
+	millis, err = iFaceToInt64(valueIface)
+	return millis, true, err
+}
+
+//formatMilliseconds formats a Unix timestamp in milliseconds
+//the way Logstash formats Netflow v9 timestamps
+func formatMilliseconds(millis int64) string {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+}
+
+//fillFromNSELBSONMap reads a Cisco ASA NSEL event record and inserts
+//the connection it describes into the output flow. NSEL records describe
+//both directions of a connection, so the output flow is a biflow running
+//from the initiator to the responder. ErrSkippedRecord is returned for
+//events which don't produce a flow.
+func (f *FlowDeserializer) fillFromNSELBSONMap(netflowMap bson.M, outputFlow *Flow, host string) error {
+	event, err := firstInt64Field(netflowMap, nselEventFields, 0)
+	if err != nil {
+		return err
+	}
+
+	eventTime, eventTimeOk, err := firstTimeField(netflowMap, nselEventTimeFields)
+	if err != nil {
+		return err
+	}
+	if !eventTimeOk {
+		return errors.New("NSEL records must contain key 'netflow.event_time_msec'")
+	}
+
+	flowStart, flowStartOk, err := firstTimeField(netflowMap, nselFlowStartFields)
+	if err != nil {
+		return err
+	}
+
+	initiatorOctets, err := firstInt64Field(netflowMap, nselInitiatorOctetsFields, 0)
+	if err != nil {
+		return err
+	}
+	responderOctets, err := firstInt64Field(netflowMap, nselResponderOctetsFields, 0)
+	if err != nil {
+		return err
+	}
+	initiatorPackets, err := firstInt64Field(netflowMap, nselInitiatorPacketsFields, 0)
+	if err != nil {
+		return err
+	}
+	responderPackets, err := firstInt64Field(netflowMap, nselResponderPacketsFields, 0)
+	if err != nil {
+		return err
+	}
+
+	//events can only be paired if the connection ID is present
+	connIDIface, pairable := firstField(netflowMap, nselConnIDFields)
+	var key nselKey
+	if pairable {
+		connID, err := iFaceToInt64(connIDIface)
+		if err != nil {
+			return err
+		}
+		key = nselKey{host: host, connID: connID}
+	}
+	pairable = pairable && f.nselOptions.PairEvents
+
+	switch event {
+	case nselFlowCreated, nselFlowUpdate:
+		if !pairable {
+			return ErrSkippedRecord
+		}
+		conn, ok := f.nselConnections[key]
+		if !ok {
+			f.evictStaleNSELConnections(eventTime)
+			conn = &nselConnection{flowStart: eventTime}
+			f.nselConnections[key] = conn
+		}
+		if flowStartOk {
+			conn.flowStart = flowStart
+		}
+		conn.lastEvent = eventTime
+		//update events carry the counts since the last update
+		conn.initiatorOctets += initiatorOctets
+		conn.responderOctets += responderOctets
+		conn.initiatorPackets += initiatorPackets
+		conn.responderPackets += responderPackets
+		return ErrSkippedRecord
+
+	case nselFlowDeleted:
+		if pairable {
+			conn, ok := f.nselConnections[key]
+			if ok {
+				delete(f.nselConnections, key)
+				if !flowStartOk {
+					flowStart = conn.flowStart
+					flowStartOk = true
+				}
+				initiatorOctets += conn.initiatorOctets
+				responderOctets += conn.responderOctets
+				initiatorPackets += conn.initiatorPackets
+				responderPackets += conn.responderPackets
+			}
+		}
+
+	case nselFlowDenied:
+		if f.nselOptions.DropDenied {
+			return ErrSkippedRecord
+		}
+		//denied connections never start
+		flowStart = eventTime
+		flowStartOk = true
+
+	default:
+		return ErrSkippedRecord
+	}
+
+	if !flowStartOk {
+		flowStart = eventTime
+	}
+
+	//the rest of the record is read like any other Netflow v9 record
+	v9Map := make(bson.M, len(netflowMap)+4)
+	for fieldName, value := range netflowMap {
+		v9Map[fieldName] = value
+	}
+	v9Map["first_switched"] = formatMilliseconds(flowStart)
+	v9Map["last_switched"] = formatMilliseconds(eventTime)
+	v9Map["in_bytes"] = initiatorOctets
+	v9Map["in_pkts"] = initiatorPackets
+
+	err = f.fillFromNetflowv9BSONMap(v9Map, outputFlow)
+	if err != nil {
+		return err
+	}
+	outputFlow.Netflow.Bidirectional = true
+	outputFlow.Netflow.ReverseOctetTotalCount = responderOctets
+	outputFlow.Netflow.ReversePacketTotalCount = responderPackets
+	outputFlow.Netflow.Denied = event == nselFlowDenied
+	return nil
+}
+
+//evictStaleNSELConnections discards the connections which have gone
+//without an event for nselPendingTimeout once too many connections are
+//waiting for their teardown events. If none of the connections are stale,
+//such as when teardown events are lost, the connections which have gone
+//without an event the longest are discarded instead. The teardown events
+//for these connections are read as if the events had not been paired.
+func (f *FlowDeserializer) evictStaleNSELConnections(eventTime int64) {
+	if len(f.nselConnections) < maxPendingNSELConnections {
+		return
+	}
+	for key, conn := range f.nselConnections {
+		if eventTime-conn.lastEvent > nselPendingTimeout {
+			delete(f.nselConnections, key)
+		}
+	}
+	if len(f.nselConnections) < maxPendingNSELConnections {
+		return
+	}
+
+	keys := make([]nselKey, 0, len(f.nselConnections))
+	for key := range f.nselConnections {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return f.nselConnections[keys[i]].lastEvent < f.nselConnections[keys[j]].lastEvent
+	})
+	for _, key := range keys[:len(keys)-nselEvictToConnections] {
+		delete(f.nselConnections, key)
+	}
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/globalsign/mgo/bson"
+	"github.com/stretchr/testify/require"
+)
+
+//newNSELRecord creates a Logstash record holding an NSEL event
+//for a connection from 192.168.1.10 to 8.8.8.8
+func newNSELRecord(event int, connID int64, eventTime int64) bson.M {
+	return bson.M{
+		"_id":  bson.NewObjectId(),
+		"host": "10.0.0.1",
+		"netflow": bson.M{
+			"version":         9,
+			"fw_event":        event,
+			"conn_id":         connID,
+			"ipv4_src_addr":   "192.168.1.10",
+			"l4_src_port":     51000,
+			"ipv4_dst_addr":   "8.8.8.8",
+			"l4_dst_port":     53,
+			"protocol":        int(protocols.UDP),
+			"event_time_msec": eventTime,
+		},
+	}
+}
+
+func TestNSELTeardown(t *testing.T) {
+	flowDeserializer := NewFlowDeserializer()
+	var flow Flow
+
+	//creation events are skipped unless events are paired
+	err := flowDeserializer.DeserializeNextBSONMap(newNSELRecord(nselFlowCreated, 1, 1533759021000), &flow)
+	require.Equal(t, ErrSkippedRecord, err)
+
+	teardown := newNSELRecord(nselFlowDeleted, 1, 1533759025000)
+	netflowMap := teardown["netflow"].(bson.M)
+	netflowMap["flow_start_msec"] = "2018-08-08T20:10:21.000Z"
+	netflowMap["initiatorOctets"] = 120
+	netflowMap["responderOctets"] = 360
+	err = flowDeserializer.DeserializeNextBSONMap(teardown, &flow)
+	require.Nil(t, err)
+
+	require.Equal(t, "192.168.1.10", flow.SourceIPAddress())
+	require.Equal(t, "8.8.8.8", flow.DestinationIPAddress())
+	require.Equal(t, uint16(51000), flow.SourcePort())
+	require.Equal(t, uint16(53), flow.DestinationPort())
+	require.Equal(t, protocols.UDP, flow.ProtocolIdentifier())
+	require.Equal(t, input.EndOfFlow, flow.FlowEndReason())
+
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1533759021000), flowStart)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1533759025000), flowEnd)
+
+	require.True(t, input.IsBidirectional(&flow))
+	require.False(t, input.IsDenied(&flow))
+	require.Equal(t, int64(120), flow.OctetTotalCount())
+	require.Equal(t, int64(360), flow.ReverseOctetTotalCount())
+	reverseStart, err := flow.ReverseFlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, flowStart, reverseStart)
+
+	//the biflow fields don't leak into the next record
+	err = flowDeserializer.DeserializeNextBSONMap(newNetflowv9Record(), &flow)
+	require.Nil(t, err)
+	require.False(t, input.IsBidirectional(&flow))
+}
+
+func TestNSELPairEvents(t *testing.T) {
+	flowDeserializer := NewFlowDeserializer()
+	flowDeserializer.nselOptions.PairEvents = true
+	var flow Flow
+
+	err := flowDeserializer.DeserializeNextBSONMap(newNSELRecord(nselFlowCreated, 7, 1533759021000), &flow)
+	require.Equal(t, ErrSkippedRecord, err)
+
+	update := newNSELRecord(nselFlowUpdate, 7, 1533759023000)
+	update["netflow"].(bson.M)["fwd_flow_delta_bytes"] = 100
+	update["netflow"].(bson.M)["rev_flow_delta_bytes"] = 200
+	err = flowDeserializer.DeserializeNextBSONMap(update, &flow)
+	require.Equal(t, ErrSkippedRecord, err)
+
+	//events for other connections are kept apart
+	other := newNSELRecord(nselFlowDeleted, 8, 1533759024000)
+	err = flowDeserializer.DeserializeNextBSONMap(other, &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), flow.OctetTotalCount())
+
+	teardown := newNSELRecord(nselFlowDeleted, 7, 1533759025000)
+	teardown["netflow"].(bson.M)["fwd_flow_delta_bytes"] = 20
+	teardown["netflow"].(bson.M)["rev_flow_delta_bytes"] = 40
+	err = flowDeserializer.DeserializeNextBSONMap(teardown, &flow)
+	require.Nil(t, err)
+
+	//the start time comes from the creation event
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1533759021000), flowStart)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1533759025000), flowEnd)
+	require.Equal(t, int64(120), flow.OctetTotalCount())
+	require.Equal(t, int64(240), flow.ReverseOctetTotalCount())
+	require.Len(t, flowDeserializer.nselConnections, 0)
+}
+
+func TestNSELPendingConnectionsBounded(t *testing.T) {
+	flowDeserializer := NewFlowDeserializer()
+	eventTime := int64(1533759021000)
+
+	//the teardown events were lost, but none of the connections are stale
+	for i := 0; i < maxPendingNSELConnections; i++ {
+		flowDeserializer.nselConnections[nselKey{host: "10.0.0.1", connID: int64(i)}] = &nselConnection{
+			flowStart: eventTime,
+			lastEvent: eventTime + int64(i),
+		}
+	}
+	flowDeserializer.evictStaleNSELConnections(eventTime + maxPendingNSELConnections)
+
+	//the connections which went without an event the longest are discarded
+	require.Len(t, flowDeserializer.nselConnections, nselEvictToConnections)
+	evicted := maxPendingNSELConnections - nselEvictToConnections
+	require.NotContains(t, flowDeserializer.nselConnections, nselKey{host: "10.0.0.1", connID: int64(evicted - 1)})
+	require.Contains(t, flowDeserializer.nselConnections, nselKey{host: "10.0.0.1", connID: int64(evicted)})
+}
+
+func TestNSELDenied(t *testing.T) {
+	flowDeserializer := NewFlowDeserializer()
+	var flow Flow
+
+	err := flowDeserializer.DeserializeNextBSONMap(newNSELRecord(nselFlowDenied, 3, 1533759021000), &flow)
+	require.Nil(t, err)
+	require.True(t, input.IsDenied(&flow))
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, flowStart, flowEnd)
+
+	flowDeserializer.nselOptions.DropDenied = true
+	err = flowDeserializer.DeserializeNextBSONMap(newNSELRecord(nselFlowDenied, 4, 1533759021000), &flow)
+	require.Equal(t, ErrSkippedRecord, err)
+}
+
+//newNetflowv9Record creates a Logstash record holding
+//an ordinary Netflow v9 flow
+func newNetflowv9Record() bson.M {
+	return bson.M{
+		"_id":  bson.NewObjectId(),
+		"host": "10.0.0.2",
+		"netflow": bson.M{
+			"version":        9,
+			"ipv4_src_addr":  "192.168.1.10",
+			"l4_src_port":    51000,
+			"ipv4_dst_addr":  "8.8.8.8",
+			"l4_dst_port":    53,
+			"protocol":       int(protocols.UDP),
+			"first_switched": "2018-08-08T20:10:21.000Z",
+			"last_switched":  "2018-08-08T20:10:21.000Z",
+			"in_bytes":       60,
+			"in_pkts":        1,
+		},
+	}
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"github.com/activecm/ipfix-rita/converter/config"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//The names Logstash gives the fields announcing 1-in-N sampling rates.
+//Netflow v5 records carry the rate of the exporter in every record.
+//Netflow v9 and IPFIX exporters announce their rates in options
+//records, though IPFIX exporters may also place the rate in flow records.
+var samplingRateFields = []string{
+	"sampling_interval", "flow_sampler_random_interval",
+	"samplingInterval", "samplerRandomInterval",
+}
+
+//samplingPacketIntervalField and samplingPacketSpaceField describe
+//systematic count-based sampling (RFC 5477). Interval packets are
+//selected, then space packets are skipped. If the space is not
+//given, the interval is read as a 1-in-N sampling rate.
+const (
+	samplingPacketIntervalField = "samplingPacketInterval"
+	samplingPacketSpaceField    = "samplingPacketSpace"
+)
+
+//samplerIDFields name the fields identifying the sampler which
+//selected the packets in a flow. Exporters which run several samplers
+//announce the rate of each sampler separately.
+var samplerIDFields = []string{"flow_sampler_id", "samplerId", "selectorId"}
+
+//flowAddressFields name the fields only found in flow records.
+//Records announcing sampling rates without these fields
+//are options records.
+var flowAddressFields = []string{
+	"ipv4_src_addr", "ipv6_src_addr",
+	"sourceIPv4Address", "sourceIPv6Address",
+}
+
+//samplerKey identifies a sampler on an exporting host.
+//Rates announced without a sampler ID are stored under samplerID 0
+//and apply to each sampler on the host without a rate of its own.
+type samplerKey struct {
+	host      string
+	samplerID int64
+}
+
+//newSamplingOverrides checks the configured sampling rates
+func newSamplingOverrides(conf config.Sampling) (map[string]int64, error) {
+	overrides := make(map[string]int64, len(conf.GetExporters()))
+	for exporter, rate := range conf.GetExporters() {
+		if rate < 1 {
+			return nil, errors.Errorf("the sampling rate for exporter %s must be at least 1", exporter)
+		}
+		overrides[exporter] = rate
+	}
+	return overrides, nil
+}
+
+//readSamplingRate reads the 1-in-N sampling rate announced in a record.
+//ok is false if the record does not announce a rate.
+func readSamplingRate(netflowMap bson.M) (rate int64, ok bool, err error) {
+	if intervalIface, intervalOk := netflowMap[samplingPacketIntervalField]; intervalOk {
+		interval, err := numberToInt64(intervalIface)
+		if err != nil {
+			return 0, true, err
+		}
+		if spaceIface, spaceOk := netflowMap[samplingPacketSpaceField]; spaceOk && interval > 0 {
+			space, err := numberToInt64(spaceIface)
+			if err != nil {
+				return 0, true, err
+			}
+			interval = (interval + space) / interval
+		}
+		return interval, true, nil
+	}
+
+	rateIface, ok := firstField(netflowMap, samplingRateFields)
+	if !ok {
+		return 0, false, nil
+	}
+	rate, err = numberToInt64(rateIface)
+	return rate, true, err
+}
+
+//updateSamplingRates records the sampling rate announced in an options
+//record. isOptionsRecord is true if the record only announces a sampling
+//rate and does not hold a flow.
+func (f *FlowDeserializer) updateSamplingRates(netflowMap bson.M, host string) (isOptionsRecord bool, err error) {
+	rate, ok, err := readSamplingRate(netflowMap)
+	if err != nil || !ok {
+		return false, err
+	}
+	if _, isFlow := firstField(netflowMap, flowAddressFields); isFlow {
+		return false, nil
+	}
+
+	samplerID, err := firstInt64Field(netflowMap, samplerIDFields, 0)
+	if err != nil {
+		return true, err
+	}
+	key := samplerKey{host: host, samplerID: samplerID}
+
+	//a rate of 0 means the sampler was disabled
+	if rate <= 1 {
+		delete(f.samplingRates, key)
+	} else {
+		f.samplingRates[key] = rate
+	}
+	return true, nil
+}
+
+//samplingRate returns the 1-in-N sampling rate for a flow record.
+//Rates given in the flow record take precedence over the rates announced
+//for the record's sampler, which take precedence over the rates announced
+//for the exporter. The configured rate for the exporter is used
+//if the exporter does not announce any rates.
+func (f *FlowDeserializer) samplingRate(netflowMap bson.M, host string) (int64, error) {
+	rate, ok, err := readSamplingRate(netflowMap)
+	if err != nil {
+		return 0, err
+	}
+	if ok && rate > 1 {
+		return rate, nil
+	}
+
+	samplerID, err := firstInt64Field(netflowMap, samplerIDFields, 0)
+	if err != nil {
+		return 0, err
+	}
+	if rate, ok := f.samplingRates[samplerKey{host: host, samplerID: samplerID}]; ok {
+		return rate, nil
+	}
+	if rate, ok := f.samplingRates[samplerKey{host: host}]; ok {
+		return rate, nil
+	}
+	if rate, ok := f.samplingOverrides[host]; ok {
+		return rate, nil
+	}
+	return 1, nil
+}
+
+//scaleCounts multiplies the packet and byte counts of a flow
+//by the sampling rate used to observe it
+func (i *Flow) scaleCounts(rate int64) {
+	i.Netflow.OctetTotalCount *= rate
+	i.Netflow.PacketTotalCount *= rate
+	i.Netflow.ReverseOctetTotalCount *= rate
+	i.Netflow.ReversePacketTotalCount *= rate
+}
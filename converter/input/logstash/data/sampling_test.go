@@ -0,0 +1,89 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/stretchr/testify/require"
+)
+
+//newSamplingOptionsRecord creates a Logstash record holding a
+//Netflow v9 options record announcing a sampler's rate
+func newSamplingOptionsRecord(host string, samplerID int, rate int) bson.M {
+	return bson.M{
+		"_id":  bson.NewObjectId(),
+		"host": host,
+		"netflow": bson.M{
+			"version":                      9,
+			"flow_sampler_id":              samplerID,
+			"flow_sampler_mode":            2,
+			"flow_sampler_random_interval": rate,
+		},
+	}
+}
+
+func TestSamplingRates(t *testing.T) {
+	flowDeserializer := NewFlowDeserializer()
+	flowDeserializer.samplingOverrides["10.0.0.3"] = 10
+	var flow Flow
+
+	//unsampled exporters are left alone
+	record := newNetflowv9Record()
+	err := flowDeserializer.DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(60), flow.OctetTotalCount())
+	require.Equal(t, int64(1), flow.PacketTotalCount())
+
+	//options records are skipped
+	err = flowDeserializer.DeserializeNextBSONMap(newSamplingOptionsRecord("10.0.0.2", 0, 100), &flow)
+	require.Equal(t, ErrSkippedRecord, err)
+	err = flowDeserializer.DeserializeNextBSONMap(newSamplingOptionsRecord("10.0.0.2", 2, 1000), &flow)
+	require.Equal(t, ErrSkippedRecord, err)
+
+	//the exporter's rate applies to flows without a sampler of their own
+	err = flowDeserializer.DeserializeNextBSONMap(newNetflowv9Record(), &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(6000), flow.OctetTotalCount())
+	require.Equal(t, int64(100), flow.PacketTotalCount())
+
+	record = newNetflowv9Record()
+	record["netflow"].(bson.M)["flow_sampler_id"] = 2
+	err = flowDeserializer.DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(60000), flow.OctetTotalCount())
+
+	record = newNetflowv9Record()
+	record["netflow"].(bson.M)["flow_sampler_id"] = 3
+	err = flowDeserializer.DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(6000), flow.OctetTotalCount())
+
+	//the configured rate is used for exporters which don't announce theirs
+	record = newNetflowv9Record()
+	record["host"] = "10.0.0.3"
+	err = flowDeserializer.DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(600), flow.OctetTotalCount())
+
+	//IPFIX flow records may carry their own rates
+	record = newVendorRecord("10.0.0.2")
+	netflowMap := record["netflow"].(bson.M)
+	netflowMap["flowStartMilliseconds"] = "2018-05-04T22:36:40.000Z"
+	netflowMap["octetTotalCount"] = int64(50)
+	netflowMap["samplingPacketInterval"] = 1
+	netflowMap["samplingPacketSpace"] = 9
+	err = flowDeserializer.DeserializeNextBSONMap(record, &flow)
+	require.Nil(t, err)
+	require.Equal(t, int64(500), flow.OctetTotalCount())
+	require.Equal(t, int64(100), flow.PacketTotalCount())
+}
+
+func TestSamplingOverridesInvalid(t *testing.T) {
+	_, err := newSamplingOverrides(testSampling{"10.0.0.1": 0})
+	require.NotNil(t, err)
+}
+
+//testSampling implements config.Sampling
+type testSampling map[string]int64
+
+func (t testSampling) GetExporters() map[string]int64 { return t }
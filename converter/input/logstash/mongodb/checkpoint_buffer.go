@@ -0,0 +1,155 @@
+package mongodb
+
+import (
+	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/quarantine"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	mgo "github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//checkpointBuffer reads records from the input collection in order
+//of their _id fields without removing them. The records are removed
+//by the Checkpointer once the flows read from them have been
+//stitched and written out.
+type checkpointBuffer struct {
+	input        *mgo.Collection
+	checkpointer *Checkpointer
+	quarantine   quarantine.Quarantine
+	buffer       []bson.M
+	readIndex    int
+	//lastRead is the _id of the last record fetched from the input collection
+	lastRead bson.ObjectId
+	err      error
+	log      logging.Logger
+	*data.FlowDeserializer
+}
+
+//NewCheckpointBuffer returns an ipfix.Buffer backed by MongoDB and fed by
+//Logstash which only removes records from the input collection once
+//the flows read from them have been acknowledged. Reading starts
+//after the Checkpointer's last checkpoint. Records are decoded by
+//the given FlowDeserializer. Records which can't be decoded are
+//given to the Quarantine.
+func NewCheckpointBuffer(input *mgo.Collection, checkpointer *Checkpointer,
+	deserializer *data.FlowDeserializer, quarantine quarantine.Quarantine,
+	bufferSize int64, log logging.Logger) Buffer {
+	return &checkpointBuffer{
+		input:            input,
+		checkpointer:     checkpointer,
+		quarantine:       quarantine,
+		buffer:           make([]bson.M, 0, bufferSize),
+		lastRead:         checkpointer.LastCheckpoint(),
+		log:              log,
+		FlowDeserializer: deserializer,
+	}
+}
+
+//Next returns the next record that was inserted into the input collection.
+//Next returns false if there is no more data. Next may set an error when
+//it returns false. This error can be read with Err()
+func (b *checkpointBuffer) Next(out *data.Flow) bool {
+	b.err = nil
+
+	//loop until we have a good record stored in out
+	for {
+		//if we are at the end of the buffer
+		//the buffer length starts at zero
+		if b.readIndex == len(b.buffer) {
+			if !b.refill() {
+				return false
+			}
+		}
+
+		inputMap := b.buffer[b.readIndex]
+		b.readIndex++
+
+		id, ok := inputMap["_id"].(bson.ObjectId)
+		if !ok {
+			b.log.Error(errors.New("input record does not have an ObjectId _id"), logging.Fields{"inputMap": inputMap})
+			continue
+		}
+		b.checkpointer.track(id)
+
+		err := b.FlowDeserializer.DeserializeNextBSONMap(inputMap, out)
+		if err == nil {
+			return true
+		}
+		if err == data.ErrSkippedRecord {
+			//the record was read but won't make it to the output
+			b.checkpointer.Acknowledge(id)
+			continue
+		}
+		exporter, _ := inputMap["host"].(string)
+		b.log.Error(err, logging.Fields{"inputMap": inputMap, "exporter": exporter})
+		quarantineErr := b.quarantine.Add(exporter, inputMap, err)
+		if quarantineErr != nil {
+			//leave the record unacknowledged so it isn't deleted.
+			//The record will be quarantined again after a restart.
+			b.err = quarantineErr
+			return false
+		}
+		//the record won't make it to the output
+		b.checkpointer.Acknowledge(id)
+	}
+}
+
+//refill saves a checkpoint and fetches the next batch of records.
+//refill returns false if no records were found.
+func (b *checkpointBuffer) refill() bool {
+	//checkpoint whenever we poll for new data so the
+	//input collection is cleared out as we go
+	err := b.checkpointer.Checkpoint()
+	if err != nil {
+		b.err = err
+		return false
+	}
+
+	var query interface{}
+	if b.lastRead != "" {
+		query = bson.M{"_id": bson.M{"$gt": b.lastRead}}
+	}
+
+	//clear the buffer
+	b.buffer = b.buffer[:0]
+	b.readIndex = 0
+
+	//refill the buffer
+	err = b.input.Find(query).Sort("_id").Batch(cap(b.buffer)).Limit(cap(b.buffer)).All(&b.buffer)
+	if err != nil {
+		if err != mgo.ErrNotFound {
+			b.err = errors.Wrap(err, "could not fetch next batch of records from input collection")
+		}
+		return false
+	}
+
+	//nothing found
+	if len(b.buffer) == 0 {
+		return false
+	}
+
+	lastID, ok := b.buffer[len(b.buffer)-1]["_id"].(bson.ObjectId)
+	if !ok {
+		b.err = errors.Errorf("input record has an invalid _id: %+v", b.buffer[len(b.buffer)-1]["_id"])
+		return false
+	}
+	b.lastRead = lastID
+	return true
+}
+
+//Acknowledge marks the record with the given _id as fully processed
+func (b *checkpointBuffer) Acknowledge(id bson.ObjectId) {
+	b.checkpointer.Acknowledge(id)
+}
+
+//Err returns any errors set by Read()
+func (b *checkpointBuffer) Err() error {
+	return b.err
+}
+
+//Close closes the socket to the MongoDB server. The Checkpointer
+//must be closed separately once the output has finished writing.
+func (b *checkpointBuffer) Close() {
+	b.input.Database.Session.Close()
+}
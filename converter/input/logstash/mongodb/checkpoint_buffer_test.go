@@ -0,0 +1,93 @@
+package mongodb_test
+
+import (
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/environment"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/mongodb"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/quarantine"
+	"github.com/activecm/ipfix-rita/converter/integrationtest"
+	"github.com/globalsign/mgo/bson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointBuffer(t *testing.T) {
+	fixtures := fixturesManager.BeginTest(t)
+	defer fixturesManager.EndTest(t)
+	env := fixtures.GetWithSkip(t, integrationtest.EnvironmentFixture.Key).(environment.Environment)
+	inputDB := fixtures.GetWithSkip(t, inputDBTestFixture.Key).(mongodb.LogstashMongoInputDB)
+
+	checkpointColl := inputDB.NewHelperCollection("checkpoints")
+	defer checkpointColl.Database.Session.Close()
+	defer checkpointColl.DropCollection()
+
+	c := inputDB.NewInputConnection()
+	defer c.Database.Session.Close()
+	for _, testFlow := range []*data.Flow{testFlow1, testFlow2} {
+		flowCopy := *testFlow
+		flowCopy.ID = ""
+		require.Nil(t, c.Insert(&flowCopy))
+	}
+
+	checkpointer, err := mongodb.NewCheckpointer(
+		inputDB.NewInputConnection(), inputDB.NewHelperCollection("checkpoints"), env.Logger,
+	)
+	require.Nil(t, err)
+	buffer := mongodb.NewCheckpointBuffer(
+		inputDB.NewInputConnection(), checkpointer, data.NewFlowDeserializer(),
+		quarantine.NewNullQuarantine(), 1000, env.Logger,
+	)
+
+	var flow1, flow2, flow3 data.Flow
+	require.True(t, buffer.Next(&flow1))
+	require.True(t, buffer.Next(&flow2))
+	require.False(t, buffer.Next(&flow3))
+	require.Nil(t, buffer.Err())
+	buffer.Close()
+
+	//reading the records doesn't remove them
+	count, err := c.Count()
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+
+	//the checkpoint can't pass the first record until it is acknowledged
+	checkpointer.Acknowledge(flow2.ID)
+	require.Nil(t, checkpointer.Checkpoint())
+	count, err = c.Count()
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+
+	checkpointer.Acknowledge(flow1.ID)
+	require.Nil(t, checkpointer.Close())
+	count, err = c.Count()
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+
+	//resume from the checkpoint
+	flowCopy := *testFlow3
+	flowCopy.ID = ""
+	require.Nil(t, c.Insert(&flowCopy))
+
+	checkpointer, err = mongodb.NewCheckpointer(
+		inputDB.NewInputConnection(), inputDB.NewHelperCollection("checkpoints"), env.Logger,
+	)
+	require.Nil(t, err)
+	require.Equal(t, flow2.ID, checkpointer.LastCheckpoint())
+	buffer = mongodb.NewCheckpointBuffer(
+		inputDB.NewInputConnection(), checkpointer, data.NewFlowDeserializer(),
+		quarantine.NewNullQuarantine(), 1000, env.Logger,
+	)
+
+	require.True(t, buffer.Next(&flow3))
+	require.True(t, flow3.ID > flow2.ID)
+	require.Equal(t, testFlow3.Host, flow3.Host)
+	require.False(t, buffer.Next(&flow3))
+	buffer.Close()
+
+	checkpointer.Acknowledge(flow3.ID)
+	require.Nil(t, checkpointer.Close())
+	count, err = c.Find(bson.M{"_id": flow3.ID}).Count()
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+}
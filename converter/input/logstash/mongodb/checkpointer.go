@@ -0,0 +1,171 @@
+package mongodb
+
+import (
+	"sync"
+
+	"github.com/activecm/ipfix-rita/converter/logging"
+	mgo "github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//checkpoint is the record stored in the checkpoint collection.
+//LastID is the _id of the last input record which has been
+//stitched and written to the output along with every record before it.
+type checkpoint struct {
+	InputCollection string        `bson:"_id"`
+	LastID          bson.ObjectId `bson:"lastID"`
+}
+
+//Checkpointer keeps track of which input records have been fully
+//processed by the pipeline. The records are tracked in the order they are
+//read from the input collection. Once every record up to a given record
+//has been acknowledged, the given record's _id is saved in the checkpoint
+//collection, and the input records up to and including it are deleted.
+//
+//Since sessions may wait in the stitching matcher for some time, the
+//checkpoint may trail the read position by a fair amount. Records
+//between the checkpoint and the read position are read again after
+//a crash, so they may be written out twice.
+type Checkpointer struct {
+	input       *mgo.Collection
+	checkpoints *mgo.Collection
+
+	//trackMutex protects pending, acknowledged, and lastAcknowledged
+	trackMutex *sync.Mutex
+	//pending holds the _ids which have been read but are either
+	//unacknowledged or follow an unacknowledged _id
+	pending []bson.ObjectId
+	//acknowledged holds the pending _ids which have been acknowledged
+	acknowledged map[bson.ObjectId]bool
+	//lastAcknowledged is the last _id for which
+	//every previous _id has been acknowledged
+	lastAcknowledged bson.ObjectId
+
+	//saveMutex ensures only one checkpoint is saved at a time
+	saveMutex *sync.Mutex
+	lastSaved bson.ObjectId
+
+	log logging.Logger
+}
+
+//NewCheckpointer creates a Checkpointer which tracks the records
+//read from the input collection. The progress is saved in the checkpoints
+//collection. The previously saved checkpoint is loaded and any input
+//records which were left over from the last run are deleted.
+func NewCheckpointer(input *mgo.Collection, checkpoints *mgo.Collection,
+	log logging.Logger) (*Checkpointer, error) {
+
+	c := &Checkpointer{
+		input:        input,
+		checkpoints:  checkpoints,
+		trackMutex:   new(sync.Mutex),
+		acknowledged: make(map[bson.ObjectId]bool),
+		saveMutex:    new(sync.Mutex),
+		log:          log,
+	}
+
+	var saved checkpoint
+	err := checkpoints.FindId(input.Name).One(&saved)
+	if err == mgo.ErrNotFound {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load input checkpoint")
+	}
+
+	c.lastAcknowledged = saved.LastID
+	c.lastSaved = saved.LastID
+
+	//the last run may have saved the checkpoint without
+	//deleting the records it covers
+	_, err = input.RemoveAll(bson.M{"_id": bson.M{"$lte": saved.LastID}})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not remove checkpointed records from input collection")
+	}
+	log.Info("resuming from input checkpoint", logging.Fields{
+		"collection": input.Name,
+		"last ID":    saved.LastID.Hex(),
+	})
+	return c, nil
+}
+
+//LastCheckpoint returns the _id of the last record covered
+//by the checkpoint. Records must be read starting after this _id.
+//The empty ObjectId is returned if there is no checkpoint.
+func (c *Checkpointer) LastCheckpoint() bson.ObjectId {
+	c.saveMutex.Lock()
+	defer c.saveMutex.Unlock()
+	return c.lastSaved
+}
+
+//track registers a record which has been read from the input collection.
+//Records must be tracked in the order they were read.
+func (c *Checkpointer) track(id bson.ObjectId) {
+	c.trackMutex.Lock()
+	c.pending = append(c.pending, id)
+	c.trackMutex.Unlock()
+}
+
+//Acknowledge marks the record with the given _id as fully processed
+func (c *Checkpointer) Acknowledge(id bson.ObjectId) {
+	c.trackMutex.Lock()
+	defer c.trackMutex.Unlock()
+
+	//ObjectIds sort bytewise. Ignore repeated acknowledgements.
+	if id <= c.lastAcknowledged {
+		return
+	}
+	c.acknowledged[id] = true
+
+	//advance past the run of acknowledged records at the front
+	advanced := 0
+	for advanced < len(c.pending) && c.acknowledged[c.pending[advanced]] {
+		delete(c.acknowledged, c.pending[advanced])
+		c.lastAcknowledged = c.pending[advanced]
+		advanced++
+	}
+	//the space at the front of the slice is reclaimed
+	//when append reallocates the slice
+	c.pending = c.pending[advanced:]
+}
+
+//Checkpoint saves the _id of the last record for which every previous
+//record has been acknowledged. The input records covered by the
+//checkpoint are then deleted.
+func (c *Checkpointer) Checkpoint() error {
+	c.saveMutex.Lock()
+	defer c.saveMutex.Unlock()
+
+	c.trackMutex.Lock()
+	lastAcknowledged := c.lastAcknowledged
+	c.trackMutex.Unlock()
+
+	if lastAcknowledged == c.lastSaved {
+		return nil
+	}
+
+	_, err := c.checkpoints.UpsertId(c.input.Name, checkpoint{
+		InputCollection: c.input.Name,
+		LastID:          lastAcknowledged,
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not save input checkpoint")
+	}
+	c.lastSaved = lastAcknowledged
+
+	_, err = c.input.RemoveAll(bson.M{"_id": bson.M{"$lte": lastAcknowledged}})
+	if err != nil {
+		return errors.Wrap(err, "could not remove checkpointed records from input collection")
+	}
+	return nil
+}
+
+//Close saves a final checkpoint and closes the sockets to the MongoDB
+//server. Close should be called after the output has finished writing.
+func (c *Checkpointer) Close() error {
+	err := c.Checkpoint()
+	c.input.Database.Session.Close()
+	c.checkpoints.Database.Session.Close()
+	return err
+}
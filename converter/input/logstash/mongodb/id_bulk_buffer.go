@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/quarantine"
 	"github.com/activecm/ipfix-rita/converter/logging"
 	mgo "github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -16,19 +17,23 @@ import (
 //idBulkBuffer works by selecting and removing the least recently inserted
 //record in an input collection
 type idBulkBuffer struct {
-	input     *mgo.Collection
-	buffer    []bson.M
-	removeWG  *sync.WaitGroup
-	readIndex int
-	err       error
-	log       logging.Logger
+	input      *mgo.Collection
+	quarantine quarantine.Quarantine
+	buffer     []bson.M
+	removeWG   *sync.WaitGroup
+	readIndex  int
+	err        error
+	log        logging.Logger
 	*data.FlowDeserializer
 }
 
-//NewIDBulkBuffer returns an ipfix.Buffer backed by MongoDB and fed by Logstash
-func NewIDBulkBuffer(input *mgo.Collection, bufferSize int64, log logging.Logger) Buffer {
+//NewIDBulkBuffer returns an ipfix.Buffer backed by MongoDB and fed by Logstash.
+//Records which can't be decoded are given to the Quarantine.
+func NewIDBulkBuffer(input *mgo.Collection, quarantine quarantine.Quarantine,
+	bufferSize int64, log logging.Logger) Buffer {
 	return &idBulkBuffer{
 		input:            input,
+		quarantine:       quarantine,
 		buffer:           make([]bson.M, 0, bufferSize),
 		removeWG:         new(sync.WaitGroup),
 		log:              log,
@@ -102,8 +107,14 @@ func (b *idBulkBuffer) Next(out *data.Flow) bool {
 		err := b.FlowDeserializer.DeserializeNextBSONMap(inputMap, out)
 		if err == nil {
 			getNextRecord = false
-		} else {
-			b.log.Error(err, logging.Fields{"inputMap": inputMap})
+		} else if err != data.ErrSkippedRecord {
+			exporter, _ := inputMap["host"].(string)
+			b.log.Error(err, logging.Fields{"inputMap": inputMap, "exporter": exporter})
+			//the record has already been removed from the input collection
+			quarantineErr := b.quarantine.Add(exporter, inputMap, err)
+			if quarantineErr != nil {
+				b.log.Error(quarantineErr, logging.Fields{"inputMap": inputMap})
+			}
 		}
 	}
 
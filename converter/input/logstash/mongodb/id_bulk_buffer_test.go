@@ -6,6 +6,7 @@ import (
 	"github.com/activecm/ipfix-rita/converter/environment"
 	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
 	"github.com/activecm/ipfix-rita/converter/input/logstash/mongodb"
+	"github.com/activecm/ipfix-rita/converter/input/logstash/quarantine"
 	"github.com/activecm/ipfix-rita/converter/integrationtest"
 	"github.com/stretchr/testify/require"
 )
@@ -15,7 +16,7 @@ func TestIDBulkBuffer(t *testing.T) {
 	defer fixturesManager.EndTest(t)
 	env := fixtures.GetWithSkip(t, integrationtest.EnvironmentFixture.Key).(environment.Environment)
 	inputDB := fixtures.GetWithSkip(t, inputDBTestFixture.Key).(mongodb.LogstashMongoInputDB)
-	buffer := mongodb.NewIDBulkBuffer(inputDB.NewInputConnection(), 1000, env.Logger)
+	buffer := mongodb.NewIDBulkBuffer(inputDB.NewInputConnection(), quarantine.NewNullQuarantine(), 1000, env.Logger)
 	testBufferOrder(buffer, inputDB, t)
 }
 
@@ -7,24 +7,37 @@ import (
 	"github.com/activecm/ipfix-rita/converter/input"
 	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
 	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/globalsign/mgo/bson"
 	"github.com/pkg/errors"
 )
 
 //Reader implements input.Reader
 type Reader struct {
-	buffer   Buffer
-	pollWait time.Duration
-	log      logging.Logger
+	buffer      Buffer
+	minPollWait time.Duration
+	maxPollWait time.Duration
+	log         logging.Logger
 }
 
 //NewReader returns a new input.Reader backed by a mgologstash.Buffer.
 //If there is no data in the buffer, the reader will wait for `pollWait`,
 //then try reading the buffer again.
 func NewReader(buffer Buffer, pollWait time.Duration, log logging.Logger) input.Reader {
+	return NewAdaptiveReader(buffer, pollWait, pollWait, log)
+}
+
+//NewAdaptiveReader returns a new input.Reader backed by a mgologstash.Buffer
+//which adapts how often it polls the buffer to the rate data arrives.
+//After data is found, the reader waits for `minPollWait` before reading
+//the buffer again. Each time the buffer is found empty, the wait is
+//doubled, up to `maxPollWait`. This keeps the latency low while
+//data is flowing without hammering MongoDB while the input is idle.
+func NewAdaptiveReader(buffer Buffer, minPollWait, maxPollWait time.Duration, log logging.Logger) input.Reader {
 	return Reader{
-		buffer:   buffer,
-		pollWait: pollWait,
-		log:      log,
+		buffer:      buffer,
+		minPollWait: minPollWait,
+		maxPollWait: maxPollWait,
+		log:         log,
 	}
 }
 
@@ -33,48 +46,92 @@ func (r Reader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
 	out := make(chan input.Flow)
 	errs := make(chan error)
 
-	go func(buffer Buffer, pollWait time.Duration, out chan<- input.Flow, errs chan<- error) {
-		pollTicker := time.NewTicker(pollWait)
-
-		r.drainInner(ctx, buffer, out, errs)
+	go func(buffer Buffer, out chan<- input.Flow, errs chan<- error) {
+		pollWait := r.minPollWait
+		//log when data starts and stops arriving rather than
+		//every time the buffer is polled
+		reportedBusy := false
+		reportedIdle := false
 	Loop:
 		for {
+			if r.drainInner(ctx, buffer, !reportedBusy, out, errs) {
+				reportedBusy = true
+				reportedIdle = false
+				pollWait = r.minPollWait
+			} else {
+				if !reportedIdle {
+					r.log.Info("no data available in input buffer", nil)
+					reportedIdle = true
+					reportedBusy = false
+				}
+				pollWait *= 2
+				if pollWait > r.maxPollWait {
+					pollWait = r.maxPollWait
+				}
+			}
+
+			pollTimer := time.NewTimer(pollWait)
 			select {
 			case <-ctx.Done():
+				pollTimer.Stop()
 				break Loop
-			case <-pollTicker.C:
-				r.drainInner(ctx, buffer, out, errs)
+			case <-pollTimer.C:
 			}
 		}
 		buffer.Close()
-		pollTicker.Stop()
 		close(errs)
 		close(out)
-	}(r.buffer, r.pollWait, out, errs)
+	}(r.buffer, out, errs)
 
 	return out, errs
 }
 
-func (r Reader) drainInner(ctx context.Context, buffer Buffer, out chan<- input.Flow, errs chan<- error) {
+//drainInner reads the buffer until it is empty.
+//drainInner returns true if any data was read.
+func (r Reader) drainInner(ctx context.Context, buffer Buffer, logStart bool,
+	out chan<- input.Flow, errs chan<- error) bool {
+
 	dataFound := false
 	flow := &data.Flow{}
 	for buffer.Next(flow) {
-		if !dataFound {
+		if !dataFound && logStart {
 			r.log.Info("reading new data from input buffer", nil)
-			dataFound = true
 		}
+		dataFound = true
 
-		out <- flow
+		//let buffers which hold onto their records know
+		//when the flows have been processed
+		if ackBuffer, ok := buffer.(acknowledgingBuffer); ok {
+			out <- acknowledgedFlow{Flow: flow, buffer: ackBuffer}
+		} else {
+			out <- flow
+		}
 		//ensure we stop even if there is more data
 		if ctx.Err() != nil {
 			break
 		}
 		flow = &data.Flow{}
 	}
-	if !dataFound {
-		r.log.Info("no data available in input buffer", nil)
-	}
 	if buffer.Err() != nil {
 		errs <- errors.Wrap(buffer.Err(), "could not drain input buffer")
 	}
+	return dataFound
+}
+
+//acknowledgingBuffer is implemented by Buffers which must be
+//told when the flows read from them have been fully processed
+type acknowledgingBuffer interface {
+	Acknowledge(id bson.ObjectId)
+}
+
+//acknowledgedFlow wraps a flow read from an acknowledgingBuffer,
+//implementing input.Acknowledger
+type acknowledgedFlow struct {
+	*data.Flow
+	buffer acknowledgingBuffer
+}
+
+//Acknowledge lets the buffer know the flow has been fully processed
+func (a acknowledgedFlow) Acknowledge() {
+	a.buffer.Acknowledge(a.Flow.ID)
 }
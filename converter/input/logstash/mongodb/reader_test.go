@@ -14,6 +14,7 @@ import (
 	"github.com/activecm/ipfix-rita/converter/input/logstash/data"
 	"github.com/activecm/ipfix-rita/converter/input/logstash/mongodb"
 	"github.com/activecm/ipfix-rita/converter/integrationtest"
+	"github.com/activecm/ipfix-rita/converter/logging"
 	"github.com/activecm/ipfix-rita/converter/protocols"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/stretchr/testify/require"
@@ -172,3 +173,77 @@ func TestReader(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, 0, count)
 }
+
+//queueBuffer implements mongodb.Buffer using a slice
+//which may be appended to while the buffer is being read
+type queueBuffer struct {
+	mutex  sync.Mutex
+	queue  []*data.Flow
+	polls  int
+	closed bool
+}
+
+func (q *queueBuffer) push(flow *data.Flow) {
+	q.mutex.Lock()
+	q.queue = append(q.queue, flow)
+	q.mutex.Unlock()
+}
+
+func (q *queueBuffer) pollCount() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.polls
+}
+
+func (q *queueBuffer) Next(out *data.Flow) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.polls++
+	if len(q.queue) == 0 {
+		return false
+	}
+	*out = *q.queue[0]
+	q.queue = q.queue[1:]
+	return true
+}
+
+func (q *queueBuffer) Err() error { return nil }
+
+func (q *queueBuffer) Close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+}
+
+func TestAdaptiveReader(t *testing.T) {
+	buff := &queueBuffer{}
+	reader := mongodb.NewAdaptiveReader(buff, time.Millisecond, 50*time.Millisecond, logging.NewTestLogger(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	flows, errs := reader.Drain(ctx)
+
+	//let the reader back off to the max wait
+	time.Sleep(500 * time.Millisecond)
+	idlePolls := buff.pollCount()
+	//1 ms doubled until 50 ms, then 50 ms at a time
+	require.True(t, idlePolls < 20, "reader polled %d times while idle", idlePolls)
+
+	//data is picked up within the max wait
+	for _, testFlow := range []*data.Flow{testFlow1, testFlow2, testFlow3} {
+		buff.push(testFlow)
+		select {
+		case flow := <-flows:
+			require.Equal(t, testFlow.Host, flow.Exporter())
+		case err := <-errs:
+			t.Fatalf("%+v", err)
+		case <-time.After(time.Second):
+			t.Fatal("flow was not read")
+		}
+	}
+
+	cancel()
+	for range flows {
+	}
+	for range errs {
+	}
+	require.True(t, buff.closed)
+}
@@ -0,0 +1,32 @@
+package quarantine
+
+import (
+	mgo "github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//collectionQuarantine inserts the rejected documents into a MongoDB collection
+type collectionQuarantine struct {
+	coll *mgo.Collection
+}
+
+//NewCollectionQuarantine returns a Quarantine which
+//inserts Records into the given collection
+func NewCollectionQuarantine(coll *mgo.Collection) Quarantine {
+	return collectionQuarantine{coll: coll}
+}
+
+func (c collectionQuarantine) Add(exporter string, document bson.M, reason error) error {
+	err := c.coll.Insert(newRecord(exporter, document, reason))
+	if err != nil {
+		return errors.Wrapf(err, "could not insert document into quarantine collection %s", c.coll.Name)
+	}
+	return nil
+}
+
+//Close closes the socket to the MongoDB server
+func (c collectionQuarantine) Close() error {
+	c.coll.Database.Session.Close()
+	return nil
+}
@@ -0,0 +1,53 @@
+package quarantine
+
+import (
+	"os"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//fileQuarantine appends the rejected documents to a file.
+//Each Record is written as a BSON document, the same format
+//used by mongodump, so the file may be loaded with mongorestore.
+type fileQuarantine struct {
+	path  string
+	file  *os.File
+	mutex *sync.Mutex
+}
+
+//NewFileQuarantine returns a Quarantine which appends
+//Records to the file at the given path
+func NewFileQuarantine(path string) (Quarantine, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open quarantine file %s", path)
+	}
+	return &fileQuarantine{
+		path:  path,
+		file:  file,
+		mutex: new(sync.Mutex),
+	}, nil
+}
+
+func (f *fileQuarantine) Add(exporter string, document bson.M, reason error) error {
+	record, err := bson.Marshal(newRecord(exporter, document, reason))
+	if err != nil {
+		return errors.Wrap(err, "could not serialize quarantined document")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, err = f.file.Write(record)
+	if err != nil {
+		return errors.Wrapf(err, "could not write to quarantine file %s", f.path)
+	}
+	return nil
+}
+
+//Close closes the quarantine file
+func (f *fileQuarantine) Close() error {
+	err := f.file.Close()
+	return errors.Wrapf(err, "could not close quarantine file %s", f.path)
+}
@@ -0,0 +1,71 @@
+package quarantine_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input/logstash/quarantine"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+//readRecords reads the BSON documents held in a quarantine file
+func readRecords(t *testing.T, path string) []quarantine.Record {
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+
+	var records []quarantine.Record
+	for len(data) != 0 {
+		require.True(t, len(data) >= 4)
+		length := int(binary.LittleEndian.Uint32(data[0:4]))
+		require.True(t, len(data) >= length)
+		var record quarantine.Record
+		require.Nil(t, bson.Unmarshal(data[:length], &record))
+		records = append(records, record)
+		data = data[length:]
+	}
+	return records
+}
+
+func TestFileQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quarantine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quarantine.bson")
+
+	id := bson.NewObjectId()
+	q, err := quarantine.NewFileQuarantine(path)
+	require.Nil(t, err)
+	require.Nil(t, q.Add("10.0.0.1", bson.M{
+		"_id":     id,
+		"host":    "10.0.0.1",
+		"netflow": bson.M{"version": 10, "vendorField": int64(5)},
+	}, errors.New("missing flowStartMilliseconds")))
+	require.Nil(t, q.Close())
+
+	//the file is appended to
+	q, err = quarantine.NewFileQuarantine(path)
+	require.Nil(t, err)
+	require.Nil(t, q.Add("", bson.M{"_id": bson.NewObjectId()}, errors.New("missing host")))
+	require.Nil(t, q.Close())
+
+	records := readRecords(t, path)
+	require.Len(t, records, 2)
+
+	require.Equal(t, "10.0.0.1", records[0].Exporter)
+	require.Equal(t, "missing flowStartMilliseconds", records[0].Error)
+	require.False(t, records[0].QuarantinedAt.IsZero())
+	require.Equal(t, id, records[0].Document["_id"])
+	//the document types are preserved for reprocessing
+	netflow, ok := records[0].Document["netflow"].(bson.M)
+	require.True(t, ok)
+	require.Equal(t, 10, netflow["version"])
+	require.Equal(t, int64(5), netflow["vendorField"])
+
+	require.Equal(t, "", records[1].Exporter)
+	require.Equal(t, "missing host", records[1].Error)
+}
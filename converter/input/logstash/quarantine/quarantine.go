@@ -0,0 +1,53 @@
+package quarantine
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+//Record holds a raw Logstash document which could not be decoded along
+//with why it was rejected. The document is kept as it was read from
+//MongoDB so it can be reprocessed once the decoder supports it.
+type Record struct {
+	Exporter      string    `bson:"exporter"`
+	Error         string    `bson:"error"`
+	QuarantinedAt time.Time `bson:"quarantinedAt"`
+	Document      bson.M    `bson:"document"`
+}
+
+//Quarantine sets aside Logstash documents which could not be decoded
+type Quarantine interface {
+	//Add quarantines a document which was rejected for the given reason.
+	//exporter is the address of the exporting process, if it is known.
+	Add(exporter string, document bson.M, reason error) error
+	//Close releases any resources held by the Quarantine
+	Close() error
+}
+
+//newRecord creates a Record for a rejected document
+func newRecord(exporter string, document bson.M, reason error) Record {
+	return Record{
+		Exporter:      exporter,
+		Error:         reason.Error(),
+		QuarantinedAt: time.Now(),
+		Document:      document,
+	}
+}
+
+//nullQuarantine drops the rejected documents
+type nullQuarantine struct{}
+
+//NewNullQuarantine returns a Quarantine which drops the documents
+//given to it. Use this when the documents are only logged.
+func NewNullQuarantine() Quarantine {
+	return nullQuarantine{}
+}
+
+func (n nullQuarantine) Add(string, bson.M, error) error {
+	return nil
+}
+
+func (n nullQuarantine) Close() error {
+	return nil
+}
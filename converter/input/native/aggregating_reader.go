@@ -0,0 +1,157 @@
+package native
+
+import (
+	"context"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/benbjohnson/clock"
+)
+
+//flowKey identifies the flows which may be aggregated together
+type flowKey struct {
+	exporter           string
+	sourceIP           string
+	sourcePort         uint16
+	destinationIP      string
+	destinationPort    uint16
+	protocolIdentifier protocols.Identifier
+}
+
+//AggregatingReader implements input.Reader by combining the flows
+//produced by another Reader which share the same exporter, addresses,
+//ports, and protocol. The aggregated flows are written out at the
+//end of each window. This reduces the number of records produced
+//from packet sampling protocols such as sFlow, which describe
+//each sampled packet separately.
+type AggregatingReader struct {
+	reader input.Reader
+	window time.Duration
+	clock  clock.Clock
+}
+
+//NewAggregatingReader returns a new input.Reader which aggregates
+//the flows produced by the given Reader over the given window
+func NewAggregatingReader(reader input.Reader, window time.Duration, clock clock.Clock) input.Reader {
+	return AggregatingReader{
+		reader: reader,
+		window: window,
+		clock:  clock,
+	}
+}
+
+//Drain asynchronously drains the underlying Reader and aggregates
+//its flows. Any flows held when the underlying Reader finishes
+//are written out before the channels are closed.
+func (a AggregatingReader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	out := make(chan input.Flow)
+	errs := make(chan error)
+
+	go func(out chan<- input.Flow, errs chan<- error) {
+		flows, readerErrs := a.reader.Drain(ctx)
+		aggregates := make(map[flowKey]*Flow)
+		ticker := a.clock.Ticker(a.window)
+
+		flush := func() {
+			for key, flow := range aggregates {
+				out <- flow
+				delete(aggregates, key)
+			}
+		}
+
+		for flows != nil || readerErrs != nil {
+			select {
+			case flow, ok := <-flows:
+				if !ok {
+					flows = nil
+					continue
+				}
+				a.aggregate(aggregates, flow, errs)
+			case err, ok := <-readerErrs:
+				if !ok {
+					readerErrs = nil
+					continue
+				}
+				errs <- err
+			case <-ticker.C:
+				flush()
+			}
+		}
+
+		ticker.Stop()
+		flush()
+		close(errs)
+		close(out)
+	}(out, errs)
+
+	return out, errs
+}
+
+//aggregate adds a flow to the aggregate it belongs to,
+//creating the aggregate if it does not exist
+func (a AggregatingReader) aggregate(aggregates map[flowKey]*Flow, flow input.Flow, errs chan<- error) {
+	flowStart, err := flow.FlowStartMilliseconds()
+	if err != nil {
+		errs <- err
+		return
+	}
+	flowEnd, err := flow.FlowEndMilliseconds()
+	if err != nil {
+		errs <- err
+		return
+	}
+
+	key := flowKey{
+		exporter:           flow.Exporter(),
+		sourceIP:           flow.SourceIPAddress(),
+		sourcePort:         flow.SourcePort(),
+		destinationIP:      flow.DestinationIPAddress(),
+		destinationPort:    flow.DestinationPort(),
+		protocolIdentifier: flow.ProtocolIdentifier(),
+	}
+
+	aggregate, ok := aggregates[key]
+	if !ok {
+		aggregate = &Flow{Host: flow.Exporter()}
+		//input.Flow doesn't distinguish IPv4 from IPv6 addresses
+		if isIPv4(flow.SourceIPAddress()) {
+			aggregate.Netflow.SourceIPv4 = flow.SourceIPAddress()
+		} else {
+			aggregate.Netflow.SourceIPv6 = flow.SourceIPAddress()
+		}
+		if isIPv4(flow.DestinationIPAddress()) {
+			aggregate.Netflow.DestinationIPv4 = flow.DestinationIPAddress()
+		} else {
+			aggregate.Netflow.DestinationIPv6 = flow.DestinationIPAddress()
+		}
+		aggregate.Netflow.SourcePort = flow.SourcePort()
+		aggregate.Netflow.DestinationPort = flow.DestinationPort()
+		aggregate.Netflow.ProtocolIdentifier = flow.ProtocolIdentifier()
+		aggregate.Netflow.FlowStartMilliseconds = flowStart
+		aggregate.Netflow.FlowEndMilliseconds = flowEnd
+		aggregate.Netflow.Version = flow.Version()
+		aggregates[key] = aggregate
+	}
+
+	if flowStart < aggregate.Netflow.FlowStartMilliseconds {
+		aggregate.Netflow.FlowStartMilliseconds = flowStart
+	}
+	if flowEnd > aggregate.Netflow.FlowEndMilliseconds {
+		aggregate.Netflow.FlowEndMilliseconds = flowEnd
+	}
+	aggregate.Netflow.OctetTotalCount += flow.OctetTotalCount()
+	aggregate.Netflow.PacketTotalCount += flow.PacketTotalCount()
+	//the latest end reason describes the aggregate
+	aggregate.Netflow.FlowEndReason = flow.FlowEndReason()
+}
+
+//isIPv4 returns true if the address is written in IPv4 dotted decimal
+func isIPv4(address string) bool {
+	for i := 0; i < len(address); i++ {
+		if address[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
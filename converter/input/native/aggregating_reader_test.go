@@ -0,0 +1,98 @@
+package native_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+//chanReader implements input.Reader by passing along the flows
+//sent on a channel
+type chanReader chan input.Flow
+
+func (c chanReader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	errs := make(chan error)
+	close(errs)
+	return c, errs
+}
+
+//newSampleFlow creates a flow describing a single sampled packet
+func newSampleFlow(src string, dst string, octets int64, packets int64, timestamp int64) *native.Flow {
+	flow := &native.Flow{Host: "A"}
+	flow.Netflow.SourceIPv4 = src
+	flow.Netflow.DestinationIPv4 = dst
+	flow.Netflow.SourcePort = 24846
+	flow.Netflow.DestinationPort = 443
+	flow.Netflow.ProtocolIdentifier = protocols.TCP
+	flow.Netflow.OctetTotalCount = octets
+	flow.Netflow.PacketTotalCount = packets
+	flow.Netflow.FlowStartMilliseconds = timestamp
+	flow.Netflow.FlowEndMilliseconds = timestamp
+	flow.Netflow.FlowEndReason = input.ActiveTimeout
+	flow.Netflow.Version = native.SFlowVersion
+	return flow
+}
+
+func TestAggregatingReader(t *testing.T) {
+	mockClock := clock.NewMock()
+	upstream := make(chanReader)
+	reader := native.NewAggregatingReader(upstream, time.Minute, mockClock)
+	flows, errs := reader.Drain(context.Background())
+
+	upstream <- newSampleFlow("1.1.1.1", "2.2.2.2", 1500, 10, 1000)
+	upstream <- newSampleFlow("1.1.1.1", "2.2.2.2", 500, 10, 3000)
+	upstream <- newSampleFlow("1.1.1.1", "2.2.2.2", 1000, 10, 2000)
+	upstream <- newSampleFlow("2.2.2.2", "1.1.1.1", 100, 10, 1500)
+
+	//the aggregates are written out at the end of the window
+	mockClock.Add(time.Minute)
+	aggregates := make(map[string]input.Flow)
+	for i := 0; i < 2; i++ {
+		select {
+		case flow := <-flows:
+			aggregates[flow.SourceIPAddress()] = flow
+		case err := <-errs:
+			t.Fatalf("%+v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no flows received")
+		}
+	}
+
+	flow := aggregates["1.1.1.1"]
+	require.NotNil(t, flow)
+	require.Equal(t, "A", flow.Exporter())
+	require.Equal(t, "2.2.2.2", flow.DestinationIPAddress())
+	require.Equal(t, uint16(24846), flow.SourcePort())
+	require.Equal(t, uint16(443), flow.DestinationPort())
+	require.Equal(t, protocols.TCP, flow.ProtocolIdentifier())
+	require.Equal(t, int64(3000), flow.OctetTotalCount())
+	require.Equal(t, int64(30), flow.PacketTotalCount())
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1000), flowStart)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(3000), flowEnd)
+
+	flow = aggregates["2.2.2.2"]
+	require.NotNil(t, flow)
+	require.Equal(t, int64(100), flow.OctetTotalCount())
+
+	//held flows are written out when the upstream reader finishes
+	upstream <- newSampleFlow("2001:db8::1", "2001:db8::2", 100, 10, 4000)
+	close(upstream)
+	flow = <-flows
+	require.Equal(t, "2001:db8::1", flow.SourceIPAddress())
+	require.Equal(t, "2001:db8::2", flow.DestinationIPAddress())
+
+	_, ok := <-flows
+	require.False(t, ok)
+	_, ok = <-errs
+	require.False(t, ok)
+}
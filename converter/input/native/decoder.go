@@ -0,0 +1,61 @@
+package native
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/pkg/errors"
+)
+
+//Decoder converts the raw export packets sent by an exporter
+//into input.Flow objects. Decoders may hold state, such as
+//templates, between calls to Decode. Decode may return
+//flows alongside errors if only part of the packet could be decoded.
+type Decoder interface {
+	Decode(exporter string, packet []byte) ([]input.Flow, []error)
+}
+
+//DecoderFactory creates a new Decoder. Readers use DecoderFactories
+//when the Decoder's state must not outlive a single transport session.
+type DecoderFactory func() Decoder
+
+//DecodeUnsigned decodes a big endian unsigned integer of up
+//to 8 bytes. IPFIX reduced size encoding and Netflow v9's
+//variable field lengths mean counters may be sent using fewer
+//bytes than their abstract data type calls for.
+func DecodeUnsigned(value []byte) (uint64, error) {
+	if len(value) == 0 || len(value) > 8 {
+		return 0, errors.Errorf("could not decode %d bytes as an unsigned integer", len(value))
+	}
+	var out uint64
+	for i := range value {
+		out = out<<8 | uint64(value[i])
+	}
+	return out, nil
+}
+
+//DecodeIPv4Address decodes a 4 byte IPv4 address into its string form
+func DecodeIPv4Address(value []byte) (string, error) {
+	if len(value) != net.IPv4len {
+		return "", errors.Errorf("could not decode %d bytes as an IPv4 address", len(value))
+	}
+	return net.IP(value).String(), nil
+}
+
+//DecodeIPv6Address decodes a 16 byte IPv6 address into its string form
+func DecodeIPv6Address(value []byte) (string, error) {
+	if len(value) != net.IPv6len {
+		return "", errors.Errorf("could not decode %d bytes as an IPv6 address", len(value))
+	}
+	return net.IP(value).String(), nil
+}
+
+//PeekVersion returns the version number found in the first
+//two bytes of a Netflow v5, Netflow v9, or IPFIX packet
+func PeekVersion(packet []byte) (uint16, error) {
+	if len(packet) < 2 {
+		return 0, errors.New("packet is too short to contain a version number")
+	}
+	return binary.BigEndian.Uint16(packet), nil
+}
@@ -0,0 +1,154 @@
+package native
+
+import (
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+)
+
+//SFlowVersion is the Version given to flows built from sFlow samples.
+//sFlow v5 shares its version number with Netflow v5, so a value which
+//isn't used by Netflow or IPFIX keeps the two apart.
+const SFlowVersion uint8 = 255
+
+//Flow represents an IPFIX/ Netflow flow record decoded directly
+//from the packets sent by an exporter. The field layout mirrors
+//data.Flow. However, the timestamps are held as Unix timestamps
+//in milliseconds since there is no Logstash serialization to undo.
+type Flow struct {
+	Host    string //Host is the address of the metering process host
+	Netflow struct {
+		SourceIPv4 string
+		SourceIPv6 string
+		SourcePort uint16
+
+		DestinationIPv4 string
+		DestinationIPv6 string
+		DestinationPort uint16
+
+		FlowStartMilliseconds int64
+		FlowEndMilliseconds   int64
+
+		OctetTotalCount  int64
+		PacketTotalCount int64
+
+		ProtocolIdentifier protocols.Identifier
+		FlowEndReason      input.FlowEndReason
+		//Version is 5 or 9 for Netflow, 10 for IPFIX,
+		//and SFlowVersion for flows built from sFlow samples
+		Version uint8
+
+		//Bidirectional is set if the record is an RFC 5103 biflow.
+		//The reverse fields are only filled for biflows.
+		Bidirectional                bool
+		ReverseOctetTotalCount       int64
+		ReversePacketTotalCount      int64
+		ReverseFlowDeltaMilliseconds int64
+	}
+
+	//Received is the time the collector received the flow as a Unix timestamp
+	//in milliseconds. Received is 0 for flows read from files.
+	Received int64
+}
+
+//SourceIPAddress returns the source IPv4 or IPv6 address
+func (i *Flow) SourceIPAddress() string {
+	if len(i.Netflow.SourceIPv4) != 0 {
+		return i.Netflow.SourceIPv4
+	}
+	return i.Netflow.SourceIPv6
+}
+
+//SourcePort returns the source transport port
+func (i *Flow) SourcePort() uint16 {
+	return i.Netflow.SourcePort
+}
+
+//DestinationIPAddress returns the destination IPv4 or IPv6 address
+func (i *Flow) DestinationIPAddress() string {
+	if len(i.Netflow.DestinationIPv4) != 0 {
+		return i.Netflow.DestinationIPv4
+	}
+	return i.Netflow.DestinationIPv6
+}
+
+//DestinationPort returns the destination transport port
+func (i *Flow) DestinationPort() uint16 {
+	return i.Netflow.DestinationPort
+}
+
+//ProtocolIdentifier returns which transport protocol was used
+func (i *Flow) ProtocolIdentifier() protocols.Identifier {
+	return i.Netflow.ProtocolIdentifier
+}
+
+//FlowStartMilliseconds is the time the flow started as a Unix timestamp
+func (i *Flow) FlowStartMilliseconds() (int64, error) {
+	return i.Netflow.FlowStartMilliseconds, nil
+}
+
+//FlowEndMilliseconds is the time the flow ended as a Unix timestamp
+func (i *Flow) FlowEndMilliseconds() (int64, error) {
+	return i.Netflow.FlowEndMilliseconds, nil
+}
+
+//OctetTotalCount returns the total amount of bytes sent (including IP headers and payload)
+func (i *Flow) OctetTotalCount() int64 {
+	return i.Netflow.OctetTotalCount
+}
+
+//PacketTotalCount returns the number of packets sent from the source to the destination
+func (i *Flow) PacketTotalCount() int64 {
+	return i.Netflow.PacketTotalCount
+}
+
+//FlowEndReason returns why the metering process stopped recording the flow
+func (i *Flow) FlowEndReason() input.FlowEndReason {
+	return i.Netflow.FlowEndReason
+}
+
+//Version returns the IPFIX/Netflow version
+func (i *Flow) Version() uint8 {
+	return i.Netflow.Version
+}
+
+//Exporter returns the address of the exporting process for this flow
+func (i *Flow) Exporter() string {
+	return i.Host
+}
+
+//IsBidirectional returns true if the flow holds the reverse direction
+func (i *Flow) IsBidirectional() bool {
+	return i.Netflow.Bidirectional
+}
+
+//ReverseFlowStartMilliseconds is the time the reverse direction
+//started as a Unix timestamp
+func (i *Flow) ReverseFlowStartMilliseconds() (int64, error) {
+	return i.Netflow.FlowStartMilliseconds + i.Netflow.ReverseFlowDeltaMilliseconds, nil
+}
+
+//ReverseOctetTotalCount returns the total amount of bytes sent
+//from the destination to the source
+func (i *Flow) ReverseOctetTotalCount() int64 {
+	return i.Netflow.ReverseOctetTotalCount
+}
+
+//ReversePacketTotalCount returns the number of packets sent
+//from the destination to the source
+func (i *Flow) ReversePacketTotalCount() int64 {
+	return i.Netflow.ReversePacketTotalCount
+}
+
+//ReceivedMilliseconds is the time the collector
+//received the flow as a Unix timestamp
+func (i *Flow) ReceivedMilliseconds() int64 {
+	return i.Received
+}
+
+//ShiftTimestamps moves each of the flow's timestamps
+//by the given number of milliseconds
+func (i *Flow) ShiftTimestamps(offsetMillis int64) error {
+	i.Netflow.FlowStartMilliseconds += offsetMillis
+	i.Netflow.FlowEndMilliseconds += offsetMillis
+	return nil
+}
@@ -0,0 +1,239 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/pkg/errors"
+)
+
+//Decoder implements native.Decoder for IPFIX (RFC 7011) messages.
+//The decoder learns templates from template sets and options template
+//sets, and uses them to decode the data sets that follow.
+//Similar to data.FlowDeserializer, the decoder holds the
+//systemInitTimeMilliseconds sent by each exporter as state in order
+//to resolve flowStartSysUpTime and flowEndSysUpTime timestamps.
+//Templates are dropped when the exporter withdraws them or when the
+//message sequence numbers reset, signaling that the exporter restarted.
+type Decoder struct {
+	templates       *native.TemplateCache
+	sequences       *native.SequenceTracker
+	systemInitTimes map[native.Domain]int64 //map from observation domain to systemInitTimeMilliseconds values
+	mutex           *sync.Mutex             //guards systemInitTimes
+}
+
+//NewDecoder creates a new IPFIX Decoder which stores the templates
+//it learns in the given TemplateCache
+func NewDecoder(templates *native.TemplateCache) *Decoder {
+	return &Decoder{
+		templates:       templates,
+		sequences:       native.NewSequenceTracker(),
+		systemInitTimes: make(map[native.Domain]int64),
+		mutex:           new(sync.Mutex),
+	}
+}
+
+//Decode decodes an IPFIX message sent by the given exporter.
+//Template sets are stored for later use, and the data records
+//are returned as input.Flows. Option records are consumed
+//for their systemInitTimeMilliseconds values. Decode is safe
+//for concurrent use.
+func (d *Decoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if len(packet) < messageHeaderLength {
+		return nil, []error{errors.Errorf("IPFIX message from %s is too short: %d bytes", exporter, len(packet))}
+	}
+	version := binary.BigEndian.Uint16(packet[0:2])
+	if version != 10 {
+		return nil, []error{errors.Errorf("unsupported IPFIX version from %s: %d", exporter, version)}
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < messageHeaderLength || length > len(packet) {
+		return nil, []error{errors.Errorf("invalid IPFIX message length from %s: %d", exporter, length)}
+	}
+	exportTime := binary.BigEndian.Uint32(packet[4:8])
+	sequence := binary.BigEndian.Uint32(packet[8:12])
+	domain := native.Domain{
+		Exporter: exporter,
+		Version:  10,
+		ID:       binary.BigEndian.Uint32(packet[12:16]),
+	}
+
+	var flows []input.Flow
+	var errs []error
+
+	//the exporter restarted, its templates may no longer be valid
+	if d.sequences.Update(domain, sequence) {
+		delete(d.systemInitTimes, domain)
+		err := d.templates.RemoveDomain(domain, nil)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	sets := packet[messageHeaderLength:length]
+	for len(sets) > 0 {
+		if len(sets) < setHeaderLength {
+			errs = append(errs, errors.Errorf("truncated IPFIX set header from %s", exporter))
+			break
+		}
+		setID := binary.BigEndian.Uint16(sets[0:2])
+		setLength := int(binary.BigEndian.Uint16(sets[2:4]))
+		if setLength < setHeaderLength || setLength > len(sets) {
+			errs = append(errs, errors.Errorf("invalid IPFIX set length from %s: %d", exporter, setLength))
+			break
+		}
+		setBody := sets[setHeaderLength:setLength]
+		sets = sets[setLength:]
+
+		if setID == templateSetID || setID == optionsTemplateSetID {
+			err := d.decodeTemplateSet(domain, setBody, setID == optionsTemplateSetID)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		} else if setID >= minDataSetID {
+			setFlows, setErrs := d.decodeDataSet(domain, setID, exportTime, setBody)
+			flows = append(flows, setFlows...)
+			errs = append(errs, setErrs...)
+		}
+		//Set IDs 0, 1 and 4-255 are reserved. Skip them.
+	}
+	return flows, errs
+}
+
+//decodeTemplateSet stores each template in a template set or
+//options template set in the template cache
+func (d *Decoder) decodeTemplateSet(domain native.Domain, setBody []byte, options bool) error {
+	//the set may be padded with fewer bytes than a record header
+	for len(setBody) >= 4 {
+		templateID := binary.BigEndian.Uint16(setBody[0:2])
+		fieldCount := binary.BigEndian.Uint16(setBody[2:4])
+		setBody = setBody[4:]
+
+		key := native.TemplateKey{
+			Domain:     domain,
+			TemplateID: templateID,
+		}
+
+		//A field count of 0 withdraws the template. Using the set ID
+		//as the template ID withdraws all of the templates of that type.
+		if fieldCount == 0 {
+			var err error
+			if templateID == templateSetID || templateID == optionsTemplateSetID {
+				err = d.templates.RemoveDomain(domain, func(template native.Template) bool {
+					return template.IsOptionsTemplate() == options
+				})
+			} else {
+				err = d.templates.Remove(key)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		template := native.Template{
+			ID:     templateID,
+			Fields: make([]native.FieldSpecifier, 0, fieldCount),
+		}
+
+		if options {
+			if len(setBody) < 2 {
+				return errors.Errorf("truncated IPFIX options template %d from %s", templateID, domain.Exporter)
+			}
+			template.ScopeFieldCount = binary.BigEndian.Uint16(setBody[0:2])
+			setBody = setBody[2:]
+		}
+
+		for i := uint16(0); i < fieldCount; i++ {
+			if len(setBody) < 4 {
+				return errors.Errorf("truncated IPFIX template %d from %s", templateID, domain.Exporter)
+			}
+			field := native.FieldSpecifier{
+				ID:     binary.BigEndian.Uint16(setBody[0:2]),
+				Length: binary.BigEndian.Uint16(setBody[2:4]),
+			}
+			setBody = setBody[4:]
+			//the enterprise bit signals a Private Enterprise Number follows
+			if field.ID&0x8000 != 0 {
+				if len(setBody) < 4 {
+					return errors.Errorf("truncated IPFIX template %d from %s", templateID, domain.Exporter)
+				}
+				field.ID &= 0x7FFF
+				field.EnterpriseNumber = binary.BigEndian.Uint32(setBody[0:4])
+				setBody = setBody[4:]
+			}
+			template.Fields = append(template.Fields, field)
+		}
+
+		err := d.templates.Put(key, template)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//decodeDataSet decodes the data records in a data set using
+//the template referenced by the set ID
+func (d *Decoder) decodeDataSet(domain native.Domain, setID uint16,
+	exportTime uint32, setBody []byte) ([]input.Flow, []error) {
+
+	template, ok := d.templates.Get(native.TemplateKey{
+		Domain:     domain,
+		TemplateID: setID,
+	})
+	if !ok {
+		return nil, []error{errors.Errorf(
+			"no template %d found for exporter %s in observation domain %d",
+			setID, domain.Exporter, domain.ID,
+		)}
+	}
+
+	minRecordLength := template.MinRecordLength()
+	if minRecordLength == 0 {
+		return nil, []error{errors.Errorf("template %d from %s describes empty records", setID, domain.Exporter)}
+	}
+
+	var flows []input.Flow
+	var errs []error
+	//anything shorter than a record is padding
+	for len(setBody) >= minRecordLength {
+		record, recordLength, err := decodeDataRecord(template, setBody)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "could not decode record using template %d from %s", setID, domain.Exporter))
+			break
+		}
+		setBody = setBody[recordLength:]
+
+		//handle recording systemInitTimeMilliseconds
+		d.updateSystemInitTime(domain, record)
+
+		//option records carry metadata about the exporter rather than flows
+		if template.IsOptionsTemplate() {
+			continue
+		}
+
+		flow := &native.Flow{}
+		err = d.fillFlow(domain, exportTime, record, flow)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		flows = append(flows, flow)
+	}
+	return flows, errs
+}
+
+//updateSystemInitTime stores the systemInitTimeMilliseconds
+//for an observation domain if the record contains it
+func (d *Decoder) updateSystemInitTime(domain native.Domain, record dataRecord) {
+	systemInitTime, ok, err := record.unsigned(systemInitTimeMilliseconds)
+	if ok && err == nil {
+		d.systemInitTimes[domain] = int64(systemInitTime)
+	}
+}
@@ -0,0 +1,388 @@
+package ipfix_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/input/native/ipfix"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/stretchr/testify/require"
+)
+
+/*  **********  Helper Functions  **********  */
+
+//newMessage wraps the given sets in an IPFIX message header
+func newMessage(exportTime uint32, sequence uint32, domainID uint32, sets ...[]byte) []byte {
+	msg := make([]byte, 16)
+	binary.BigEndian.PutUint16(msg[0:2], 10)
+	binary.BigEndian.PutUint32(msg[4:8], exportTime)
+	binary.BigEndian.PutUint32(msg[8:12], sequence)
+	binary.BigEndian.PutUint32(msg[12:16], domainID)
+	for i := range sets {
+		msg = append(msg, sets[i]...)
+	}
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	return msg
+}
+
+//newSet wraps the given records in an IPFIX set header
+func newSet(setID uint16, records ...[]byte) []byte {
+	set := make([]byte, 4)
+	binary.BigEndian.PutUint16(set[0:2], setID)
+	for i := range records {
+		set = append(set, records[i]...)
+	}
+	binary.BigEndian.PutUint16(set[2:4], uint16(len(set)))
+	return set
+}
+
+//newTemplateRecord creates a template record. Each field is given
+//as {element id, length} or {element id, length, enterprise number}.
+func newTemplateRecord(templateID uint16, scopeFieldCount uint16, fields ...[]uint32) []byte {
+	record := make([]byte, 4)
+	binary.BigEndian.PutUint16(record[0:2], templateID)
+	binary.BigEndian.PutUint16(record[2:4], uint16(len(fields)))
+	if scopeFieldCount > 0 {
+		record = append(record, u16(scopeFieldCount)...)
+	}
+	for _, field := range fields {
+		if len(field) == 3 {
+			record = append(record, u16(uint16(field[0])|0x8000)...)
+			record = append(record, u16(uint16(field[1]))...)
+			record = append(record, u32(field[2])...)
+		} else {
+			record = append(record, u16(uint16(field[0]))...)
+			record = append(record, u16(uint16(field[1]))...)
+		}
+	}
+	return record
+}
+
+func u16(value uint16) []byte {
+	out := make([]byte, 2)
+	binary.BigEndian.PutUint16(out, value)
+	return out
+}
+
+func u32(value uint32) []byte {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, value)
+	return out
+}
+
+func u64(value uint64) []byte {
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint64(out, value)
+	return out
+}
+
+func ip(address string) []byte {
+	parsed := net.ParseIP(address)
+	if v4 := parsed.To4(); v4 != nil {
+		return v4
+	}
+	return parsed
+}
+
+func concat(fields ...[]byte) []byte {
+	var out []byte
+	for i := range fields {
+		out = append(out, fields[i]...)
+	}
+	return out
+}
+
+//absoluteTemplate describes a flow using absolute millisecond timestamps
+//and reduced size encoding for the packet count
+var absoluteTemplate = newTemplateRecord(256, 0,
+	[]uint32{8, 4},   //sourceIPv4Address
+	[]uint32{12, 4},  //destinationIPv4Address
+	[]uint32{7, 2},   //sourceTransportPort
+	[]uint32{11, 2},  //destinationTransportPort
+	[]uint32{4, 1},   //protocolIdentifier
+	[]uint32{1, 8},   //octetDeltaCount
+	[]uint32{2, 4},   //packetDeltaCount
+	[]uint32{152, 8}, //flowStartMilliseconds
+	[]uint32{153, 8}, //flowEndMilliseconds
+	[]uint32{136, 1}, //flowEndReason
+)
+
+func newAbsoluteRecord(src string, dst string, srcPort uint16, dstPort uint16,
+	octets uint64, packets uint32, start uint64, end uint64, endReason uint8) []byte {
+	return concat(
+		ip(src), ip(dst), u16(srcPort), u16(dstPort), []byte{uint8(protocols.TCP)},
+		u64(octets), u32(packets), u64(start), u64(end), []byte{endReason},
+	)
+}
+
+/*  **********  Tests  **********  */
+
+func TestDecodeTemplateAndData(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+	msg := newMessage(1525473401, 0, 1,
+		newSet(2, absoluteTemplate),
+		newSet(256,
+			newAbsoluteRecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 1525473400766, 1525473400960, uint8(input.ActiveTimeout)),
+			newAbsoluteRecord("2.2.2.2", "1.1.1.1", 443, 24846, 4000, 9, 1525473400770, 1525473400950, uint8(input.IdleTimeout)),
+		),
+	)
+
+	flows, errs := decoder.Decode("A", msg)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 2)
+
+	flow := flows[0]
+	require.Equal(t, "A", flow.Exporter())
+	require.Equal(t, "1.1.1.1", flow.SourceIPAddress())
+	require.Equal(t, "2.2.2.2", flow.DestinationIPAddress())
+	require.Equal(t, uint16(24846), flow.SourcePort())
+	require.Equal(t, uint16(443), flow.DestinationPort())
+	require.Equal(t, protocols.TCP, flow.ProtocolIdentifier())
+	require.Equal(t, int64(5000), flow.OctetTotalCount())
+	require.Equal(t, int64(10), flow.PacketTotalCount())
+	require.Equal(t, input.ActiveTimeout, flow.FlowEndReason())
+	require.Equal(t, uint8(10), flow.Version())
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473400766), flowStart)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473400960), flowEnd)
+
+	require.Equal(t, "2.2.2.2", flows[1].SourceIPAddress())
+	require.Equal(t, input.IdleTimeout, flows[1].FlowEndReason())
+}
+
+func TestDecodeTemplateFromEarlierMessage(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+	flows, errs := decoder.Decode("A", newMessage(1525473401, 0, 1, newSet(2, absoluteTemplate)))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 0)
+
+	dataMsg := newMessage(1525473401, 0, 1, newSet(256,
+		newAbsoluteRecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 1525473400766, 1525473400960, 1),
+	))
+	flows, errs = decoder.Decode("A", dataMsg)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	//templates are scoped to the exporter and observation domain
+	flows, errs = decoder.Decode("B", dataMsg)
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+
+	otherDomainMsg := newMessage(1525473401, 0, 2, newSet(256,
+		newAbsoluteRecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 1525473400766, 1525473400960, 1),
+	))
+	flows, errs = decoder.Decode("A", otherDomainMsg)
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
+
+func TestDecodeTemplateWithdrawal(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+	dataSet := newSet(256,
+		newAbsoluteRecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 1525473400766, 1525473400960, 1),
+	)
+	flows, errs := decoder.Decode("A", newMessage(1525473401, 0, 1, newSet(2, absoluteTemplate), dataSet))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	withdrawal := newTemplateRecord(256, 0)
+	flows, errs = decoder.Decode("A", newMessage(1525473401, 1, 1, newSet(2, withdrawal), dataSet))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
+
+func TestDecodeSystemUpTime(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+
+	optionsTemplate := newTemplateRecord(257, 1,
+		[]uint32{144, 4}, //exportingProcessId (scope)
+		[]uint32{160, 8}, //systemInitTimeMilliseconds
+	)
+	uptimeTemplate := newTemplateRecord(258, 0,
+		[]uint32{27, 16}, //sourceIPv6Address
+		[]uint32{28, 16}, //destinationIPv6Address
+		[]uint32{7, 2},   //sourceTransportPort
+		[]uint32{11, 2},  //destinationTransportPort
+		[]uint32{4, 1},   //protocolIdentifier
+		[]uint32{85, 8},  //octetTotalCount
+		[]uint32{86, 8},  //packetTotalCount
+		[]uint32{22, 4},  //flowStartSysUpTime
+		[]uint32{21, 4},  //flowEndSysUpTime
+	)
+	uptimeRecord := concat(
+		ip("2001:db8::1"), ip("2001:db8::2"), u16(53), u16(5353), []byte{uint8(protocols.UDP)},
+		u64(100), u64(1), u32(1000), u32(2000),
+	)
+
+	//without the system init time, the record can't be placed in time
+	flows, errs := decoder.Decode("A", newMessage(1525473401, 0, 1,
+		newSet(3, optionsTemplate),
+		newSet(2, uptimeTemplate),
+		newSet(258, uptimeRecord),
+	))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+
+	flows, errs = decoder.Decode("A", newMessage(1525473401, 1, 1,
+		newSet(257, concat(u32(1), u64(1525473000000))),
+		newSet(258, uptimeRecord),
+	))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+	require.Equal(t, "2001:db8::1", flows[0].SourceIPAddress())
+	require.Equal(t, "2001:db8::2", flows[0].DestinationIPAddress())
+	require.Equal(t, input.EndOfFlow, flows[0].FlowEndReason())
+	flowStart, err := flows[0].FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473001000), flowStart)
+	flowEnd, err := flows[0].FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473002000), flowEnd)
+}
+
+func TestDecodeVariableLengthAndEnterpriseFields(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+	template := newTemplateRecord(300, 0,
+		[]uint32{8, 4},                   //sourceIPv4Address
+		[]uint32{12, 4},                  //destinationIPv4Address
+		[]uint32{82, 65535},              //interfaceName (variable length)
+		[]uint32{7, 2},                   //sourceTransportPort
+		[]uint32{11, 2},                  //destinationTransportPort
+		[]uint32{4, 1},                   //protocolIdentifier
+		[]uint32{1, 4},                   //octetDeltaCount
+		[]uint32{2, 4},                   //packetDeltaCount
+		[]uint32{1, 4, 29305},            //reverseOctetDeltaCount (enterprise)
+		[]uint32{150, 4},                 //flowStartSeconds
+		[]uint32{151, 4},                 //flowEndSeconds
+		[]uint32{226, 4},                 //postNATDestinationIPv4Address
+		[]uint32{228, 2},                 //postNAPTDestinationTransportPort
+		[]uint32{100, 65535, 0x00000009}, //enterprise element (variable length)
+	)
+	longValue := make([]byte, 300)
+	record := concat(
+		ip("10.0.0.1"), ip("3.3.3.3"), []byte{4}, []byte("eth0"), u16(1234), u16(80),
+		[]byte{uint8(protocols.TCP)}, u32(600), u32(6), u32(700),
+		u32(1525473400), u32(1525473402), ip("4.4.4.4"), u16(8080),
+		[]byte{255}, u16(uint16(len(longValue))), longValue,
+	)
+	flows, errs := decoder.Decode("A", newMessage(1525473401, 0, 1,
+		newSet(2, template),
+		//the trailing bytes are padding
+		newSet(300, record, []byte{0, 0, 0}),
+	))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+	require.Equal(t, "4.4.4.4", flows[0].DestinationIPAddress())
+	require.Equal(t, uint16(8080), flows[0].DestinationPort())
+	require.Equal(t, int64(600), flows[0].OctetTotalCount())
+	flowStart, err := flows[0].FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473400000), flowStart)
+}
+
+func TestDecodeBiflow(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+	template := newTemplateRecord(301, 0,
+		[]uint32{8, 4},         //sourceIPv4Address
+		[]uint32{12, 4},        //destinationIPv4Address
+		[]uint32{7, 2},         //sourceTransportPort
+		[]uint32{11, 2},        //destinationTransportPort
+		[]uint32{4, 1},         //protocolIdentifier
+		[]uint32{85, 8},        //octetTotalCount
+		[]uint32{86, 8},        //packetTotalCount
+		[]uint32{85, 8, 29305}, //reverseOctetTotalCount
+		[]uint32{86, 8, 29305}, //reversePacketTotalCount
+		[]uint32{21, 4, 6871},  //reverseFlowDeltaMilliseconds
+		[]uint32{152, 8},       //flowStartMilliseconds
+		[]uint32{153, 8},       //flowEndMilliseconds
+	)
+	record := concat(
+		ip("1.1.1.1"), ip("2.2.2.2"), u16(24846), u16(443), []byte{uint8(protocols.TCP)},
+		u64(500), u64(5), u64(4000), u64(4), u32(12),
+		u64(1525473400766), u64(1525473400960),
+	)
+	flows, errs := decoder.Decode("A", newMessage(1525473401, 0, 1,
+		newSet(2, template),
+		newSet(301, record),
+	))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	require.True(t, input.IsBidirectional(flows[0]))
+	biflow := flows[0].(input.BidirectionalFlow)
+	require.Equal(t, int64(500), biflow.OctetTotalCount())
+	require.Equal(t, int64(4000), biflow.ReverseOctetTotalCount())
+	require.Equal(t, int64(4), biflow.ReversePacketTotalCount())
+	reverseStart, err := biflow.ReverseFlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473400778), reverseStart)
+}
+
+func TestDecodeMalformedMessages(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+
+	_, errs := decoder.Decode("A", []byte{0, 10, 0})
+	require.Len(t, errs, 1)
+
+	netflowV9 := newMessage(0, 0, 0)
+	binary.BigEndian.PutUint16(netflowV9[0:2], 9)
+	_, errs = decoder.Decode("A", netflowV9)
+	require.Len(t, errs, 1)
+
+	badSetLength := newMessage(0, 0, 0, newSet(2, absoluteTemplate))
+	binary.BigEndian.PutUint16(badSetLength[18:20], 1000)
+	_, errs = decoder.Decode("A", badSetLength)
+	require.Len(t, errs, 1)
+}
+
+func TestDecodeWithdrawAllTemplates(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+	optionsTemplate := newTemplateRecord(257, 1,
+		[]uint32{144, 4}, //exportingProcessId (scope)
+		[]uint32{160, 8}, //systemInitTimeMilliseconds
+	)
+	dataSet := newSet(256,
+		newAbsoluteRecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 1525473400766, 1525473400960, 1),
+	)
+	optionsSet := newSet(257, concat(u32(1), u64(1525473000000)))
+
+	_, errs := decoder.Decode("A", newMessage(1525473401, 0, 1,
+		newSet(2, absoluteTemplate), newSet(3, optionsTemplate),
+	))
+	require.Len(t, errs, 0)
+
+	//withdrawing all options templates leaves the other templates alone
+	flows, errs := decoder.Decode("A", newMessage(1525473401, 0, 1,
+		newSet(3, newTemplateRecord(3, 0)), dataSet, optionsSet,
+	))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 1)
+
+	flows, errs = decoder.Decode("A", newMessage(1525473401, 1, 1,
+		newSet(2, newTemplateRecord(2, 0)), dataSet,
+	))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
+
+func TestDecodeSequenceReset(t *testing.T) {
+	decoder := ipfix.NewDecoder(native.NewTemplateCache())
+	dataSet := newSet(256,
+		newAbsoluteRecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 1525473400766, 1525473400960, 1),
+	)
+
+	flows, errs := decoder.Decode("A", newMessage(1525473401, 50000, 1, newSet(2, absoluteTemplate), dataSet))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	//the exporter restarted and has not resent its templates
+	flows, errs = decoder.Decode("A", newMessage(1525473401, 0, 1, dataSet))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
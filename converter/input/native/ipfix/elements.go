@@ -0,0 +1,76 @@
+package ipfix
+
+//IANA assigned IPFIX Information Element identifiers used by the decoder.
+//See https://www.iana.org/assignments/ipfix/ipfix.xhtml
+const (
+	octetDeltaCount                  uint16 = 1
+	packetDeltaCount                 uint16 = 2
+	protocolIdentifier               uint16 = 4
+	sourceTransportPort              uint16 = 7
+	sourceIPv4Address                uint16 = 8
+	destinationTransportPort         uint16 = 11
+	destinationIPv4Address           uint16 = 12
+	flowEndSysUpTime                 uint16 = 21
+	flowStartSysUpTime               uint16 = 22
+	sourceIPv6Address                uint16 = 27
+	destinationIPv6Address           uint16 = 28
+	octetTotalCount                  uint16 = 85
+	packetTotalCount                 uint16 = 86
+	flowEndReason                    uint16 = 136
+	flowStartSeconds                 uint16 = 150
+	flowEndSeconds                   uint16 = 151
+	flowStartMilliseconds            uint16 = 152
+	flowEndMilliseconds              uint16 = 153
+	flowStartMicroseconds            uint16 = 154
+	flowEndMicroseconds              uint16 = 155
+	flowStartNanoseconds             uint16 = 156
+	flowEndNanoseconds               uint16 = 157
+	flowStartDeltaMicroseconds       uint16 = 158
+	flowEndDeltaMicroseconds         uint16 = 159
+	systemInitTimeMilliseconds       uint16 = 160
+	postNATDestinationIPv4Address    uint16 = 226
+	postNAPTDestinationTransportPort uint16 = 228
+	postNATDestinationIPv6Address    uint16 = 282
+)
+
+//reverseEnterpriseNumber is the Private Enterprise Number used by RFC 5103
+//for the reverse direction of biflows. The reverse counterpart of an IANA
+//element uses the same element identifier under this enterprise number.
+const reverseEnterpriseNumber uint32 = 29305
+
+//reverseElement marks the reverse counterpart of an IANA element
+//in a dataRecord. Element identifiers are 15 bits wide, leaving
+//the top bit free.
+const reverseElement uint16 = 0x8000
+
+//certEnterpriseNumber is the CERT Private Enterprise Number. YAF reports
+//the reverse direction's start time as an offset from the forward
+//direction's start time using CERT element 21.
+const certEnterpriseNumber uint32 = 6871
+
+//certReverseFlowDeltaMilliseconds is the CERT element identifier
+//of reverseFlowDeltaMilliseconds
+const certReverseFlowDeltaMilliseconds uint16 = 21
+
+//reverseFlowDeltaMilliseconds is the dataRecord key for CERT's
+//reverseFlowDeltaMilliseconds. The key is the reverse counterpart of an
+//unassigned IANA element so it can't collide with the other elements.
+const reverseFlowDeltaMilliseconds = 0x7FFF | reverseElement
+
+//Set IDs as defined by RFC 7011 Section 3.3.2
+const (
+	templateSetID        uint16 = 2
+	optionsTemplateSetID uint16 = 3
+	minDataSetID         uint16 = 256
+)
+
+//messageHeaderLength is the length of the IPFIX Message Header
+const messageHeaderLength = 16
+
+//setHeaderLength is the length of an IPFIX Set Header
+const setHeaderLength = 4
+
+//ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+//and the Unix epoch (1970). IPFIX microsecond and nanosecond timestamps
+//are encoded in the NTP format.
+const ntpEpochOffset = 2208988800
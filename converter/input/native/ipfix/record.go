@@ -0,0 +1,398 @@
+package ipfix
+
+import (
+	"encoding/binary"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/pkg/errors"
+)
+
+//dataRecord maps the IANA Information Element identifiers
+//found in a data record to their raw values. The RFC 5103 reverse
+//elements are held under their IANA identifiers combined with
+//reverseElement. CERT's reverseFlowDeltaMilliseconds is held under
+//reverseFlowDeltaMilliseconds. Other enterprise specific elements are
+//not held as the decoder does not use them.
+type dataRecord map[uint16][]byte
+
+//decodeDataRecord splits the data record at the beginning of
+//data into its fields. The values in the resulting dataRecord
+//reference data. The number of bytes consumed is returned alongside
+//the record.
+func decodeDataRecord(template native.Template, data []byte) (dataRecord, int, error) {
+	record := make(dataRecord, len(template.Fields))
+	offset := 0
+	for _, field := range template.Fields {
+		fieldLength := int(field.Length)
+		if field.Length == native.VariableLength {
+			//RFC 7011 Section 7: lengths under 255 are encoded in one byte,
+			//otherwise 255 is followed by a two byte length
+			if offset+1 > len(data) {
+				return nil, 0, errors.New("truncated variable length field")
+			}
+			fieldLength = int(data[offset])
+			offset++
+			if fieldLength == 255 {
+				if offset+2 > len(data) {
+					return nil, 0, errors.New("truncated variable length field")
+				}
+				fieldLength = int(binary.BigEndian.Uint16(data[offset : offset+2]))
+				offset += 2
+			}
+		}
+		if offset+fieldLength > len(data) {
+			return nil, 0, errors.Errorf("truncated field %d", field.ID)
+		}
+		if field.EnterpriseNumber == 0 {
+			record[field.ID] = data[offset : offset+fieldLength]
+		} else if field.EnterpriseNumber == reverseEnterpriseNumber {
+			record[field.ID|reverseElement] = data[offset : offset+fieldLength]
+		} else if field.EnterpriseNumber == certEnterpriseNumber &&
+			field.ID == certReverseFlowDeltaMilliseconds {
+			record[reverseFlowDeltaMilliseconds] = data[offset : offset+fieldLength]
+		}
+		offset += fieldLength
+	}
+	return record, offset, nil
+}
+
+//unsigned decodes an unsigned integer field. ok is false if the
+//field is not present in the record.
+func (r dataRecord) unsigned(id uint16) (value uint64, ok bool, err error) {
+	raw, ok := r[id]
+	if !ok {
+		return 0, false, nil
+	}
+	value, err = native.DecodeUnsigned(raw)
+	return value, true, errors.Wrapf(err, "could not decode IPFIX element %d", id)
+}
+
+//ipv4Address decodes an IPv4 address field. ok is false if the
+//field is not present in the record.
+func (r dataRecord) ipv4Address(id uint16) (value string, ok bool, err error) {
+	raw, ok := r[id]
+	if !ok {
+		return "", false, nil
+	}
+	value, err = native.DecodeIPv4Address(raw)
+	return value, true, errors.Wrapf(err, "could not decode IPFIX element %d", id)
+}
+
+//ipv6Address decodes an IPv6 address field. ok is false if the
+//field is not present in the record.
+func (r dataRecord) ipv6Address(id uint16) (value string, ok bool, err error) {
+	raw, ok := r[id]
+	if !ok {
+		return "", false, nil
+	}
+	value, err = native.DecodeIPv6Address(raw)
+	return value, true, errors.Wrapf(err, "could not decode IPFIX element %d", id)
+}
+
+//ntpMilliseconds decodes a dateTimeMicroseconds or dateTimeNanoseconds
+//field into a Unix timestamp in milliseconds. These fields use the
+//NTP Timestamp format (RFC 5905).
+func (r dataRecord) ntpMilliseconds(id uint16) (value int64, ok bool, err error) {
+	raw, ok := r[id]
+	if !ok {
+		return 0, false, nil
+	}
+	if len(raw) != 8 {
+		return 0, true, errors.Errorf("could not decode IPFIX element %d: expected 8 bytes, found %d", id, len(raw))
+	}
+	seconds := int64(binary.BigEndian.Uint32(raw[0:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(raw[4:8]))
+	return seconds*1000 + (fraction*1000)>>32, true, nil
+}
+
+//fillFlow reads the data from an IPFIX data record and inserts it
+//into the output flow, returning nil if the conversion was successful.
+//The rules for which elements are used mirror those in
+//data.FlowDeserializer.
+func (d *Decoder) fillFlow(domain native.Domain, exportTime uint32,
+	record dataRecord, outputFlow *native.Flow) error {
+
+	sourceIPv4, sourceIPv4Ok, err := record.ipv4Address(sourceIPv4Address)
+	if err != nil {
+		return err
+	}
+	sourceIPv6, sourceIPv6Ok, err := record.ipv6Address(sourceIPv6Address)
+	if err != nil {
+		return err
+	}
+	if !sourceIPv4Ok && !sourceIPv6Ok {
+		return errors.New("data record must contain element 'sourceIPv4Address' or 'sourceIPv6Address'")
+	}
+
+	sourcePort, ok, err := record.unsigned(sourceTransportPort)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain element 'sourceTransportPort'")
+	}
+
+	destIPv4, destIPv4Ok, err := record.ipv4Address(destinationIPv4Address)
+	if err != nil {
+		return err
+	}
+	destIPv6, destIPv6Ok, err := record.ipv6Address(destinationIPv6Address)
+	if err != nil {
+		return err
+	}
+	if destIPv4Ok {
+		postNatDestIPv4, postNatDestIPv4Ok, err := record.ipv4Address(postNATDestinationIPv4Address)
+		if err != nil {
+			return err
+		}
+		if postNatDestIPv4Ok {
+			destIPv4 = postNatDestIPv4
+		}
+	} else if destIPv6Ok {
+		postNatDestIPv6, postNatDestIPv6Ok, err := record.ipv6Address(postNATDestinationIPv6Address)
+		if err != nil {
+			return err
+		}
+		if postNatDestIPv6Ok {
+			destIPv6 = postNatDestIPv6
+		}
+	} else {
+		return errors.New("data record must contain element 'destinationIPv4Address' or 'destinationIPv6Address'")
+	}
+
+	destPort, ok, err := record.unsigned(destinationTransportPort)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain element 'destinationTransportPort'")
+	}
+	postNaptDestPort, postNaptDestPortOk, err := record.unsigned(postNAPTDestinationTransportPort)
+	if err != nil {
+		return err
+	}
+	if postNaptDestPortOk {
+		destPort = postNaptDestPort
+	}
+
+	flowStart, flowEnd, err := d.flowTimes(domain, exportTime, record)
+	if err != nil {
+		return err
+	}
+
+	octetTotal, ok, err := record.unsigned(octetTotalCount)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		//delta counts CAN be total counts by RFC definition >.<"
+		octetTotal, ok, err = record.unsigned(octetDeltaCount)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("data record must contain element 'octetTotalCount' or 'octetDeltaCount'")
+		}
+	}
+
+	packetTotal, ok, err := record.unsigned(packetTotalCount)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		//delta counts CAN be total counts by RFC definition >.<"
+		packetTotal, ok, err = record.unsigned(packetDeltaCount)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("data record must contain element 'packetTotalCount' or 'packetDeltaCount'")
+		}
+	}
+
+	protocolID, ok, err := record.unsigned(protocolIdentifier)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain element 'protocolIdentifier'")
+	}
+
+	//assume EndOfFlow if flowEndReason is not present
+	endReason := input.EndOfFlow
+	endReasonInt, ok, err := record.unsigned(flowEndReason)
+	if err != nil {
+		return err
+	}
+	if ok {
+		endReason = input.FlowEndReason(endReasonInt)
+	}
+
+	//RFC 5103 biflows carry the reverse direction in the same record
+	reverseOctetTotal, reversePacketTotal, reverseDelta, bidirectional, err := record.reverseFields()
+	if err != nil {
+		return err
+	}
+
+	//Fill in the flow now that we know we have all the data
+	outputFlow.Host = domain.Exporter
+	if sourceIPv4Ok {
+		outputFlow.Netflow.SourceIPv4 = sourceIPv4
+	}
+	if sourceIPv6Ok {
+		outputFlow.Netflow.SourceIPv6 = sourceIPv6
+	}
+	outputFlow.Netflow.SourcePort = uint16(sourcePort)
+
+	if destIPv4Ok {
+		outputFlow.Netflow.DestinationIPv4 = destIPv4
+	}
+	if destIPv6Ok {
+		outputFlow.Netflow.DestinationIPv6 = destIPv6
+	}
+	outputFlow.Netflow.DestinationPort = uint16(destPort)
+
+	outputFlow.Netflow.FlowStartMilliseconds = flowStart
+	outputFlow.Netflow.FlowEndMilliseconds = flowEnd
+	outputFlow.Netflow.OctetTotalCount = int64(octetTotal)
+	outputFlow.Netflow.PacketTotalCount = int64(packetTotal)
+	outputFlow.Netflow.ProtocolIdentifier = protocols.Identifier(protocolID)
+	outputFlow.Netflow.FlowEndReason = endReason
+	outputFlow.Netflow.Version = 10
+	outputFlow.Netflow.Bidirectional = bidirectional
+	outputFlow.Netflow.ReverseOctetTotalCount = int64(reverseOctetTotal)
+	outputFlow.Netflow.ReversePacketTotalCount = int64(reversePacketTotal)
+	outputFlow.Netflow.ReverseFlowDeltaMilliseconds = int64(reverseDelta)
+	return nil
+}
+
+//reverseFields reads the reverse direction of an RFC 5103 biflow.
+//bidirectional is false if the record doesn't hold reverse counters.
+//A missing reverse counter is treated as zero.
+//The reverse direction is assumed to start with the forward direction
+//if reverseFlowDeltaMilliseconds is not present.
+func (r dataRecord) reverseFields() (octetTotal, packetTotal, delta uint64, bidirectional bool, err error) {
+	octetTotal, octetTotalOk, err := r.unsigned(octetTotalCount | reverseElement)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	if !octetTotalOk {
+		octetTotal, octetTotalOk, err = r.unsigned(octetDeltaCount | reverseElement)
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+	}
+
+	packetTotal, packetTotalOk, err := r.unsigned(packetTotalCount | reverseElement)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	if !packetTotalOk {
+		packetTotal, packetTotalOk, err = r.unsigned(packetDeltaCount | reverseElement)
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+	}
+
+	if !octetTotalOk && !packetTotalOk {
+		return 0, 0, 0, false, nil
+	}
+
+	delta, _, err = r.unsigned(reverseFlowDeltaMilliseconds)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	return octetTotal, packetTotal, delta, true, nil
+}
+
+//flowTimes finds the absolute start and end times of a flow
+//as Unix timestamps in milliseconds. IPFIX allows timestamps to be
+//sent in several different formats. The most precise pair available
+//is used.
+func (d *Decoder) flowTimes(domain native.Domain, exportTime uint32,
+	record dataRecord) (int64, int64, error) {
+
+	//Case 1: We have an absolute start and end time (this is ideal)
+	flowStart, flowStartOk, err := record.unsigned(flowStartMilliseconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	flowEnd, flowEndOk, err := record.unsigned(flowEndMilliseconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	if flowStartOk && flowEndOk {
+		return int64(flowStart), int64(flowEnd), nil
+	}
+
+	//Case 2: We have absolute times in the NTP format
+	for _, ids := range [][2]uint16{
+		{flowStartMicroseconds, flowEndMicroseconds},
+		{flowStartNanoseconds, flowEndNanoseconds},
+	} {
+		ntpStart, ntpStartOk, err := record.ntpMilliseconds(ids[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		ntpEnd, ntpEndOk, err := record.ntpMilliseconds(ids[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		if ntpStartOk && ntpEndOk {
+			return ntpStart, ntpEnd, nil
+		}
+	}
+
+	//Case 3: We have absolute times with a resolution of seconds
+	flowStart, flowStartOk, err = record.unsigned(flowStartSeconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	flowEnd, flowEndOk, err = record.unsigned(flowEndSeconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	if flowStartOk && flowEndOk {
+		return int64(flowStart) * 1000, int64(flowEnd) * 1000, nil
+	}
+
+	//Case 4: We have a start and end time in milliseconds from system init and
+	//we have the absolute system init time
+	flowStart, flowStartOk, err = record.unsigned(flowStartSysUpTime)
+	if err != nil {
+		return 0, 0, err
+	}
+	flowEnd, flowEndOk, err = record.unsigned(flowEndSysUpTime)
+	if err != nil {
+		return 0, 0, err
+	}
+	systemInitTime, systemInitTimeOk := d.systemInitTimes[domain]
+	if flowStartOk && flowEndOk && systemInitTimeOk {
+		return systemInitTime + int64(flowStart), systemInitTime + int64(flowEnd), nil
+	}
+
+	//Case 5: We have a start and end time in microseconds before the
+	//message was exported
+	flowStart, flowStartOk, err = record.unsigned(flowStartDeltaMicroseconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	flowEnd, flowEndOk, err = record.unsigned(flowEndDeltaMicroseconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	if flowStartOk && flowEndOk {
+		exportMillis := int64(exportTime) * 1000
+		return exportMillis - int64(flowStart)/1000, exportMillis - int64(flowEnd)/1000, nil
+	}
+
+	return 0, 0, errors.New(
+		"data record must contain valid start and end timestamps.\n\n" +
+			"If this problem persists, please report this problem at\n" +
+			"support@activecountermeasures.com. If your device supports\n" +
+			"alternative versions of Netflow, you may resolve this issue by\n" +
+			"disabling IPFIX and enabling Netflow version 5 or 9. ",
+	)
+}
@@ -0,0 +1,160 @@
+package native
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/pkg/errors"
+)
+
+//IPFIXFileReader implements input.Reader by reading IPFIX
+//files (RFC 5655). IPFIX files hold a series of IPFIX messages
+//in the same form as they are sent over TCP. The files are read
+//one after another, and the channels are closed once each file
+//has been read.
+type IPFIXFileReader struct {
+	paths    []string
+	exporter string
+	decoder  Decoder
+	log      logging.Logger
+}
+
+//DefaultIPFIXFileExporter is the exporter name given to the flows
+//read by an IPFIXFileReader if no exporter name is configured
+const DefaultIPFIXFileExporter = "ipfix-files"
+
+//NewIPFIXFileReader returns a new input.Reader which reads
+//the IPFIX files at the given paths. Each path may name a file,
+//a directory holding IPFIX files, or a glob pattern.
+//IPFIX files don't record which exporter sent the messages they hold,
+//so the flows are attributed to the given exporter name. If the name
+//is empty, DefaultIPFIXFileExporter is used instead. Every file is
+//attributed to the same exporter since rotated files often rely on
+//templates sent in the files before them.
+func NewIPFIXFileReader(paths []string, exporter string, decoder Decoder, log logging.Logger) input.Reader {
+	if exporter == "" {
+		exporter = DefaultIPFIXFileExporter
+	}
+	return IPFIXFileReader{
+		paths:    paths,
+		exporter: exporter,
+		decoder:  decoder,
+		log:      log,
+	}
+}
+
+//Drain asynchronously reads each of the IPFIX files in order
+func (r IPFIXFileReader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	out := make(chan input.Flow)
+	errs := make(chan error)
+
+	go func(out chan<- input.Flow, errs chan<- error) {
+		files, err := ExpandPaths(r.paths)
+		if err != nil {
+			errs <- err
+		}
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			r.readFile(ctx, file, out, errs)
+		}
+		close(errs)
+		close(out)
+	}(out, errs)
+
+	return out, errs
+}
+
+//readFile decodes the IPFIX messages held in a file until
+//the end of the file is reached or the context is cancelled
+func (r IPFIXFileReader) readFile(ctx context.Context, path string,
+	out chan<- input.Flow, errs chan<- error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		errs <- errors.Wrapf(err, "could not open IPFIX file %s", path)
+		return
+	}
+	defer file.Close()
+
+	r.log.Info("reading IPFIX file", logging.Fields{"file": path})
+	messageCount := 0
+	stream := bufio.NewReader(file)
+	for ctx.Err() == nil {
+		message, err := readIPFIXMessage(stream)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs <- errors.Wrapf(err, "could not read IPFIX message %d from %s", messageCount, path)
+			break
+		}
+		messageCount++
+
+		flows, decodeErrs := r.decoder.Decode(r.exporter, message)
+		for i := range decodeErrs {
+			errs <- errors.Wrapf(decodeErrs[i], "could not decode message from %s", path)
+		}
+		for i := range flows {
+			out <- flows[i]
+		}
+	}
+	r.log.Info("finished reading IPFIX file", logging.Fields{
+		"file":     path,
+		"messages": messageCount,
+	})
+}
+
+//ExpandPaths expands each path into the files it names, in order.
+//A path may name a file, a directory, or a glob pattern. The regular
+//files held directly in a directory are listed in lexical order,
+//as are the files matching a glob pattern.
+func ExpandPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if strings.ContainsAny(path, "*?[") {
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return files, errors.Wrapf(err, "invalid glob pattern %s", path)
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				info, err := os.Stat(match)
+				if err == nil && info.Mode().IsRegular() {
+					files = append(files, match)
+				}
+			}
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return files, errors.Wrapf(err, "could not find %s", path)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		//ReadDir sorts its results by name
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return files, errors.Wrapf(err, "could not list directory %s", path)
+		}
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
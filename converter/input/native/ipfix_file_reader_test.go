@@ -0,0 +1,195 @@
+package native_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/input/native/ipfix"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/stretchr/testify/require"
+)
+
+//newTemplatedIPFIXMessage wraps the given sets in an IPFIX message header
+func newTemplatedIPFIXMessage(sets ...[]byte) []byte {
+	msg := concat(u16(10), u16(0), u32(1525473401), u32(0), u32(0))
+	msg = append(msg, concat(sets...)...)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	return msg
+}
+
+func concat(fields ...[]byte) []byte {
+	var out []byte
+	for i := range fields {
+		out = append(out, fields[i]...)
+	}
+	return out
+}
+
+func u16(value uint16) []byte {
+	out := make([]byte, 2)
+	binary.BigEndian.PutUint16(out, value)
+	return out
+}
+
+func u32(value uint32) []byte {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, value)
+	return out
+}
+
+func u64(value uint64) []byte {
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint64(out, value)
+	return out
+}
+
+//newTestTemplateSet creates an IPFIX template set holding template 256
+func newTestTemplateSet() []byte {
+	return concat(
+		u16(2), u16(4+4+10*4),
+		u16(256), u16(10),
+		u16(8), u16(4), //sourceIPv4Address
+		u16(12), u16(4), //destinationIPv4Address
+		u16(7), u16(2), //sourceTransportPort
+		u16(11), u16(2), //destinationTransportPort
+		u16(4), u16(1), //protocolIdentifier
+		u16(1), u16(8), //octetDeltaCount
+		u16(2), u16(4), //packetDeltaCount
+		u16(152), u16(8), //flowStartMilliseconds
+		u16(153), u16(8), //flowEndMilliseconds
+		u16(136), u16(1), //flowEndReason
+	)
+}
+
+//newTestDataSet creates an IPFIX data set holding a
+//single record described by template 256
+func newTestDataSet() []byte {
+	return concat(
+		u16(256), u16(4+4+4+2+2+1+8+4+8+8+1),
+		net.ParseIP("1.1.1.1").To4(), net.ParseIP("2.2.2.2").To4(),
+		u16(24846), u16(443), []byte{uint8(protocols.TCP)},
+		u64(1500), u32(10), u64(1525473400000), u64(1525473401000),
+		[]byte{uint8(input.IdleTimeout)},
+	)
+}
+
+//drainAll reads from a Reader until its channels are closed
+func drainAll(reader input.Reader) ([]input.Flow, []error) {
+	flows, errs := reader.Drain(context.Background())
+	var outFlows []input.Flow
+	var outErrs []error
+	for flows != nil || errs != nil {
+		select {
+		case flow, ok := <-flows:
+			if !ok {
+				flows = nil
+				continue
+			}
+			outFlows = append(outFlows, flow)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			outErrs = append(outErrs, err)
+		}
+	}
+	return outFlows, outErrs
+}
+
+func TestIPFIXFileReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfix-file-reader")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "archive"), 0755))
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "archive", "b.ipfix"),
+		append(newIPFIXMessage(20), newIPFIXMessage(30)...),
+		0644,
+	))
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "archive", "a.ipfix"),
+		newIPFIXMessage(10),
+		0644,
+	))
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "c.ipfix"),
+		newIPFIXMessage(40),
+		0644,
+	))
+
+	//directories and globs are read in lexical order
+	reader := native.NewIPFIXFileReader(
+		[]string{filepath.Join(dir, "archive"), filepath.Join(dir, "*.ipfix")},
+		"archive", lengthDecoder{}, logging.NewTestLogger(t),
+	)
+	flows, errs := drainAll(reader)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 4)
+	for i, expectedLength := range []int64{26, 36, 46, 56} {
+		require.Equal(t, expectedLength, flows[i].OctetTotalCount())
+		require.Equal(t, "archive", flows[i].Exporter())
+	}
+
+	//every file is attributed to the default exporter if none is given
+	reader = native.NewIPFIXFileReader(
+		[]string{filepath.Join(dir, "archive"), filepath.Join(dir, "c.ipfix")},
+		"", lengthDecoder{}, logging.NewTestLogger(t),
+	)
+	flows, errs = drainAll(reader)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 4)
+	for i := range flows {
+		require.Equal(t, native.DefaultIPFIXFileExporter, flows[i].Exporter())
+	}
+}
+
+func TestIPFIXFileReaderTemplatesAcrossFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfix-file-reader")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	//the first file carries the template used by the second file
+	template := newTestTemplateSet()
+	data := newTestDataSet()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.ipfix"), newTemplatedIPFIXMessage(template), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "b.ipfix"), newTemplatedIPFIXMessage(data), 0644))
+
+	reader := native.NewIPFIXFileReader(
+		[]string{filepath.Join(dir, "*.ipfix")},
+		"", ipfix.NewDecoder(native.NewTemplateCache()), logging.NewTestLogger(t),
+	)
+	flows, errs := drainAll(reader)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+	require.Equal(t, native.DefaultIPFIXFileExporter, flows[0].Exporter())
+	require.Equal(t, "1.1.1.1", flows[0].SourceIPAddress())
+	require.Equal(t, "2.2.2.2", flows[0].DestinationIPAddress())
+}
+
+func TestIPFIXFileReaderTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfix-file-reader")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	//the second message was cut off
+	truncated := append(newIPFIXMessage(10), newIPFIXMessage(30)[:20]...)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.ipfix"), truncated, 0644))
+
+	reader := native.NewIPFIXFileReader(
+		[]string{filepath.Join(dir, "a.ipfix"), filepath.Join(dir, "missing.ipfix")},
+		"", lengthDecoder{}, logging.NewTestLogger(t),
+	)
+	flows, errs := drainAll(reader)
+	require.Len(t, flows, 1)
+	require.Len(t, errs, 2)
+}
@@ -0,0 +1,98 @@
+package netflow5
+
+import (
+	"encoding/binary"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/pkg/errors"
+)
+
+//packetHeaderLength is the length of the Netflow v5 packet header
+const packetHeaderLength = 24
+
+//recordLength is the length of a Netflow v5 flow record
+const recordLength = 48
+
+//maxRecordCount is the largest number of records allowed in a packet
+const maxRecordCount = 30
+
+//packetHeader holds the Netflow v5 header fields
+//needed to place the flows in a packet in time
+type packetHeader struct {
+	sysUptime uint32 //milliseconds since the exporter booted
+	unixSecs  uint32 //seconds since the Unix epoch when the packet was sent
+	unixNsecs uint32 //residual nanoseconds since the Unix epoch
+}
+
+//Decoder implements native.Decoder for Netflow v5 packets.
+//Netflow v5 uses a fixed record format, so the decoder holds no state.
+type Decoder struct{}
+
+//NewDecoder creates a new Netflow v5 Decoder
+func NewDecoder() Decoder {
+	return Decoder{}
+}
+
+//Decode decodes a Netflow v5 packet sent by the given exporter
+//and returns the flow records it contains as input.Flows
+func (d Decoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	if len(packet) < packetHeaderLength {
+		return nil, []error{errors.Errorf("Netflow v5 packet from %s is too short: %d bytes", exporter, len(packet))}
+	}
+	version := binary.BigEndian.Uint16(packet[0:2])
+	if version != 5 {
+		return nil, []error{errors.Errorf("unsupported Netflow v5 version from %s: %d", exporter, version)}
+	}
+	count := int(binary.BigEndian.Uint16(packet[2:4]))
+	if count > maxRecordCount || len(packet) < packetHeaderLength+count*recordLength {
+		return nil, []error{errors.Errorf("invalid Netflow v5 record count from %s: %d", exporter, count)}
+	}
+	header := packetHeader{
+		sysUptime: binary.BigEndian.Uint32(packet[4:8]),
+		unixSecs:  binary.BigEndian.Uint32(packet[8:12]),
+		unixNsecs: binary.BigEndian.Uint32(packet[12:16]),
+	}
+
+	flows := make([]input.Flow, 0, count)
+	for i := 0; i < count; i++ {
+		offset := packetHeaderLength + i*recordLength
+		flow := &native.Flow{}
+		fillFlow(exporter, header, packet[offset:offset+recordLength], flow)
+		flows = append(flows, flow)
+	}
+	return flows, nil
+}
+
+//switchedMilliseconds converts a First or Last value, which is
+//given in milliseconds since the exporter booted, into a Unix
+//timestamp in milliseconds using the sysUptime, unix_secs,
+//and unix_nsecs values in the packet header.
+func switchedMilliseconds(header packetHeader, switched uint32) int64 {
+	exportMillis := int64(header.unixSecs)*1000 + int64(header.unixNsecs)/1000000
+	//sysUptime wraps around after ~49.7 days. Subtracting as
+	//uint32 and converting to int32 handles the wrap, and allows
+	//for flows which end slightly after the header was written.
+	age := int64(int32(header.sysUptime - switched))
+	return exportMillis - age
+}
+
+//fillFlow reads the data from a Netflow v5 flow record and
+//inserts it into the output flow. Every field is present
+//in a Netflow v5 record, so the conversion can't fail.
+func fillFlow(exporter string, header packetHeader, record []byte, outputFlow *native.Flow) {
+	outputFlow.Host = exporter
+	outputFlow.Netflow.SourceIPv4, _ = native.DecodeIPv4Address(record[0:4])
+	outputFlow.Netflow.DestinationIPv4, _ = native.DecodeIPv4Address(record[4:8])
+	outputFlow.Netflow.PacketTotalCount = int64(binary.BigEndian.Uint32(record[16:20]))
+	outputFlow.Netflow.OctetTotalCount = int64(binary.BigEndian.Uint32(record[20:24]))
+	outputFlow.Netflow.FlowStartMilliseconds = switchedMilliseconds(header, binary.BigEndian.Uint32(record[24:28]))
+	outputFlow.Netflow.FlowEndMilliseconds = switchedMilliseconds(header, binary.BigEndian.Uint32(record[28:32]))
+	outputFlow.Netflow.SourcePort = binary.BigEndian.Uint16(record[32:34])
+	outputFlow.Netflow.DestinationPort = binary.BigEndian.Uint16(record[34:36])
+	outputFlow.Netflow.ProtocolIdentifier = protocols.Identifier(record[38])
+	//assume end of flow since we don't have the data
+	outputFlow.Netflow.FlowEndReason = input.EndOfFlow
+	outputFlow.Netflow.Version = 5
+}
@@ -0,0 +1,104 @@
+package netflow5_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native/netflow5"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/stretchr/testify/require"
+)
+
+//newPacket creates a Netflow v5 packet holding the given records
+func newPacket(sysUptime uint32, unixSecs uint32, unixNsecs uint32, records ...[]byte) []byte {
+	packet := make([]byte, 24)
+	binary.BigEndian.PutUint16(packet[0:2], 5)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(records)))
+	binary.BigEndian.PutUint32(packet[4:8], sysUptime)
+	binary.BigEndian.PutUint32(packet[8:12], unixSecs)
+	binary.BigEndian.PutUint32(packet[12:16], unixNsecs)
+	for i := range records {
+		packet = append(packet, records[i]...)
+	}
+	return packet
+}
+
+//newRecord creates a Netflow v5 flow record
+func newRecord(src string, dst string, srcPort uint16, dstPort uint16, protocol protocols.Identifier,
+	octets uint32, packets uint32, first uint32, last uint32) []byte {
+	record := make([]byte, 48)
+	copy(record[0:4], net.ParseIP(src).To4())
+	copy(record[4:8], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint32(record[16:20], packets)
+	binary.BigEndian.PutUint32(record[20:24], octets)
+	binary.BigEndian.PutUint32(record[24:28], first)
+	binary.BigEndian.PutUint32(record[28:32], last)
+	binary.BigEndian.PutUint16(record[32:34], srcPort)
+	binary.BigEndian.PutUint16(record[34:36], dstPort)
+	record[38] = uint8(protocol)
+	return record
+}
+
+func TestDecode(t *testing.T) {
+	decoder := netflow5.NewDecoder()
+	packet := newPacket(100000, 1525473401, 250000000,
+		newRecord("1.1.1.1", "2.2.2.2", 24846, 443, protocols.TCP, 5000, 10, 95000, 99500),
+		newRecord("2.2.2.2", "1.1.1.1", 443, 24846, protocols.TCP, 4000, 9, 95100, 99400),
+	)
+
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 2)
+
+	flow := flows[0]
+	require.Equal(t, "A", flow.Exporter())
+	require.Equal(t, "1.1.1.1", flow.SourceIPAddress())
+	require.Equal(t, "2.2.2.2", flow.DestinationIPAddress())
+	require.Equal(t, uint16(24846), flow.SourcePort())
+	require.Equal(t, uint16(443), flow.DestinationPort())
+	require.Equal(t, protocols.TCP, flow.ProtocolIdentifier())
+	require.Equal(t, int64(5000), flow.OctetTotalCount())
+	require.Equal(t, int64(10), flow.PacketTotalCount())
+	require.Equal(t, input.EndOfFlow, flow.FlowEndReason())
+	require.Equal(t, uint8(5), flow.Version())
+
+	//the packet was sent at 1525473401.25
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401250-5000), flowStart)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401250-500), flowEnd)
+
+	require.Equal(t, "2.2.2.2", flows[1].SourceIPAddress())
+	require.Equal(t, int64(4000), flows[1].OctetTotalCount())
+}
+
+func TestDecodeSysUptimeWrap(t *testing.T) {
+	decoder := netflow5.NewDecoder()
+	packet := newPacket(1000, 1525473401, 0,
+		newRecord("1.1.1.1", "2.2.2.2", 24846, 443, protocols.TCP, 5000, 10, 0xFFFFFFFF-999, 500),
+	)
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+	flowStart, err := flows[0].FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000-2000), flowStart)
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	decoder := netflow5.NewDecoder()
+	packet := newPacket(100000, 1525473401, 0,
+		newRecord("1.1.1.1", "2.2.2.2", 24846, 443, protocols.TCP, 5000, 10, 95000, 99500),
+	)
+	flows, errs := decoder.Decode("A", packet[:len(packet)-1])
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+
+	flows, errs = decoder.Decode("A", packet[:10])
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
@@ -0,0 +1,248 @@
+package netflow9
+
+import (
+	"encoding/binary"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/pkg/errors"
+)
+
+//packetHeader holds the Netflow v9 Packet Header fields
+//needed to place the flows in a packet in time
+type packetHeader struct {
+	sysUptime uint32 //milliseconds since the exporter booted
+	unixSecs  uint32 //seconds since the Unix epoch when the packet was sent
+}
+
+//Decoder implements native.Decoder for Netflow v9 (RFC 3954) packets.
+//The decoder learns templates from template and options template
+//FlowSets, and uses them to decode the data FlowSets that follow.
+//Templates are dropped when the packet sequence numbers reset,
+//signaling that the exporter restarted.
+type Decoder struct {
+	templates *native.TemplateCache
+	sequences *native.SequenceTracker
+}
+
+//NewDecoder creates a new Netflow v9 Decoder which stores the templates
+//it learns in the given TemplateCache
+func NewDecoder(templates *native.TemplateCache) *Decoder {
+	return &Decoder{
+		templates: templates,
+		sequences: native.NewSequenceTracker(),
+	}
+}
+
+//Decode decodes a Netflow v9 packet sent by the given exporter.
+//Templates are stored for later use, and the data records
+//are returned as input.Flows. Option records are ignored.
+func (d *Decoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	if len(packet) < packetHeaderLength {
+		return nil, []error{errors.Errorf("Netflow v9 packet from %s is too short: %d bytes", exporter, len(packet))}
+	}
+	version := binary.BigEndian.Uint16(packet[0:2])
+	if version != 9 {
+		return nil, []error{errors.Errorf("unsupported Netflow v9 version from %s: %d", exporter, version)}
+	}
+	header := packetHeader{
+		sysUptime: binary.BigEndian.Uint32(packet[4:8]),
+		unixSecs:  binary.BigEndian.Uint32(packet[8:12]),
+	}
+	sequence := binary.BigEndian.Uint32(packet[12:16])
+	source := native.Domain{
+		Exporter: exporter,
+		Version:  9,
+		ID:       binary.BigEndian.Uint32(packet[16:20]),
+	}
+
+	var flows []input.Flow
+	var errs []error
+
+	//the exporter restarted, its templates may no longer be valid
+	if d.sequences.Update(source, sequence) {
+		err := d.templates.RemoveDomain(source, nil)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	//Netflow v9 doesn't record the packet length in the header,
+	//the FlowSets run until the end of the packet
+	flowSets := packet[packetHeaderLength:]
+	for len(flowSets) > 0 {
+		if len(flowSets) < flowSetHeaderLength {
+			errs = append(errs, errors.Errorf("truncated Netflow v9 FlowSet header from %s", exporter))
+			break
+		}
+		flowSetID := binary.BigEndian.Uint16(flowSets[0:2])
+		flowSetLength := int(binary.BigEndian.Uint16(flowSets[2:4]))
+		if flowSetLength < flowSetHeaderLength || flowSetLength > len(flowSets) {
+			errs = append(errs, errors.Errorf("invalid Netflow v9 FlowSet length from %s: %d", exporter, flowSetLength))
+			break
+		}
+		flowSetBody := flowSets[flowSetHeaderLength:flowSetLength]
+		flowSets = flowSets[flowSetLength:]
+
+		if flowSetID == templateFlowSetID {
+			err := d.decodeTemplateFlowSet(source, flowSetBody)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		} else if flowSetID == optionsTemplateFlowSetID {
+			err := d.decodeOptionsTemplateFlowSet(source, flowSetBody)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		} else if flowSetID >= minDataFlowSetID {
+			flowSetFlows, flowSetErrs := d.decodeDataFlowSet(source, header, flowSetID, flowSetBody)
+			flows = append(flows, flowSetFlows...)
+			errs = append(errs, flowSetErrs...)
+		}
+		//FlowSet IDs 2-255 are reserved. Skip them.
+	}
+	return flows, errs
+}
+
+//decodeTemplateFlowSet stores each template in a template FlowSet
+//in the template cache
+func (d *Decoder) decodeTemplateFlowSet(source native.Domain, flowSetBody []byte) error {
+	//the FlowSet may be padded with fewer bytes than a template header
+	for len(flowSetBody) >= 4 {
+		templateID := binary.BigEndian.Uint16(flowSetBody[0:2])
+		fieldCount := binary.BigEndian.Uint16(flowSetBody[2:4])
+		flowSetBody = flowSetBody[4:]
+
+		if len(flowSetBody) < int(fieldCount)*4 {
+			return errors.Errorf("truncated Netflow v9 template %d from %s", templateID, source.Exporter)
+		}
+
+		template := native.Template{
+			ID:     templateID,
+			Fields: decodeFieldSpecifiers(flowSetBody[:int(fieldCount)*4]),
+		}
+		flowSetBody = flowSetBody[int(fieldCount)*4:]
+
+		err := checkFieldLengths(template.Fields)
+		if err != nil {
+			return errors.Wrapf(err, "invalid Netflow v9 template %d from %s", templateID, source.Exporter)
+		}
+
+		err = d.templates.Put(native.TemplateKey{Domain: source, TemplateID: templateID}, template)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//decodeOptionsTemplateFlowSet stores each template in an options
+//template FlowSet in the template cache
+func (d *Decoder) decodeOptionsTemplateFlowSet(source native.Domain, flowSetBody []byte) error {
+	//the FlowSet may be padded with fewer bytes than a template header
+	for len(flowSetBody) >= 6 {
+		templateID := binary.BigEndian.Uint16(flowSetBody[0:2])
+		//unlike the template FlowSet, the lengths are given in bytes
+		scopeLength := int(binary.BigEndian.Uint16(flowSetBody[2:4]))
+		optionLength := int(binary.BigEndian.Uint16(flowSetBody[4:6]))
+		flowSetBody = flowSetBody[6:]
+
+		if scopeLength%4 != 0 || optionLength%4 != 0 || len(flowSetBody) < scopeLength+optionLength {
+			return errors.Errorf("truncated Netflow v9 options template %d from %s", templateID, source.Exporter)
+		}
+
+		template := native.Template{
+			ID:              templateID,
+			ScopeFieldCount: uint16(scopeLength / 4),
+			Fields:          decodeFieldSpecifiers(flowSetBody[:scopeLength+optionLength]),
+		}
+		flowSetBody = flowSetBody[scopeLength+optionLength:]
+
+		//a template without scope fields can't be told apart from a normal template
+		if template.ScopeFieldCount == 0 {
+			return errors.Errorf("Netflow v9 options template %d from %s has no scope fields", templateID, source.Exporter)
+		}
+
+		err := checkFieldLengths(template.Fields)
+		if err != nil {
+			return errors.Wrapf(err, "invalid Netflow v9 options template %d from %s", templateID, source.Exporter)
+		}
+
+		err = d.templates.Put(native.TemplateKey{Domain: source, TemplateID: templateID}, template)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//decodeFieldSpecifiers decodes a run of (type, length) field definitions
+func decodeFieldSpecifiers(data []byte) []native.FieldSpecifier {
+	fields := make([]native.FieldSpecifier, 0, len(data)/4)
+	for len(data) >= 4 {
+		fields = append(fields, native.FieldSpecifier{
+			ID:     binary.BigEndian.Uint16(data[0:2]),
+			Length: binary.BigEndian.Uint16(data[2:4]),
+		})
+		data = data[4:]
+	}
+	return fields
+}
+
+//checkFieldLengths ensures each field has a usable length. Unlike
+//IPFIX, Netflow v9 has no variable length fields, so the IPFIX
+//variable length marker is rejected along with empty fields.
+func checkFieldLengths(fields []native.FieldSpecifier) error {
+	for i := range fields {
+		if fields[i].Length == 0 || fields[i].Length == native.VariableLength {
+			return errors.Errorf("field %d has invalid length %d", fields[i].ID, fields[i].Length)
+		}
+	}
+	return nil
+}
+
+//decodeDataFlowSet decodes the data records in a data FlowSet using
+//the template referenced by the FlowSet ID
+func (d *Decoder) decodeDataFlowSet(source native.Domain, header packetHeader,
+	flowSetID uint16, flowSetBody []byte) ([]input.Flow, []error) {
+
+	template, ok := d.templates.Get(native.TemplateKey{Domain: source, TemplateID: flowSetID})
+	if !ok {
+		return nil, []error{errors.Errorf(
+			"no template %d found for exporter %s with source ID %d",
+			flowSetID, source.Exporter, source.ID,
+		)}
+	}
+
+	//option records carry metadata about the exporter rather than flows
+	if template.IsOptionsTemplate() {
+		return nil, nil
+	}
+
+	recordLength := template.MinRecordLength()
+	if recordLength == 0 {
+		return nil, []error{errors.Errorf("template %d from %s describes empty records", flowSetID, source.Exporter)}
+	}
+
+	var flows []input.Flow
+	var errs []error
+	//anything shorter than a record is padding
+	for len(flowSetBody) >= recordLength {
+		record, err := decodeDataRecord(template, flowSetBody[:recordLength])
+		if err != nil {
+			//every record uses the same template, the rest can't be read either
+			errs = append(errs, errors.Wrapf(err, "could not decode record with template %d from %s", flowSetID, source.Exporter))
+			break
+		}
+		flowSetBody = flowSetBody[recordLength:]
+
+		flow := &native.Flow{}
+		err = fillFlow(source, header, record, flow)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		flows = append(flows, flow)
+	}
+	return flows, errs
+}
@@ -0,0 +1,314 @@
+package netflow9_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/input/native/netflow9"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/stretchr/testify/require"
+)
+
+/*  **********  Helper Functions  **********  */
+
+//newPacket wraps the given FlowSets in a Netflow v9 packet header
+func newPacket(sysUptime uint32, unixSecs uint32, sourceID uint32, flowSets ...[]byte) []byte {
+	packet := make([]byte, 20)
+	binary.BigEndian.PutUint16(packet[0:2], 9)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(flowSets)))
+	binary.BigEndian.PutUint32(packet[4:8], sysUptime)
+	binary.BigEndian.PutUint32(packet[8:12], unixSecs)
+	binary.BigEndian.PutUint32(packet[16:20], sourceID)
+	for i := range flowSets {
+		packet = append(packet, flowSets[i]...)
+	}
+	return packet
+}
+
+//newFlowSet wraps the given records in a FlowSet header
+func newFlowSet(flowSetID uint16, records ...[]byte) []byte {
+	flowSet := make([]byte, 4)
+	binary.BigEndian.PutUint16(flowSet[0:2], flowSetID)
+	for i := range records {
+		flowSet = append(flowSet, records[i]...)
+	}
+	binary.BigEndian.PutUint16(flowSet[2:4], uint16(len(flowSet)))
+	return flowSet
+}
+
+//newTemplate creates a template record. Each field is given as {type, length}.
+func newTemplate(templateID uint16, fields ...[2]uint16) []byte {
+	record := append(u16(templateID), u16(uint16(len(fields)))...)
+	for _, field := range fields {
+		record = append(record, u16(field[0])...)
+		record = append(record, u16(field[1])...)
+	}
+	return record
+}
+
+//newOptionsTemplate creates an options template record
+func newOptionsTemplate(templateID uint16, scopeFields [][2]uint16, optionFields [][2]uint16) []byte {
+	record := u16(templateID)
+	record = append(record, u16(uint16(len(scopeFields)*4))...)
+	record = append(record, u16(uint16(len(optionFields)*4))...)
+	for _, field := range append(scopeFields, optionFields...) {
+		record = append(record, u16(field[0])...)
+		record = append(record, u16(field[1])...)
+	}
+	return record
+}
+
+func u16(value uint16) []byte {
+	out := make([]byte, 2)
+	binary.BigEndian.PutUint16(out, value)
+	return out
+}
+
+func u32(value uint32) []byte {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, value)
+	return out
+}
+
+func ip(address string) []byte {
+	parsed := net.ParseIP(address)
+	if v4 := parsed.To4(); v4 != nil {
+		return v4
+	}
+	return parsed
+}
+
+func concat(fields ...[]byte) []byte {
+	var out []byte
+	for i := range fields {
+		out = append(out, fields[i]...)
+	}
+	return out
+}
+
+//asaTemplate describes a NAT'ed IPv4 flow similar to those sent by Cisco ASAs
+var asaTemplate = newTemplate(256,
+	[2]uint16{8, 4},   //IPV4_SRC_ADDR
+	[2]uint16{12, 4},  //IPV4_DST_ADDR
+	[2]uint16{7, 2},   //L4_SRC_PORT
+	[2]uint16{11, 2},  //L4_DST_PORT
+	[2]uint16{4, 1},   //PROTOCOL
+	[2]uint16{1, 4},   //IN_BYTES
+	[2]uint16{2, 4},   //IN_PKTS
+	[2]uint16{22, 4},  //FIRST_SWITCHED
+	[2]uint16{21, 4},  //LAST_SWITCHED
+	[2]uint16{226, 4}, //XLATE_DST_ADDR_IPV4
+	[2]uint16{228, 2}, //XLATE_DST_PORT
+)
+
+func newASARecord(src string, dst string, srcPort uint16, dstPort uint16,
+	octets uint32, packets uint32, firstSwitched uint32, lastSwitched uint32,
+	xlateDst string, xlatePort uint16) []byte {
+	return concat(
+		ip(src), ip(dst), u16(srcPort), u16(dstPort), []byte{uint8(protocols.TCP)},
+		u32(octets), u32(packets), u32(firstSwitched), u32(lastSwitched),
+		ip(xlateDst), u16(xlatePort),
+	)
+}
+
+/*  **********  Tests  **********  */
+
+func TestDecodeTemplateAndData(t *testing.T) {
+	decoder := netflow9.NewDecoder(native.NewTemplateCache())
+	packet := newPacket(100000, 1525473401, 1,
+		newFlowSet(0, asaTemplate),
+		newFlowSet(256,
+			newASARecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 95000, 99500, "3.3.3.3", 8443),
+			//the trailing bytes are padding
+			[]byte{0, 0},
+		),
+	)
+
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	flow := flows[0]
+	require.Equal(t, "A", flow.Exporter())
+	require.Equal(t, "1.1.1.1", flow.SourceIPAddress())
+	require.Equal(t, uint16(24846), flow.SourcePort())
+	//post NAT fields replace the destination
+	require.Equal(t, "3.3.3.3", flow.DestinationIPAddress())
+	require.Equal(t, uint16(8443), flow.DestinationPort())
+	require.Equal(t, protocols.TCP, flow.ProtocolIdentifier())
+	require.Equal(t, int64(5000), flow.OctetTotalCount())
+	require.Equal(t, int64(10), flow.PacketTotalCount())
+	require.Equal(t, input.EndOfFlow, flow.FlowEndReason())
+	require.Equal(t, uint8(9), flow.Version())
+
+	//the flow started 5 seconds and ended 0.5 seconds before the packet was sent
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000-5000), flowStart)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000-500), flowEnd)
+}
+
+func TestDecodeSysUptimeWrap(t *testing.T) {
+	decoder := netflow9.NewDecoder(native.NewTemplateCache())
+	//the exporter's uptime wrapped around after the flow started
+	packet := newPacket(1000, 1525473401, 1,
+		newFlowSet(0, asaTemplate),
+		newFlowSet(256,
+			newASARecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 0xFFFFFFFF-999, 500, "2.2.2.2", 443),
+		),
+	)
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+	flowStart, err := flows[0].FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000-2000), flowStart)
+	flowEnd, err := flows[0].FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000-500), flowEnd)
+}
+
+func TestDecodeTemplateScope(t *testing.T) {
+	decoder := netflow9.NewDecoder(native.NewTemplateCache())
+	_, errs := decoder.Decode("A", newPacket(100000, 1525473401, 1, newFlowSet(0, asaTemplate)))
+	require.Len(t, errs, 0)
+
+	dataFlowSet := newFlowSet(256,
+		newASARecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 95000, 99500, "2.2.2.2", 443),
+	)
+
+	flows, errs := decoder.Decode("A", newPacket(100000, 1525473401, 1, dataFlowSet))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	//templates are scoped to the exporter and source ID
+	flows, errs = decoder.Decode("A", newPacket(100000, 1525473401, 2, dataFlowSet))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+
+	flows, errs = decoder.Decode("B", newPacket(100000, 1525473401, 1, dataFlowSet))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
+
+func TestDecodeIPv6AndOptions(t *testing.T) {
+	decoder := netflow9.NewDecoder(native.NewTemplateCache())
+	optionsTemplate := newOptionsTemplate(257,
+		[][2]uint16{{1, 4}},           //System scope
+		[][2]uint16{{34, 4}, {35, 1}}, //SAMPLING_INTERVAL, SAMPLING_ALGORITHM
+	)
+	ipv6Template := newTemplate(258,
+		[2]uint16{27, 16}, //IPV6_SRC_ADDR
+		[2]uint16{28, 16}, //IPV6_DST_ADDR
+		[2]uint16{7, 2},   //L4_SRC_PORT
+		[2]uint16{11, 2},  //L4_DST_PORT
+		[2]uint16{4, 1},   //PROTOCOL
+		[2]uint16{1, 8},   //IN_BYTES
+		[2]uint16{2, 8},   //IN_PKTS
+		[2]uint16{22, 4},  //FIRST_SWITCHED
+		[2]uint16{21, 4},  //LAST_SWITCHED
+	)
+	ipv6Record := concat(
+		ip("2001:db8::1"), ip("2001:db8::2"), u16(53), u16(5353), []byte{uint8(protocols.UDP)},
+		u32(0), u32(100), u32(0), u32(1), u32(1000), u32(2000),
+	)
+	packet := newPacket(3000, 1525473401, 1,
+		newFlowSet(1, optionsTemplate),
+		newFlowSet(0, ipv6Template),
+		newFlowSet(257, concat(u32(1), u32(100), []byte{2})),
+		newFlowSet(258, ipv6Record),
+	)
+
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+	require.Equal(t, "2001:db8::1", flows[0].SourceIPAddress())
+	require.Equal(t, "2001:db8::2", flows[0].DestinationIPAddress())
+	require.Equal(t, int64(100), flows[0].OctetTotalCount())
+	require.Equal(t, int64(1), flows[0].PacketTotalCount())
+	flowStart, err := flows[0].FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000-2000), flowStart)
+}
+
+func TestDecodeMissingFields(t *testing.T) {
+	decoder := netflow9.NewDecoder(native.NewTemplateCache())
+	noPortsTemplate := newTemplate(256,
+		[2]uint16{8, 4},  //IPV4_SRC_ADDR
+		[2]uint16{12, 4}, //IPV4_DST_ADDR
+		[2]uint16{4, 1},  //PROTOCOL
+		[2]uint16{1, 4},  //IN_BYTES
+		[2]uint16{2, 4},  //IN_PKTS
+		[2]uint16{22, 4}, //FIRST_SWITCHED
+		[2]uint16{21, 4}, //LAST_SWITCHED
+	)
+	packet := newPacket(100000, 1525473401, 1,
+		newFlowSet(0, noPortsTemplate),
+		newFlowSet(256, concat(
+			ip("1.1.1.1"), ip("2.2.2.2"), []byte{uint8(protocols.ICMP)},
+			u32(100), u32(1), u32(99000), u32(99000),
+		)),
+	)
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
+
+func TestDecodeSequenceReset(t *testing.T) {
+	decoder := netflow9.NewDecoder(native.NewTemplateCache())
+	dataFlowSet := newFlowSet(256,
+		newASARecord("1.1.1.1", "2.2.2.2", 24846, 443, 5000, 10, 95000, 99500, "2.2.2.2", 443),
+	)
+
+	packet := newPacket(100000, 1525473401, 1, newFlowSet(0, asaTemplate), dataFlowSet)
+	binary.BigEndian.PutUint32(packet[12:16], 50000)
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	//the exporter restarted and has not resent its templates
+	flows, errs = decoder.Decode("A", newPacket(1000, 1525473401, 1, dataFlowSet))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
+
+func TestDecodeInvalidFieldLength(t *testing.T) {
+	templates := native.NewTemplateCache()
+	decoder := netflow9.NewDecoder(templates)
+	//Netflow v9 has no variable length fields
+	packet := newPacket(100000, 1525473401, 1,
+		newFlowSet(0, newTemplate(256, [2]uint16{8, 4}, [2]uint16{12, 65535})),
+		newFlowSet(256, concat(ip("1.1.1.1"), ip("2.2.2.2"), u32(0))),
+	)
+	flows, errs := decoder.Decode("A", packet)
+	require.Len(t, flows, 0)
+	//the template is rejected, so the data can't be decoded
+	require.Len(t, errs, 2)
+
+	packet = newPacket(100000, 1525473401, 1,
+		newFlowSet(1, newOptionsTemplate(257, [][2]uint16{{1, 4}}, [][2]uint16{{36, 0}})),
+	)
+	flows, errs = decoder.Decode("A", packet)
+	require.Len(t, flows, 0)
+	require.Len(t, errs, 1)
+
+	//templates cached before they were checked can't overrun the record
+	require.Nil(t, templates.Put(
+		native.TemplateKey{
+			Domain:     native.Domain{Exporter: "A", Version: 9, ID: 1},
+			TemplateID: 258,
+		},
+		native.Template{ID: 258, Fields: []native.FieldSpecifier{{ID: 8, Length: 4}, {ID: 12, Length: 65535}}},
+	))
+	packet = newPacket(100000, 1525473401, 1,
+		newFlowSet(258, concat(ip("1.1.1.1"), ip("2.2.2.2"), u32(0))),
+	)
+	flows, errs = decoder.Decode("A", packet)
+	require.Len(t, flows, 0)
+	require.Len(t, errs, 1)
+}
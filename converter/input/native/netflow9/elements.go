@@ -0,0 +1,33 @@
+package netflow9
+
+//Netflow v9 field type definitions used by the decoder.
+//See https://www.cisco.com/en/US/technologies/tk648/tk362/technologies_white_paper09186a00800a3db9.html
+const (
+	inBytes          uint16 = 1
+	inPkts           uint16 = 2
+	protocol         uint16 = 4
+	l4SrcPort        uint16 = 7
+	ipv4SrcAddr      uint16 = 8
+	l4DstPort        uint16 = 11
+	ipv4DstAddr      uint16 = 12
+	lastSwitched     uint16 = 21
+	firstSwitched    uint16 = 22
+	ipv6SrcAddr      uint16 = 27
+	ipv6DstAddr      uint16 = 28
+	xlateDstAddrIPv4 uint16 = 226
+	xlateDstPort     uint16 = 228
+	xlateDstAddrIPv6 uint16 = 282
+)
+
+//FlowSet IDs as defined by RFC 3954 Section 5
+const (
+	templateFlowSetID        uint16 = 0
+	optionsTemplateFlowSetID uint16 = 1
+	minDataFlowSetID         uint16 = 256
+)
+
+//packetHeaderLength is the length of the Netflow v9 Packet Header
+const packetHeaderLength = 20
+
+//flowSetHeaderLength is the length of a Netflow v9 FlowSet Header
+const flowSetHeaderLength = 4
@@ -0,0 +1,222 @@
+package netflow9
+
+import (
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/pkg/errors"
+)
+
+//dataRecord maps the field types found in a data record
+//to their raw values
+type dataRecord map[uint16][]byte
+
+//decodeDataRecord splits a data record into its fields. Netflow v9
+//fields have a fixed length, so the data must hold exactly one record.
+//The values in the resulting dataRecord reference data.
+func decodeDataRecord(template native.Template, data []byte) (dataRecord, error) {
+	record := make(dataRecord, len(template.Fields))
+	offset := 0
+	for _, field := range template.Fields {
+		if offset+int(field.Length) > len(data) {
+			return nil, errors.Errorf(
+				"field %d with length %d overruns the %d byte record",
+				field.ID, field.Length, len(data),
+			)
+		}
+		record[field.ID] = data[offset : offset+int(field.Length)]
+		offset += int(field.Length)
+	}
+	return record, nil
+}
+
+//unsigned decodes an unsigned integer field. ok is false if the
+//field is not present in the record.
+func (r dataRecord) unsigned(id uint16) (value uint64, ok bool, err error) {
+	raw, ok := r[id]
+	if !ok {
+		return 0, false, nil
+	}
+	value, err = native.DecodeUnsigned(raw)
+	return value, true, errors.Wrapf(err, "could not decode Netflow v9 field %d", id)
+}
+
+//ipv4Address decodes an IPv4 address field. ok is false if the
+//field is not present in the record.
+func (r dataRecord) ipv4Address(id uint16) (value string, ok bool, err error) {
+	raw, ok := r[id]
+	if !ok {
+		return "", false, nil
+	}
+	value, err = native.DecodeIPv4Address(raw)
+	return value, true, errors.Wrapf(err, "could not decode Netflow v9 field %d", id)
+}
+
+//ipv6Address decodes an IPv6 address field. ok is false if the
+//field is not present in the record.
+func (r dataRecord) ipv6Address(id uint16) (value string, ok bool, err error) {
+	raw, ok := r[id]
+	if !ok {
+		return "", false, nil
+	}
+	value, err = native.DecodeIPv6Address(raw)
+	return value, true, errors.Wrapf(err, "could not decode Netflow v9 field %d", id)
+}
+
+//switchedMilliseconds converts a FIRST_SWITCHED or LAST_SWITCHED
+//value, which is given in milliseconds since the exporter booted,
+//into a Unix timestamp in milliseconds using the sysUptime and
+//unix_secs values in the packet header.
+func switchedMilliseconds(header packetHeader, switched uint32) int64 {
+	//sysUptime wraps around after ~49.7 days. Subtracting as
+	//uint32 and converting to int32 handles the wrap, and allows
+	//for flows which end slightly after the header was written.
+	age := int64(int32(header.sysUptime - switched))
+	return int64(header.unixSecs)*1000 - age
+}
+
+//fillFlow reads the data from a Netflow v9 data record and inserts it
+//into the output flow, returning nil if the conversion was successful.
+//The rules for which fields are used mirror those in
+//data.FlowDeserializer.
+func fillFlow(source native.Domain, header packetHeader,
+	record dataRecord, outputFlow *native.Flow) error {
+
+	sourceIPv4, sourceIPv4Ok, err := record.ipv4Address(ipv4SrcAddr)
+	if err != nil {
+		return err
+	}
+	var sourceIPv6 string
+	var sourceIPv6Ok bool
+	if !sourceIPv4Ok {
+		sourceIPv6, sourceIPv6Ok, err = record.ipv6Address(ipv6SrcAddr)
+		if err != nil {
+			return err
+		}
+		if !sourceIPv6Ok {
+			return errors.New("data record must contain field 'IPV4_SRC_ADDR' or 'IPV6_SRC_ADDR'")
+		}
+	}
+
+	sourcePort, ok, err := record.unsigned(l4SrcPort)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain field 'L4_SRC_PORT'")
+	}
+
+	destIPv4, destIPv4Ok, err := record.ipv4Address(ipv4DstAddr)
+	if err != nil {
+		return err
+	}
+	var destIPv6 string
+	var destIPv6Ok bool
+	if destIPv4Ok {
+		postNatDestIPv4, postNatDestIPv4Ok, err := record.ipv4Address(xlateDstAddrIPv4)
+		if err != nil {
+			return err
+		}
+		if postNatDestIPv4Ok {
+			destIPv4 = postNatDestIPv4
+		}
+	} else {
+		destIPv6, destIPv6Ok, err = record.ipv6Address(ipv6DstAddr)
+		if err != nil {
+			return err
+		}
+		if !destIPv6Ok {
+			return errors.New("data record must contain field 'IPV4_DST_ADDR' or 'IPV6_DST_ADDR'")
+		}
+		postNatDestIPv6, postNatDestIPv6Ok, err := record.ipv6Address(xlateDstAddrIPv6)
+		if err != nil {
+			return err
+		}
+		if postNatDestIPv6Ok {
+			destIPv6 = postNatDestIPv6
+		}
+	}
+
+	destPort, ok, err := record.unsigned(l4DstPort)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain field 'L4_DST_PORT'")
+	}
+	postNaptDestPort, postNaptDestPortOk, err := record.unsigned(xlateDstPort)
+	if err != nil {
+		return err
+	}
+	if postNaptDestPortOk {
+		destPort = postNaptDestPort
+	}
+
+	flowStart, ok, err := record.unsigned(firstSwitched)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain field 'FIRST_SWITCHED'")
+	}
+
+	flowEnd, ok, err := record.unsigned(lastSwitched)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain field 'LAST_SWITCHED'")
+	}
+
+	octetTotal, ok, err := record.unsigned(inBytes)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain field 'IN_BYTES'")
+	}
+
+	packetTotal, ok, err := record.unsigned(inPkts)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain field 'IN_PKTS'")
+	}
+
+	protocolID, ok, err := record.unsigned(protocol)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("data record must contain field 'PROTOCOL'")
+	}
+
+	//Fill in the flow now that we know we have all the data
+	outputFlow.Host = source.Exporter
+	if sourceIPv4Ok {
+		outputFlow.Netflow.SourceIPv4 = sourceIPv4
+	}
+	if sourceIPv6Ok {
+		outputFlow.Netflow.SourceIPv6 = sourceIPv6
+	}
+	outputFlow.Netflow.SourcePort = uint16(sourcePort)
+
+	if destIPv4Ok {
+		outputFlow.Netflow.DestinationIPv4 = destIPv4
+	}
+	if destIPv6Ok {
+		outputFlow.Netflow.DestinationIPv6 = destIPv6
+	}
+	outputFlow.Netflow.DestinationPort = uint16(destPort)
+
+	outputFlow.Netflow.FlowStartMilliseconds = switchedMilliseconds(header, uint32(flowStart))
+	outputFlow.Netflow.FlowEndMilliseconds = switchedMilliseconds(header, uint32(flowEnd))
+	outputFlow.Netflow.OctetTotalCount = int64(octetTotal)
+	outputFlow.Netflow.PacketTotalCount = int64(packetTotal)
+	outputFlow.Netflow.ProtocolIdentifier = protocols.Identifier(protocolID)
+	//assume end of flow since we don't have the data
+	outputFlow.Netflow.FlowEndReason = input.EndOfFlow
+	outputFlow.Netflow.Version = 9
+	return nil
+}
@@ -0,0 +1,161 @@
+package nfcapd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/pkg/errors"
+)
+
+//Reader implements input.Reader by reading the binary files
+//written by nfcapd (nfdump 1.6, file layout version 1).
+//The files are read one after another, and the channels are closed
+//once each file has been read.
+//nfdump writes its files using the byte order of the machine
+//which wrote them. Both byte orders are supported.
+//Compressed files are not supported. They may be decompressed with
+//nfdump -r <compressed file> -w <uncompressed file>.
+type Reader struct {
+	paths []string
+	log   logging.Logger
+}
+
+//NewReader returns a new input.Reader which reads the nfcapd
+//files at the given paths. Each path may name a file,
+//a directory holding nfcapd files, or a glob pattern.
+func NewReader(paths []string, log logging.Logger) input.Reader {
+	return Reader{
+		paths: paths,
+		log:   log,
+	}
+}
+
+//Drain asynchronously reads each of the nfcapd files in order
+func (r Reader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	out := make(chan input.Flow)
+	errs := make(chan error)
+
+	go func(out chan<- input.Flow, errs chan<- error) {
+		files, err := native.ExpandPaths(r.paths)
+		if err != nil {
+			errs <- err
+		}
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			r.readFile(ctx, file, out, errs)
+		}
+		close(errs)
+		close(out)
+	}(out, errs)
+
+	return out, errs
+}
+
+//readFile decodes the flow records held in a file until
+//the end of the file is reached or the context is cancelled
+func (r Reader) readFile(ctx context.Context, path string,
+	out chan<- input.Flow, errs chan<- error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		errs <- errors.Wrapf(err, "could not open nfcapd file %s", path)
+		return
+	}
+	defer file.Close()
+
+	r.log.Info("reading nfcapd file", logging.Fields{"file": path})
+	stream := bufio.NewReader(file)
+	byteOrder, numBlocks, err := readFileHeader(stream)
+	if err != nil {
+		errs <- errors.Wrapf(err, "could not read nfcapd file %s", path)
+		return
+	}
+
+	decoder := newBlockDecoder(path, byteOrder)
+	flowCount := 0
+	for i := uint32(0); i < numBlocks && ctx.Err() == nil; i++ {
+		blockHeader := make([]byte, dataBlockHeaderLength)
+		_, err := io.ReadFull(stream, blockHeader)
+		if err != nil {
+			errs <- errors.Wrapf(err, "could not read block %d header from %s", i, path)
+			break
+		}
+		numRecords := byteOrder.Uint32(blockHeader[0:4])
+		size := byteOrder.Uint32(blockHeader[4:8])
+		blockType := byteOrder.Uint16(blockHeader[8:10])
+
+		if size > maxBlockSize {
+			errs <- errors.Errorf("block %d from %s is too large: %d bytes", i, path, size)
+			break
+		}
+		block := make([]byte, size)
+		_, err = io.ReadFull(stream, block)
+		if err != nil {
+			errs <- errors.Wrapf(err, "could not read block %d from %s", i, path)
+			break
+		}
+
+		//only blocks holding nfdump 1.6 records are supported
+		if blockType != dataBlockType2 {
+			continue
+		}
+
+		flows, decodeErrs := decoder.decodeBlock(numRecords, block)
+		for j := range decodeErrs {
+			errs <- errors.Wrapf(decodeErrs[j], "could not decode block %d from %s", i, path)
+		}
+		for j := range flows {
+			out <- flows[j]
+		}
+		flowCount += len(flows)
+	}
+	r.log.Info("finished reading nfcapd file", logging.Fields{
+		"file":  path,
+		"flows": flowCount,
+	})
+}
+
+//readFileHeader reads the file header and the statistics record which
+//follows it. The byte order of the file and the number of data blocks
+//in the file are returned.
+func readFileHeader(stream io.Reader) (binary.ByteOrder, uint32, error) {
+	header := make([]byte, fileHeaderLength)
+	_, err := io.ReadFull(stream, header)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not read file header")
+	}
+
+	var byteOrder binary.ByteOrder
+	if binary.LittleEndian.Uint16(header[0:2]) == fileMagic {
+		byteOrder = binary.LittleEndian
+	} else if binary.BigEndian.Uint16(header[0:2]) == fileMagic {
+		byteOrder = binary.BigEndian
+	} else {
+		return nil, 0, errors.New("file is not an nfcapd file")
+	}
+
+	version := byteOrder.Uint16(header[2:4])
+	if version != layoutVersion1 {
+		return nil, 0, errors.Errorf("unsupported nfcapd file layout version %d", version)
+	}
+
+	flags := byteOrder.Uint32(header[4:8])
+	if flags&compressionFlags != 0 {
+		return nil, 0, errors.New("compressed nfcapd files are not supported")
+	}
+	numBlocks := byteOrder.Uint32(header[8:12])
+
+	_, err = io.ReadFull(stream, make([]byte, statRecordLength))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not read statistics record")
+	}
+	return byteOrder, numBlocks, nil
+}
@@ -0,0 +1,239 @@
+package nfcapd_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native/nfcapd"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/stretchr/testify/require"
+)
+
+/*  **********  Helper Functions  **********  */
+
+//newFile creates an nfcapd file holding the given data blocks
+func newFile(byteOrder binary.ByteOrder, flags uint32, blocks ...[]byte) []byte {
+	file := make([]byte, 140+136)
+	byteOrder.PutUint16(file[0:2], 0xA50C)
+	byteOrder.PutUint16(file[2:4], 1)
+	byteOrder.PutUint32(file[4:8], flags)
+	byteOrder.PutUint32(file[8:12], uint32(len(blocks)))
+	for i := range blocks {
+		file = append(file, blocks[i]...)
+	}
+	return file
+}
+
+//newBlock creates a data block holding the given records
+func newBlock(byteOrder binary.ByteOrder, records ...[]byte) []byte {
+	block := make([]byte, 12)
+	byteOrder.PutUint32(block[0:4], uint32(len(records)))
+	byteOrder.PutUint16(block[8:10], 2)
+	for i := range records {
+		block = append(block, records[i]...)
+	}
+	byteOrder.PutUint32(block[4:8], uint32(len(block)-12))
+	return block
+}
+
+//putIPv4 stores an IPv4 address as a 32 bit integer
+func putIPv4(byteOrder binary.ByteOrder, out []byte, address string) {
+	byteOrder.PutUint32(out, binary.BigEndian.Uint32(net.ParseIP(address).To4()))
+}
+
+//putIPv6 stores an IPv6 address as two 64 bit integers
+func putIPv6(byteOrder binary.ByteOrder, out []byte, address string) {
+	ip := net.ParseIP(address).To16()
+	byteOrder.PutUint64(out[0:8], binary.BigEndian.Uint64(ip[0:8]))
+	byteOrder.PutUint64(out[8:16], binary.BigEndian.Uint64(ip[8:16]))
+}
+
+//newExporterInfo creates an exporter info record for an IPv4 exporter
+func newExporterInfo(byteOrder binary.ByteOrder, sysID uint16, version uint32, address string) []byte {
+	record := make([]byte, 32)
+	byteOrder.PutUint16(record[0:2], 7)
+	byteOrder.PutUint16(record[2:4], 32)
+	byteOrder.PutUint32(record[4:8], version)
+	if byteOrder == binary.LittleEndian {
+		putIPv4(byteOrder, record[16:20], address)
+	} else {
+		putIPv4(byteOrder, record[20:24], address)
+	}
+	byteOrder.PutUint16(record[24:26], 2)
+	byteOrder.PutUint16(record[26:28], sysID)
+	return record
+}
+
+//newCommonRecord creates a flow record. IPv6 addresses and 64 bit
+//counters are used if the addresses are IPv6 addresses.
+func newCommonRecord(byteOrder binary.ByteOrder, sysID uint16,
+	src string, dst string, srcPort uint16, dstPort uint16, protocol protocols.Identifier,
+	packets uint64, bytes uint64, first uint32, msecFirst uint16, last uint32, msecLast uint16) []byte {
+
+	ipv6 := net.ParseIP(src).To4() == nil
+	record := make([]byte, 32)
+	byteOrder.PutUint16(record[0:2], 10)
+	byteOrder.PutUint16(record[8:10], msecFirst)
+	byteOrder.PutUint16(record[10:12], msecLast)
+	byteOrder.PutUint32(record[12:16], first)
+	byteOrder.PutUint32(record[16:20], last)
+	record[22] = uint8(protocol)
+	byteOrder.PutUint16(record[24:26], srcPort)
+	byteOrder.PutUint16(record[26:28], dstPort)
+	byteOrder.PutUint16(record[28:30], sysID)
+
+	if ipv6 {
+		byteOrder.PutUint16(record[4:6], 0x01|0x02|0x04)
+		addresses := make([]byte, 32)
+		putIPv6(byteOrder, addresses[0:16], src)
+		putIPv6(byteOrder, addresses[16:32], dst)
+		counters := make([]byte, 16)
+		byteOrder.PutUint64(counters[0:8], packets)
+		byteOrder.PutUint64(counters[8:16], bytes)
+		record = append(record, addresses...)
+		record = append(record, counters...)
+	} else {
+		addresses := make([]byte, 8)
+		putIPv4(byteOrder, addresses[0:4], src)
+		putIPv4(byteOrder, addresses[4:8], dst)
+		counters := make([]byte, 8)
+		byteOrder.PutUint32(counters[0:4], uint32(packets))
+		byteOrder.PutUint32(counters[4:8], uint32(bytes))
+		record = append(record, addresses...)
+		record = append(record, counters...)
+	}
+	//optional extensions follow the required ones
+	record = append(record, make([]byte, 8)...)
+	byteOrder.PutUint16(record[2:4], uint16(len(record)))
+	return record
+}
+
+//drainAll reads from a Reader until its channels are closed
+func drainAll(reader input.Reader) ([]input.Flow, []error) {
+	flows, errs := reader.Drain(context.Background())
+	var outFlows []input.Flow
+	var outErrs []error
+	for flows != nil || errs != nil {
+		select {
+		case flow, ok := <-flows:
+			if !ok {
+				flows = nil
+				continue
+			}
+			outFlows = append(outFlows, flow)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			outErrs = append(outErrs, err)
+		}
+	}
+	return outFlows, outErrs
+}
+
+/*  **********  Tests  **********  */
+
+func TestReader(t *testing.T) {
+	for _, byteOrder := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(byteOrder.String(), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "nfcapd")
+			require.Nil(t, err)
+			defer os.RemoveAll(dir)
+
+			file := newFile(byteOrder, 0,
+				newBlock(byteOrder,
+					newExporterInfo(byteOrder, 1, 9, "10.0.0.1"),
+					newCommonRecord(byteOrder, 1, "1.1.1.1", "2.2.2.2", 24846, 443, protocols.TCP,
+						10, 5000, 1525473401, 250, 1525473405, 750),
+				),
+				newBlock(byteOrder,
+					newCommonRecord(byteOrder, 1, "2001:db8::1", "2001:db8::2", 5353, 53, protocols.UDP,
+						1, 80, 1525473406, 0, 1525473406, 0),
+				),
+			)
+			require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "nfcapd.201805042250"), file, 0644))
+
+			flows, errs := drainAll(nfcapd.NewReader([]string{dir}, logging.NewTestLogger(t)))
+			require.Len(t, errs, 0)
+			require.Len(t, flows, 2)
+
+			flow := flows[0]
+			require.Equal(t, "10.0.0.1", flow.Exporter())
+			require.Equal(t, "1.1.1.1", flow.SourceIPAddress())
+			require.Equal(t, "2.2.2.2", flow.DestinationIPAddress())
+			require.Equal(t, uint16(24846), flow.SourcePort())
+			require.Equal(t, uint16(443), flow.DestinationPort())
+			require.Equal(t, protocols.TCP, flow.ProtocolIdentifier())
+			require.Equal(t, int64(10), flow.PacketTotalCount())
+			require.Equal(t, int64(5000), flow.OctetTotalCount())
+			require.Equal(t, uint8(9), flow.Version())
+			flowStart, err := flow.FlowStartMilliseconds()
+			require.Nil(t, err)
+			require.Equal(t, int64(1525473401250), flowStart)
+			flowEnd, err := flow.FlowEndMilliseconds()
+			require.Nil(t, err)
+			require.Equal(t, int64(1525473405750), flowEnd)
+
+			flow = flows[1]
+			require.Equal(t, "10.0.0.1", flow.Exporter())
+			require.Equal(t, "2001:db8::1", flow.SourceIPAddress())
+			require.Equal(t, "2001:db8::2", flow.DestinationIPAddress())
+			require.Equal(t, uint16(53), flow.DestinationPort())
+			require.Equal(t, protocols.UDP, flow.ProtocolIdentifier())
+			require.Equal(t, int64(80), flow.OctetTotalCount())
+		})
+	}
+}
+
+func TestReaderUnknownExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfcapd")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	byteOrder := binary.LittleEndian
+	path := filepath.Join(dir, "nfcapd.201805042250")
+	file := newFile(byteOrder, 0, newBlock(byteOrder,
+		newCommonRecord(byteOrder, 3, "1.1.1.1", "2.2.2.2", 24846, 443, protocols.TCP,
+			10, 5000, 1525473401, 0, 1525473405, 0),
+	))
+	require.Nil(t, ioutil.WriteFile(path, file, 0644))
+
+	flows, errs := drainAll(nfcapd.NewReader([]string{path}, logging.NewTestLogger(t)))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+	require.Equal(t, path+"#3", flows[0].Exporter())
+}
+
+func TestReaderInvalidFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfcapd")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	byteOrder := binary.LittleEndian
+	block := newBlock(byteOrder,
+		newCommonRecord(byteOrder, 1, "1.1.1.1", "2.2.2.2", 24846, 443, protocols.TCP,
+			10, 5000, 1525473401, 0, 1525473405, 0),
+	)
+
+	//LZO compressed
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a"), newFile(byteOrder, 0x01, block), 0644))
+	//not an nfcapd file
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "b"), make([]byte, 300), 0644))
+	//truncated in the middle of the block
+	truncated := newFile(byteOrder, 0, block)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "c"), truncated[:len(truncated)-10], 0644))
+	//valid
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "d"), newFile(byteOrder, 0, block), 0644))
+
+	flows, errs := drainAll(nfcapd.NewReader([]string{dir}, logging.NewTestLogger(t)))
+	require.Len(t, errs, 3)
+	require.Len(t, flows, 1)
+}
@@ -0,0 +1,245 @@
+package nfcapd
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/pkg/errors"
+)
+
+//nfcapd file layout. See nffile.h and nfx.h in nfdump 1.6.
+const (
+	fileMagic      uint16 = 0xA50C
+	layoutVersion1 uint16 = 1
+
+	//fileHeaderLength is the length of the file header, including
+	//the 128 byte identification string
+	fileHeaderLength = 140
+	//statRecordLength is the length of the statistics record
+	//which follows the file header
+	statRecordLength = 136
+	//dataBlockHeaderLength is the length of the header which
+	//precedes each block of records
+	dataBlockHeaderLength = 12
+	//maxBlockSize is the size of nfdump's block buffer (BUFFSIZE)
+	maxBlockSize = 5 * 1024 * 1024
+
+	dataBlockType2 uint16 = 2
+)
+
+//compressionFlags holds the file header flags for LZO, bzip2,
+//LZ4, and zstd compressed blocks
+const compressionFlags uint32 = 0x01 | 0x08 | 0x10 | 0x20
+
+//Record types
+const (
+	recordHeaderLength = 4
+
+	exporterInfoRecordType uint16 = 7
+	commonRecordType       uint16 = 10
+)
+
+//exporterInfoRecordLength is the length of an exporter info record
+const exporterInfoRecordLength = 32
+
+//commonRecordHeaderLength is the length of the fixed portion of
+//a common record. The addresses and counters follow it.
+const commonRecordHeaderLength = 32
+
+//Common record flags
+const (
+	flagIPv6Address uint16 = 0x01
+	flagPackets64   uint16 = 0x02
+	flagBytes64     uint16 = 0x04
+)
+
+//afInet is the address family used for IPv4 exporters
+const afInet = 2
+
+//exporter describes an exporter listed in an exporter info record
+type exporter struct {
+	address string
+	version uint8
+}
+
+//blockDecoder decodes the records held in the data blocks of a file.
+//Exporter info records are held so they can be referenced by
+//later flow records in the same file.
+type blockDecoder struct {
+	path      string
+	byteOrder binary.ByteOrder
+	exporters map[uint16]exporter
+}
+
+//newBlockDecoder creates a blockDecoder for the file at the
+//given path which was written using the given byte order
+func newBlockDecoder(path string, byteOrder binary.ByteOrder) *blockDecoder {
+	return &blockDecoder{
+		path:      path,
+		byteOrder: byteOrder,
+		exporters: make(map[uint16]exporter),
+	}
+}
+
+//decodeBlock decodes the records held in a data block.
+//Records other than flow records and exporter info records are skipped.
+func (b *blockDecoder) decodeBlock(numRecords uint32, block []byte) ([]input.Flow, []error) {
+	var flows []input.Flow
+	var errs []error
+	for i := uint32(0); i < numRecords; i++ {
+		if len(block) < recordHeaderLength {
+			errs = append(errs, errors.Errorf("block ended after %d of %d records", i, numRecords))
+			break
+		}
+		recordType := b.byteOrder.Uint16(block[0:2])
+		size := int(b.byteOrder.Uint16(block[2:4]))
+		if size < recordHeaderLength || size > len(block) {
+			errs = append(errs, errors.Errorf("record %d has invalid size %d", i, size))
+			break
+		}
+		record := block[:size]
+		block = block[size:]
+
+		switch recordType {
+		case exporterInfoRecordType:
+			err := b.decodeExporterInfo(record)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "could not decode record %d", i))
+			}
+		case commonRecordType:
+			flow, err := b.decodeCommonRecord(record)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "could not decode record %d", i))
+				continue
+			}
+			flows = append(flows, flow)
+		}
+	}
+	return flows, errs
+}
+
+//decodeExporterInfo reads an exporter info record, which maps
+//the system ID used by flow records to the exporter's address
+func (b *blockDecoder) decodeExporterInfo(record []byte) error {
+	if len(record) < exporterInfoRecordLength {
+		return errors.Errorf("exporter info record is too short: %d bytes", len(record))
+	}
+	version := b.byteOrder.Uint32(record[4:8])
+	address := record[8:24]
+	family := b.byteOrder.Uint16(record[24:26])
+	sysID := b.byteOrder.Uint16(record[26:28])
+
+	var exporterAddress string
+	if family == afInet {
+		//IPv4 addresses are stored in the third word on little endian
+		//machines and in the fourth word on big endian machines
+		offset := 12
+		if b.byteOrder == binary.LittleEndian {
+			offset = 8
+		}
+		exporterAddress = b.decodeIPv4(address[offset : offset+4])
+	} else {
+		exporterAddress = b.decodeIPv6(address)
+	}
+
+	b.exporters[sysID] = exporter{
+		address: exporterAddress,
+		version: uint8(version),
+	}
+	return nil
+}
+
+//decodeCommonRecord converts a common record into a flow
+func (b *blockDecoder) decodeCommonRecord(record []byte) (input.Flow, error) {
+	if len(record) < commonRecordHeaderLength {
+		return nil, errors.Errorf("flow record is too short: %d bytes", len(record))
+	}
+	flags := b.byteOrder.Uint16(record[4:6])
+
+	//the addresses and counters are required extensions
+	//which always follow the fixed portion of the record
+	addressLength := 4
+	if flags&flagIPv6Address != 0 {
+		addressLength = 16
+	}
+	packetsLength := 4
+	if flags&flagPackets64 != 0 {
+		packetsLength = 8
+	}
+	bytesLength := 4
+	if flags&flagBytes64 != 0 {
+		bytesLength = 8
+	}
+	expectedLength := commonRecordHeaderLength + 2*addressLength + packetsLength + bytesLength
+	if len(record) < expectedLength {
+		return nil, errors.Errorf("flow record is too short: expected %d bytes, found %d", expectedLength, len(record))
+	}
+
+	outputFlow := &native.Flow{}
+	sysID := b.byteOrder.Uint16(record[28:30])
+	exporter, ok := b.exporters[sysID]
+	if !ok {
+		//fall back to identifying the exporter by its ID within the file
+		exporter.address = b.path + "#" + strconv.Itoa(int(sysID))
+	}
+	outputFlow.Host = exporter.address
+	outputFlow.Netflow.Version = exporter.version
+	if outputFlow.Netflow.Version == 0 {
+		outputFlow.Netflow.Version = 9
+	}
+
+	msecFirst := int64(b.byteOrder.Uint16(record[8:10]))
+	msecLast := int64(b.byteOrder.Uint16(record[10:12]))
+	first := int64(b.byteOrder.Uint32(record[12:16]))
+	last := int64(b.byteOrder.Uint32(record[16:20]))
+	outputFlow.Netflow.FlowStartMilliseconds = first*1000 + msecFirst
+	outputFlow.Netflow.FlowEndMilliseconds = last*1000 + msecLast
+
+	outputFlow.Netflow.ProtocolIdentifier = protocols.Identifier(record[22])
+	outputFlow.Netflow.SourcePort = b.byteOrder.Uint16(record[24:26])
+	outputFlow.Netflow.DestinationPort = b.byteOrder.Uint16(record[26:28])
+
+	data := record[commonRecordHeaderLength:]
+	if addressLength == 16 {
+		outputFlow.Netflow.SourceIPv6 = b.decodeIPv6(data[0:16])
+		outputFlow.Netflow.DestinationIPv6 = b.decodeIPv6(data[16:32])
+	} else {
+		outputFlow.Netflow.SourceIPv4 = b.decodeIPv4(data[0:4])
+		outputFlow.Netflow.DestinationIPv4 = b.decodeIPv4(data[4:8])
+	}
+	data = data[2*addressLength:]
+	outputFlow.Netflow.PacketTotalCount = b.decodeCounter(data[:packetsLength])
+	data = data[packetsLength:]
+	outputFlow.Netflow.OctetTotalCount = b.decodeCounter(data[:bytesLength])
+
+	//assume end of flow since we don't have the data
+	outputFlow.Netflow.FlowEndReason = input.EndOfFlow
+	return outputFlow, nil
+}
+
+//decodeIPv4 decodes an IPv4 address stored as a 32 bit integer
+func (b *blockDecoder) decodeIPv4(value []byte) string {
+	address := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(address, b.byteOrder.Uint32(value))
+	return address.String()
+}
+
+//decodeIPv6 decodes an IPv6 address stored as two 64 bit integers
+func (b *blockDecoder) decodeIPv6(value []byte) string {
+	address := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(address[0:8], b.byteOrder.Uint64(value[0:8]))
+	binary.BigEndian.PutUint64(address[8:16], b.byteOrder.Uint64(value[8:16]))
+	return address.String()
+}
+
+//decodeCounter decodes a 32 or 64 bit counter
+func (b *blockDecoder) decodeCounter(value []byte) int64 {
+	if len(value) == 8 {
+		return int64(b.byteOrder.Uint64(value))
+	}
+	return int64(b.byteOrder.Uint32(value))
+}
@@ -0,0 +1,352 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Magic numbers identifying pcap files with microsecond
+//and nanosecond timestamps
+const (
+	pcapMagicMicroseconds uint32 = 0xA1B2C3D4
+	pcapMagicNanoseconds  uint32 = 0xA1B23C4D
+)
+
+//pcapHeaderLength is the length of the pcap global header
+const pcapHeaderLength = 24
+
+//pcapRecordHeaderLength is the length of the header preceding each packet
+const pcapRecordHeaderLength = 16
+
+//pcapng block types. See https://tools.ietf.org/html/draft-tuexen-opsawg-pcapng
+const (
+	sectionHeaderBlockType    uint32 = 0x0A0D0D0A
+	interfaceDescriptionType  uint32 = 1
+	simplePacketBlockType     uint32 = 3
+	enhancedPacketBlockType   uint32 = 6
+	pcapngByteOrderMagic      uint32 = 0x1A2B3C4D
+	pcapngTimestampResolution uint16 = 9
+)
+
+//maxPacketLength bounds the length of the packets which may be read.
+//This is the largest snap length used by tcpdump and Wireshark.
+const maxPacketLength = 262144
+
+//maxBlockLength bounds the length of pcapng blocks which may be read
+const maxBlockLength = maxPacketLength + 1024*1024
+
+//capturedPacket is a packet read from a capture file
+type capturedPacket struct {
+	timestamp time.Time
+	linkType  uint32
+	data      []byte
+}
+
+//packetSource reads the packets held in a capture file.
+//next returns io.EOF once each packet has been read.
+type packetSource interface {
+	next() (capturedPacket, error)
+}
+
+//newPacketSource detects whether a capture file is a
+//pcap or pcapng file and returns a packetSource for it
+func newPacketSource(stream *bufio.Reader) (packetSource, error) {
+	magic, err := stream.Peek(4)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read capture file header")
+	}
+	if binary.BigEndian.Uint32(magic) == sectionHeaderBlockType {
+		return &pcapngSource{stream: stream}, nil
+	}
+	return newPcapSource(stream)
+}
+
+/*  **********  pcap  **********  */
+
+//pcapSource reads the packets held in a pcap file
+type pcapSource struct {
+	stream      *bufio.Reader
+	byteOrder   binary.ByteOrder
+	nanoseconds bool
+	linkType    uint32
+}
+
+//newPcapSource reads the pcap global header and returns
+//a pcapSource for the packets which follow it
+func newPcapSource(stream *bufio.Reader) (*pcapSource, error) {
+	header := make([]byte, pcapHeaderLength)
+	_, err := io.ReadFull(stream, header)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read pcap header")
+	}
+
+	source := &pcapSource{stream: stream}
+	for _, byteOrder := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch byteOrder.Uint32(header[0:4]) {
+		case pcapMagicMicroseconds:
+			source.byteOrder = byteOrder
+		case pcapMagicNanoseconds:
+			source.byteOrder = byteOrder
+			source.nanoseconds = true
+		}
+	}
+	if source.byteOrder == nil {
+		return nil, errors.New("file is not a pcap or pcapng file")
+	}
+	source.linkType = source.byteOrder.Uint32(header[20:24])
+	return source, nil
+}
+
+func (p *pcapSource) next() (capturedPacket, error) {
+	header := make([]byte, pcapRecordHeaderLength)
+	_, err := io.ReadFull(p.stream, header)
+	if err != nil {
+		if err == io.EOF {
+			return capturedPacket{}, err
+		}
+		return capturedPacket{}, errors.Wrap(err, "could not read pcap record header")
+	}
+
+	seconds := int64(p.byteOrder.Uint32(header[0:4]))
+	fraction := int64(p.byteOrder.Uint32(header[4:8]))
+	capturedLength := p.byteOrder.Uint32(header[8:12])
+	if capturedLength > maxPacketLength {
+		return capturedPacket{}, errors.Errorf("pcap record is too large: %d bytes", capturedLength)
+	}
+
+	data := make([]byte, capturedLength)
+	_, err = io.ReadFull(p.stream, data)
+	if err != nil {
+		return capturedPacket{}, errors.Wrap(err, "could not read pcap record")
+	}
+
+	if !p.nanoseconds {
+		fraction *= 1000
+	}
+	return capturedPacket{
+		timestamp: time.Unix(seconds, fraction),
+		linkType:  p.linkType,
+		data:      data,
+	}, nil
+}
+
+/*  **********  pcapng  **********  */
+
+//pcapngInterface holds the details of an interface described
+//by an Interface Description Block
+type pcapngInterface struct {
+	linkType   uint32
+	snapLength uint32
+	//timestamps are recorded in units of 10^-resolution seconds,
+	//or 2^-resolution seconds if binaryResolution is set
+	resolution       uint8
+	binaryResolution bool
+}
+
+//pcapngSource reads the packets held in a pcapng file
+type pcapngSource struct {
+	stream     *bufio.Reader
+	byteOrder  binary.ByteOrder
+	interfaces []pcapngInterface
+	//lastTimestamp is used for Simple Packet Blocks, which
+	//don't carry timestamps
+	lastTimestamp time.Time
+}
+
+func (p *pcapngSource) next() (capturedPacket, error) {
+	for {
+		blockType, body, err := p.readBlock()
+		if err != nil {
+			return capturedPacket{}, err
+		}
+
+		switch blockType {
+		case interfaceDescriptionType:
+			err = p.readInterfaceDescription(body)
+			if err != nil {
+				return capturedPacket{}, err
+			}
+		case enhancedPacketBlockType:
+			return p.readEnhancedPacket(body)
+		case simplePacketBlockType:
+			return p.readSimplePacket(body)
+		}
+	}
+}
+
+//readBlock reads the next block, returning its type and body.
+//Section Header Blocks are handled here since they set the
+//byte order used by the blocks which follow them.
+func (p *pcapngSource) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(p.stream, header)
+	if err != nil {
+		if err == io.EOF {
+			return 0, nil, err
+		}
+		return 0, nil, errors.Wrap(err, "could not read pcapng block header")
+	}
+
+	//the section header block type is a palindrome
+	if binary.BigEndian.Uint32(header[0:4]) == sectionHeaderBlockType {
+		byteOrderMagic := make([]byte, 4)
+		_, err = io.ReadFull(p.stream, byteOrderMagic)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "could not read pcapng section header")
+		}
+		if binary.LittleEndian.Uint32(byteOrderMagic) == pcapngByteOrderMagic {
+			p.byteOrder = binary.LittleEndian
+		} else if binary.BigEndian.Uint32(byteOrderMagic) == pcapngByteOrderMagic {
+			p.byteOrder = binary.BigEndian
+		} else {
+			return 0, nil, errors.New("invalid pcapng byte order magic")
+		}
+		//interface IDs are scoped to the section
+		p.interfaces = nil
+
+		blockLength := p.byteOrder.Uint32(header[4:8])
+		if blockLength < 16 || blockLength > maxBlockLength || blockLength%4 != 0 {
+			return 0, nil, errors.Errorf("invalid pcapng block length %d", blockLength)
+		}
+		_, err = io.ReadFull(p.stream, make([]byte, blockLength-12))
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "could not read pcapng section header")
+		}
+		return sectionHeaderBlockType, nil, nil
+	}
+
+	if p.byteOrder == nil {
+		return 0, nil, errors.New("pcapng file does not start with a section header")
+	}
+	blockType := p.byteOrder.Uint32(header[0:4])
+	blockLength := p.byteOrder.Uint32(header[4:8])
+	if blockLength < 12 || blockLength > maxBlockLength || blockLength%4 != 0 {
+		return 0, nil, errors.Errorf("invalid pcapng block length %d", blockLength)
+	}
+
+	//the body is followed by a copy of the block length
+	body := make([]byte, blockLength-8)
+	_, err = io.ReadFull(p.stream, body)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "could not read pcapng block")
+	}
+	return blockType, body[:len(body)-4], nil
+}
+
+//readInterfaceDescription reads the link type and timestamp
+//resolution of an interface
+func (p *pcapngSource) readInterfaceDescription(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("pcapng interface description is truncated")
+	}
+	iface := pcapngInterface{
+		linkType:   uint32(p.byteOrder.Uint16(body[0:2])),
+		snapLength: p.byteOrder.Uint32(body[4:8]),
+		resolution: 6,
+	}
+
+	options := body[8:]
+	for len(options) >= 4 {
+		code := p.byteOrder.Uint16(options[0:2])
+		length := int(p.byteOrder.Uint16(options[2:4]))
+		options = options[4:]
+		if length > len(options) {
+			break
+		}
+		if code == pcapngTimestampResolution && length == 1 {
+			iface.resolution = options[0] & 0x7F
+			iface.binaryResolution = options[0]&0x80 != 0
+		}
+		padded := (length + 3) &^ 3
+		if padded > len(options) {
+			break
+		}
+		options = options[padded:]
+	}
+
+	if iface.binaryResolution && iface.resolution > 63 {
+		return errors.Errorf("unsupported pcapng timestamp resolution 2^-%d", iface.resolution)
+	}
+	if !iface.binaryResolution && iface.resolution > 19 {
+		return errors.Errorf("unsupported pcapng timestamp resolution 10^-%d", iface.resolution)
+	}
+	p.interfaces = append(p.interfaces, iface)
+	return nil
+}
+
+//readEnhancedPacket reads the packet held in an Enhanced Packet Block
+func (p *pcapngSource) readEnhancedPacket(body []byte) (capturedPacket, error) {
+	if len(body) < 20 {
+		return capturedPacket{}, errors.New("pcapng enhanced packet block is truncated")
+	}
+	interfaceID := p.byteOrder.Uint32(body[0:4])
+	if interfaceID >= uint32(len(p.interfaces)) {
+		return capturedPacket{}, errors.Errorf("pcapng packet refers to unknown interface %d", interfaceID)
+	}
+	iface := p.interfaces[interfaceID]
+	units := uint64(p.byteOrder.Uint32(body[4:8]))<<32 | uint64(p.byteOrder.Uint32(body[8:12]))
+	capturedLength := p.byteOrder.Uint32(body[12:16])
+	if capturedLength > uint32(len(body)-20) {
+		return capturedPacket{}, errors.New("pcapng enhanced packet block is truncated")
+	}
+
+	p.lastTimestamp = iface.timestamp(units)
+	return capturedPacket{
+		timestamp: p.lastTimestamp,
+		linkType:  iface.linkType,
+		data:      body[20 : 20+capturedLength],
+	}, nil
+}
+
+//readSimplePacket reads the packet held in a Simple Packet Block.
+//Simple Packet Blocks always refer to the first interface.
+func (p *pcapngSource) readSimplePacket(body []byte) (capturedPacket, error) {
+	if len(body) < 4 || len(p.interfaces) == 0 {
+		return capturedPacket{}, errors.New("invalid pcapng simple packet block")
+	}
+	iface := p.interfaces[0]
+	capturedLength := p.byteOrder.Uint32(body[0:4])
+	if iface.snapLength != 0 && capturedLength > iface.snapLength {
+		capturedLength = iface.snapLength
+	}
+	if capturedLength > uint32(len(body)-4) {
+		capturedLength = uint32(len(body) - 4)
+	}
+	return capturedPacket{
+		timestamp: p.lastTimestamp,
+		linkType:  iface.linkType,
+		data:      body[4 : 4+capturedLength],
+	}, nil
+}
+
+//timestamp converts a timestamp recorded by the interface into a time.Time
+func (i pcapngInterface) timestamp(units uint64) time.Time {
+	if i.binaryResolution {
+		seconds := units >> i.resolution
+		fraction := units & (uint64(1)<<i.resolution - 1)
+		nanoseconds := float64(fraction) / float64(uint64(1)<<i.resolution) * 1e9
+		return time.Unix(int64(seconds), int64(nanoseconds))
+	}
+
+	scale := uint64(1)
+	for j := uint8(0); j < i.resolution; j++ {
+		scale *= 10
+	}
+	seconds := units / scale
+	fraction := units % scale
+	//convert the fraction to nanoseconds
+	if i.resolution <= 9 {
+		for j := i.resolution; j < 9; j++ {
+			fraction *= 10
+		}
+	} else {
+		for j := uint8(9); j < i.resolution; j++ {
+			fraction /= 10
+		}
+	}
+	return time.Unix(int64(seconds), int64(fraction))
+}
@@ -0,0 +1,186 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+//Link types. See http://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull      uint32 = 0
+	linkTypeEthernet  uint32 = 1
+	linkTypeRaw       uint32 = 101
+	linkTypeRawAlt    uint32 = 12
+	linkTypeLinuxSLL  uint32 = 113
+	linkTypeLinuxSLL2 uint32 = 276
+)
+
+//EtherTypes used when parsing link layer headers
+const (
+	etherTypeIPv4 uint16 = 0x0800
+	etherTypeIPv6 uint16 = 0x86DD
+	etherTypeVLAN uint16 = 0x8100
+	etherTypeQinQ uint16 = 0x88A8
+)
+
+//Protocol numbers used when walking IP headers
+const (
+	protocolUDP         uint8 = 17
+	ipv6HopByHop        uint8 = 0
+	ipv6Routing         uint8 = 43
+	ipv6Fragment        uint8 = 44
+	ipv6DestinationOpts uint8 = 60
+)
+
+//errNotUDP is returned for packets which don't hold UDP datagrams
+var errNotUDP = errors.New("packet does not hold a UDP datagram")
+
+//errFragmented is returned for packets holding IP fragments.
+//Fragmented datagrams are not reassembled.
+var errFragmented = errors.New("packet holds an IP fragment")
+
+//udpDatagram is a UDP datagram read from a captured packet
+type udpDatagram struct {
+	source          string
+	destinationPort uint16
+	payload         []byte
+}
+
+//parseUDP reads the UDP datagram held in a captured packet
+func parseUDP(linkType uint32, data []byte) (udpDatagram, error) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return udpDatagram{}, errors.New("Ethernet header is truncated")
+		}
+		etherType = binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		//skip over 802.1Q and 802.1ad tags
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(data) < 4 {
+				return udpDatagram{}, errors.New("VLAN tag is truncated")
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return udpDatagram{}, errors.New("Linux cooked header is truncated")
+		}
+		etherType = binary.BigEndian.Uint16(data[14:16])
+		data = data[16:]
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return udpDatagram{}, errors.New("Linux cooked header is truncated")
+		}
+		etherType = binary.BigEndian.Uint16(data[0:2])
+		data = data[20:]
+	case linkTypeNull, linkTypeRaw, linkTypeRawAlt:
+		//the loopback header holds the address family in host byte order,
+		//so the IP version is used instead
+		if linkType == linkTypeNull {
+			if len(data) < 4 {
+				return udpDatagram{}, errors.New("loopback header is truncated")
+			}
+			data = data[4:]
+		}
+		if len(data) == 0 {
+			return udpDatagram{}, errNotUDP
+		}
+		switch data[0] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+	default:
+		return udpDatagram{}, errors.Errorf("unsupported link type %d", linkType)
+	}
+
+	switch etherType {
+	case etherTypeIPv4:
+		return parseIPv4(data)
+	case etherTypeIPv6:
+		return parseIPv6(data)
+	}
+	return udpDatagram{}, errNotUDP
+}
+
+//parseIPv4 reads the UDP datagram held in an IPv4 packet
+func parseIPv4(packet []byte) (udpDatagram, error) {
+	if len(packet) < 20 || packet[0]>>4 != 4 {
+		return udpDatagram{}, errors.New("invalid IPv4 header")
+	}
+	headerLength := int(packet[0]&0x0F) * 4
+	totalLength := int(binary.BigEndian.Uint16(packet[2:4]))
+	if headerLength < 20 || totalLength < headerLength || len(packet) < headerLength {
+		return udpDatagram{}, errors.New("invalid IPv4 header")
+	}
+	if packet[9] != protocolUDP {
+		return udpDatagram{}, errNotUDP
+	}
+	//more fragments or a fragment offset
+	if binary.BigEndian.Uint16(packet[6:8])&0x3FFF != 0 {
+		return udpDatagram{}, errFragmented
+	}
+	//strip any link layer padding
+	if totalLength < len(packet) {
+		packet = packet[:totalLength]
+	}
+	return parseUDPHeader(net.IP(packet[12:16]).String(), packet[headerLength:])
+}
+
+//parseIPv6 reads the UDP datagram held in an IPv6 packet
+func parseIPv6(packet []byte) (udpDatagram, error) {
+	if len(packet) < 40 || packet[0]>>4 != 6 {
+		return udpDatagram{}, errors.New("invalid IPv6 header")
+	}
+	source := net.IP(packet[8:24]).String()
+	payloadLength := int(binary.BigEndian.Uint16(packet[4:6]))
+	nextHeader := packet[6]
+	payload := packet[40:]
+	if payloadLength < len(payload) {
+		payload = payload[:payloadLength]
+	}
+
+	//walk the extension headers to find the UDP header
+	for {
+		switch nextHeader {
+		case protocolUDP:
+			return parseUDPHeader(source, payload)
+		case ipv6HopByHop, ipv6Routing, ipv6DestinationOpts:
+			if len(payload) < 8 {
+				return udpDatagram{}, errors.New("IPv6 extension header is truncated")
+			}
+			extensionLength := (int(payload[1]) + 1) * 8
+			if len(payload) < extensionLength {
+				return udpDatagram{}, errors.New("IPv6 extension header is truncated")
+			}
+			nextHeader = payload[0]
+			payload = payload[extensionLength:]
+		case ipv6Fragment:
+			return udpDatagram{}, errFragmented
+		default:
+			return udpDatagram{}, errNotUDP
+		}
+	}
+}
+
+//parseUDPHeader reads the UDP header and the payload which follows it
+func parseUDPHeader(source string, segment []byte) (udpDatagram, error) {
+	if len(segment) < 8 {
+		return udpDatagram{}, errors.New("UDP header is truncated")
+	}
+	length := int(binary.BigEndian.Uint16(segment[4:6]))
+	if length < 8 || length > len(segment) {
+		return udpDatagram{}, errors.New("UDP datagram is truncated")
+	}
+	return udpDatagram{
+		source:          source,
+		destinationPort: binary.BigEndian.Uint16(segment[2:4]),
+		payload:         segment[8:length],
+	}, nil
+}
@@ -0,0 +1,185 @@
+package pcap
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+)
+
+//Reader implements input.Reader by replaying the IPFIX/ Netflow
+//packets held in pcap and pcapng files. The UDP datagrams sent to
+//the collector ports are decoded as if they were received
+//by the native collector. The files are read one after another,
+//and the channels are closed once each file has been read.
+//IP fragments are not reassembled and are skipped.
+type Reader struct {
+	paths        []string
+	ports        map[uint16]bool
+	decoder      native.Decoder
+	alignToToday bool
+	clock        clock.Clock
+	timezone     *time.Location
+	log          logging.Logger
+}
+
+//NewReader returns a new input.Reader which replays the capture files
+//at the given paths. Each path may name a file, a directory holding
+//capture files, or a glob pattern. Datagrams sent to the given ports
+//are decoded with the given Decoder. If alignToToday is set, the flows
+//are shifted in time such that the first packet in the capture
+//appears to have been sent at the most recent midnight in the
+//given timezone.
+func NewReader(paths []string, ports []uint16, decoder native.Decoder,
+	alignToToday bool, clock clock.Clock, timezone *time.Location,
+	log logging.Logger) input.Reader {
+
+	portSet := make(map[uint16]bool)
+	for _, port := range ports {
+		portSet[port] = true
+	}
+	return Reader{
+		paths:        paths,
+		ports:        portSet,
+		decoder:      decoder,
+		alignToToday: alignToToday,
+		clock:        clock,
+		timezone:     timezone,
+		log:          log,
+	}
+}
+
+//Drain asynchronously replays each of the capture files in order
+func (r Reader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	out := make(chan input.Flow)
+	errs := make(chan error)
+
+	go func(out chan<- input.Flow, errs chan<- error) {
+		files, err := native.ExpandPaths(r.paths)
+		if err != nil {
+			errs <- err
+		}
+		//the offset is set by the first packet in the first file
+		//so the files keep their relative timing
+		align := &alignment{}
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			r.readFile(ctx, file, align, out, errs)
+		}
+		close(errs)
+		close(out)
+	}(out, errs)
+
+	return out, errs
+}
+
+//readFile decodes the IPFIX/ Netflow packets held in a capture file
+//until the end of the file is reached or the context is cancelled
+func (r Reader) readFile(ctx context.Context, path string, align *alignment,
+	out chan<- input.Flow, errs chan<- error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		errs <- errors.Wrapf(err, "could not open capture file %s", path)
+		return
+	}
+	defer file.Close()
+
+	r.log.Info("replaying capture file", logging.Fields{"file": path})
+	source, err := newPacketSource(bufio.NewReader(file))
+	if err != nil {
+		errs <- errors.Wrapf(err, "could not read capture file %s", path)
+		return
+	}
+
+	packetCount := 0
+	fragmentCount := 0
+	for ctx.Err() == nil {
+		packet, err := source.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs <- errors.Wrapf(err, "could not read packet %d from %s", packetCount, path)
+			break
+		}
+		packetCount++
+
+		if r.alignToToday && !align.set {
+			midnight := r.lastMidnight()
+			align.offset = midnight.Sub(packet.timestamp).Nanoseconds() / 1000000
+			align.set = true
+			r.log.Info("aligning capture to today", logging.Fields{
+				"first packet": packet.timestamp.String(),
+				"aligned to":   midnight.String(),
+			})
+		}
+
+		datagram, err := parseUDP(packet.linkType, packet.data)
+		if err == errFragmented {
+			fragmentCount++
+			continue
+		}
+		if err != nil || !r.ports[datagram.destinationPort] {
+			continue
+		}
+
+		flows, decodeErrs := r.decoder.Decode(datagram.source, datagram.payload)
+		for i := range decodeErrs {
+			errs <- errors.Wrapf(decodeErrs[i], "could not decode packet %d from %s", packetCount, path)
+		}
+		for i := range flows {
+			if align.set {
+				err = shiftFlow(flows[i], align.offset)
+				if err != nil {
+					errs <- errors.Wrapf(err, "could not align a flow from packet %d in %s", packetCount, path)
+					continue
+				}
+			}
+			out <- flows[i]
+		}
+	}
+
+	if fragmentCount != 0 {
+		r.log.Warn("skipped fragmented packets", logging.Fields{
+			"file":      path,
+			"fragments": fragmentCount,
+		})
+	}
+	r.log.Info("finished replaying capture file", logging.Fields{
+		"file":    path,
+		"packets": packetCount,
+	})
+}
+
+//alignment holds the offset used to shift the flows to today
+type alignment struct {
+	set    bool
+	offset int64
+}
+
+//lastMidnight returns the start of the current day
+func (r Reader) lastMidnight() time.Time {
+	now := r.clock.Now().In(r.timezone)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, r.timezone)
+}
+
+//shiftFlow moves the timestamps of a flow by a number of milliseconds.
+//The flow is shifted in place so the other interfaces it implements,
+//such as input.BidirectionalFlow, remain available downstream.
+func shiftFlow(flow input.Flow, offset int64) error {
+	shiftableFlow, ok := flow.(input.ShiftableFlow)
+	if !ok {
+		return errors.Errorf("flow from %s does not support shifting its timestamps", flow.Exporter())
+	}
+	return shiftableFlow.ShiftTimestamps(offset)
+}
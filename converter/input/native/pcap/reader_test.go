@@ -0,0 +1,316 @@
+package pcap_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/input/native/netflow5"
+	"github.com/activecm/ipfix-rita/converter/input/native/pcap"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+/*  **********  Helper Functions  **********  */
+
+//newNetflow5Packet creates a Netflow v5 packet holding a single record
+//with the given source address. The flow ends at the export time.
+func newNetflow5Packet(src string, unixSecs uint32) []byte {
+	packet := make([]byte, 24+48)
+	binary.BigEndian.PutUint16(packet[0:2], 5)
+	binary.BigEndian.PutUint16(packet[2:4], 1)
+	binary.BigEndian.PutUint32(packet[4:8], 10000)
+	binary.BigEndian.PutUint32(packet[8:12], unixSecs)
+	record := packet[24:]
+	copy(record[0:4], net.ParseIP(src).To4())
+	copy(record[4:8], net.ParseIP("2.2.2.2").To4())
+	binary.BigEndian.PutUint32(record[16:20], 1)
+	binary.BigEndian.PutUint32(record[20:24], 100)
+	binary.BigEndian.PutUint32(record[24:28], 9000)
+	binary.BigEndian.PutUint32(record[28:32], 10000)
+	record[38] = uint8(protocols.UDP)
+	return packet
+}
+
+//newEthernetFrame wraps a UDP payload in Ethernet, IPv4, and UDP headers
+func newEthernetFrame(exporter string, dstPort uint16, flags uint16, payload []byte) []byte {
+	frame := make([]byte, 14+20+8)
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+8+len(payload)))
+	binary.BigEndian.PutUint16(ip[6:8], flags)
+	ip[9] = 17
+	copy(ip[12:16], net.ParseIP(exporter).To4())
+	copy(ip[16:20], net.ParseIP("10.0.0.2").To4())
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp[0:2], 30000)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(payload)))
+	return append(frame, payload...)
+}
+
+//newPcapFile creates a little endian pcap file holding Ethernet frames
+func newPcapFile(timestamps []time.Time, frames ...[]byte) []byte {
+	file := make([]byte, 24)
+	binary.LittleEndian.PutUint32(file[0:4], 0xA1B2C3D4)
+	binary.LittleEndian.PutUint16(file[4:6], 2)
+	binary.LittleEndian.PutUint16(file[6:8], 4)
+	binary.LittleEndian.PutUint32(file[16:20], 65535)
+	binary.LittleEndian.PutUint32(file[20:24], 1)
+	for i := range frames {
+		header := make([]byte, 16)
+		binary.LittleEndian.PutUint32(header[0:4], uint32(timestamps[i].Unix()))
+		binary.LittleEndian.PutUint32(header[4:8], uint32(timestamps[i].Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(header[8:12], uint32(len(frames[i])))
+		binary.LittleEndian.PutUint32(header[12:16], uint32(len(frames[i])))
+		file = append(file, header...)
+		file = append(file, frames[i]...)
+	}
+	return file
+}
+
+//newPcapngBlock creates a big endian pcapng block
+func newPcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	block := make([]byte, 8)
+	binary.BigEndian.PutUint32(block[0:4], blockType)
+	binary.BigEndian.PutUint32(block[4:8], uint32(12+len(body)))
+	block = append(block, body...)
+	trailer := make([]byte, 4)
+	binary.BigEndian.PutUint32(trailer, uint32(12+len(body)))
+	return append(block, trailer...)
+}
+
+//newPcapngFile creates a big endian pcapng file holding Ethernet frames
+//with nanosecond timestamps
+func newPcapngFile(timestamps []time.Time, frames ...[]byte) []byte {
+	sectionHeader := make([]byte, 16)
+	binary.BigEndian.PutUint32(sectionHeader[0:4], 0x1A2B3C4D)
+	binary.BigEndian.PutUint16(sectionHeader[4:6], 1)
+	binary.BigEndian.PutUint64(sectionHeader[8:16], ^uint64(0))
+	file := newPcapngBlock(0x0A0D0D0A, sectionHeader)
+
+	iface := make([]byte, 8+8+4)
+	binary.BigEndian.PutUint16(iface[0:2], 1)
+	binary.BigEndian.PutUint32(iface[4:8], 65535)
+	//if_tsresol: nanoseconds
+	binary.BigEndian.PutUint16(iface[8:10], 9)
+	binary.BigEndian.PutUint16(iface[10:12], 1)
+	iface[12] = 9
+	file = append(file, newPcapngBlock(1, iface)...)
+
+	for i := range frames {
+		packet := make([]byte, 20)
+		units := uint64(timestamps[i].UnixNano())
+		binary.BigEndian.PutUint32(packet[4:8], uint32(units>>32))
+		binary.BigEndian.PutUint32(packet[8:12], uint32(units))
+		binary.BigEndian.PutUint32(packet[12:16], uint32(len(frames[i])))
+		binary.BigEndian.PutUint32(packet[16:20], uint32(len(frames[i])))
+		file = append(file, newPcapngBlock(6, append(packet, frames[i]...))...)
+	}
+	return file
+}
+
+//biflowDecoder decodes every packet into a single biflow
+//which ends at the given time
+type biflowDecoder struct {
+	flowEnd int64
+}
+
+func (b biflowDecoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	flow := &native.Flow{Host: exporter}
+	flow.Netflow.SourceIPv4 = "1.1.1.1"
+	flow.Netflow.DestinationIPv4 = "2.2.2.2"
+	flow.Netflow.FlowStartMilliseconds = b.flowEnd - 1000
+	flow.Netflow.FlowEndMilliseconds = b.flowEnd
+	flow.Netflow.ProtocolIdentifier = protocols.TCP
+	flow.Netflow.Version = 10
+	flow.Netflow.Bidirectional = true
+	flow.Netflow.ReverseOctetTotalCount = 500
+	flow.Netflow.ReversePacketTotalCount = 5
+	flow.Netflow.ReverseFlowDeltaMilliseconds = 200
+	return []input.Flow{flow}, nil
+}
+
+//drainAll reads from a Reader until its channels are closed
+func drainAll(reader input.Reader) ([]input.Flow, []error) {
+	flows, errs := reader.Drain(context.Background())
+	var outFlows []input.Flow
+	var outErrs []error
+	for flows != nil || errs != nil {
+		select {
+		case flow, ok := <-flows:
+			if !ok {
+				flows = nil
+				continue
+			}
+			outFlows = append(outFlows, flow)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			outErrs = append(outErrs, err)
+		}
+	}
+	return outFlows, outErrs
+}
+
+/*  **********  Tests  **********  */
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	captureTime := time.Unix(1525473401, 0)
+	timestamps := []time.Time{captureTime, captureTime.Add(time.Second), captureTime.Add(2 * time.Second)}
+	frames := [][]byte{
+		newEthernetFrame("10.0.0.1", 2055, 0, newNetflow5Packet("1.1.1.1", 1525473401)),
+		//not sent to a collector port
+		newEthernetFrame("10.0.0.1", 53, 0, newNetflow5Packet("3.3.3.3", 1525473402)),
+		//fragments are skipped
+		newEthernetFrame("10.0.0.1", 2055, 0x2000, newNetflow5Packet("4.4.4.4", 1525473403)),
+	}
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.pcap"), newPcapFile(timestamps, frames...), 0644))
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "b.pcapng"),
+		newPcapngFile(timestamps[:1],
+			newEthernetFrame("10.0.0.3", 2055, 0, newNetflow5Packet("5.5.5.5", 1525473404)),
+		),
+		0644,
+	))
+
+	reader := pcap.NewReader(
+		[]string{dir}, []uint16{2055}, netflow5.NewDecoder(),
+		false, clock.NewMock(), time.UTC, logging.NewTestLogger(t),
+	)
+	flows, errs := drainAll(reader)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 2)
+
+	require.Equal(t, "10.0.0.1", flows[0].Exporter())
+	require.Equal(t, "1.1.1.1", flows[0].SourceIPAddress())
+	flowEnd, err := flows[0].FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000), flowEnd)
+
+	require.Equal(t, "10.0.0.3", flows[1].Exporter())
+	require.Equal(t, "5.5.5.5", flows[1].SourceIPAddress())
+}
+
+func TestReaderAlignToToday(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	captureTime := time.Unix(1525473401, 0)
+	timestamps := []time.Time{captureTime, captureTime.Add(time.Hour)}
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "a.pcapng"),
+		newPcapngFile(timestamps,
+			newEthernetFrame("10.0.0.1", 2055, 0, newNetflow5Packet("1.1.1.1", 1525473401)),
+			newEthernetFrame("10.0.0.1", 2055, 0, newNetflow5Packet("1.1.1.1", 1525477001)),
+		),
+		0644,
+	))
+
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Date(2018, time.June, 10, 15, 30, 0, 0, time.UTC))
+	reader := pcap.NewReader(
+		[]string{filepath.Join(dir, "*.pcapng")}, []uint16{2055}, netflow5.NewDecoder(),
+		true, mockClock, time.UTC, logging.NewTestLogger(t),
+	)
+	flows, errs := drainAll(reader)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 2)
+
+	midnight := time.Date(2018, time.June, 10, 0, 0, 0, 0, time.UTC).UnixNano() / 1000000
+	flowEnd, err := flows[0].FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, midnight, flowEnd)
+	flowStart, err := flows[0].FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, midnight-1000, flowStart)
+
+	flowEnd, err = flows[1].FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, midnight+int64(time.Hour/time.Millisecond), flowEnd)
+}
+
+func TestReaderAlignBiflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	captureTime := time.Unix(1525473401, 0)
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, "a.pcap"),
+		newPcapFile([]time.Time{captureTime},
+			newEthernetFrame("10.0.0.1", 4739, 0, []byte{0}),
+		),
+		0644,
+	))
+
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Date(2018, time.June, 10, 15, 30, 0, 0, time.UTC))
+	reader := pcap.NewReader(
+		[]string{filepath.Join(dir, "a.pcap")}, []uint16{4739},
+		biflowDecoder{flowEnd: captureTime.UnixNano() / 1000000},
+		true, mockClock, time.UTC, logging.NewTestLogger(t),
+	)
+	flows, errs := drainAll(reader)
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	biflow, ok := flows[0].(input.BidirectionalFlow)
+	require.True(t, ok)
+	require.True(t, biflow.IsBidirectional())
+	require.Equal(t, int64(500), biflow.ReverseOctetTotalCount())
+	require.Equal(t, int64(5), biflow.ReversePacketTotalCount())
+
+	midnight := time.Date(2018, time.June, 10, 0, 0, 0, 0, time.UTC).UnixNano() / 1000000
+	flowEnd, err := biflow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, midnight, flowEnd)
+	flowStart, err := biflow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, midnight-1000, flowStart)
+	reverseStart, err := biflow.ReverseFlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, midnight-800, reverseStart)
+}
+
+func TestReaderInvalidFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	timestamps := []time.Time{time.Unix(1525473401, 0)}
+	valid := newPcapFile(timestamps,
+		newEthernetFrame("10.0.0.1", 2055, 0, newNetflow5Packet("1.1.1.1", 1525473401)),
+	)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.pcap"), valid[:len(valid)-10], 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "b.pcap"), make([]byte, 100), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "c.pcap"), valid, 0644))
+
+	reader := pcap.NewReader(
+		[]string{dir}, []uint16{2055}, netflow5.NewDecoder(),
+		false, clock.NewMock(), time.UTC, logging.NewTestLogger(t),
+	)
+	flows, errs := drainAll(reader)
+	require.Len(t, errs, 2)
+	require.Len(t, flows, 1)
+}
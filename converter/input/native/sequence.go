@@ -0,0 +1,59 @@
+package native
+
+import "sync"
+
+//sequenceReorderTolerance is how far a sequence number may move
+//backwards before the exporter is considered to have restarted.
+//Smaller steps backwards are caused by packets arriving out of order.
+const sequenceReorderTolerance = 4096
+
+//SequenceTracker watches the sequence numbers sent in the headers
+//of IPFIX and Netflow v9 packets in order to detect when an
+//exporter restarts. Exporters which restart may reuse template
+//IDs with different layouts. The tracker is safe for concurrent use.
+type SequenceTracker struct {
+	lastSequences map[Domain]uint32
+	mutex         *sync.Mutex
+}
+
+//NewSequenceTracker creates an empty SequenceTracker
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{
+		lastSequences: make(map[Domain]uint32),
+		mutex:         new(sync.Mutex),
+	}
+}
+
+//Update records the latest sequence number sent by a Domain
+//and returns true if the sequence number was reset since the
+//last packet was seen
+func (s *SequenceTracker) Update(domain Domain, sequence uint32) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	lastSequence, ok := s.lastSequences[domain]
+	s.lastSequences[domain] = sequence
+	if !ok || sequence >= lastSequence {
+		return false
+	}
+
+	//exporters start counting from 0
+	if sequence == 0 {
+		return true
+	}
+
+	backwards := lastSequence - sequence
+	//the counter wrapped around, the new sequence number is the latest
+	if backwards >= 1<<31 {
+		return false
+	}
+
+	//large steps backwards are resets
+	if backwards > sequenceReorderTolerance {
+		return true
+	}
+
+	//keep the highest sequence number seen when packets arrive out of order
+	s.lastSequences[domain] = lastSequence
+	return false
+}
@@ -0,0 +1,34 @@
+package native_test
+
+import (
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	tracker := native.NewSequenceTracker()
+	domain := native.Domain{Exporter: "A", Version: 10, ID: 1}
+	otherDomain := native.Domain{Exporter: "A", Version: 10, ID: 2}
+
+	require.False(t, tracker.Update(domain, 100000))
+	require.False(t, tracker.Update(domain, 100010))
+	//domains are tracked separately
+	require.False(t, tracker.Update(otherDomain, 5))
+
+	//out of order packets are not resets
+	require.False(t, tracker.Update(domain, 100005))
+	require.False(t, tracker.Update(domain, 100020))
+
+	//wrapping around is not a reset
+	require.False(t, tracker.Update(domain, 0xFFFFFFF0))
+	require.False(t, tracker.Update(domain, 10))
+
+	//starting over is a reset
+	require.False(t, tracker.Update(domain, 50000))
+	require.True(t, tracker.Update(domain, 0))
+	require.False(t, tracker.Update(domain, 20))
+	require.False(t, tracker.Update(domain, 50000))
+	require.True(t, tracker.Update(domain, 100))
+}
@@ -0,0 +1,265 @@
+package sflow
+
+import (
+	"encoding/binary"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+)
+
+//sFlow v5 structure formats (enterprise 0).
+//See https://sflow.org/sflow_version_5.txt
+const (
+	flowSampleFormat         uint32 = 1
+	expandedFlowSampleFormat uint32 = 3
+
+	rawPacketHeaderFormat uint32 = 1
+	sampledIPv4Format     uint32 = 3
+	sampledIPv6Format     uint32 = 4
+)
+
+//Header protocols used in raw packet header records
+const (
+	headerProtocolEthernet uint32 = 1
+	headerProtocolIPv4     uint32 = 11
+	headerProtocolIPv6     uint32 = 12
+)
+
+//Agent address types
+const (
+	addressTypeIPv4 uint32 = 1
+	addressTypeIPv6 uint32 = 2
+)
+
+//Decoder implements native.Decoder for sFlow v5 datagrams.
+//Each flow sample is converted into a flow describing the sampled
+//packet, with the byte and packet counts scaled by the sampling rate.
+//sFlow samples do not carry absolute timestamps, so the flows are
+//timestamped with the time the datagram was received.
+//Counter samples are ignored.
+type Decoder struct {
+	clock clock.Clock
+}
+
+//NewDecoder creates a new sFlow v5 Decoder which timestamps
+//flows using the given clock
+func NewDecoder(clock clock.Clock) Decoder {
+	return Decoder{
+		clock: clock,
+	}
+}
+
+//Decode decodes an sFlow v5 datagram sent by the given agent
+//and returns the flow samples it contains as input.Flows
+func (d Decoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	datagram := xdrReader{data: packet}
+	version := datagram.uint32()
+	if datagram.err == nil && version != 5 {
+		return nil, []error{errors.Errorf("unsupported sFlow version from %s: %d", exporter, version)}
+	}
+	switch datagram.uint32() {
+	case addressTypeIPv4:
+		datagram.skip(4)
+	case addressTypeIPv6:
+		datagram.skip(16)
+	default:
+		if datagram.err == nil {
+			return nil, []error{errors.Errorf("invalid sFlow agent address type from %s", exporter)}
+		}
+	}
+	datagram.skip(12) //sub agent id, sequence number, uptime
+	sampleCount := datagram.uint32()
+	if datagram.err != nil {
+		return nil, []error{errors.Wrapf(datagram.err, "truncated sFlow datagram from %s", exporter)}
+	}
+
+	now := d.clock.Now().UnixNano() / 1000000
+	var flows []input.Flow
+	var errs []error
+	for i := uint32(0); i < sampleCount; i++ {
+		format := datagram.uint32()
+		sample := xdrReader{data: datagram.opaque()}
+		if datagram.err != nil {
+			errs = append(errs, errors.Wrapf(datagram.err, "truncated sFlow sample from %s", exporter))
+			break
+		}
+
+		//ignore counter samples and vendor specific samples
+		if format != flowSampleFormat && format != expandedFlowSampleFormat {
+			continue
+		}
+
+		sampleFlows, sampleErrs := d.decodeFlowSample(exporter, now, format == expandedFlowSampleFormat, &sample)
+		flows = append(flows, sampleFlows...)
+		errs = append(errs, sampleErrs...)
+	}
+	return flows, errs
+}
+
+//decodeFlowSample converts the packets described in a flow sample
+//or expanded flow sample into flows
+func (d Decoder) decodeFlowSample(exporter string, now int64, expanded bool, sample *xdrReader) ([]input.Flow, []error) {
+	sample.skip(4) //sequence number
+	if expanded {
+		sample.skip(8) //source id type, source id index
+	} else {
+		sample.skip(4) //source id
+	}
+	samplingRate := int64(sample.uint32())
+	sample.skip(8) //sample pool, drops
+	if expanded {
+		sample.skip(16) //input and output interface formats and values
+	} else {
+		sample.skip(8) //input and output interfaces
+	}
+	recordCount := sample.uint32()
+	if sample.err != nil {
+		return nil, []error{errors.Wrapf(sample.err, "truncated sFlow flow sample from %s", exporter)}
+	}
+	//a sampling rate of 0 is invalid, treat it as unsampled
+	if samplingRate == 0 {
+		samplingRate = 1
+	}
+
+	var flows []input.Flow
+	var errs []error
+	for i := uint32(0); i < recordCount; i++ {
+		format := sample.uint32()
+		record := xdrReader{data: sample.opaque()}
+		if sample.err != nil {
+			errs = append(errs, errors.Wrapf(sample.err, "truncated sFlow flow record from %s", exporter))
+			break
+		}
+
+		var summary packetSummary
+		var frameLength int64
+		var err error
+		switch format {
+		case rawPacketHeaderFormat:
+			summary, frameLength, err = decodeRawPacketHeader(&record)
+		case sampledIPv4Format, sampledIPv6Format:
+			summary, frameLength, err = decodeSampledIP(&record, format == sampledIPv6Format)
+		default:
+			//extended data, such as switch and router information, is not used
+			continue
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "could not decode sFlow flow record from %s", exporter))
+			continue
+		}
+
+		flow := &native.Flow{}
+		flow.Host = exporter
+		flow.Netflow.SourceIPv4 = summary.sourceIPv4
+		flow.Netflow.SourceIPv6 = summary.sourceIPv6
+		flow.Netflow.SourcePort = summary.sourcePort
+		flow.Netflow.DestinationIPv4 = summary.destinationIPv4
+		flow.Netflow.DestinationIPv6 = summary.destinationIPv6
+		flow.Netflow.DestinationPort = summary.destinationPort
+		flow.Netflow.ProtocolIdentifier = summary.protocol
+		flow.Netflow.FlowStartMilliseconds = now
+		flow.Netflow.FlowEndMilliseconds = now
+		//each sampled packet represents samplingRate packets
+		flow.Netflow.OctetTotalCount = frameLength * samplingRate
+		flow.Netflow.PacketTotalCount = samplingRate
+		//a sampled packet doesn't tell us whether the connection ended
+		flow.Netflow.FlowEndReason = input.ActiveTimeout
+		flow.Netflow.Version = native.SFlowVersion
+		flows = append(flows, flow)
+	}
+	return flows, errs
+}
+
+//decodeRawPacketHeader reads a raw packet header record, returning
+//the addressing information and the length of the original frame
+func decodeRawPacketHeader(record *xdrReader) (packetSummary, int64, error) {
+	headerProtocol := record.uint32()
+	frameLength := int64(record.uint32())
+	record.skip(4) //stripped
+	header := record.opaque()
+	if record.err != nil {
+		return packetSummary{}, 0, record.err
+	}
+
+	var summary packetSummary
+	var err error
+	switch headerProtocol {
+	case headerProtocolEthernet:
+		summary, err = parseEthernet(header)
+	case headerProtocolIPv4:
+		summary, err = parseIPv4(header)
+	case headerProtocolIPv6:
+		summary, err = parseIPv6(header)
+	default:
+		err = errors.Errorf("unsupported sampled header protocol %d", headerProtocol)
+	}
+	return summary, frameLength, err
+}
+
+//decodeSampledIP reads a sampled IPv4 or IPv6 record, returning
+//the addressing information and the length of the IP packet
+func decodeSampledIP(record *xdrReader, ipv6 bool) (packetSummary, int64, error) {
+	var summary packetSummary
+	length := int64(record.uint32())
+	summary.protocol = protocols.Identifier(record.uint32())
+	if ipv6 {
+		summary.sourceIPv6, _ = native.DecodeIPv6Address(record.bytes(16))
+		summary.destinationIPv6, _ = native.DecodeIPv6Address(record.bytes(16))
+	} else {
+		summary.sourceIPv4, _ = native.DecodeIPv4Address(record.bytes(4))
+		summary.destinationIPv4, _ = native.DecodeIPv4Address(record.bytes(4))
+	}
+	summary.sourcePort = uint16(record.uint32())
+	summary.destinationPort = uint16(record.uint32())
+	return summary, length, record.err
+}
+
+//xdrReader reads the XDR (RFC 4506) encoded structures used by sFlow.
+//The first error encountered is held in err, and later reads
+//return zero values.
+type xdrReader struct {
+	data []byte
+	err  error
+}
+
+//bytes reads the next n bytes
+func (x *xdrReader) bytes(n int) []byte {
+	if x.err != nil {
+		return nil
+	}
+	if n < 0 || len(x.data) < n {
+		x.err = errors.Errorf("expected %d bytes, found %d", n, len(x.data))
+		return nil
+	}
+	out := x.data[:n]
+	x.data = x.data[n:]
+	return out
+}
+
+//skip discards the next n bytes
+func (x *xdrReader) skip(n int) {
+	x.bytes(n)
+}
+
+//uint32 reads an unsigned integer
+func (x *xdrReader) uint32() uint32 {
+	raw := x.bytes(4)
+	if raw == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(raw)
+}
+
+//opaque reads variable length opaque data, which is
+//prefixed by its length and padded to a multiple of 4 bytes
+func (x *xdrReader) opaque() []byte {
+	length := int(x.uint32())
+	out := x.bytes(length)
+	if padding := (4 - length%4) % 4; padding != 0 {
+		x.skip(padding)
+	}
+	return out
+}
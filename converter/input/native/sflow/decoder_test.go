@@ -0,0 +1,234 @@
+package sflow_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/input/native/sflow"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+/*  **********  Helper Functions  **********  */
+
+//xdr builds XDR encoded structures
+type xdr []byte
+
+func (x xdr) uint32(value uint32) xdr {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, value)
+	return append(x, out...)
+}
+
+func (x xdr) opaque(data []byte) xdr {
+	x = x.uint32(uint32(len(data)))
+	x = append(x, data...)
+	for len(x)%4 != 0 {
+		x = append(x, 0)
+	}
+	return x
+}
+
+//newDatagram creates an sFlow v5 datagram holding the given
+//samples. Each sample is a format followed by its data.
+func newDatagram(samples ...xdr) []byte {
+	datagram := xdr{}.uint32(5).uint32(1)
+	datagram = append(datagram, net.ParseIP("10.0.0.1").To4()...)
+	datagram = datagram.uint32(0).uint32(1).uint32(1000)
+	datagram = datagram.uint32(uint32(len(samples)))
+	for i := range samples {
+		datagram = append(datagram, samples[i]...)
+	}
+	return datagram
+}
+
+//newFlowSample creates a flow sample (format 1) holding the given records
+func newFlowSample(samplingRate uint32, records ...xdr) xdr {
+	sample := xdr{}.uint32(1).uint32(3).uint32(samplingRate).uint32(0).uint32(0)
+	sample = sample.uint32(1).uint32(2)
+	sample = sample.uint32(uint32(len(records)))
+	for i := range records {
+		sample = append(sample, records[i]...)
+	}
+	return xdr{}.uint32(1).opaque(sample)
+}
+
+//newExpandedFlowSample creates an expanded flow sample (format 3)
+//holding the given records
+func newExpandedFlowSample(samplingRate uint32, records ...xdr) xdr {
+	sample := xdr{}.uint32(1).uint32(0).uint32(3).uint32(samplingRate).uint32(0).uint32(0)
+	sample = sample.uint32(0).uint32(1).uint32(0).uint32(2)
+	sample = sample.uint32(uint32(len(records)))
+	for i := range records {
+		sample = append(sample, records[i]...)
+	}
+	return xdr{}.uint32(3).opaque(sample)
+}
+
+//newCounterSample creates an empty counter sample (format 2)
+func newCounterSample() xdr {
+	return xdr{}.uint32(2).opaque(xdr{}.uint32(1).uint32(3).uint32(0))
+}
+
+//newRawPacketHeader creates a raw packet header record
+func newRawPacketHeader(headerProtocol uint32, frameLength uint32, header []byte) xdr {
+	record := xdr{}.uint32(headerProtocol).uint32(frameLength).uint32(4).opaque(header)
+	return xdr{}.uint32(1).opaque(record)
+}
+
+//newSampledIPv4 creates a sampled IPv4 record
+func newSampledIPv4(length uint32, protocol protocols.Identifier,
+	src string, dst string, srcPort uint32, dstPort uint32) xdr {
+	record := xdr{}.uint32(length).uint32(uint32(protocol))
+	record = append(record, net.ParseIP(src).To4()...)
+	record = append(record, net.ParseIP(dst).To4()...)
+	record = record.uint32(srcPort).uint32(dstPort).uint32(0).uint32(0)
+	return xdr{}.uint32(3).opaque(record)
+}
+
+//newIPv4Header creates an IPv4 header followed by the start of a transport header
+func newIPv4Header(protocol protocols.Identifier, src string, dst string, srcPort uint16, dstPort uint16) []byte {
+	header := make([]byte, 24)
+	header[0] = 0x45
+	header[9] = uint8(protocol)
+	copy(header[12:16], net.ParseIP(src).To4())
+	copy(header[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(header[20:22], srcPort)
+	binary.BigEndian.PutUint16(header[22:24], dstPort)
+	return header
+}
+
+//newIPv6Header creates an IPv6 header followed by the start of a transport header
+func newIPv6Header(protocol protocols.Identifier, src string, dst string, srcPort uint16, dstPort uint16) []byte {
+	header := make([]byte, 44)
+	header[0] = 0x60
+	header[6] = uint8(protocol)
+	copy(header[8:24], net.ParseIP(src).To16())
+	copy(header[24:40], net.ParseIP(dst).To16())
+	binary.BigEndian.PutUint16(header[40:42], srcPort)
+	binary.BigEndian.PutUint16(header[42:44], dstPort)
+	return header
+}
+
+//newEthernetFrame wraps a packet in an 802.1Q tagged Ethernet header
+func newEthernetFrame(etherType uint16, packet []byte) []byte {
+	frame := make([]byte, 18)
+	binary.BigEndian.PutUint16(frame[12:14], 0x8100)
+	binary.BigEndian.PutUint16(frame[14:16], 10)
+	binary.BigEndian.PutUint16(frame[16:18], etherType)
+	return append(frame, packet...)
+}
+
+/*  **********  Tests  **********  */
+
+func TestDecodeEthernetIPv4(t *testing.T) {
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1525473401, 0))
+	decoder := sflow.NewDecoder(mockClock)
+
+	frame := newEthernetFrame(0x0800, newIPv4Header(protocols.TCP, "1.1.1.1", "2.2.2.2", 24846, 443))
+	flows, errs := decoder.Decode("A", newDatagram(
+		newCounterSample(),
+		newFlowSample(100, newRawPacketHeader(1, 1500, frame)),
+	))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	flow := flows[0]
+	require.Equal(t, "A", flow.Exporter())
+	require.Equal(t, "1.1.1.1", flow.SourceIPAddress())
+	require.Equal(t, "2.2.2.2", flow.DestinationIPAddress())
+	require.Equal(t, uint16(24846), flow.SourcePort())
+	require.Equal(t, uint16(443), flow.DestinationPort())
+	require.Equal(t, protocols.TCP, flow.ProtocolIdentifier())
+	require.Equal(t, int64(150000), flow.OctetTotalCount())
+	require.Equal(t, int64(100), flow.PacketTotalCount())
+	require.Equal(t, input.ActiveTimeout, flow.FlowEndReason())
+	require.Equal(t, native.SFlowVersion, flow.Version())
+
+	flowStart, err := flow.FlowStartMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000), flowStart)
+	flowEnd, err := flow.FlowEndMilliseconds()
+	require.Nil(t, err)
+	require.Equal(t, int64(1525473401000), flowEnd)
+}
+
+func TestDecodeIPv6(t *testing.T) {
+	decoder := sflow.NewDecoder(clock.NewMock())
+
+	header := newIPv6Header(protocols.UDP, "2001:db8::1", "2001:db8::2", 5353, 53)
+	flows, errs := decoder.Decode("A", newDatagram(
+		newExpandedFlowSample(0, newRawPacketHeader(12, 80, header)),
+	))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	flow := flows[0]
+	require.Equal(t, "2001:db8::1", flow.SourceIPAddress())
+	require.Equal(t, "2001:db8::2", flow.DestinationIPAddress())
+	require.Equal(t, uint16(5353), flow.SourcePort())
+	require.Equal(t, uint16(53), flow.DestinationPort())
+	require.Equal(t, protocols.UDP, flow.ProtocolIdentifier())
+	//a sampling rate of 0 is treated as unsampled
+	require.Equal(t, int64(80), flow.OctetTotalCount())
+	require.Equal(t, int64(1), flow.PacketTotalCount())
+}
+
+func TestDecodeSampledIPv4(t *testing.T) {
+	decoder := sflow.NewDecoder(clock.NewMock())
+
+	flows, errs := decoder.Decode("A", newDatagram(
+		newFlowSample(10,
+			newSampledIPv4(60, protocols.UDP, "3.3.3.3", "4.4.4.4", 1234, 53),
+			newSampledIPv4(40, protocols.TCP, "4.4.4.4", "3.3.3.3", 80, 4321),
+		),
+	))
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 2)
+
+	require.Equal(t, "3.3.3.3", flows[0].SourceIPAddress())
+	require.Equal(t, uint16(53), flows[0].DestinationPort())
+	require.Equal(t, int64(600), flows[0].OctetTotalCount())
+	require.Equal(t, int64(10), flows[0].PacketTotalCount())
+
+	require.Equal(t, "4.4.4.4", flows[1].SourceIPAddress())
+	require.Equal(t, protocols.TCP, flows[1].ProtocolIdentifier())
+	require.Equal(t, int64(400), flows[1].OctetTotalCount())
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	decoder := sflow.NewDecoder(clock.NewMock())
+
+	datagram := newDatagram(
+		newFlowSample(10, newSampledIPv4(60, protocols.UDP, "3.3.3.3", "4.4.4.4", 1234, 53)),
+	)
+
+	//truncated datagrams return errors rather than panicking
+	for i := 0; i < len(datagram); i++ {
+		flows, errs := decoder.Decode("A", datagram[:i])
+		require.Len(t, flows, 0)
+		require.NotEqual(t, 0, len(errs))
+	}
+
+	//unsupported versions are rejected
+	wrongVersion := append([]byte{}, datagram...)
+	binary.BigEndian.PutUint32(wrongVersion[0:4], 4)
+	_, errs := decoder.Decode("A", wrongVersion)
+	require.Len(t, errs, 1)
+
+	//unparseable headers are reported without dropping the other records
+	flows, errs := decoder.Decode("A", newDatagram(
+		newFlowSample(10,
+			newRawPacketHeader(1, 100, []byte{1, 2, 3}),
+			newSampledIPv4(60, protocols.UDP, "3.3.3.3", "4.4.4.4", 1234, 53),
+		),
+	))
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 1)
+}
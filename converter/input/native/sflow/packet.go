@@ -0,0 +1,157 @@
+package sflow
+
+import (
+	"encoding/binary"
+
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/pkg/errors"
+)
+
+//EtherTypes used when parsing sampled Ethernet frames
+const (
+	etherTypeIPv4 uint16 = 0x0800
+	etherTypeIPv6 uint16 = 0x86DD
+	etherTypeVLAN uint16 = 0x8100
+	etherTypeQinQ uint16 = 0x88A8
+)
+
+//ethernetHeaderLength is the length of an untagged Ethernet header
+const ethernetHeaderLength = 14
+
+//vlanTagLength is the length of an 802.1Q tag
+const vlanTagLength = 4
+
+//IPv6 extension headers which may appear before the transport header
+const (
+	ipv6HopByHop        uint8 = 0
+	ipv6Routing         uint8 = 43
+	ipv6Fragment        uint8 = 44
+	ipv6DestinationOpts uint8 = 60
+)
+
+//packetSummary holds the addressing information read from a sampled packet
+type packetSummary struct {
+	sourceIPv4      string
+	sourceIPv6      string
+	destinationIPv4 string
+	destinationIPv6 string
+	sourcePort      uint16
+	destinationPort uint16
+	protocol        protocols.Identifier
+}
+
+//parseEthernet reads the network and transport headers from a
+//sampled Ethernet frame. The frame may be truncated.
+func parseEthernet(frame []byte) (packetSummary, error) {
+	if len(frame) < ethernetHeaderLength {
+		return packetSummary{}, errors.New("sampled Ethernet header is truncated")
+	}
+	etherType := binary.BigEndian.Uint16(frame[12:14])
+	payload := frame[ethernetHeaderLength:]
+	//skip over 802.1Q and 802.1ad tags
+	for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+		if len(payload) < vlanTagLength {
+			return packetSummary{}, errors.New("sampled VLAN tag is truncated")
+		}
+		etherType = binary.BigEndian.Uint16(payload[2:4])
+		payload = payload[vlanTagLength:]
+	}
+
+	switch etherType {
+	case etherTypeIPv4:
+		return parseIPv4(payload)
+	case etherTypeIPv6:
+		return parseIPv6(payload)
+	}
+	return packetSummary{}, errors.Errorf("unsupported EtherType in sampled packet: 0x%04x", etherType)
+}
+
+//parseIPv4 reads the network and transport headers from a
+//sampled IPv4 packet. The packet may be truncated.
+func parseIPv4(packet []byte) (packetSummary, error) {
+	if len(packet) < 20 {
+		return packetSummary{}, errors.New("sampled IPv4 header is truncated")
+	}
+	if packet[0]>>4 != 4 {
+		return packetSummary{}, errors.Errorf("sampled IPv4 header has version %d", packet[0]>>4)
+	}
+	headerLength := int(packet[0]&0x0F) * 4
+	if headerLength < 20 {
+		return packetSummary{}, errors.Errorf("sampled IPv4 header has invalid length %d", headerLength)
+	}
+
+	var summary packetSummary
+	summary.protocol = protocols.Identifier(packet[9])
+	summary.sourceIPv4, _ = native.DecodeIPv4Address(packet[12:16])
+	summary.destinationIPv4, _ = native.DecodeIPv4Address(packet[16:20])
+
+	//only the first fragment carries the transport header
+	fragmentOffset := binary.BigEndian.Uint16(packet[6:8]) & 0x1FFF
+	if fragmentOffset == 0 && len(packet) >= headerLength {
+		summary.sourcePort, summary.destinationPort = parsePorts(summary.protocol, packet[headerLength:])
+	}
+	return summary, nil
+}
+
+//parseIPv6 reads the network and transport headers from a
+//sampled IPv6 packet. The packet may be truncated.
+func parseIPv6(packet []byte) (packetSummary, error) {
+	if len(packet) < 40 {
+		return packetSummary{}, errors.New("sampled IPv6 header is truncated")
+	}
+	if packet[0]>>4 != 6 {
+		return packetSummary{}, errors.Errorf("sampled IPv6 header has version %d", packet[0]>>4)
+	}
+
+	var summary packetSummary
+	summary.sourceIPv6, _ = native.DecodeIPv6Address(packet[8:24])
+	summary.destinationIPv6, _ = native.DecodeIPv6Address(packet[24:40])
+
+	nextHeader := packet[6]
+	payload := packet[40:]
+	firstFragment := true
+	//walk the extension headers to find the transport protocol
+	for {
+		switch nextHeader {
+		case ipv6HopByHop, ipv6Routing, ipv6DestinationOpts:
+			if len(payload) < 8 {
+				summary.protocol = protocols.Identifier(nextHeader)
+				return summary, nil
+			}
+			extensionLength := (int(payload[1]) + 1) * 8
+			nextHeader = payload[0]
+			if len(payload) < extensionLength {
+				summary.protocol = protocols.Identifier(nextHeader)
+				return summary, nil
+			}
+			payload = payload[extensionLength:]
+			continue
+		case ipv6Fragment:
+			if len(payload) < 8 {
+				summary.protocol = protocols.Identifier(nextHeader)
+				return summary, nil
+			}
+			firstFragment = binary.BigEndian.Uint16(payload[2:4])>>3 == 0
+			nextHeader = payload[0]
+			payload = payload[8:]
+			continue
+		}
+		break
+	}
+
+	summary.protocol = protocols.Identifier(nextHeader)
+	if firstFragment {
+		summary.sourcePort, summary.destinationPort = parsePorts(summary.protocol, payload)
+	}
+	return summary, nil
+}
+
+//parsePorts reads the source and destination ports from
+//a TCP or UDP header. Other protocols use port 0.
+func parsePorts(protocol protocols.Identifier, header []byte) (uint16, uint16) {
+	if (protocol != protocols.TCP && protocol != protocols.UDP) || len(header) < 4 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint16(header[0:2]), binary.BigEndian.Uint16(header[2:4])
+}
@@ -0,0 +1,210 @@
+package native
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/config"
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/pkg/errors"
+)
+
+//ipfixMessageHeaderLength is the length of the IPFIX Message Header.
+//The header holds the length of the message, which is used to
+//split TCP streams and IPFIX files into messages.
+const ipfixMessageHeaderLength = 16
+
+//TCPReader implements input.Reader by accepting IPFIX (RFC 7011)
+//streams sent over TCP, optionally secured with TLS.
+//Each connection is read in its own goroutine.
+type TCPReader struct {
+	address    string
+	tlsConfig  *tls.Config
+	newDecoder DecoderFactory
+	log        logging.Logger
+}
+
+//NewTCPReader returns a new input.Reader which listens on the given
+//TCP address and decodes the IPFIX messages it receives. If tlsConfig
+//is not nil, the exporters must connect using TLS. Templates sent over
+//TCP are scoped to the connection which carried them (RFC 7011 section 8),
+//so each connection is decoded by a new Decoder from newDecoder, which is
+//discarded along with its templates once the connection closes.
+func NewTCPReader(address string, tlsConfig *tls.Config, newDecoder DecoderFactory, log logging.Logger) input.Reader {
+	return TCPReader{
+		address:    address,
+		tlsConfig:  tlsConfig,
+		newDecoder: newDecoder,
+		log:        log,
+	}
+}
+
+//NewServerTLSConfig creates the TLS configuration used to
+//accept connections from exporters. If the certificate is to be
+//verified, the exporters must present a client certificate
+//signed by the configured certificate authority.
+func NewServerTLSConfig(tlsConf config.CollectorTLS) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(tlsConf.GetCertFile(), tlsConf.GetKeyFile())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load TLS certificate and key")
+	}
+	serverConf := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	if tlsConf.ShouldVerifyCertificate() {
+		caFilePath := tlsConf.GetCAFile()
+		if len(caFilePath) == 0 {
+			return nil, errors.New("a CA file is required to verify client certificates")
+		}
+		pem, err := ioutil.ReadFile(caFilePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read CA file")
+		}
+		serverConf.ClientCAs = x509.NewCertPool()
+		if !serverConf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in CA file %s", caFilePath)
+		}
+		serverConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return serverConf, nil
+}
+
+//Drain asynchronously accepts connections and decodes the
+//messages sent over them until the context is cancelled
+func (r TCPReader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	out := make(chan input.Flow)
+	errs := make(chan error)
+
+	go func(out chan<- input.Flow, errs chan<- error) {
+		listener, err := net.Listen("tcp", r.address)
+		if err != nil {
+			errs <- errors.Wrapf(err, "could not listen on %s", r.address)
+			close(errs)
+			close(out)
+			return
+		}
+		if r.tlsConfig != nil {
+			listener = tls.NewListener(listener, r.tlsConfig)
+		}
+
+		r.log.Info("listening for flows", logging.Fields{
+			"address": listener.Addr().String(),
+			"tls":     r.tlsConfig != nil,
+		})
+
+		//closing the listener unblocks Accept
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+
+		connections := new(sync.WaitGroup)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					errs <- errors.Wrap(err, "could not accept TCP connection")
+				}
+				break
+			}
+			connections.Add(1)
+			go func(conn net.Conn) {
+				r.readConnection(ctx, conn, out, errs)
+				connections.Done()
+			}(conn)
+		}
+
+		connections.Wait()
+		close(errs)
+		close(out)
+	}(out, errs)
+
+	return out, errs
+}
+
+//readConnection decodes the IPFIX messages sent over a connection
+//until the exporter disconnects or the context is cancelled
+func (r TCPReader) readConnection(ctx context.Context, conn net.Conn,
+	out chan<- input.Flow, errs chan<- error) {
+
+	exporter := conn.RemoteAddr().String()
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		exporter = tcpAddr.IP.String()
+	}
+	r.log.Info("exporter connected", logging.Fields{"exporter": exporter})
+
+	//closing the connection unblocks reads
+	connDone := make(chan struct{})
+	defer close(connDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-connDone:
+		}
+		conn.Close()
+	}()
+
+	decoder := r.newDecoder()
+	stream := bufio.NewReader(conn)
+	for {
+		message, err := readIPFIXMessage(stream)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				errs <- errors.Wrapf(err, "could not read IPFIX message from %s", exporter)
+			}
+			break
+		}
+
+		flows, decodeErrs := decoder.Decode(exporter, message)
+		for i := range decodeErrs {
+			errs <- errors.Wrap(decodeErrs[i], "could not decode message")
+		}
+		received := time.Now().UnixNano() / int64(time.Millisecond)
+		for i := range flows {
+			if flow, ok := flows[i].(*Flow); ok {
+				flow.Received = received
+			}
+			out <- flows[i]
+		}
+	}
+	r.log.Info("exporter disconnected", logging.Fields{"exporter": exporter})
+}
+
+//readIPFIXMessage reads the next IPFIX message from a stream.
+//io.EOF is returned if the stream ends between messages.
+//The stream can't be resynchronized if an error is returned.
+func readIPFIXMessage(stream io.Reader) ([]byte, error) {
+	header := make([]byte, ipfixMessageHeaderLength)
+	_, err := io.ReadFull(stream, header)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "could not read IPFIX message header")
+	}
+
+	version := binary.BigEndian.Uint16(header[0:2])
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if version != 10 || length < ipfixMessageHeaderLength {
+		return nil, errors.Errorf("invalid IPFIX message header: version %d, length %d", version, length)
+	}
+
+	message := make([]byte, length)
+	copy(message, header)
+	_, err = io.ReadFull(stream, message[ipfixMessageHeaderLength:])
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read IPFIX message body")
+	}
+	return message, nil
+}
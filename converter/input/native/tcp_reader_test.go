@@ -0,0 +1,323 @@
+package native_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/input/native/ipfix"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/stretchr/testify/require"
+)
+
+/*  **********  Helper Functions  **********  */
+
+//lengthDecoder returns one flow per message. The message's
+//length is used as the flow's byte count.
+type lengthDecoder struct{}
+
+func (l lengthDecoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	flow := &native.Flow{Host: exporter}
+	flow.Netflow.OctetTotalCount = int64(len(packet))
+	return []input.Flow{flow}, nil
+}
+
+//newLengthDecoder implements native.DecoderFactory
+func newLengthDecoder() native.Decoder {
+	return lengthDecoder{}
+}
+
+//newIPFIXMessage creates an IPFIX message with the given
+//number of bytes following the header
+func newIPFIXMessage(bodyLength int) []byte {
+	msg := make([]byte, 16+bodyLength)
+	binary.BigEndian.PutUint16(msg[0:2], 10)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	return msg
+}
+
+//testCollectorTLS implements config.CollectorTLS
+type testCollectorTLS struct {
+	verify   bool
+	caFile   string
+	certFile string
+	keyFile  string
+}
+
+func (t testCollectorTLS) IsEnabled() bool               { return true }
+func (t testCollectorTLS) ShouldVerifyCertificate() bool { return t.verify }
+func (t testCollectorTLS) GetCAFile() string             { return t.caFile }
+func (t testCollectorTLS) GetCertFile() string           { return t.certFile }
+func (t testCollectorTLS) GetKeyFile() string            { return t.keyFile }
+
+//writeCertificate creates a certificate signed by the parent
+//certificate and key, or a self signed certificate if the parent is nil.
+//The certificate and key are written out as PEM files.
+func writeCertificate(t *testing.T, dir string, name string,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0644,
+	))
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		0600,
+	))
+	return cert, key
+}
+
+//freeTCPAddress finds an open port on the loopback interface
+func freeTCPAddress(t *testing.T) string {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	address := probe.Addr().String()
+	probe.Close()
+	return address
+}
+
+//dialUntilListening retries dial until the reader starts listening
+func dialUntilListening(t *testing.T, dial func() (net.Conn, error)) net.Conn {
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		conn, err = dial()
+		if err == nil {
+			return conn
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Nil(t, err)
+	return nil
+}
+
+/*  **********  Tests  **********  */
+
+func TestTCPReader(t *testing.T) {
+	address := freeTCPAddress(t)
+	reader := native.NewTCPReader(address, nil, newLengthDecoder, logging.NewTestLogger(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	flows, errs := reader.Drain(ctx)
+
+	conn := dialUntilListening(t, func() (net.Conn, error) {
+		return net.Dial("tcp", address)
+	})
+
+	//messages may be split across and combined in TCP segments
+	stream := append(newIPFIXMessage(10), newIPFIXMessage(100)...)
+	_, err := conn.Write(stream[:5])
+	require.Nil(t, err)
+	_, err = conn.Write(stream[5:])
+	require.Nil(t, err)
+
+	for _, expectedLength := range []int64{26, 116} {
+		select {
+		case flow := <-flows:
+			require.Equal(t, expectedLength, flow.OctetTotalCount())
+			require.Equal(t, "127.0.0.1", flow.Exporter())
+		case err := <-errs:
+			t.Fatalf("%+v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no flows received")
+		}
+	}
+
+	//the stream can't be read after an invalid header
+	_, err = conn.Write(make([]byte, 16))
+	require.Nil(t, err)
+	select {
+	case <-flows:
+		t.Fatal("invalid message decoded")
+	case err := <-errs:
+		require.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error received")
+	}
+	conn.Close()
+
+	cancel()
+	for range flows {
+	}
+	for range errs {
+	}
+}
+
+func TestTCPReaderTemplatesScopedToConnection(t *testing.T) {
+	address := freeTCPAddress(t)
+	newDecoder := func() native.Decoder {
+		return ipfix.NewDecoder(native.NewTemplateCache())
+	}
+	reader := native.NewTCPReader(address, nil, newDecoder, logging.NewTestLogger(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	flows, errs := reader.Drain(ctx)
+
+	conn := dialUntilListening(t, func() (net.Conn, error) {
+		return net.Dial("tcp", address)
+	})
+	_, err := conn.Write(newTemplatedIPFIXMessage(newTestTemplateSet(), newTestDataSet()))
+	require.Nil(t, err)
+	select {
+	case flow := <-flows:
+		require.Equal(t, "1.1.1.1", flow.SourceIPAddress())
+	case err := <-errs:
+		t.Fatalf("%+v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no flows received")
+	}
+	conn.Close()
+
+	//the template was withdrawn when the first connection closed
+	conn, err = net.Dial("tcp", address)
+	require.Nil(t, err)
+	_, err = conn.Write(newTemplatedIPFIXMessage(newTestDataSet()))
+	require.Nil(t, err)
+	select {
+	case <-flows:
+		t.Fatal("data record decoded with a template from another connection")
+	case err := <-errs:
+		require.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error received")
+	}
+	conn.Close()
+
+	cancel()
+	for range flows {
+	}
+	for range errs {
+	}
+}
+
+func TestTCPReaderTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp-reader")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	caCert, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	writeCertificate(t, dir, "server", caCert, caKey)
+	writeCertificate(t, dir, "exporter", caCert, caKey)
+	writeCertificate(t, dir, "rogue", nil, nil)
+
+	tlsConf, err := native.NewServerTLSConfig(testCollectorTLS{
+		verify:   true,
+		caFile:   filepath.Join(dir, "ca.crt"),
+		certFile: filepath.Join(dir, "server.crt"),
+		keyFile:  filepath.Join(dir, "server.key"),
+	})
+	require.Nil(t, err)
+
+	address := freeTCPAddress(t)
+	reader := native.NewTCPReader(address, tlsConf, newLengthDecoder, logging.NewTestLogger(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	flows, errs := reader.Drain(ctx)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	dialAs := func(name string) (net.Conn, error) {
+		clientCert, err := tls.LoadX509KeyPair(
+			filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"),
+		)
+		require.Nil(t, err)
+		return tls.Dial("tcp", address, &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{clientCert},
+		})
+	}
+
+	conn := dialUntilListening(t, func() (net.Conn, error) {
+		return dialAs("exporter")
+	})
+	_, err = conn.Write(newIPFIXMessage(10))
+	require.Nil(t, err)
+	select {
+	case flow := <-flows:
+		require.Equal(t, int64(26), flow.OctetTotalCount())
+	case err := <-errs:
+		t.Fatalf("%+v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no flows received")
+	}
+	conn.Close()
+
+	//exporters without a trusted certificate are rejected
+	conn, err = dialAs("rogue")
+	if err == nil {
+		conn.Write(newIPFIXMessage(10))
+		select {
+		case <-flows:
+			t.Fatal("flow accepted from untrusted exporter")
+		case err := <-errs:
+			require.NotNil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no error received")
+		}
+		conn.Close()
+	}
+
+	cancel()
+	for range flows {
+	}
+	for range errs {
+	}
+}
+
+func TestNewServerTLSConfigRequiresCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp-reader")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeCertificate(t, dir, "server", nil, nil)
+
+	_, err = native.NewServerTLSConfig(testCollectorTLS{
+		verify:   true,
+		certFile: filepath.Join(dir, "server.crt"),
+		keyFile:  filepath.Join(dir, "server.key"),
+	})
+	require.NotNil(t, err)
+
+	_, err = native.NewServerTLSConfig(testCollectorTLS{
+		certFile: filepath.Join(dir, "server.crt"),
+		keyFile:  filepath.Join(dir, "server.key"),
+	})
+	require.Nil(t, err)
+}
@@ -0,0 +1,213 @@
+package native
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+//VariableLength is the field length used by IPFIX to signal
+//that a field's length is encoded in the data record itself
+const VariableLength = 65535
+
+//FieldSpecifier describes a single field in a template
+type FieldSpecifier struct {
+	//ID is the Information Element identifier without the enterprise bit
+	ID uint16
+	//Length is the length of the field in bytes or VariableLength
+	Length uint16
+	//EnterpriseNumber is the IANA Private Enterprise Number which
+	//defines the Information Element. Standard elements use 0.
+	EnterpriseNumber uint32
+}
+
+//Template describes the layout of the data records sent by an exporter
+type Template struct {
+	ID uint16
+	//ScopeFieldCount is the number of scope fields at the beginning
+	//of an options template. ScopeFieldCount is 0 for normal templates.
+	ScopeFieldCount uint16
+	Fields          []FieldSpecifier
+}
+
+//IsOptionsTemplate returns true if the template describes option records
+func (t Template) IsOptionsTemplate() bool {
+	return t.ScopeFieldCount > 0
+}
+
+//MinRecordLength returns the smallest number of bytes a data
+//record described by this template may take up. Variable length
+//fields take up at least one byte.
+func (t Template) MinRecordLength() int {
+	length := 0
+	for i := range t.Fields {
+		if t.Fields[i].Length == VariableLength {
+			length++
+		} else {
+			length += int(t.Fields[i].Length)
+		}
+	}
+	return length
+}
+
+//Domain identifies an IPFIX Observation Domain or a Netflow v9
+//Source ID on an exporter. Template IDs are only unique within a Domain.
+type Domain struct {
+	Exporter string
+	//Version is the IPFIX/ Netflow version used by the exporter
+	Version uint16
+	//ID is the Observation Domain ID or Source ID
+	ID uint32
+}
+
+//TemplateKey identifies a template sent by an exporter
+type TemplateKey struct {
+	Domain     Domain
+	TemplateID uint16
+}
+
+//templateFileEntry is the form a template takes in a template cache file
+type templateFileEntry struct {
+	Key      TemplateKey
+	Template Template
+}
+
+//TemplateCache holds the templates learned from each exporter.
+//If the cache is backed by a file, the file is rewritten
+//whenever the cache changes. The cache is safe for concurrent use.
+type TemplateCache struct {
+	templates map[TemplateKey]Template
+	path      string
+	mutex     *sync.RWMutex
+}
+
+//NewTemplateCache creates an empty TemplateCache which
+//is held in memory
+func NewTemplateCache() *TemplateCache {
+	return &TemplateCache{
+		templates: make(map[TemplateKey]Template),
+		mutex:     new(sync.RWMutex),
+	}
+}
+
+//LoadTemplateCache creates a TemplateCache backed by the file at
+//the given path. The templates saved in the file are loaded
+//if the file exists.
+func LoadTemplateCache(path string) (*TemplateCache, error) {
+	cache := NewTemplateCache()
+	cache.path = path
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create directory for template cache file %s", path)
+	}
+
+	fileContents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read template cache file %s", path)
+	}
+
+	var entries []templateFileEntry
+	err = json.Unmarshal(fileContents, &entries)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse template cache file %s", path)
+	}
+	for i := range entries {
+		cache.templates[entries[i].Key] = entries[i].Template
+	}
+	return cache, nil
+}
+
+//Get returns the template stored under the given key
+func (c *TemplateCache) Get(key TemplateKey) (Template, bool) {
+	c.mutex.RLock()
+	template, ok := c.templates[key]
+	c.mutex.RUnlock()
+	return template, ok
+}
+
+//Put stores a template under the given key, replacing any
+//existing template. An error is returned if the cache file
+//could not be updated.
+func (c *TemplateCache) Put(key TemplateKey, template Template) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	//exporters resend their templates periodically, avoid
+	//rewriting the cache file if nothing changed
+	existing, ok := c.templates[key]
+	if ok && reflect.DeepEqual(existing, template) {
+		return nil
+	}
+	c.templates[key] = template
+	return c.save()
+}
+
+//Remove deletes the template stored under the given key.
+//An error is returned if the cache file could not be updated.
+func (c *TemplateCache) Remove(key TemplateKey) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.templates[key]; !ok {
+		return nil
+	}
+	delete(c.templates, key)
+	return c.save()
+}
+
+//RemoveDomain deletes the templates stored for the given Domain.
+//If match is not nil, only the templates for which match returns
+//true are deleted. An error is returned if the cache file could
+//not be updated.
+func (c *TemplateCache) RemoveDomain(domain Domain, match func(Template) bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	removed := false
+	for key, template := range c.templates {
+		if key.Domain == domain && (match == nil || match(template)) {
+			delete(c.templates, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return c.save()
+}
+
+//save writes the templates out to the cache file if the cache
+//is backed by a file. The mutex must be held by the caller.
+func (c *TemplateCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	entries := make([]templateFileEntry, 0, len(c.templates))
+	for key, template := range c.templates {
+		entries = append(entries, templateFileEntry{Key: key, Template: template})
+	}
+	fileContents, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "could not serialize template cache")
+	}
+
+	//write to a temporary file and swap it in so a crash
+	//can't leave a partially written cache behind
+	tmpPath := c.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, fileContents, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not write template cache file %s", tmpPath)
+	}
+	err = os.Rename(tmpPath, c.path)
+	if err != nil {
+		return errors.Wrapf(err, "could not replace template cache file %s", c.path)
+	}
+	return nil
+}
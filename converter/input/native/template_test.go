@@ -0,0 +1,81 @@
+package native_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTemplate(id uint16, scopeFieldCount uint16) native.Template {
+	return native.Template{
+		ID:              id,
+		ScopeFieldCount: scopeFieldCount,
+		Fields: []native.FieldSpecifier{
+			{ID: 8, Length: 4},
+			{ID: 82, Length: native.VariableLength},
+			{ID: 1, Length: 8, EnterpriseNumber: 29305},
+		},
+	}
+}
+
+func TestTemplateCachePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template-cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache", "templates.json")
+
+	domainA := native.Domain{Exporter: "A", Version: 10, ID: 1}
+	domainB := native.Domain{Exporter: "B", Version: 9, ID: 1}
+
+	cache, err := native.LoadTemplateCache(path)
+	require.Nil(t, err)
+	require.Nil(t, cache.Put(native.TemplateKey{Domain: domainA, TemplateID: 256}, newTestTemplate(256, 0)))
+	require.Nil(t, cache.Put(native.TemplateKey{Domain: domainA, TemplateID: 257}, newTestTemplate(257, 1)))
+	require.Nil(t, cache.Put(native.TemplateKey{Domain: domainB, TemplateID: 256}, newTestTemplate(256, 0)))
+
+	//templates survive a restart
+	reloaded, err := native.LoadTemplateCache(path)
+	require.Nil(t, err)
+	template, ok := reloaded.Get(native.TemplateKey{Domain: domainA, TemplateID: 257})
+	require.True(t, ok)
+	require.Equal(t, newTestTemplate(257, 1), template)
+	_, ok = reloaded.Get(native.TemplateKey{Domain: domainB, TemplateID: 256})
+	require.True(t, ok)
+	_, ok = reloaded.Get(native.TemplateKey{Domain: domainA, TemplateID: 258})
+	require.False(t, ok)
+
+	//withdrawn templates are removed from the file
+	require.Nil(t, cache.Remove(native.TemplateKey{Domain: domainB, TemplateID: 256}))
+	require.Nil(t, cache.RemoveDomain(domainA, func(template native.Template) bool {
+		return template.IsOptionsTemplate()
+	}))
+	reloaded, err = native.LoadTemplateCache(path)
+	require.Nil(t, err)
+	_, ok = reloaded.Get(native.TemplateKey{Domain: domainB, TemplateID: 256})
+	require.False(t, ok)
+	_, ok = reloaded.Get(native.TemplateKey{Domain: domainA, TemplateID: 257})
+	require.False(t, ok)
+	_, ok = reloaded.Get(native.TemplateKey{Domain: domainA, TemplateID: 256})
+	require.True(t, ok)
+
+	require.Nil(t, cache.RemoveDomain(domainA, nil))
+	reloaded, err = native.LoadTemplateCache(path)
+	require.Nil(t, err)
+	_, ok = reloaded.Get(native.TemplateKey{Domain: domainA, TemplateID: 256})
+	require.False(t, ok)
+}
+
+func TestTemplateCacheCorruptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template-cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "templates.json")
+
+	require.Nil(t, ioutil.WriteFile(path, []byte("{not json"), 0644))
+	_, err = native.LoadTemplateCache(path)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,124 @@
+package native
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/pkg/errors"
+)
+
+//maxDatagramSize is the largest UDP payload which may be received
+const maxDatagramSize = 65535
+
+//receiveBufferSize is the requested size of the socket receive buffer.
+//This matches the setting used for the Logstash UDP input.
+//Needs: sudo sysctl -w net.core.rmem_max=$((1024*1024*64))
+const receiveBufferSize = 1024 * 1024 * 64
+
+//readErrorBackoff is how long to wait after a failed read
+//so a persistent error doesn't flood the logs
+const readErrorBackoff = 100 * time.Millisecond
+
+//UDPReader implements input.Reader by listening for the packets
+//sent directly from exporters over UDP
+type UDPReader struct {
+	address string
+	decoder Decoder
+	log     logging.Logger
+}
+
+//NewUDPReader returns a new input.Reader which listens on the given
+//UDP address and decodes the packets it receives with the given Decoder
+func NewUDPReader(address string, decoder Decoder, log logging.Logger) input.Reader {
+	return UDPReader{
+		address: address,
+		decoder: decoder,
+		log:     log,
+	}
+}
+
+//Drain asynchronously receives and decodes packets until the
+//context is cancelled
+func (r UDPReader) Drain(ctx context.Context) (<-chan input.Flow, <-chan error) {
+	out := make(chan input.Flow)
+	errs := make(chan error)
+
+	go func(out chan<- input.Flow, errs chan<- error) {
+		conn, err := net.ListenPacket("udp", r.address)
+		if err != nil {
+			errs <- errors.Wrapf(err, "could not listen on %s", r.address)
+			close(errs)
+			close(out)
+			return
+		}
+
+		if udpConn, ok := conn.(*net.UDPConn); ok {
+			err = udpConn.SetReadBuffer(receiveBufferSize)
+			if err != nil {
+				r.log.Warn("could not enlarge the UDP receive buffer", logging.Fields{"error": err.Error()})
+			}
+		}
+
+		r.log.Info("listening for flows", logging.Fields{"address": conn.LocalAddr().String()})
+
+		//closing the connection unblocks ReadFrom
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+
+		buffer := make([]byte, maxDatagramSize)
+	readLoop:
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				//errors such as ICMP port unreachable messages don't
+				//break the socket, keep listening
+				r.log.Error(errors.Wrap(err, "could not read from UDP socket"), nil)
+				select {
+				case <-time.After(readErrorBackoff):
+				case <-ctx.Done():
+					break readLoop
+				}
+				continue
+			}
+
+			exporter := addr.String()
+			if udpAddr, ok := addr.(*net.UDPAddr); ok {
+				exporter = udpAddr.IP.String()
+			}
+
+			//a stalled consumer must not keep the reader from shutting down
+			flows, decodeErrs := r.decoder.Decode(exporter, buffer[:n])
+			for i := range decodeErrs {
+				select {
+				case errs <- errors.Wrap(decodeErrs[i], "could not decode packet"):
+				case <-ctx.Done():
+					break readLoop
+				}
+			}
+			received := time.Now().UnixNano() / int64(time.Millisecond)
+			for i := range flows {
+				if flow, ok := flows[i].(*Flow); ok {
+					flow.Received = received
+				}
+				select {
+				case out <- flows[i]:
+				case <-ctx.Done():
+					break readLoop
+				}
+			}
+		}
+
+		close(errs)
+		close(out)
+	}(out, errs)
+
+	return out, errs
+}
@@ -0,0 +1,121 @@
+package native_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+//echoDecoder returns one flow per packet. The packet's
+//contents are used as the flow's source address.
+type echoDecoder struct{}
+
+func (e echoDecoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	if len(packet) == 0 {
+		return nil, []error{errors.New("empty packet")}
+	}
+	flow := &native.Flow{Host: exporter}
+	flow.Netflow.SourceIPv4 = string(packet)
+	return []input.Flow{flow}, nil
+}
+
+func TestUDPReader(t *testing.T) {
+	//find a free port
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	address := probe.LocalAddr().String()
+	probe.Close()
+
+	reader := native.NewUDPReader(address, echoDecoder{}, logging.NewTestLogger(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	flows, errs := reader.Drain(ctx)
+
+	conn, err := net.Dial("udp", address)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	//the listener may not be up yet, keep sending until a flow arrives
+	var flow input.Flow
+	timeout := time.After(5 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	for flow == nil {
+		select {
+		case <-ticker.C:
+			_, err = conn.Write([]byte("1.1.1.1"))
+			require.Nil(t, err)
+		case flow = <-flows:
+		case err := <-errs:
+			t.Fatalf("%+v", err)
+		case <-timeout:
+			t.Fatal("no flows received")
+		}
+	}
+	ticker.Stop()
+	require.Equal(t, "1.1.1.1", flow.SourceIPAddress())
+	require.Equal(t, "127.0.0.1", flow.Exporter())
+
+	cancel()
+	//drain any flows sent by the extra writes
+	for range flows {
+	}
+	for range errs {
+	}
+}
+
+func TestUDPReaderStalledConsumer(t *testing.T) {
+	//find a free port
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	address := probe.LocalAddr().String()
+	probe.Close()
+
+	reader := native.NewUDPReader(address, echoDecoder{}, logging.NewTestLogger(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	flows, errs := reader.Drain(ctx)
+
+	conn, err := net.Dial("udp", address)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	//wait for the listener to come up
+	timeout := time.After(5 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	for received := false; !received; {
+		select {
+		case <-ticker.C:
+			conn.Write([]byte("1.1.1.1"))
+		case <-flows:
+			received = true
+		case <-timeout:
+			t.Fatal("no flows received")
+		}
+	}
+	ticker.Stop()
+
+	//nobody reads the flows, so the reader blocks trying to send them
+	for i := 0; i < 10; i++ {
+		_, err = conn.Write([]byte("1.1.1.1"))
+		require.Nil(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	timeout = time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-errs:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the reader did not shut down")
+		}
+	}
+}
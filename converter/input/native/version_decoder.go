@@ -0,0 +1,36 @@
+package native
+
+import (
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/pkg/errors"
+)
+
+//VersionDecoder implements Decoder by handing each packet
+//to the Decoder registered for the packet's version number.
+//This allows a single collector to receive Netflow v5, Netflow v9,
+//and IPFIX packets on the same port.
+type VersionDecoder struct {
+	decoders map[uint16]Decoder
+}
+
+//NewVersionDecoder creates a new VersionDecoder from a map
+//of version numbers to the Decoders which handle them
+func NewVersionDecoder(decoders map[uint16]Decoder) VersionDecoder {
+	return VersionDecoder{
+		decoders: decoders,
+	}
+}
+
+//Decode peeks the version number of the packet and
+//decodes the packet with the matching Decoder
+func (v VersionDecoder) Decode(exporter string, packet []byte) ([]input.Flow, []error) {
+	version, err := PeekVersion(packet)
+	if err != nil {
+		return nil, []error{errors.Wrapf(err, "could not read packet from %s", exporter)}
+	}
+	decoder, ok := v.decoders[version]
+	if !ok {
+		return nil, []error{errors.Errorf("unsupported netflow version from %s: %d", exporter, version)}
+	}
+	return decoder.Decode(exporter, packet)
+}
@@ -0,0 +1,26 @@
+package native_test
+
+import (
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input/native"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionDecoder(t *testing.T) {
+	decoder := native.NewVersionDecoder(map[uint16]native.Decoder{
+		10: echoDecoder{},
+	})
+
+	flows, errs := decoder.Decode("A", []byte{0, 10})
+	require.Len(t, errs, 0)
+	require.Len(t, flows, 1)
+
+	flows, errs = decoder.Decode("A", []byte{0, 9})
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+
+	flows, errs = decoder.Decode("A", []byte{0})
+	require.Len(t, errs, 1)
+	require.Len(t, flows, 0)
+}
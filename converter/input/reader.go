@@ -12,9 +12,6 @@ type Reader interface {
 	Drain(context.Context) (<-chan Flow, <-chan error)
 }
 
-//DrainNReaders is not used in ipfix-rita. However it is kept
-//here for convenience.
-
 //DrainNReaders allows a piece of code to read several
 //readers at once
 func DrainNReaders(ctx context.Context, readers []Reader) (<-chan Flow, <-chan error) {
@@ -85,3 +82,12 @@ func DrainNReaders(ctx context.Context, readers []Reader) (<-chan Flow, <-chan e
 
 	return outData, outErrors
 }
+
+//MultiReader implements Reader by draining several
+//Readers at once with DrainNReaders
+type MultiReader []Reader
+
+//Drain asynchronously drains each of the Readers
+func (m MultiReader) Drain(ctx context.Context) (<-chan Flow, <-chan error) {
+	return DrainNReaders(ctx, m)
+}
@@ -2,6 +2,7 @@ package integrationtest
 
 import (
 	"net"
+	"time"
 
 	"github.com/activecm/ipfix-rita/converter/config"
 	"github.com/activecm/mgosec"
@@ -12,18 +13,83 @@ type TestConfig struct {
 	input     InputConfig
 	output    OutputConfig
 	filtering FilteringConfig
+	stitching StitchingConfig
 }
 
 func (t *TestConfig) GetInputConfig() config.Input         { return &t.input }
 func (t *TestConfig) GetOutputConfig() config.Output       { return &t.output }
 func (t *TestConfig) GetFilteringConfig() config.Filtering { return &t.filtering }
+func (t *TestConfig) GetStitchingConfig() config.Stitching { return &t.stitching }
 
 //InputConfig implements config.Input
 type InputConfig struct {
 	logstashMongo LogstashMongoConfig
+	collector     CollectorConfig
+	ipfixFiles    IPFIXFilesConfig
+	nfcapdFiles   NfcapdFilesConfig
+	pcapFiles     PCAPFilesConfig
+	clockSkew     ClockSkewConfig
 }
 
 func (t *InputConfig) GetLogstashMongoDBConfig() config.LogstashMongoDB { return &t.logstashMongo }
+func (t *InputConfig) GetCollectorConfig() config.Collector             { return &t.collector }
+func (t *InputConfig) GetIPFIXFilesConfig() config.IPFIXFiles           { return &t.ipfixFiles }
+func (t *InputConfig) GetNfcapdFilesConfig() config.NfcapdFiles         { return &t.nfcapdFiles }
+func (t *InputConfig) GetPCAPFilesConfig() config.PCAPFiles             { return &t.pcapFiles }
+func (t *InputConfig) GetClockSkewConfig() config.ClockSkew             { return &t.clockSkew }
+
+//ClockSkewConfig implements config.ClockSkew
+type ClockSkewConfig struct{}
+
+func (c *ClockSkewConfig) IsEnabled() bool                       { return false }
+func (c *ClockSkewConfig) GetWarnThreshold() time.Duration       { return 0 }
+func (c *ClockSkewConfig) ShouldCorrect() bool                   { return false }
+func (c *ClockSkewConfig) GetCorrectionThreshold() time.Duration { return 0 }
+
+//IPFIXFilesConfig implements config.IPFIXFiles
+type IPFIXFilesConfig struct{}
+
+func (i *IPFIXFilesConfig) IsEnabled() bool     { return false }
+func (i *IPFIXFilesConfig) GetPaths() []string  { return nil }
+func (i *IPFIXFilesConfig) GetExporter() string { return "" }
+
+//NfcapdFilesConfig implements config.NfcapdFiles
+type NfcapdFilesConfig struct{}
+
+func (n *NfcapdFilesConfig) IsEnabled() bool    { return false }
+func (n *NfcapdFilesConfig) GetPaths() []string { return nil }
+
+//PCAPFilesConfig implements config.PCAPFiles
+type PCAPFilesConfig struct{}
+
+func (p *PCAPFilesConfig) IsEnabled() bool          { return false }
+func (p *PCAPFilesConfig) GetPaths() []string       { return nil }
+func (p *PCAPFilesConfig) GetPorts() []uint16       { return nil }
+func (p *PCAPFilesConfig) ShouldAlignToToday() bool { return false }
+
+//CollectorConfig implements config.Collector
+type CollectorConfig struct{}
+
+func (c *CollectorConfig) IsEnabled() bool              { return false }
+func (c *CollectorConfig) GetUDPAddress() string        { return "127.0.0.1:0" }
+func (c *CollectorConfig) GetTCPAddress() string        { return "" }
+func (c *CollectorConfig) GetTLS() config.CollectorTLS  { return &CollectorTLSConfig{} }
+func (c *CollectorConfig) GetTemplateCachePath() string { return "" }
+func (c *CollectorConfig) GetSFlowConfig() config.SFlow { return &SFlowConfig{} }
+
+//SFlowConfig implements config.SFlow
+type SFlowConfig struct{}
+
+func (s *SFlowConfig) GetUDPAddress() string               { return "" }
+func (s *SFlowConfig) GetAggregationWindow() time.Duration { return 0 }
+
+//CollectorTLSConfig implements config.CollectorTLS
+type CollectorTLSConfig struct {
+	TLSConfig
+}
+
+func (c *CollectorTLSConfig) GetCertFile() string { return "" }
+func (c *CollectorTLSConfig) GetKeyFile() string  { return "" }
 
 //LogstashMongoConfig implements config.LogstashMongoDB
 type LogstashMongoConfig struct {
@@ -33,6 +99,37 @@ type LogstashMongoConfig struct {
 func (t *LogstashMongoConfig) GetConnectionConfig() config.MongoDBConnection { return &t.mongoDB }
 func (t *LogstashMongoConfig) GetDatabase() string                           { return "IPFIX" }
 func (t *LogstashMongoConfig) GetCollection() string                         { return "in" }
+func (t *LogstashMongoConfig) GetQuarantineConfig() config.Quarantine        { return &QuarantineConfig{} }
+func (t *LogstashMongoConfig) GetFieldMappingConfig() config.FieldMapping {
+	return &FieldMappingConfig{}
+}
+func (t *LogstashMongoConfig) GetNSELConfig() config.NSEL         { return &NSELConfig{} }
+func (t *LogstashMongoConfig) GetSamplingConfig() config.Sampling { return &SamplingConfig{} }
+
+//QuarantineConfig implements config.Quarantine
+type QuarantineConfig struct{}
+
+func (q *QuarantineConfig) GetCollection() string { return "" }
+func (q *QuarantineConfig) GetFile() string       { return "" }
+
+//FieldMappingConfig implements config.FieldMapping
+type FieldMappingConfig struct{}
+
+func (f *FieldMappingConfig) GetDefault() map[string][]config.FieldSource { return nil }
+func (f *FieldMappingConfig) GetExporters() map[string]map[string][]config.FieldSource {
+	return nil
+}
+
+//NSELConfig implements config.NSEL
+type NSELConfig struct{}
+
+func (n *NSELConfig) ShouldPairEvents() bool { return false }
+func (n *NSELConfig) ShouldDropDenied() bool { return false }
+
+//SamplingConfig implements config.Sampling
+type SamplingConfig struct{}
+
+func (s *SamplingConfig) GetExporters() map[string]int64 { return nil }
 
 //MongoDBConfig implements config.MongoDB
 type MongoDBConfig struct {
@@ -91,3 +188,46 @@ func (f *FilteringConfig) GetNeverIncludeSubnets() ([]net.IPNet, []error) {
 func (f *FilteringConfig) GetInternalSubnets() ([]net.IPNet, []error) {
 	return []net.IPNet{}, []error{}
 }
+
+//StitchingConfig implements config.Stitching
+type StitchingConfig struct{}
+
+func (s *StitchingConfig) GetMatcherConfig() config.Matcher { return &MatcherConfig{} }
+
+//MatcherConfig implements config.Matcher
+type MatcherConfig struct{}
+
+func (m *MatcherConfig) GetType() string           { return "RAM" }
+func (m *MatcherConfig) GetMaxSize() int64         { return 5000 }
+func (m *MatcherConfig) GetPath() string           { return "" }
+func (m *MatcherConfig) GetEvictionPolicy() string { return "Default" }
+func (m *MatcherConfig) GetMongoDBConfig() config.MatcherMongoDB {
+	return &MatcherMongoDBConfig{}
+}
+func (m *MatcherConfig) GetSnapshotConfig() config.MatcherSnapshot {
+	return &MatcherSnapshotConfig{}
+}
+func (m *MatcherConfig) GetIdleTimeoutConfig() config.MatcherIdleTimeout {
+	return &MatcherIdleTimeoutConfig{}
+}
+
+//MatcherMongoDBConfig implements config.MatcherMongoDB
+type MatcherMongoDBConfig struct {
+	mongoDB MongoDBConfig
+}
+
+func (m *MatcherMongoDBConfig) GetConnectionConfig() config.MongoDBConnection { return &m.mongoDB }
+func (m *MatcherMongoDBConfig) GetDatabase() string                           { return "IPFIX" }
+
+//MatcherSnapshotConfig implements config.MatcherSnapshot
+type MatcherSnapshotConfig struct{}
+
+func (m *MatcherSnapshotConfig) GetPath() string          { return "" }
+func (m *MatcherSnapshotConfig) GetMaxAge() time.Duration { return 10 * time.Minute }
+
+//MatcherIdleTimeoutConfig implements config.MatcherIdleTimeout
+type MatcherIdleTimeoutConfig struct{}
+
+func (m *MatcherIdleTimeoutConfig) GetTCPTimeout() time.Duration   { return 0 }
+func (m *MatcherIdleTimeoutConfig) GetUDPTimeout() time.Duration   { return 0 }
+func (m *MatcherIdleTimeoutConfig) GetOtherTimeout() time.Duration { return 0 }
@@ -96,8 +96,9 @@ func (r *batchRITAConnDateWriter) Write(sessions <-chan *session.Aggregate) <-ch
 					break WriteLoop
 				}
 
-				//insert the record
-				err = outColl.Insert(connRecord)
+				//insert the record, acknowledging the session's flows
+				//once the record has been written
+				err = outColl.InsertWithCallback(connRecord, sess.Acknowledge)
 				if err != nil {
 					errs <- err
 					break WriteLoop
@@ -96,7 +96,14 @@ Loop:
 //Insert writes a record into the Collection's buffer.
 //If the buffer is full after the insertion, Flush is called.
 func (b *AutoFlushCollection) Insert(data interface{}) error {
-	err := b.bufferedColl.Insert(data)
+	return b.InsertWithCallback(data, nil)
+}
+
+//InsertWithCallback writes a record into the Collection's buffer.
+//onFlush is called once the record has been written to MongoDB.
+//If the buffer is full after the insertion, Flush is called.
+func (b *AutoFlushCollection) InsertWithCallback(data interface{}, onFlush func()) error {
+	err := b.bufferedColl.InsertWithCallback(data, onFlush)
 	if err != nil {
 		return err
 	}
@@ -12,7 +12,10 @@ import (
 type Collection struct {
 	mgoCollection *mgo.Collection
 	buffer        []interface{}
-	mutex         *sync.Mutex
+	//onFlush holds the callbacks for the buffered records
+	//which must be run once the records are written
+	onFlush []func()
+	mutex   *sync.Mutex
 }
 
 //InitializeCollection wraps a *mgo.Collection with a buffer of a given size
@@ -36,8 +39,18 @@ func (b *Collection) Name() string {
 //Insert writes a record into the Collection's buffer.
 //If the buffer is full after the insertion, Flush is called.
 func (b *Collection) Insert(data interface{}) error {
+	return b.InsertWithCallback(data, nil)
+}
+
+//InsertWithCallback writes a record into the Collection's buffer.
+//onFlush is called once the record has been written to MongoDB.
+//If the buffer is full after the insertion, Flush is called.
+func (b *Collection) InsertWithCallback(data interface{}, onFlush func()) error {
 	b.mutex.Lock()
 	b.buffer = append(b.buffer, data)
+	if onFlush != nil {
+		b.onFlush = append(b.onFlush, onFlush)
+	}
 	shouldFlush := len(b.buffer) == cap(b.buffer)
 	b.mutex.Unlock()
 	if shouldFlush {
@@ -60,6 +73,10 @@ func (b *Collection) Flush() error {
 		return errors.Wrap(err, "could not perform bulk insert of output data into MongoDB")
 	}
 	b.buffer = b.buffer[:0]
+	for i := range b.onFlush {
+		b.onFlush[i]()
+	}
+	b.onFlush = b.onFlush[:0]
 	return nil
 }
 
@@ -20,6 +20,7 @@ func (s SpewRITAConnWriter) Write(sessions <-chan *session.Aggregate) <-chan err
 			var conn parsetypes.Conn
 			sess.ToRITAConn(&conn, func(ipAddress string) bool { return false })
 			spew.Dump(conn)
+			sess.Acknowledge()
 		}
 		close(errs)
 	}()
@@ -267,7 +267,7 @@ WriteLoop:
 				}
 
 				//Insert into today's db
-				err := s.currentCollection.Insert(ritaConn)
+				err := s.currentCollection.InsertWithCallback(ritaConn, sess.Acknowledge)
 				if err != nil {
 					errsOut <- errors.Wrap(err, "could not insert session into the current period collection")
 					break WriteLoop
@@ -288,7 +288,7 @@ WriteLoop:
 				}
 
 				//Insert into yesterday's db
-				err := s.previousCollection.Insert(ritaConn)
+				err := s.previousCollection.InsertWithCallback(ritaConn, sess.Acknowledge)
 				if err != nil {
 					errsOut <- errors.Wrap(err, "could not insert session into the previous period collection")
 					break WriteLoop
@@ -299,6 +299,7 @@ WriteLoop:
 				})
 				//TODO: Add counters and track this
 				//Drop the connection record
+				sess.Acknowledge()
 			}
 
 			s.collectionMutex.Unlock()
@@ -18,8 +18,8 @@ type NullSessionWriter struct{}
 func (n NullSessionWriter) Write(sessions <-chan *session.Aggregate) <-chan error {
 	errs := make(chan error)
 	go func() {
-		for _ = range sessions {
-
+		for sess := range sessions {
+			sess.Acknowledge()
 		}
 		close(errs)
 	}()
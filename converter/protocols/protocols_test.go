@@ -15,3 +15,11 @@ func TestProtocols(t *testing.T) {
 	require.Equal(t, protocols.Identifier(132), protocols.SCTP)
 	require.Equal(t, protocols.Identifier(142), protocols.ROHC)
 }
+
+func TestTCPFlags(t *testing.T) {
+	require.Equal(t, uint16(0x01), protocols.TCPFIN)
+	require.Equal(t, uint16(0x02), protocols.TCPSYN)
+	require.Equal(t, uint16(0x04), protocols.TCPRST)
+	require.Equal(t, uint16(0x10), protocols.TCPACK)
+	require.Equal(t, uint16(0x100), protocols.TCPNS)
+}
@@ -0,0 +1,16 @@
+package protocols
+
+//TCP control bits as defined by RFC 793 and RFC 3168.
+//IPFIX and Netflow report the bits seen over the life of a flow
+//OR'd together in the tcpControlBits/ tcp_flags field.
+const (
+	TCPFIN uint16 = 1 << iota
+	TCPSYN
+	TCPRST
+	TCPPSH
+	TCPACK
+	TCPURG
+	TCPECE
+	TCPCWR
+	TCPNS
+)
@@ -3,12 +3,14 @@ package stitching
 import (
 	"encoding/binary"
 	"hash/fnv"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/activecm/ipfix-rita/converter/filter"
 	"github.com/activecm/ipfix-rita/converter/input"
 	"github.com/activecm/ipfix-rita/converter/logging"
-	"github.com/activecm/ipfix-rita/converter/stitching/matching/rammatch"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
 	"github.com/activecm/ipfix-rita/converter/stitching/session"
 	"github.com/pkg/errors"
 )
@@ -36,6 +38,12 @@ type Manager struct {
 	//will flush when a flush happens. The matcher will flush to
 	//matcherMaxSize * matcherFlushToPercent.
 	matcherFlushToPercent float64
+	//newMatcher creates the matcher which holds the session aggregates
+	//waiting to be stitched
+	newMatcher MatcherFactory
+	//snapshot determines whether the contents of the matcher are
+	//saved when the Manager is stopped and restored when it starts
+	snapshot SnapshotOptions
 	//flowFilter determines which flows should be dropped from the pipeline.
 	//The dropped flows will not be stitched, and they will not appear in the
 	//result stream.
@@ -44,10 +52,27 @@ type Manager struct {
 	log logging.Logger
 }
 
+//SnapshotOptions determines whether a Manager carries the session
+//aggregates held by its matcher across graceful restarts. Snapshots
+//are only taken if the matcher implements matching.Snapshotter.
+type SnapshotOptions struct {
+	//Path is the file the snapshot is saved to.
+	//Snapshots are disabled if Path is empty.
+	Path string
+	//MaxAge is how old a snapshot may be and still be restored.
+	//The sessions held in older snapshots are written out instead.
+	MaxAge time.Duration
+	//Stop is closed when the converter has been asked to shut down.
+	//If the input ends without Stop being closed, the input was
+	//exhausted and the matcher is flushed out as usual.
+	Stop <-chan struct{}
+}
+
 //NewManager creates a Manager with the given settings
 func NewManager(sameSessionThreshold int64, numStitchers int32,
 	stitcherBufferSize, outputBufferSize int64, matcherMaxSize int64,
-	matcherFlushToPercent float64, flowFilter filter.FlowFilter,
+	matcherFlushToPercent float64, newMatcher MatcherFactory,
+	snapshot SnapshotOptions, flowFilter filter.FlowFilter,
 	log logging.Logger) Manager {
 
 	return Manager{
@@ -57,6 +82,8 @@ func NewManager(sameSessionThreshold int64, numStitchers int32,
 		outputBufferSize:      outputBufferSize,
 		matcherMaxSize:        matcherMaxSize,
 		matcherFlushToPercent: matcherFlushToPercent,
+		newMatcher:            newMatcher,
+		snapshot:              snapshot,
 		flowFilter:            flowFilter,
 		log:                   log,
 	}
@@ -113,12 +140,29 @@ func (m Manager) RunAsync(input <-chan input.Flow) (<-chan *session.Aggregate, <
 }
 
 //runInner implements the bulk of RunAsync
-func (m Manager) runInner(input <-chan input.Flow,
+func (m Manager) runInner(inputFlows <-chan input.Flow,
 	sessions chan<- *session.Aggregate, errs chan<- error) {
 
 	//the matcher allows the stitchers to find session.Aggregates
 	//which may need to be stitched with other aggregates
-	matcher := rammatch.NewRAMMatcher(m.log, sessions, uint64(m.matcherMaxSize), m.matcherFlushToPercent)
+	matcher, err := m.newMatcher(m.log, sessions, uint64(m.matcherMaxSize), m.matcherFlushToPercent)
+	if err != nil {
+		errs <- errors.Wrap(err, "could not create the matcher")
+		//drain the input so the reader doesn't block. The flows are
+		//left unacknowledged so they may be read again after a restart.
+		for range inputFlows {
+		}
+		close(sessions)
+		close(errs)
+		return
+	}
+
+	//pick up where the last run left off before reading new input
+	m.loadSnapshot(matcher, errs)
+
+	//matchers which evict idle sessions need to know how far
+	//event time has advanced
+	idleEvicter, evictsIdle := matcher.(matching.IdleEvicter)
 
 	//In order to parallelize the stitching process, we use hash partitioning
 	//which ensures no two stitchers will work on the same session.AggregateQuery.
@@ -144,17 +188,21 @@ func (m Manager) runInner(input <-chan input.Flow,
 	//If the input is coming from input.mgologstash and managed by
 	//convert.go, the input channel will
 	//be closed when the program recieves CTRL-C
-	for inFlow := range input {
+	for inFlow := range inputFlows {
 		flowCount++
 
 		//check if we should filter out a flow
+		//flows which leave the pipeline early are acknowledged so
+		//the input doesn't wait on them forever
 		shouldFilterOut, err := m.flowFilter.Match(inFlow)
 		if err != nil {
 			errs <- errors.Wrap(err, "could not determine if flow should be filtered out")
+			input.Acknowledge(inFlow)
 			continue
 		}
 		if shouldFilterOut {
 			flowsFilteredOut++
+			input.Acknowledge(inFlow)
 			continue
 		}
 
@@ -166,6 +214,22 @@ func (m Manager) runInner(input <-chan input.Flow,
 			m.log.Info("Stitcher Buffer Counts", buffCounts)
 			m.log.Info("Out Buffer Count", logging.Fields{"count": len(sessions)})
 		*/
+		if evictsIdle {
+			//flows with bad timestamps are reported by the stitchers
+			flowEnd, err := inFlow.FlowEndMilliseconds()
+			if err == nil {
+				//a flow can't end after its record was received,
+				//don't let a skewed clock push event time ahead
+				if receivedFlow, ok := inFlow.(input.ReceivedFlow); ok {
+					received := receivedFlow.ReceivedMilliseconds()
+					if received != 0 && flowEnd > received {
+						flowEnd = received
+					}
+				}
+				idleEvicter.AdvanceEventTime(inFlow.Exporter(), flowEnd)
+			}
+		}
+
 		//use the hash partitioner to assign the flow to a stitcher
 		stitcherID := m.selectStitcher(inFlow)
 		//Send the flow to the assigned stitcher
@@ -200,8 +264,14 @@ func (m Manager) runInner(input <-chan input.Flow,
 	//Wait for the stitchers to exit
 	stitchersDone.Wait()
 
+	//save the unmatched sessions if the converter is being restarted
+	m.saveSnapshot(matcher, errs)
+
 	//close the matcher and flush the rest of the sessions out
-	matcher.Close()
+	err = matcher.Close()
+	if err != nil {
+		errs <- errors.Wrap(err, "could not close the matcher")
+	}
 
 	m.log.Info("stitching manager exiting", logging.Fields{
 		"flows processed":    flowCount,
@@ -215,6 +285,59 @@ func (m Manager) runInner(input <-chan input.Flow,
 	m.log.Info("stitching manager exited", nil)
 }
 
+//loadSnapshot restores the matcher's contents from the snapshot file
+//if one exists. The file is removed once it has been loaded so the
+//sessions it holds can't be restored twice.
+func (m Manager) loadSnapshot(matcher matching.Matcher, errs chan<- error) {
+	if m.snapshot.Path == "" {
+		return
+	}
+	snapshotter, ok := matcher.(matching.Snapshotter)
+	if !ok {
+		m.log.Warn("the matcher does not support snapshots", logging.Fields{
+			"path": m.snapshot.Path,
+		})
+		return
+	}
+	if _, err := os.Stat(m.snapshot.Path); os.IsNotExist(err) {
+		return
+	}
+
+	err := snapshotter.LoadSnapshot(m.snapshot.Path, m.snapshot.MaxAge)
+	if err != nil {
+		errs <- errors.Wrap(err, "could not load the matcher snapshot")
+		return
+	}
+	err = os.Remove(m.snapshot.Path)
+	if err != nil {
+		errs <- errors.Wrap(err, "could not remove the matcher snapshot")
+	}
+}
+
+//saveSnapshot saves the matcher's contents to the snapshot file
+//if the Manager was asked to stop. If the snapshot can't be saved,
+//the sessions are flushed out when the matcher is closed.
+func (m Manager) saveSnapshot(matcher matching.Matcher, errs chan<- error) {
+	if m.snapshot.Path == "" {
+		return
+	}
+	snapshotter, ok := matcher.(matching.Snapshotter)
+	if !ok {
+		return
+	}
+	select {
+	case <-m.snapshot.Stop:
+	default:
+		//the input ran dry, there is nothing to resume
+		return
+	}
+
+	err := snapshotter.SaveSnapshot(m.snapshot.Path)
+	if err != nil {
+		errs <- errors.Wrap(err, "could not save the matcher snapshot")
+	}
+}
+
 //selectStitcher hashes a flow's flow key and mods the result over the
 //number of stitchers
 func (m Manager) selectStitcher(f input.Flow) int {
@@ -2,6 +2,9 @@ package stitching
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
@@ -10,6 +13,7 @@ import (
 	"github.com/activecm/ipfix-rita/converter/input"
 	"github.com/activecm/ipfix-rita/converter/logging"
 	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
 	"github.com/activecm/ipfix-rita/converter/stitching/session"
 	"github.com/stretchr/testify/require"
 )
@@ -30,6 +34,7 @@ func newTestingStitchingManager(logger logging.Logger) Manager {
 	outputBufferSize := int64(5)            //number of session aggregates that are buffered for output
 	matcherMaxSize := int64(20)             //number of unstitched flows that can be held for matching
 	matcherFlushToPercent := 0.9
+	evictionPolicy, _ := matching.NewEvictionPolicy("Default")
 	return NewManager(
 		sameSessionThreshold,
 		numStitchers,
@@ -37,6 +42,8 @@ func newTestingStitchingManager(logger logging.Logger) Manager {
 		outputBufferSize,
 		matcherMaxSize,
 		matcherFlushToPercent,
+		newRAMMatcherFactory(matching.IdleTimeouts{}, evictionPolicy),
+		SnapshotOptions{},
 		filter.NewNullFilter(),
 		logger,
 	)
@@ -1392,3 +1399,255 @@ func TestChooseBestMatchingAggregate(t *testing.T) {
 	requireFlowsStitchedFlippedSides(t, flow1, flow2, sessions[0])
 	requireFlowsStitchedFlippedSides(t, flow3, flow4, sessions[1])
 }
+
+//conflictingMatcher simulates another converter sharing the matcher
+//by adding a packet to the stored session aggregate and reporting
+//a conflict the first time Update is called
+type conflictingMatcher struct {
+	matching.Matcher
+	conflicted bool
+}
+
+func (m *conflictingMatcher) Update(sessAgg *session.Aggregate) error {
+	if m.conflicted {
+		return m.Matcher.Update(sessAgg)
+	}
+	m.conflicted = true
+
+	var stored session.Aggregate
+	iter := m.Matcher.Find(&sessAgg.AggregateQuery)
+	if !iter.Next(&stored) {
+		return iter.Err()
+	}
+	stored.PacketTotalCountAB++
+	err := m.Matcher.Update(&stored)
+	if err != nil {
+		return err
+	}
+	return matching.ErrConflict
+}
+
+func TestConflictingUpdateRetried(t *testing.T) {
+	flow1 := input.NewFlowMock()
+	flow1.MockSourceIPAddress = "1.1.1.1"
+	flow1.MockSourcePort = 29445
+	flow1.MockDestinationIPAddress = "2.2.2.2"
+	flow1.MockDestinationPort = 53
+	flow1.MockProtocolIdentifier = protocols.UDP
+	flow1.MockFlowEndReason = input.IdleTimeout
+
+	flow2 := new(input.FlowMock)
+	*flow2 = *flow1
+	flow2.MockFlowStartMilliseconds = flow1.MockFlowEndMilliseconds + thirtySecondsMillis
+	flow2.MockFlowEndMilliseconds = flow2.MockFlowStartMilliseconds + (flow1.MockFlowEndMilliseconds - flow1.MockFlowStartMilliseconds)
+
+	stitchingManager := newTestingStitchingManager(logging.NewTestLogger(t))
+	newRAMMatcher := stitchingManager.newMatcher
+	stitchingManager.newMatcher = func(log logging.Logger, sessionsOut chan<- *session.Aggregate,
+		maxSize uint64, flushToPercent float64) (matching.Matcher, error) {
+		matcher, err := newRAMMatcher(log, sessionsOut, maxSize, flushToPercent)
+		return &conflictingMatcher{Matcher: matcher}, err
+	}
+	sessions, errs := stitchingManager.RunSync([]input.Flow{flow1, flow2})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 1)
+
+	//the flow is stitched again on top of the other converter's update
+	require.Equal(t, flow1.PacketTotalCount()+flow2.PacketTotalCount()+1, sessions[0].PacketTotalCountAB)
+}
+
+/*  **********  Stitching Manager Biflow Tests  **********  */
+func TestBiflowSkipsStitching(t *testing.T) {
+	//the biflow's source is mapped to host "B"
+	flow1 := input.NewFlowMock()
+	flow1.MockSourceIPAddress = "2.2.2.2"
+	flow1.MockDestinationIPAddress = "1.1.1.1"
+	flow1.MockProtocolIdentifier = protocols.TCP
+	flow1.MockBidirectional = true
+	flow1.MockReverseFlowStartMilliseconds = flow1.MockFlowStartMilliseconds + 5
+	flow1.MockReverseOctetTotalCount = 300
+	flow1.MockReversePacketTotalCount = 3
+
+	stitchingManager := newTestingStitchingManager(logging.NewTestLogger(t))
+	sessions, errs := stitchingManager.RunSync([]input.Flow{flow1})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 1)
+
+	sess := sessions[0]
+	require.True(t, sess.FilledFromSourceA)
+	require.True(t, sess.FilledFromSourceB)
+	require.Equal(t, flow1.OctetTotalCount(), sess.OctetTotalCountBA)
+	require.Equal(t, flow1.PacketTotalCount(), sess.PacketTotalCountBA)
+	require.Equal(t, flow1.MockFlowStartMilliseconds, sess.FlowStartMillisecondsBA)
+	require.Equal(t, int64(300), sess.OctetTotalCountAB)
+	require.Equal(t, int64(3), sess.PacketTotalCountAB)
+	require.Equal(t, flow1.MockReverseFlowStartMilliseconds, sess.FlowStartMillisecondsAB)
+	require.Equal(t, flow1.MockFlowEndMilliseconds, sess.FlowEndMillisecondsAB)
+	require.Equal(t, flow1.FlowEndReason(), sess.FlowEndReasonAB)
+}
+
+/*  **********  Acknowledgement Tests  **********  */
+
+//sourceFilter filters out flows from a single source address
+type sourceFilter string
+
+func (s sourceFilter) Match(flow input.Flow) (bool, error) {
+	return flow.SourceIPAddress() == string(s), nil
+}
+
+func TestFilteredFlowsAcknowledged(t *testing.T) {
+	flow1 := input.NewAcknowledgedFlowMock()
+	flow1.MockSourceIPAddress = "1.1.1.1"
+	flow1.MockDestinationIPAddress = "2.2.2.2"
+	flow1.MockProtocolIdentifier = protocols.UDP
+
+	flow2 := input.NewAcknowledgedFlowMock()
+	flow2.MockSourceIPAddress = "3.3.3.3"
+	flow2.MockDestinationIPAddress = "2.2.2.2"
+	flow2.MockProtocolIdentifier = protocols.UDP
+
+	stitchingManager := newTestingStitchingManager(logging.NewTestLogger(t))
+	stitchingManager.flowFilter = sourceFilter("3.3.3.3")
+	sessions, errs := stitchingManager.RunSync([]input.Flow{flow1, flow2})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 1)
+
+	//the filtered flow is acknowledged by the manager
+	require.Equal(t, 1, flow2.Acks)
+	//the stitched flow is acknowledged once its session is written
+	require.Equal(t, 0, flow1.Acks)
+	sessions[0].Acknowledge()
+	require.Equal(t, 1, flow1.Acks)
+}
+
+/*  **********  Snapshot Tests  **********  */
+
+//newSnapshotTestFlows creates a UDP flow and the reply
+//which should be stitched to it
+func newSnapshotTestFlows() (*input.AcknowledgedFlowMock, *input.FlowMock) {
+	flow1 := input.NewAcknowledgedFlowMock()
+	flow1.MockSourceIPAddress = "1.1.1.1"
+	flow1.MockSourcePort = 29445
+	flow1.MockDestinationIPAddress = "2.2.2.2"
+	flow1.MockDestinationPort = 53
+	flow1.MockProtocolIdentifier = protocols.UDP
+	flow1.MockFlowEndReason = input.IdleTimeout
+
+	flow2 := input.NewFlowMock()
+	flow2.MockSourceIPAddress = flow1.MockDestinationIPAddress
+	flow2.MockDestinationIPAddress = flow1.MockSourceIPAddress
+	flow2.MockSourcePort = flow1.MockDestinationPort
+	flow2.MockDestinationPort = flow1.MockSourcePort
+	flow2.MockExporter = flow1.MockExporter
+	flow2.MockProtocolIdentifier = flow1.MockProtocolIdentifier
+	flow2.MockFlowEndReason = flow1.MockFlowEndReason
+	flow2.MockFlowStartMilliseconds = flow1.MockFlowEndMilliseconds + thirtySecondsMillis
+	flow2.MockFlowEndMilliseconds = flow2.MockFlowStartMilliseconds + (flow1.MockFlowEndMilliseconds - flow1.MockFlowStartMilliseconds)
+	return flow1, flow2
+}
+
+func TestSnapshotRestoresSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stitching")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "matcher-snapshot.bson")
+
+	flow1, flow2 := newSnapshotTestFlows()
+
+	//the converter is stopped before the reply arrives
+	stop := make(chan struct{})
+	close(stop)
+	stitchingManager := newTestingStitchingManager(logging.NewTestLogger(t))
+	stitchingManager.snapshot = SnapshotOptions{Path: path, MaxAge: time.Minute, Stop: stop}
+	sessions, errs := stitchingManager.RunSync([]input.Flow{flow1})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 0)
+	//the flow is acknowledged once it is saved in the snapshot
+	require.Equal(t, 1, flow1.Acks)
+
+	//the reply is stitched to the restored flow after the restart
+	stitchingManager.snapshot.Stop = nil
+	sessions, errs = stitchingManager.RunSync([]input.Flow{flow2})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 1)
+	requireFlowsStitchedFlippedSides(t, flow1, flow2, sessions[0])
+
+	//the snapshot can't be restored twice
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSnapshotNotTakenWhenInputEnds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stitching")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "matcher-snapshot.bson")
+
+	flow1, _ := newSnapshotTestFlows()
+
+	stitchingManager := newTestingStitchingManager(logging.NewTestLogger(t))
+	stitchingManager.snapshot = SnapshotOptions{Path: path, MaxAge: time.Minute, Stop: make(chan struct{})}
+	sessions, errs := stitchingManager.RunSync([]input.Flow{flow1})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 1)
+	requireFlowStitchedWithZeroes(t, flow1, sessions[0])
+
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestStaleSnapshotFlushed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stitching")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "matcher-snapshot.bson")
+
+	flow1, flow2 := newSnapshotTestFlows()
+
+	stop := make(chan struct{})
+	close(stop)
+	stitchingManager := newTestingStitchingManager(logging.NewTestLogger(t))
+	stitchingManager.snapshot = SnapshotOptions{Path: path, MaxAge: time.Minute, Stop: stop}
+	sessions, errs := stitchingManager.RunSync([]input.Flow{flow1})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 0)
+
+	//every snapshot is too old to restore
+	stitchingManager.snapshot = SnapshotOptions{Path: path, MaxAge: -time.Minute}
+	sessions, errs = stitchingManager.RunSync([]input.Flow{flow2})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 2)
+	requireFlowStitchedWithZeroes(t, flow1, sessions[0])
+	requireFlowStitchedWithZeroes(t, flow2, sessions[1])
+}
+
+func TestCorruptSnapshotNotRestored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stitching")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "matcher-snapshot.bson")
+
+	flow1, flow2 := newSnapshotTestFlows()
+	otherFlow, _ := newSnapshotTestFlows()
+	otherFlow.MockSourcePort = 29446
+
+	stop := make(chan struct{})
+	close(stop)
+	stitchingManager := newTestingStitchingManager(logging.NewTestLogger(t))
+	stitchingManager.snapshot = SnapshotOptions{Path: path, MaxAge: time.Minute, Stop: stop}
+	sessions, errs := stitchingManager.RunSync([]input.Flow{flow1, otherFlow})
+	require.Len(t, errs, 0)
+	require.Len(t, sessions, 0)
+
+	//cut the last session aggregate short
+	snapshot, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(path, snapshot[:len(snapshot)-10], 0644))
+
+	//none of the sessions are restored, even those read before the error
+	stitchingManager.snapshot = SnapshotOptions{Path: path, MaxAge: time.Minute}
+	sessions, errs = stitchingManager.RunSync([]input.Flow{flow2})
+	require.Len(t, errs, 1)
+	require.Len(t, sessions, 1)
+	requireFlowStitchedWithZeroes(t, flow2, sessions[0])
+}
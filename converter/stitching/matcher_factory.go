@@ -0,0 +1,79 @@
+package stitching
+
+import (
+	"strings"
+
+	"github.com/activecm/ipfix-rita/converter/config"
+	"github.com/activecm/ipfix-rita/converter/database"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching/boltmatch"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching/mongomatch"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching/rammatch"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/pkg/errors"
+)
+
+//MatcherFactory creates the Matcher used by a Manager. Session aggregates
+//evicted from the Matcher must be sent on sessionsOut. The Matcher should
+//flush once it holds more than maxSize aggregates, leaving
+//maxSize * flushToPercent aggregates behind.
+type MatcherFactory func(log logging.Logger, sessionsOut chan<- *session.Aggregate,
+	maxSize uint64, flushToPercent float64) (matching.Matcher, error)
+
+//NewMatcherFactory returns a MatcherFactory which
+//creates the Matcher described by the configuration
+func NewMatcherFactory(conf config.Matcher) (MatcherFactory, error) {
+	switch strings.ToLower(conf.GetType()) {
+	case "ram":
+		evictionPolicy, err := matching.NewEvictionPolicy(conf.GetEvictionPolicy())
+		if err != nil {
+			return nil, err
+		}
+		idleConf := conf.GetIdleTimeoutConfig()
+		return newRAMMatcherFactory(matching.IdleTimeouts{
+			TCP:   idleConf.GetTCPTimeout(),
+			UDP:   idleConf.GetUDPTimeout(),
+			Other: idleConf.GetOtherTimeout(),
+		}, evictionPolicy), nil
+	case "bolt":
+		if conf.GetPath() == "" {
+			return nil, errors.New("a path must be given for the Bolt matcher")
+		}
+		path := conf.GetPath()
+		return func(log logging.Logger, sessionsOut chan<- *session.Aggregate,
+			maxSize uint64, flushToPercent float64) (matching.Matcher, error) {
+			return boltmatch.NewBoltMatcher(path, log, sessionsOut, maxSize, flushToPercent)
+		}, nil
+	case "mongodb":
+		mongoConf := conf.GetMongoDBConfig()
+		if mongoConf.GetDatabase() == "" {
+			return nil, errors.New("a database must be given for the MongoDB matcher")
+		}
+		return func(log logging.Logger, sessionsOut chan<- *session.Aggregate,
+			maxSize uint64, flushToPercent float64) (matching.Matcher, error) {
+			ssn, err := database.Dial(mongoConf.GetConnectionConfig())
+			if err != nil {
+				return nil, err
+			}
+			sessions := ssn.DB(mongoConf.GetDatabase()).C(mongomatch.SessionsCollName)
+			matcher, err := mongomatch.NewMongoMatcher(sessions, log, sessionsOut, maxSize, flushToPercent)
+			if err != nil {
+				ssn.Close()
+			}
+			return matcher, err
+		}, nil
+	}
+	return nil, errors.Errorf("unknown matcher type: %s", conf.GetType())
+}
+
+//newRAMMatcherFactory adapts rammatch.NewRAMMatcher to MatcherFactory
+func newRAMMatcherFactory(idleTimeouts matching.IdleTimeouts,
+	evictionPolicy matching.EvictionPolicy) MatcherFactory {
+	return func(log logging.Logger, sessionsOut chan<- *session.Aggregate,
+		maxSize uint64, flushToPercent float64) (matching.Matcher, error) {
+		return rammatch.NewRAMMatcher(
+			log, sessionsOut, maxSize, flushToPercent, idleTimeouts, evictionPolicy,
+		), nil
+	}
+}
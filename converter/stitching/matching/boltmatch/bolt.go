@@ -0,0 +1,419 @@
+package boltmatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+//sessionsBucket holds the session aggregates as BSON documents. The keys
+//are made by appending the MatcherID of each aggregate to its encoded
+//AggregateQuery so the aggregates sharing an AggregateQuery are
+//stored next to each other.
+var sessionsBucket = []byte("sessions")
+
+//insertOrderBucket maps the MatcherID of each session aggregate to its
+//encoded AggregateQuery. Since MatcherIDs are handed out in increasing
+//order, the oldest aggregates come first.
+var insertOrderBucket = []byte("insertOrder")
+
+//flushBatchSize is the most sessions removed from the store in a single
+//transaction during a flush. The sessions removed by a transaction are
+//held in memory until they are written out.
+const flushBatchSize = 1000
+
+//boltMatcher provides an implementation of Matcher backed by
+//a bolt key/value store on disk. The session aggregates held by
+//the matcher survive restarts.
+type boltMatcher struct {
+	db    *bolt.DB
+	count uint64
+
+	sessionsOut      chan<- *session.Aggregate
+	preFlushMaxSize  uint64
+	postFlushMaxSize uint64
+
+	log logging.Logger
+}
+
+//NewBoltMatcher returns a new matcher which holds the session aggregates
+//in the bolt database at the given path. The database is created if it
+//doesn't exist. Otherwise, the session aggregates left in the database
+//when the matcher was last closed are made available for stitching.
+func NewBoltMatcher(path string, log logging.Logger, sessionsOut chan<- *session.Aggregate,
+	maxSize uint64, flushToPercent float64) (matching.Matcher, error) {
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create the directory holding %s", path)
+	}
+
+	//fail rather than wait forever if another converter holds the lock
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open session aggregate store %s", path)
+	}
+
+	var count int
+	err = db.Update(func(tx *bolt.Tx) error {
+		sessions, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(insertOrderBucket)
+		if err != nil {
+			return err
+		}
+		count = sessions.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "could not initialize session aggregate store %s", path)
+	}
+
+	log.Info("opened session aggregate store", logging.Fields{
+		"path":     path,
+		"sessions": count,
+	})
+
+	return &boltMatcher{
+		db:               db,
+		count:            uint64(count),
+		sessionsOut:      sessionsOut,
+		preFlushMaxSize:  maxSize,
+		postFlushMaxSize: uint64(float64(maxSize)*flushToPercent + 0.5),
+		log:              log,
+	}, nil
+}
+
+//encodeQuery serializes an AggregateQuery into a key prefix.
+//Each string is preceded by its length so no query's encoding
+//is a prefix of another's.
+func encodeQuery(query *session.AggregateQuery) []byte {
+	buffer := make([]byte, 0, 2*len(query.IPAddressA)+len(query.Exporter)+13)
+	var scratch [2]byte
+
+	buffer = append(buffer, uint8(len(query.IPAddressA)))
+	buffer = append(buffer, query.IPAddressA...)
+	binary.BigEndian.PutUint16(scratch[:], query.PortA)
+	buffer = append(buffer, scratch[:]...)
+
+	buffer = append(buffer, uint8(len(query.IPAddressB)))
+	buffer = append(buffer, query.IPAddressB...)
+	binary.BigEndian.PutUint16(scratch[:], query.PortB)
+	buffer = append(buffer, scratch[:]...)
+
+	buffer = append(buffer, uint8(query.ProtocolIdentifier))
+
+	binary.BigEndian.PutUint16(scratch[:], uint16(len(query.Exporter)))
+	buffer = append(buffer, scratch[:]...)
+	buffer = append(buffer, query.Exporter...)
+	return buffer
+}
+
+//encodeID serializes a MatcherID such that the keys sort by MatcherID
+func encodeID(id uint64) []byte {
+	var buffer [8]byte
+	binary.BigEndian.PutUint64(buffer[:], id)
+	return buffer[:]
+}
+
+//sessionKey returns the key an aggregate is stored under in the sessionsBucket
+func sessionKey(queryKey []byte, idKey []byte) []byte {
+	key := make([]byte, 0, len(queryKey)+len(idKey))
+	key = append(key, queryKey...)
+	return append(key, idKey...)
+}
+
+//matcherID extracts the MatcherID held by a session aggregate
+func matcherID(sessAgg *session.Aggregate) (uint64, error) {
+	id, ok := sessAgg.MatcherID.(uint64)
+	if !ok {
+		return 0, errors.Errorf("invalid MatcherID: %v", sessAgg.MatcherID)
+	}
+	return id, nil
+}
+
+//decodeSession reads a session aggregate stored under the given key
+func decodeSession(key []byte, value []byte, sessAgg *session.Aggregate) error {
+	sessAgg.Clear()
+	err := bson.Unmarshal(value, sessAgg)
+	if err != nil {
+		return errors.Wrap(err, "could not decode session aggregate")
+	}
+	sessAgg.MatcherID = binary.BigEndian.Uint64(key[len(key)-8:])
+	return nil
+}
+
+//Close tears down any resources consumed by the Matcher. The session
+//aggregates are left on disk so they may be stitched after a restart.
+func (b *boltMatcher) Close() error {
+	b.log.Info("closing session aggregate store", logging.Fields{
+		"path":     b.db.Path(),
+		"sessions": atomic.LoadUint64(&b.count),
+	})
+	return errors.Wrap(b.db.Close(), "could not close session aggregate store")
+}
+
+//Find searches the Matcher for Aggregates which
+//match the given AggregateQuery
+func (b *boltMatcher) Find(sessAggQuery *session.AggregateQuery) session.Iterator {
+	var results []session.Aggregate
+	prefix := encodeQuery(sessAggQuery)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(sessionsBucket).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var sessAgg session.Aggregate
+			err := decodeSession(k, v, &sessAgg)
+			if err != nil {
+				return err
+			}
+			results = append(results, sessAgg)
+		}
+		return nil
+	})
+	return session.NewSliceIterator(results, err)
+}
+
+//Insert adds a session aggregate to the Matcher and sets its MatcherID.
+//The flows in the aggregate are acknowledged once it is stored on disk.
+func (b *boltMatcher) Insert(sessAgg *session.Aggregate) error {
+	queryKey := encodeQuery(&sessAgg.AggregateQuery)
+	//concurrent calls are combined into a single transaction
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		id, err := sessions.NextSequence()
+		if err != nil {
+			return err
+		}
+		sessAgg.MatcherID = id
+		value, err := bson.Marshal(sessAgg)
+		if err != nil {
+			return err
+		}
+		idKey := encodeID(id)
+		err = sessions.Put(sessionKey(queryKey, idKey), value)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(insertOrderBucket).Put(idKey, queryKey)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not insert session aggregate:\n%+v", sessAgg.AggregateQuery)
+	}
+	atomic.AddUint64(&b.count, 1)
+	sessAgg.Acknowledge()
+	return nil
+}
+
+//Update finds an Aggregate in the Matcher using the given
+//Aggregate's AggregateQuery and MatcherID and updates
+//the matching Aggregate's data. The flows in the aggregate
+//are acknowledged once it is stored on disk.
+func (b *boltMatcher) Update(sessAgg *session.Aggregate) error {
+	id, err := matcherID(sessAgg)
+	if err != nil {
+		return err
+	}
+	key := sessionKey(encodeQuery(&sessAgg.AggregateQuery), encodeID(id))
+	err = b.db.Batch(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		if sessions.Get(key) == nil {
+			return errors.Errorf("no records found for MatcherID: %d", id)
+		}
+		value, err := bson.Marshal(sessAgg)
+		if err != nil {
+			return err
+		}
+		return sessions.Put(key, value)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not update session aggregate:\n%+v", sessAgg.AggregateQuery)
+	}
+	sessAgg.Acknowledge()
+	return nil
+}
+
+//Remove finds an Aggregate in the Matcher using the given
+//Aggregate's AggregateQuery and MatcherID and removes it
+//from the system.
+func (b *boltMatcher) Remove(sessAgg *session.Aggregate) error {
+	id, err := matcherID(sessAgg)
+	if err != nil {
+		return err
+	}
+	idKey := encodeID(id)
+	key := sessionKey(encodeQuery(&sessAgg.AggregateQuery), idKey)
+	err = b.db.Batch(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		if sessions.Get(key) == nil {
+			return errors.Errorf("no records found for MatcherID: %d", id)
+		}
+		err := sessions.Delete(key)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(insertOrderBucket).Delete(idKey)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not remove session aggregate:\n%+v", sessAgg.AggregateQuery)
+	}
+	atomic.AddUint64(&b.count, ^uint64(0)) //-1 in two's complement >.>
+	return nil
+}
+
+//ShouldFlush returns true if Flush should be called in order
+//to maintain performance and ensure unmatched records are
+//written out in a timely manner.
+func (b *boltMatcher) ShouldFlush() (bool, error) {
+	return atomic.LoadUint64(&b.count) > b.preFlushMaxSize, nil
+}
+
+//Flush evicts Aggregates from the Matcher in order to maintain
+//performance and ensure unmatched records are written out in a
+//timely manner.
+func (b *boltMatcher) Flush() error {
+	startCount := atomic.LoadUint64(&b.count)
+	targetCount := b.postFlushMaxSize
+	if startCount <= targetCount {
+		return nil
+	}
+	defer func() {
+		b.log.Info("finished session aggregate flush", logging.Fields{
+			"start count":   startCount,
+			"current count": atomic.LoadUint64(&b.count),
+			"target count":  targetCount,
+		})
+	}()
+
+	//flush out the garbage first
+	for i := int64(1); i <= 2; i++ {
+		err := b.flushNPacketConnections(i)
+		if err != nil {
+			return err
+		}
+		if atomic.LoadUint64(&b.count) <= targetCount {
+			return nil
+		}
+	}
+	return b.flushOldest(targetCount)
+}
+
+//flushNPacketConnections flushes sessions which contain
+//exactly n packets in one direction and 0 in the other
+func (b *boltMatcher) flushNPacketConnections(n int64) error {
+	var resumeKey []byte
+	for {
+		var flushed []*session.Aggregate
+		var flushedKeys [][]byte
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(sessionsBucket).Cursor()
+			k, v := cursor.First()
+			if resumeKey != nil {
+				k, v = cursor.Seek(resumeKey)
+			}
+			resumeKey = nil
+			for ; k != nil; k, v = cursor.Next() {
+				if len(flushedKeys) == flushBatchSize {
+					resumeKey = append([]byte(nil), k...)
+					break
+				}
+				sessAgg := new(session.Aggregate)
+				err := decodeSession(k, v, sessAgg)
+				if err != nil {
+					return err
+				}
+				if sessAgg.PacketTotalCountAB == n && sessAgg.PacketTotalCountBA == 0 ||
+					sessAgg.PacketTotalCountBA == n && sessAgg.PacketTotalCountAB == 0 {
+					flushed = append(flushed, sessAgg)
+					flushedKeys = append(flushedKeys, append([]byte(nil), k...))
+				}
+			}
+			return deleteKeys(tx, flushedKeys)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "could not flush %d packet connections from the session aggregate store", n)
+		}
+		b.sendFlushed(flushed)
+		if resumeKey == nil {
+			return nil
+		}
+	}
+}
+
+//flushOldest flushes the sessions which were inserted first until
+//targetCount sessions remain
+func (b *boltMatcher) flushOldest(targetCount uint64) error {
+	for atomic.LoadUint64(&b.count) > targetCount {
+		toFlush := atomic.LoadUint64(&b.count) - targetCount
+		if toFlush > flushBatchSize {
+			toFlush = flushBatchSize
+		}
+		var flushed []*session.Aggregate
+		var flushedKeys [][]byte
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			sessions := tx.Bucket(sessionsBucket)
+			cursor := tx.Bucket(insertOrderBucket).Cursor()
+			for idKey, queryKey := cursor.First(); idKey != nil && uint64(len(flushedKeys)) < toFlush; idKey, queryKey = cursor.Next() {
+				key := sessionKey(queryKey, idKey)
+				sessAgg := new(session.Aggregate)
+				err := decodeSession(key, sessions.Get(key), sessAgg)
+				if err != nil {
+					return err
+				}
+				flushed = append(flushed, sessAgg)
+				flushedKeys = append(flushedKeys, key)
+			}
+			return deleteKeys(tx, flushedKeys)
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not flush old connections from the session aggregate store")
+		}
+		if len(flushed) == 0 {
+			//the count is out of sync with the store
+			return nil
+		}
+		b.sendFlushed(flushed)
+	}
+	return nil
+}
+
+//sendFlushed writes out the sessions removed by a flush. The sessions
+//are only sent once their removal has been committed so a failed flush
+//can't leave sessions on disk which have already been written out.
+func (b *boltMatcher) sendFlushed(flushed []*session.Aggregate) {
+	atomic.AddUint64(&b.count, -uint64(len(flushed)))
+	for _, sessAgg := range flushed {
+		b.sessionsOut <- sessAgg
+	}
+}
+
+//deleteKeys removes the sessions stored under the given keys.
+//Keys are deleted after iterating since deleting under a
+//bolt cursor may skip elements.
+func deleteKeys(tx *bolt.Tx, keys [][]byte) error {
+	sessions := tx.Bucket(sessionsBucket)
+	insertOrder := tx.Bucket(insertOrderBucket)
+	for _, key := range keys {
+		err := sessions.Delete(key)
+		if err != nil {
+			return err
+		}
+		err = insertOrder.Delete(key[len(key)-8:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package boltmatch_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching/boltmatch"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+//newTestSession creates a one sided session aggregate holding
+//the given number of packets
+func newTestSession(t *testing.T, packets int64) (*session.Aggregate, *input.AcknowledgedFlowMock) {
+	flow := input.NewAcknowledgedFlowMock()
+	flow.MockSourceIPAddress = "1.1.1.1"
+	flow.MockSourcePort = 30000
+	flow.MockDestinationIPAddress = "2.2.2.2"
+	flow.MockDestinationPort = 4444
+	flow.MockProtocolIdentifier = protocols.UDP
+	flow.MockExporter = "3.3.3.3"
+	flow.MockPacketTotalCount = packets
+	sessAgg := new(session.Aggregate)
+	require.Nil(t, session.FromFlow(flow, sessAgg))
+	return sessAgg, flow
+}
+
+//findAll returns the session aggregates matching the query
+func findAll(t *testing.T, matcher matching.Matcher, query *session.AggregateQuery) []session.Aggregate {
+	var results []session.Aggregate
+	iter := matcher.Find(query)
+	var sessAgg session.Aggregate
+	for iter.Next(&sessAgg) {
+		results = append(results, sessAgg)
+	}
+	require.Nil(t, iter.Err())
+	return results
+}
+
+func TestBoltMatcherPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltmatch")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sessions.db")
+
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher, err := boltmatch.NewBoltMatcher(path, logging.NewTestLogger(t), sessionsOut, 10, 0.5)
+	require.Nil(t, err)
+
+	sessA, flowA := newTestSession(t, 5)
+	sessB, _ := newTestSession(t, 5)
+	require.Nil(t, matcher.Insert(sessA))
+	require.Nil(t, matcher.Insert(sessB))
+	require.NotEqual(t, sessA.MatcherID, sessB.MatcherID)
+	//flows are acknowledged once they are stored on disk
+	require.Equal(t, 1, flowA.Acks)
+
+	results := findAll(t, matcher, &sessA.AggregateQuery)
+	require.Len(t, results, 2)
+	require.Len(t, findAll(t, matcher, &session.AggregateQuery{IPAddressA: "1.1.1.1"}), 0)
+
+	sessA.PacketTotalCountAB = 7
+	require.Nil(t, matcher.Update(sessA))
+	require.Nil(t, matcher.Remove(sessB))
+	require.NotNil(t, matcher.Remove(sessB))
+
+	//closing the matcher keeps the sessions
+	require.Nil(t, matcher.Close())
+	require.Len(t, sessionsOut, 0)
+
+	matcher, err = boltmatch.NewBoltMatcher(path, logging.NewTestLogger(t), sessionsOut, 10, 0.5)
+	require.Nil(t, err)
+	results = findAll(t, matcher, &sessA.AggregateQuery)
+	require.Len(t, results, 1)
+	require.Equal(t, sessA.MatcherID, results[0].MatcherID)
+	require.Equal(t, int64(7), results[0].PacketTotalCountAB)
+	require.Equal(t, sessA.FlowEndMillisecondsAB, results[0].FlowEndMillisecondsAB)
+
+	//new sessions don't reuse the old MatcherIDs
+	sessC, _ := newTestSession(t, 5)
+	require.Nil(t, matcher.Insert(sessC))
+	require.True(t, sessC.MatcherID.(uint64) > sessA.MatcherID.(uint64))
+	require.Nil(t, matcher.Close())
+}
+
+func TestBoltMatcherFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltmatch")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sessionsOut := make(chan *session.Aggregate, 20)
+	matcher, err := boltmatch.NewBoltMatcher(
+		filepath.Join(dir, "sessions.db"), logging.NewTestLogger(t), sessionsOut, 10, 0.5,
+	)
+	require.Nil(t, err)
+
+	var inserted []*session.Aggregate
+	for i := 0; i < 11; i++ {
+		packets := int64(10)
+		if i == 5 {
+			packets = 1
+		}
+		sessAgg, _ := newTestSession(t, packets)
+		require.Nil(t, matcher.Insert(sessAgg))
+		inserted = append(inserted, sessAgg)
+	}
+
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Flush())
+
+	//the single packet session goes first, followed by the oldest
+	require.Len(t, sessionsOut, 6)
+	require.Equal(t, inserted[5].MatcherID, (<-sessionsOut).MatcherID)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, inserted[i].MatcherID, (<-sessionsOut).MatcherID)
+	}
+
+	shouldFlush, err = matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.False(t, shouldFlush)
+	require.Len(t, findAll(t, matcher, &inserted[0].AggregateQuery), 5)
+	require.Nil(t, matcher.Close())
+}
+
+func TestBoltMatcherFailedFlushSendsNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltmatch")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sessions.db")
+
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher, err := boltmatch.NewBoltMatcher(path, logging.NewTestLogger(t), sessionsOut, 2, 0.5)
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		sessAgg, _ := newTestSession(t, 1)
+		require.Nil(t, matcher.Insert(sessAgg))
+	}
+	require.Nil(t, matcher.Close())
+
+	//corrupt the session which is read last
+	db, err := bolt.Open(path, 0600, nil)
+	require.Nil(t, err)
+	require.Nil(t, db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket([]byte("sessions"))
+		key, _ := sessions.Cursor().Last()
+		return sessions.Put(append([]byte(nil), key...), []byte("corrupt"))
+	}))
+	require.Nil(t, db.Close())
+
+	matcher, err = boltmatch.NewBoltMatcher(path, logging.NewTestLogger(t), sessionsOut, 2, 0.5)
+	require.Nil(t, err)
+	require.NotNil(t, matcher.Flush())
+
+	//the sessions read before the error stay in the store
+	//and aren't written out
+	require.Len(t, sessionsOut, 0)
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Close())
+}
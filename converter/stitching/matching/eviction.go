@@ -0,0 +1,186 @@
+package matching
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/pkg/errors"
+)
+
+//EvictionCandidate describes an Aggregate which a Matcher may evict
+type EvictionCandidate struct {
+	Aggregate *session.Aggregate
+	//InsertOrder increases with each Aggregate inserted into the Matcher
+	InsertOrder uint64
+	//LastUpdate increases each time an Aggregate is inserted or updated
+	LastUpdate uint64
+}
+
+//EvictionPolicy decides which Aggregates a Matcher evicts first
+//when it holds too many
+type EvictionPolicy interface {
+	//Order sorts the candidates such that the Aggregates
+	//which should be evicted first come first
+	Order(candidates []EvictionCandidate)
+}
+
+//NewEvictionPolicy returns the EvictionPolicy with the given name.
+//The policies are:
+//  Default: one and two packet sessions, then the oldest sessions
+//  Oldest: the oldest sessions
+//  LRU: the sessions which were updated least recently
+//  Smallest: the sessions with the fewest packets and bytes
+//  Scans: the sessions most likely to be part of a scan, then the oldest
+func NewEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "default":
+		return smallThenOldest{}, nil
+	case "oldest":
+		return oldestFirst{}, nil
+	case "lru":
+		return leastRecentlyUpdatedFirst{}, nil
+	case "smallest":
+		return smallestFirst{}, nil
+	case "scans":
+		return likelyScansFirst{}, nil
+	}
+	return nil, errors.Errorf("unknown eviction policy: %s", name)
+}
+
+//smallThenOldest evicts one sided sessions holding one packet, then
+//those holding two packets, then the oldest sessions. Such small
+//sessions are unlikely to be stitched.
+type smallThenOldest struct{}
+
+func (smallThenOldest) Order(candidates []EvictionCandidate) {
+	rank := func(sessAgg *session.Aggregate) int {
+		for n := int64(1); n <= 2; n++ {
+			if sessAgg.PacketTotalCountAB == n && sessAgg.PacketTotalCountBA == 0 ||
+				sessAgg.PacketTotalCountBA == n && sessAgg.PacketTotalCountAB == 0 {
+				return int(n)
+			}
+		}
+		return 3
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		rankI, rankJ := rank(candidates[i].Aggregate), rank(candidates[j].Aggregate)
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		return candidates[i].InsertOrder < candidates[j].InsertOrder
+	})
+}
+
+//oldestFirst evicts the sessions which were inserted first
+type oldestFirst struct{}
+
+func (oldestFirst) Order(candidates []EvictionCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].InsertOrder < candidates[j].InsertOrder
+	})
+}
+
+//leastRecentlyUpdatedFirst evicts the sessions which haven't
+//had a flow merged into them for the longest
+type leastRecentlyUpdatedFirst struct{}
+
+func (leastRecentlyUpdatedFirst) Order(candidates []EvictionCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].LastUpdate != candidates[j].LastUpdate {
+			return candidates[i].LastUpdate < candidates[j].LastUpdate
+		}
+		return candidates[i].InsertOrder < candidates[j].InsertOrder
+	})
+}
+
+//smallestFirst evicts the sessions holding the fewest packets.
+//Ties are broken by the number of bytes, then by age.
+type smallestFirst struct{}
+
+func (smallestFirst) Order(candidates []EvictionCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		aggI, aggJ := candidates[i].Aggregate, candidates[j].Aggregate
+		packetsI := aggI.PacketTotalCountAB + aggI.PacketTotalCountBA
+		packetsJ := aggJ.PacketTotalCountAB + aggJ.PacketTotalCountBA
+		if packetsI != packetsJ {
+			return packetsI < packetsJ
+		}
+		octetsI := aggI.OctetTotalCountAB + aggI.OctetTotalCountBA
+		octetsJ := aggJ.OctetTotalCountAB + aggJ.OctetTotalCountBA
+		if octetsI != octetsJ {
+			return octetsI < octetsJ
+		}
+		return candidates[i].InsertOrder < candidates[j].InsertOrder
+	})
+}
+
+//likelyScansFirst evicts the sessions which look like probes: TCP
+//sessions which sent a SYN but never an ACK, and sessions holding no
+//more than two packets. Probes from the hosts which sent the most
+//probes go first since those hosts are most likely scanning. The
+//remaining sessions are evicted oldest first.
+type likelyScansFirst struct{}
+
+//scanMaxPackets is the most packets a session may hold
+//and still be considered a probe
+const scanMaxPackets = 2
+
+func (likelyScansFirst) Order(candidates []EvictionCandidate) {
+	isProbe := make([]bool, len(candidates))
+	probesBySender := make(map[string]int)
+	for i := range candidates {
+		sessAgg := candidates[i].Aggregate
+		isProbe[i] = looksLikeProbe(sessAgg)
+		if isProbe[i] {
+			probesBySender[sender(sessAgg)]++
+		}
+	}
+
+	//sort the indices so isProbe stays lined up with the candidates
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		candI, candJ := order[i], order[j]
+		if isProbe[candI] != isProbe[candJ] {
+			return isProbe[candI]
+		}
+		if isProbe[candI] {
+			probesI := probesBySender[sender(candidates[candI].Aggregate)]
+			probesJ := probesBySender[sender(candidates[candJ].Aggregate)]
+			if probesI != probesJ {
+				return probesI > probesJ
+			}
+		}
+		return candidates[candI].InsertOrder < candidates[candJ].InsertOrder
+	})
+
+	sorted := make([]EvictionCandidate, len(candidates))
+	for i := range order {
+		sorted[i] = candidates[order[i]]
+	}
+	copy(candidates, sorted)
+}
+
+//looksLikeProbe returns true if a session is the sort left behind by a
+//scan. The TCP flags are used if the exporter reported them.
+func looksLikeProbe(sessAgg *session.Aggregate) bool {
+	if sessAgg.ProtocolIdentifier == protocols.TCP {
+		flags := sessAgg.TCPControlBitsAB | sessAgg.TCPControlBitsBA
+		if flags != 0 {
+			return flags&protocols.TCPSYN != 0 && flags&protocols.TCPACK == 0
+		}
+	}
+	return sessAgg.PacketTotalCountAB+sessAgg.PacketTotalCountBA <= scanMaxPackets
+}
+
+//sender returns the host which sent the traffic in a one sided session
+func sender(sessAgg *session.Aggregate) string {
+	if sessAgg.FilledFromSourceA {
+		return sessAgg.IPAddressA
+	}
+	return sessAgg.IPAddressB
+}
@@ -0,0 +1,69 @@
+package matching_test
+
+import (
+	"testing"
+
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/stretchr/testify/require"
+)
+
+//newCandidate creates an eviction candidate for a one sided session
+//sent by the given host
+func newCandidate(sender string, protocol protocols.Identifier, packets, octets int64,
+	flags uint16, insertOrder, lastUpdate uint64) matching.EvictionCandidate {
+	sessAgg := &session.Aggregate{
+		MatcherID:          insertOrder,
+		FilledFromSourceA:  true,
+		PacketTotalCountAB: packets,
+		OctetTotalCountAB:  octets,
+		TCPControlBitsAB:   flags,
+	}
+	sessAgg.IPAddressA = sender
+	sessAgg.IPAddressB = "9.9.9.9"
+	sessAgg.ProtocolIdentifier = protocol
+	return matching.EvictionCandidate{
+		Aggregate:   sessAgg,
+		InsertOrder: insertOrder,
+		LastUpdate:  lastUpdate,
+	}
+}
+
+//evictionOrder orders the candidates with the named
+//policy and returns their insertion order
+func evictionOrder(t *testing.T, name string, candidates []matching.EvictionCandidate) []uint64 {
+	policy, err := matching.NewEvictionPolicy(name)
+	require.Nil(t, err)
+	ordered := append([]matching.EvictionCandidate(nil), candidates...)
+	policy.Order(ordered)
+	var order []uint64
+	for i := range ordered {
+		order = append(order, ordered[i].InsertOrder)
+	}
+	return order
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	synAck := protocols.TCPSYN | protocols.TCPACK
+	candidates := []matching.EvictionCandidate{
+		newCandidate("1.1.1.1", protocols.UDP, 10, 1000, 0, 1, 6),
+		newCandidate("1.1.1.1", protocols.UDP, 2, 100, 0, 2, 2),
+		newCandidate("2.2.2.2", protocols.TCP, 3, 180, protocols.TCPSYN, 3, 3),
+		newCandidate("2.2.2.2", protocols.TCP, 3, 120, protocols.TCPSYN, 4, 4),
+		newCandidate("3.3.3.3", protocols.TCP, 1, 60, synAck, 5, 5),
+		newCandidate("2.2.2.2", protocols.TCP, 20, 9000, synAck, 6, 1),
+	}
+
+	require.Equal(t, []uint64{5, 2, 1, 3, 4, 6}, evictionOrder(t, "Default", candidates))
+	require.Equal(t, []uint64{5, 2, 1, 3, 4, 6}, evictionOrder(t, "", candidates))
+	require.Equal(t, []uint64{1, 2, 3, 4, 5, 6}, evictionOrder(t, "Oldest", candidates))
+	require.Equal(t, []uint64{6, 2, 3, 4, 5, 1}, evictionOrder(t, "LRU", candidates))
+	require.Equal(t, []uint64{5, 2, 4, 3, 1, 6}, evictionOrder(t, "Smallest", candidates))
+	//2.2.2.2 sent two SYN probes, 1.1.1.1 sent one small UDP flow.
+	//The SYN-ACK flags mean 3.3.3.3's single packet isn't a probe.
+	require.Equal(t, []uint64{3, 4, 2, 1, 5, 6}, evictionOrder(t, "scans", candidates))
+
+	_, err := matching.NewEvictionPolicy("Random")
+	require.NotNil(t, err)
+}
@@ -1,6 +1,17 @@
 package matching
 
-import "github.com/activecm/ipfix-rita/converter/stitching/session"
+import (
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/pkg/errors"
+)
+
+//ErrConflict is returned by Matchers shared between processes when
+//an Aggregate was updated or removed by another process after it was found.
+//The caller should search the Matcher again and retry.
+var ErrConflict = errors.New("session aggregate changed since it was found")
 
 //Matcher provides an interface for finding similar
 //session.Aggregates based on the given session's
@@ -8,6 +19,8 @@ import "github.com/activecm/ipfix-rita/converter/stitching/session"
 type Matcher interface {
 	//Close tears down any resources consumed by the Matcher
 	//and flushes any remaining Aggregates from the matcher.
+	//Matchers which persist their Aggregates across restarts
+	//keep them instead.
 	Close() error
 	//Find searches the Matcher for Aggregates which
 	//match the given AggregateQuery. No other methods may be called
@@ -21,11 +34,13 @@ type Matcher interface {
 	Insert(*session.Aggregate) error
 	//Update finds an Aggregate in the Matcher using the given
 	//Aggregate's AggregateQuery and MatcherID and updates
-	//the matching Aggregate's data.
+	//the matching Aggregate's data. Matchers shared between processes
+	//return ErrConflict if the Aggregate's MatcherVersion is out of date.
 	Update(*session.Aggregate) error
 	//Remove finds an Aggregate in the Matcher using the given
 	//Aggregate's AggregateQuery and MatcherID and removes it
-	//from the system.
+	//from the system. Matchers shared between processes
+	//return ErrConflict if the Aggregate's MatcherVersion is out of date.
 	Remove(*session.Aggregate) error
 	//ShouldFlush returns true if Flush should be called in order
 	//to maintain performance and ensure unmatched records are
@@ -36,3 +51,55 @@ type Matcher interface {
 	//timely manner. No other methods may be called while Flush() is in progress.
 	Flush() error
 }
+
+//Snapshotter is implemented by Matchers which lose their Aggregates
+//when closed. Snapshots allow these Matchers to carry their
+//Aggregates across a graceful restart.
+type Snapshotter interface {
+	//SaveSnapshot writes the Aggregates held by the Matcher and the
+	//state needed to keep assigning unique MatcherIDs to the file at
+	//path. Once the snapshot is on disk, the Aggregates are acknowledged
+	//and removed from the Matcher so Close doesn't flush them out.
+	SaveSnapshot(path string) error
+	//LoadSnapshot adds the Aggregates saved in the file at path to the
+	//Matcher. If the snapshot is older than maxAge, the Aggregates are
+	//flushed out instead. If the snapshot can't be read in full,
+	//the Matcher is left untouched. LoadSnapshot must be called
+	//before any other methods.
+	LoadSnapshot(path string, maxAge time.Duration) error
+}
+
+//IdleEvicter is implemented by Matchers which evict Aggregates that
+//have gone idle. Idleness is measured in event time rather than wall
+//clock time, so quiet periods and backlogs don't skew the results.
+//Each exporter keeps its own event time since exporters' clocks may
+//disagree.
+type IdleEvicter interface {
+	//AdvanceEventTime tells the Matcher a flow ending at flowEndMillis
+	//has been seen from the given exporter. Once the newest flow seen
+	//from an exporter ends more than an idle timeout after an Aggregate's
+	//last flow from the same exporter, ShouldFlush returns true and
+	//Flush evicts the Aggregate.
+	AdvanceEventTime(exporter string, flowEndMillis int64)
+}
+
+//IdleTimeouts determines how long an Aggregate may sit in a Matcher
+//without being matched before it is evicted. Other applies to every
+//protocol besides TCP and UDP, such as ICMP. A zero timeout disables
+//idle eviction for the protocol.
+type IdleTimeouts struct {
+	TCP   time.Duration
+	UDP   time.Duration
+	Other time.Duration
+}
+
+//ForProtocol returns the idle timeout for the given protocol
+func (i IdleTimeouts) ForProtocol(protocol protocols.Identifier) time.Duration {
+	switch protocol {
+	case protocols.TCP:
+		return i.TCP
+	case protocols.UDP:
+		return i.UDP
+	}
+	return i.Other
+}
@@ -0,0 +1,49 @@
+package mongomatch_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/activecm/dbtest"
+	"github.com/activecm/ipfix-rita/converter/integrationtest"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching/mongomatch"
+)
+
+const testDBName = "test"
+
+const mongoContainerFixtureKey = "mongomatch-test-db-container"
+
+var fixtureManager *integrationtest.FixtureManager
+
+var sessionsCleanupFixture = integrationtest.TestFixture{
+	Key:         mongomatch.SessionsCollName + "-cleanup",
+	Requires:    []string{mongoContainerFixtureKey},
+	LongRunning: true,
+	After: func(t *testing.T, fixtures integrationtest.FixtureData) (interface{}, bool) {
+		mongoContainer := fixtures.Get(mongoContainerFixtureKey).(dbtest.MongoDBContainer)
+		ssn, err := mongoContainer.NewSession()
+		if err != nil {
+			t.Error(err)
+			return nil, false
+		}
+		ssn.DB(testDBName).C(mongomatch.SessionsCollName).DropCollection()
+		ssn.Close()
+		return nil, false
+	},
+}
+
+//TestMain is responsible for setting up and tearing down any
+//resources needed by all tests
+func TestMain(m *testing.M) {
+	fixtureManager = integrationtest.NewFixtureManager()
+	fixtureManager.RegisterFixture(integrationtest.EnvironmentFixture)
+	fixtureManager.RegisterFixture(integrationtest.DockerLoaderFixture)
+	fixtureManager.RegisterFixture(
+		integrationtest.NewMongoDBContainerFixture(mongoContainerFixtureKey),
+	)
+	fixtureManager.RegisterFixture(sessionsCleanupFixture)
+	fixtureManager.BeginTestPackage()
+	returnCode := m.Run()
+	fixtureManager.EndTestPackage()
+	os.Exit(returnCode)
+}
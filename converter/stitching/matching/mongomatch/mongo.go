@@ -0,0 +1,283 @@
+package mongomatch
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	mgo "github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//SessionsCollName is the name of the collection holding
+//the session aggregates waiting to be stitched
+const SessionsCollName = "sessions"
+
+//countRefreshInterval determines how often the number of session
+//aggregates is read from MongoDB. Other converters may share the
+//sessions collection, so the count kept by each matcher drifts.
+const countRefreshInterval = 5 * time.Second
+
+//queryIndex covers the session.AggregateQuery fields
+var queryIndex = mgo.Index{
+	Key: []string{
+		"IPAddressA", "transportPortA",
+		"IPAddressB", "transportPortB",
+		"protocolIdentifier", "exporter",
+	},
+}
+
+//mongoMatcher provides an implementation of Matcher backed by a
+//MongoDB collection. The session aggregates held by the matcher
+//survive restarts and may be shared by several converters.
+//Updates and removals only apply if the aggregate's MatcherVersion
+//is current, so converters sharing the collection can't overwrite
+//or remove aggregates changed by one another.
+type mongoMatcher struct {
+	sessions *mgo.Collection
+
+	//count estimates the number of session aggregates in the
+	//collection. It is adjusted as this matcher inserts and removes
+	//aggregates and is refreshed from MongoDB periodically.
+	count            int64
+	countRefreshed   time.Time
+	countRefreshLock *sync.Mutex
+
+	sessionsOut      chan<- *session.Aggregate
+	preFlushMaxSize  int64
+	postFlushMaxSize int64
+
+	log logging.Logger
+}
+
+//NewMongoMatcher returns a new matcher which holds the session
+//aggregates in the given collection. The indexes used for matching
+//are created if needed. The matcher closes the collection's
+//session when it is closed.
+func NewMongoMatcher(sessions *mgo.Collection, log logging.Logger,
+	sessionsOut chan<- *session.Aggregate,
+	maxSize uint64, flushToPercent float64) (matching.Matcher, error) {
+
+	err := sessions.EnsureIndex(queryIndex)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create index on %s", sessions.FullName)
+	}
+
+	count, err := sessions.Count()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not count the session aggregates in %s", sessions.FullName)
+	}
+
+	log.Info("opened session aggregate collection", logging.Fields{
+		"collection": sessions.FullName,
+		"sessions":   count,
+	})
+
+	return &mongoMatcher{
+		sessions:         sessions,
+		count:            int64(count),
+		countRefreshed:   time.Now(),
+		countRefreshLock: new(sync.Mutex),
+		sessionsOut:      sessionsOut,
+		preFlushMaxSize:  int64(maxSize),
+		postFlushMaxSize: int64(float64(maxSize)*flushToPercent + 0.5),
+		log:              log,
+	}, nil
+}
+
+//collection returns a new socket connected to the sessions collection.
+//The caller must close the returned collection's session.
+func (m *mongoMatcher) collection() *mgo.Collection {
+	return m.sessions.With(m.sessions.Database.Session.Copy())
+}
+
+//versionSelector selects the stored copy of the session aggregate
+//if it hasn't changed since the aggregate was read
+func versionSelector(sessAgg *session.Aggregate) bson.M {
+	return bson.M{"_id": sessAgg.MatcherID, "matcherVersion": sessAgg.MatcherVersion}
+}
+
+//refreshCount reads the number of session aggregates from MongoDB
+func (m *mongoMatcher) refreshCount() error {
+	coll := m.collection()
+	defer coll.Database.Session.Close()
+	count, err := coll.Count()
+	if err != nil {
+		return errors.Wrapf(err, "could not count the session aggregates in %s", m.sessions.FullName)
+	}
+	atomic.StoreInt64(&m.count, int64(count))
+	return nil
+}
+
+//Close tears down any resources consumed by the Matcher. The session
+//aggregates are left in MongoDB so they may be stitched after a
+//restart or by other converters.
+func (m *mongoMatcher) Close() error {
+	m.log.Info("closing session aggregate collection", logging.Fields{
+		"collection": m.sessions.FullName,
+		"sessions":   atomic.LoadInt64(&m.count),
+	})
+	m.sessions.Database.Session.Close()
+	return nil
+}
+
+//Find searches the Matcher for Aggregates which
+//match the given AggregateQuery
+func (m *mongoMatcher) Find(sessAggQuery *session.AggregateQuery) session.Iterator {
+	coll := m.collection()
+	defer coll.Database.Session.Close()
+
+	var results []session.Aggregate
+	err := coll.Find(sessAggQuery).All(&results)
+	return session.NewSliceIterator(
+		results, errors.Wrapf(err, "could not find session aggregates for:\n%+v", *sessAggQuery),
+	)
+}
+
+//Insert adds a session aggregate to the Matcher and sets its MatcherID.
+//The flows in the aggregate are acknowledged once it is stored in MongoDB.
+func (m *mongoMatcher) Insert(sessAgg *session.Aggregate) error {
+	coll := m.collection()
+	defer coll.Database.Session.Close()
+
+	//ObjectIds sort by creation time
+	sessAgg.MatcherID = bson.NewObjectId()
+	sessAgg.MatcherVersion = 0
+	err := coll.Insert(sessAgg)
+	if err != nil {
+		return errors.Wrapf(err, "could not insert session aggregate:\n%+v", sessAgg.AggregateQuery)
+	}
+	atomic.AddInt64(&m.count, 1)
+	sessAgg.Acknowledge()
+	return nil
+}
+
+//Update finds an Aggregate in the Matcher using the given
+//Aggregate's AggregateQuery and MatcherID and updates
+//the matching Aggregate's data. The flows in the aggregate
+//are acknowledged once it is stored in MongoDB. ErrConflict is
+//returned if another converter changed or removed the Aggregate
+//after it was found.
+func (m *mongoMatcher) Update(sessAgg *session.Aggregate) error {
+	coll := m.collection()
+	defer coll.Database.Session.Close()
+
+	updated := *sessAgg
+	updated.MatcherVersion++
+	err := coll.Update(versionSelector(sessAgg), &updated)
+	if err == mgo.ErrNotFound {
+		return errors.Wrapf(matching.ErrConflict, "could not update MatcherID: %v", sessAgg.MatcherID)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not update session aggregate:\n%+v", sessAgg.AggregateQuery)
+	}
+	sessAgg.MatcherVersion = updated.MatcherVersion
+	sessAgg.Acknowledge()
+	return nil
+}
+
+//Remove finds an Aggregate in the Matcher using the given
+//Aggregate's AggregateQuery and MatcherID and removes it
+//from the system. ErrConflict is returned if another converter
+//changed or removed the Aggregate after it was found.
+func (m *mongoMatcher) Remove(sessAgg *session.Aggregate) error {
+	coll := m.collection()
+	defer coll.Database.Session.Close()
+
+	err := coll.Remove(versionSelector(sessAgg))
+	if err == mgo.ErrNotFound {
+		return errors.Wrapf(matching.ErrConflict, "could not remove MatcherID: %v", sessAgg.MatcherID)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not remove session aggregate:\n%+v", sessAgg.AggregateQuery)
+	}
+	atomic.AddInt64(&m.count, -1)
+	return nil
+}
+
+//ShouldFlush returns true if Flush should be called in order
+//to maintain performance and ensure unmatched records are
+//written out in a timely manner.
+func (m *mongoMatcher) ShouldFlush() (bool, error) {
+	m.countRefreshLock.Lock()
+	defer m.countRefreshLock.Unlock()
+	if time.Since(m.countRefreshed) > countRefreshInterval {
+		m.countRefreshed = time.Now()
+		err := m.refreshCount()
+		if err != nil {
+			return false, err
+		}
+	}
+	return atomic.LoadInt64(&m.count) > m.preFlushMaxSize, nil
+}
+
+//Flush evicts Aggregates from the Matcher in order to maintain
+//performance and ensure unmatched records are written out in a
+//timely manner.
+func (m *mongoMatcher) Flush() error {
+	err := m.refreshCount()
+	if err != nil {
+		return err
+	}
+
+	startCount := atomic.LoadInt64(&m.count)
+	targetCount := m.postFlushMaxSize
+	if startCount <= targetCount {
+		return nil
+	}
+	defer func() {
+		m.log.Info("finished session aggregate flush", logging.Fields{
+			"start count":   startCount,
+			"current count": atomic.LoadInt64(&m.count),
+			"target count":  targetCount,
+		})
+	}()
+
+	//flush out the garbage first
+	for i := int64(1); i <= 2; i++ {
+		err = m.flushMatching(bson.M{"$or": []bson.M{
+			{"packetTotalCountAB": i, "packetTotalCountBA": 0},
+			{"packetTotalCountBA": i, "packetTotalCountAB": 0},
+		}}, atomic.LoadInt64(&m.count)-targetCount)
+		if err != nil {
+			return errors.Wrapf(err, "could not flush %d packet connections", i)
+		}
+		if atomic.LoadInt64(&m.count) <= targetCount {
+			return nil
+		}
+	}
+
+	err = m.flushMatching(nil, atomic.LoadInt64(&m.count)-targetCount)
+	return errors.Wrap(err, "could not flush old connections")
+}
+
+//flushMatching writes out up to limit of the oldest session
+//aggregates which match the query
+func (m *mongoMatcher) flushMatching(query interface{}, limit int64) error {
+	coll := m.collection()
+	defer coll.Database.Session.Close()
+
+	var sessAgg session.Aggregate
+	iter := coll.Find(query).Sort("_id").Limit(int(limit)).Iter()
+	for iter.Next(&sessAgg) {
+		//another converter sharing the collection may be
+		//flushing or updating the same aggregate
+		err := coll.Remove(versionSelector(&sessAgg))
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		atomic.AddInt64(&m.count, -1)
+		flushed := sessAgg
+		m.sessionsOut <- &flushed
+		sessAgg.Clear()
+	}
+	return iter.Close()
+}
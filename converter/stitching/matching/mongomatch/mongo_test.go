@@ -0,0 +1,155 @@
+package mongomatch_test
+
+import (
+	"testing"
+
+	"github.com/activecm/dbtest"
+	"github.com/activecm/ipfix-rita/converter/environment"
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/integrationtest"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching/mongomatch"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+//newTestSession creates a one sided session aggregate holding
+//the given number of packets
+func newTestSession(t *testing.T, packets int64) *session.Aggregate {
+	flow := input.NewFlowMock()
+	flow.MockSourceIPAddress = "1.1.1.1"
+	flow.MockSourcePort = 30000
+	flow.MockDestinationIPAddress = "2.2.2.2"
+	flow.MockDestinationPort = 4444
+	flow.MockProtocolIdentifier = protocols.UDP
+	flow.MockExporter = "3.3.3.3"
+	flow.MockPacketTotalCount = packets
+	sessAgg := new(session.Aggregate)
+	require.Nil(t, session.FromFlow(flow, sessAgg))
+	return sessAgg
+}
+
+//newTestMatcher connects a new mongoMatcher to the test database
+func newTestMatcher(t *testing.T, fixtures integrationtest.FixtureData,
+	sessionsOut chan<- *session.Aggregate) matching.Matcher {
+	env := fixtures.GetWithSkip(t, integrationtest.EnvironmentFixture.Key).(environment.Environment)
+	mongoDBContainer := fixtures.GetWithSkip(t, mongoContainerFixtureKey).(dbtest.MongoDBContainer)
+	ssn, err := mongoDBContainer.NewSession()
+	if err != nil {
+		env.Error(err, nil)
+		t.FailNow()
+	}
+	matcher, err := mongomatch.NewMongoMatcher(
+		ssn.DB(testDBName).C(mongomatch.SessionsCollName), env.Logger, sessionsOut, 10, 0.5,
+	)
+	require.Nil(t, err)
+	return matcher
+}
+
+//findAll returns the session aggregates matching the query
+func findAll(t *testing.T, matcher matching.Matcher, query *session.AggregateQuery) []session.Aggregate {
+	var results []session.Aggregate
+	iter := matcher.Find(query)
+	var sessAgg session.Aggregate
+	for iter.Next(&sessAgg) {
+		results = append(results, sessAgg)
+	}
+	require.Nil(t, iter.Err())
+	return results
+}
+
+func TestMongoMatcherPersists(t *testing.T) {
+	fixtures := fixtureManager.BeginTest(t)
+	defer fixtureManager.EndTest(t)
+
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher := newTestMatcher(t, fixtures, sessionsOut)
+
+	sessA := newTestSession(t, 5)
+	sessB := newTestSession(t, 5)
+	require.Nil(t, matcher.Insert(sessA))
+	require.Nil(t, matcher.Insert(sessB))
+	require.NotEqual(t, sessA.MatcherID, sessB.MatcherID)
+	require.Len(t, findAll(t, matcher, &sessA.AggregateQuery), 2)
+
+	sessA.PacketTotalCountAB = 7
+	require.Nil(t, matcher.Update(sessA))
+	require.Nil(t, matcher.Remove(sessB))
+	require.NotNil(t, matcher.Remove(sessB))
+
+	//closing the matcher keeps the sessions
+	require.Nil(t, matcher.Close())
+	require.Len(t, sessionsOut, 0)
+
+	matcher = newTestMatcher(t, fixtures, sessionsOut)
+	results := findAll(t, matcher, &sessA.AggregateQuery)
+	require.Len(t, results, 1)
+	require.Equal(t, sessA.MatcherID, results[0].MatcherID)
+	require.Equal(t, int64(7), results[0].PacketTotalCountAB)
+	require.Nil(t, matcher.Close())
+}
+
+func TestMongoMatcherFlush(t *testing.T) {
+	fixtures := fixtureManager.BeginTest(t)
+	defer fixtureManager.EndTest(t)
+
+	sessionsOut := make(chan *session.Aggregate, 20)
+	matcher := newTestMatcher(t, fixtures, sessionsOut)
+
+	var inserted []*session.Aggregate
+	for i := 0; i < 11; i++ {
+		packets := int64(10)
+		if i == 5 {
+			packets = 1
+		}
+		sessAgg := newTestSession(t, packets)
+		require.Nil(t, matcher.Insert(sessAgg))
+		inserted = append(inserted, sessAgg)
+	}
+
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Flush())
+
+	//the single packet session goes first, followed by the oldest
+	require.Len(t, sessionsOut, 6)
+	require.Equal(t, inserted[5].MatcherID, (<-sessionsOut).MatcherID)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, inserted[i].MatcherID, (<-sessionsOut).MatcherID)
+	}
+	require.Len(t, findAll(t, matcher, &inserted[0].AggregateQuery), 5)
+	require.Nil(t, matcher.Close())
+}
+
+func TestMongoMatcherStaleAggregateConflicts(t *testing.T) {
+	fixtures := fixtureManager.BeginTest(t)
+	defer fixtureManager.EndTest(t)
+
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher := newTestMatcher(t, fixtures, sessionsOut)
+
+	sessAgg := newTestSession(t, 5)
+	require.Nil(t, matcher.Insert(sessAgg))
+
+	//two converters find the same aggregate
+	results := findAll(t, matcher, &sessAgg.AggregateQuery)
+	require.Len(t, results, 1)
+	first, second := results[0], results[0]
+
+	first.PacketTotalCountAB = 7
+	require.Nil(t, matcher.Update(&first))
+
+	//the second converter's copy is out of date
+	second.PacketTotalCountAB = 9
+	require.Equal(t, matching.ErrConflict, errors.Cause(matcher.Update(&second)))
+	require.Equal(t, matching.ErrConflict, errors.Cause(matcher.Remove(&second)))
+
+	results = findAll(t, matcher, &sessAgg.AggregateQuery)
+	require.Len(t, results, 1)
+	require.Equal(t, int64(7), results[0].PacketTotalCountAB)
+	require.Nil(t, matcher.Remove(&results[0]))
+	require.Nil(t, matcher.Close())
+}
@@ -1,10 +1,10 @@
 package rammatch
 
 import (
-	"container/heap"
 	"container/list"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/activecm/ipfix-rita/converter/logging"
 	"github.com/activecm/ipfix-rita/converter/stitching/matching"
@@ -34,7 +34,7 @@ func (l *listSessionIterator) Next(sessAgg *session.Aggregate) bool {
 		return false
 	}
 
-	*sessAgg = *(l.iterNode.Value.(*session.Aggregate))
+	*sessAgg = *(l.iterNode.Value.(*ramEntry).sessAgg)
 	l.iterNode = l.iterNode.Next()
 	return true
 }
@@ -43,27 +43,86 @@ func (l *listSessionIterator) Err() error {
 	return nil
 }
 
+//ramEntry holds a session aggregate in the ramMatcher
+type ramEntry struct {
+	sessAgg *session.Aggregate
+	//lastUpdate is taken from updateTracker when
+	//the aggregate is inserted or updated
+	lastUpdate uint64
+}
+
 //ramMatcher provides an implementation of Matcher entirely in RAM
 type ramMatcher struct {
 	matchMap      sync.Map
 	insertTracker uint64
+	updateTracker uint64
 	count         uint64
 
+	evictionPolicy matching.EvictionPolicy
+
 	sessionsOut      chan<- *session.Aggregate
 	preFlushMaxSize  uint64
 	postFlushMaxSize uint64
 
+	//eventTimes holds the event time of each exporter. Each exporter's
+	//sessions are aged against its own event time so an exporter with
+	//a skewed clock can't make every other exporter's sessions look idle.
+	eventTimes      map[string]*exporterEventTime
+	eventTimesMutex *sync.Mutex
+	//idleSweepPending is set to 1 once an exporter's event time has
+	//advanced far enough to search for idle sessions again
+	idleSweepPending int32
+	idleTimeouts     matching.IdleTimeouts
+	//idleSweepInterval determines how far event time must advance
+	//between searches for idle sessions. Idle eviction is disabled
+	//if idleSweepInterval is 0.
+	idleSweepInterval int64
+
 	log logging.Logger
 }
 
-//NewRAMMatcher returns a new matcher which operates entirely in RAM
+//exporterEventTime tracks the event time of a single exporter
+type exporterEventTime struct {
+	//eventTime holds the end time of the newest flow seen in milliseconds
+	eventTime int64
+	//lastIdleSweep holds the eventTime of the last search for idle sessions
+	lastIdleSweep int64
+}
+
+//idleSweepsPerTimeout determines how often the matcher searches for
+//idle sessions. Sessions are evicted at most 1/idleSweepsPerTimeout
+//of the shortest idle timeout after they go idle.
+const idleSweepsPerTimeout = 4
+
+//NewRAMMatcher returns a new matcher which operates entirely in RAM.
+//Sessions are evicted once the matcher holds more than maxSize
+//sessions or once they have been idle longer than their idleTimeouts.
+//The evictionPolicy chooses which sessions go when the matcher is full.
 func NewRAMMatcher(log logging.Logger, sessionsOut chan<- *session.Aggregate,
-	maxSize uint64, flushToPercent float64) matching.Matcher {
+	maxSize uint64, flushToPercent float64, idleTimeouts matching.IdleTimeouts,
+	evictionPolicy matching.EvictionPolicy) matching.Matcher {
+
+	var idleSweepInterval time.Duration
+	for _, timeout := range []time.Duration{idleTimeouts.TCP, idleTimeouts.UDP, idleTimeouts.Other} {
+		if timeout > 0 && (idleSweepInterval == 0 || timeout < idleSweepInterval) {
+			idleSweepInterval = timeout
+		}
+	}
+	idleSweepInterval /= idleSweepsPerTimeout
+	if idleSweepInterval > 0 && idleSweepInterval < time.Second {
+		idleSweepInterval = time.Second
+	}
+
 	return &ramMatcher{
-		sessionsOut:      sessionsOut,
-		preFlushMaxSize:  maxSize,
-		postFlushMaxSize: uint64(float64(maxSize)*flushToPercent + 0.5),
-		log:              log,
+		sessionsOut:       sessionsOut,
+		preFlushMaxSize:   maxSize,
+		postFlushMaxSize:  uint64(float64(maxSize)*flushToPercent + 0.5),
+		eventTimes:        make(map[string]*exporterEventTime),
+		eventTimesMutex:   new(sync.Mutex),
+		idleTimeouts:      idleTimeouts,
+		idleSweepInterval: int64(idleSweepInterval / time.Millisecond),
+		evictionPolicy:    evictionPolicy,
+		log:               log,
 	}
 }
 
@@ -90,11 +149,15 @@ func (r *ramMatcher) Find(sessAggQuery *session.AggregateQuery) session.Iterator
 //is some sort of auto incrementing ID.
 func (r *ramMatcher) Insert(sessAgg *session.Aggregate) error {
 	sessAgg.MatcherID = atomic.AddUint64(&r.insertTracker, 1)
+	entry := &ramEntry{
+		sessAgg:    sessAgg,
+		lastUpdate: atomic.AddUint64(&r.updateTracker, 1),
+	}
 	newList := list.New()
-	newList.PushBack(sessAgg)
+	newList.PushBack(entry)
 	existingList, loaded := r.matchMap.LoadOrStore(sessAgg.AggregateQuery, newList)
 	if loaded {
-		existingList.(*list.List).PushBack(sessAgg)
+		existingList.(*list.List).PushBack(entry)
 	}
 	atomic.AddUint64(&r.count, 1)
 	return nil
@@ -110,10 +173,11 @@ func (r *ramMatcher) Update(sessAgg *session.Aggregate) error {
 	}
 	resultsList := resultsListIface.(*list.List)
 	for iterNode := resultsList.Front(); iterNode != nil; iterNode = iterNode.Next() {
-		otherSessAgg := iterNode.Value.(*session.Aggregate)
-		if otherSessAgg.MatcherID == sessAgg.MatcherID {
+		entry := iterNode.Value.(*ramEntry)
+		if entry.sessAgg.MatcherID == sessAgg.MatcherID {
 			//copy the new data into the pointer
-			*otherSessAgg = *sessAgg
+			*entry.sessAgg = *sessAgg
+			entry.lastUpdate = atomic.AddUint64(&r.updateTracker, 1)
 			return nil
 		}
 	}
@@ -130,7 +194,7 @@ func (r *ramMatcher) Remove(sessAgg *session.Aggregate) error {
 	}
 	resultsList := resultsListIface.(*list.List)
 	for iterNode := resultsList.Front(); iterNode != nil; iterNode = iterNode.Next() {
-		otherSessAgg := iterNode.Value.(*session.Aggregate)
+		otherSessAgg := iterNode.Value.(*ramEntry).sessAgg
 		if otherSessAgg.MatcherID == sessAgg.MatcherID {
 			//we don't have to worry about breaking the iteration with Remove
 			//since we return immediately
@@ -146,116 +210,138 @@ func (r *ramMatcher) Remove(sessAgg *session.Aggregate) error {
 //to maintain performance and ensure unmatched records are
 //written out in a timely manner.
 func (r *ramMatcher) ShouldFlush() (bool, error) {
-	return atomic.LoadUint64(&r.count) > r.preFlushMaxSize, nil
+	return atomic.LoadUint64(&r.count) > r.preFlushMaxSize || r.idleSweepDue(), nil
 }
 
 //Flush evicts Aggregates from the Matcher in order to maintain
 //performance and ensure unmatched records are written out in a
 //timely manner.
 func (r *ramMatcher) Flush() error {
+	if r.idleSweepDue() {
+		r.flushIdle()
+	}
+	if atomic.LoadUint64(&r.count) <= r.preFlushMaxSize {
+		return nil
+	}
 	return r.flushTo(r.postFlushMaxSize)
 }
 
-func (r *ramMatcher) flushTo(targetCount uint64) error {
-	//thought: subtract off the smallest MatcherID from every record
-	//and the insertTracker to prevent overflow of insertTracker
-	//response: It will take over 500 million years to overflow
-	//insertTracker at a rate of 100000 flows processed per second
-	//http://www.wolframalpha.com/input/?i=((2%5E64+-1)%2F+100000)+seconds+to+years
-
-	startCount := atomic.LoadUint64(&r.count)
-	if startCount <= targetCount {
-		return nil
+//AdvanceEventTime tells the matcher a flow ending at
+//flowEndMillis has been seen from the given exporter
+func (r *ramMatcher) AdvanceEventTime(exporter string, flowEndMillis int64) {
+	if r.idleSweepInterval == 0 {
+		return
 	}
-	defer func() {
-		r.log.Info("finished session aggregate flush", logging.Fields{
-			"start count":   startCount,
-			"current count": atomic.LoadUint64(&r.count),
-			"target count":  targetCount,
-		})
-	}()
-	//flush out the garbage first
-	for i := int64(1); i <= 2; i++ {
-		r.flushNPacketConnections(i)
-		if atomic.LoadUint64(&r.count) <= targetCount {
-			return nil
-		}
+	r.eventTimesMutex.Lock()
+	defer r.eventTimesMutex.Unlock()
+	exporterTime, ok := r.eventTimes[exporter]
+	if !ok {
+		exporterTime = &exporterEventTime{}
+		r.eventTimes[exporter] = exporterTime
+	}
+	if flowEndMillis <= exporterTime.eventTime {
+		return
+	}
+	exporterTime.eventTime = flowEndMillis
+	if exporterTime.eventTime-exporterTime.lastIdleSweep >= r.idleSweepInterval {
+		atomic.StoreInt32(&r.idleSweepPending, 1)
 	}
-	r.flushOldest(targetCount)
-	return nil
 }
 
-//flushNPacketConnections flushes sessions which contain
-//exactly n packets in one direction and 0 in the other
-func (r *ramMatcher) flushNPacketConnections(n int64) error {
+//idleSweepDue returns true if an exporter's event time has advanced
+//far enough since the last search for idle sessions to search again
+func (r *ramMatcher) idleSweepDue() bool {
+	return atomic.LoadInt32(&r.idleSweepPending) == 1
+}
+
+//flushIdle flushes the sessions whose last flow ended more than
+//their protocol's idle timeout before the newest flow seen
+//from the same exporter
+func (r *ramMatcher) flushIdle() {
+	r.eventTimesMutex.Lock()
+	eventTimes := make(map[string]int64, len(r.eventTimes))
+	for exporter, exporterTime := range r.eventTimes {
+		exporterTime.lastIdleSweep = exporterTime.eventTime
+		eventTimes[exporter] = exporterTime.eventTime
+	}
+	atomic.StoreInt32(&r.idleSweepPending, 0)
+	r.eventTimesMutex.Unlock()
+
+	var flushed int
 	r.matchMap.Range(func(aggQueryIface interface{}, aggListIface interface{}) bool {
 		aggList := aggListIface.(*list.List)
+		aggQuery := aggQueryIface.(session.AggregateQuery)
+		timeout := int64(r.idleTimeouts.ForProtocol(aggQuery.ProtocolIdentifier) / time.Millisecond)
+		eventTime, ok := eventTimes[aggQuery.Exporter]
+		if timeout <= 0 || !ok {
+			return true
+		}
 
-		//https://stackoverflow.com/questions/27662614/how-to-remove-element-from-list-while-iterating-the-same-list-in-golang
 		var next *list.Element
 		for iterNode := aggList.Front(); iterNode != nil; iterNode = next {
 			next = iterNode.Next()
-			sessAgg := iterNode.Value.(*session.Aggregate)
-
-			if sessAgg.PacketTotalCountAB == n && sessAgg.PacketTotalCountBA == int64(0) ||
-				sessAgg.PacketTotalCountBA == n && sessAgg.PacketTotalCountAB == int64(0) {
+			sessAgg := iterNode.Value.(*ramEntry).sessAgg
 
+			if eventTime-sessAgg.FlowEndMilliseconds() > timeout {
 				//write out the session aggregate
 				r.sessionsOut <- sessAgg
 
 				aggList.Remove(iterNode)
 				atomic.AddUint64(&r.count, ^uint64(0)) //-1 in two's complement >.>
+				flushed++
 			}
 		}
 		if aggList.Len() == 0 {
-			r.matchMap.Delete(aggQueryIface.(session.AggregateQuery))
+			r.matchMap.Delete(aggQuery)
 		}
 		return true
 	})
-	return nil
-}
 
-//sessionAggregateHeap defines a heap used for flushing old records out of the
-//ramMatcher map
-type sessionAggregateHeap []*session.Aggregate
-
-func (h sessionAggregateHeap) Len() int { return len(h) }
-func (h sessionAggregateHeap) Less(i, j int) bool {
-	return h[i].MatcherID.(uint64) < h[j].MatcherID.(uint64)
-}
-func (h sessionAggregateHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
-
-func (h *sessionAggregateHeap) Push(x interface{}) {
-	// Push and Pop use pointer receivers because they modify the slice's length,
-	// not just its contents.
-	*h = append(*h, x.(*session.Aggregate))
+	if flushed > 0 {
+		r.log.Info("flushed idle session aggregates", logging.Fields{
+			"flushed":       flushed,
+			"current count": atomic.LoadUint64(&r.count),
+		})
+	}
 }
 
-func (h *sessionAggregateHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	x := old[n-1]
-	*h = old[0 : n-1]
-	return x
-}
+func (r *ramMatcher) flushTo(targetCount uint64) error {
+	//thought: subtract off the smallest MatcherID from every record
+	//and the insertTracker to prevent overflow of insertTracker
+	//response: It will take over 500 million years to overflow
+	//insertTracker at a rate of 100000 flows processed per second
+	//http://www.wolframalpha.com/input/?i=((2%5E64+-1)%2F+100000)+seconds+to+years
 
-func (r *ramMatcher) flushOldest(targetCount uint64) error {
-	minHeap := new(sessionAggregateHeap)
-	heap.Init(minHeap)
-	r.matchMap.Range(func(aggQueryIface interface{}, aggListIface interface{}) bool {
+	startCount := atomic.LoadUint64(&r.count)
+	if startCount <= targetCount {
+		return nil
+	}
+	defer func() {
+		r.log.Info("finished session aggregate flush", logging.Fields{
+			"start count":   startCount,
+			"current count": atomic.LoadUint64(&r.count),
+			"target count":  targetCount,
+		})
+	}()
+	//ask the eviction policy which sessions should go first
+	candidates := make([]matching.EvictionCandidate, 0, startCount)
+	r.matchMap.Range(func(_ interface{}, aggListIface interface{}) bool {
 		aggList := aggListIface.(*list.List)
-
 		for iterNode := aggList.Front(); iterNode != nil; iterNode = iterNode.Next() {
-			heap.Push(minHeap, iterNode.Value.(*session.Aggregate))
+			entry := iterNode.Value.(*ramEntry)
+			candidates = append(candidates, matching.EvictionCandidate{
+				Aggregate:   entry.sessAgg,
+				InsertOrder: entry.sessAgg.MatcherID.(uint64),
+				LastUpdate:  entry.lastUpdate,
+			})
 		}
 		return true
 	})
+	r.evictionPolicy.Order(candidates)
 
-	for atomic.LoadUint64(&r.count) > targetCount && len(*minHeap) > 0 {
-		aggToRemove := heap.Pop(minHeap).(*session.Aggregate)
-
-		r.sessionsOut <- aggToRemove
-		r.Remove(aggToRemove)
+	for i := 0; atomic.LoadUint64(&r.count) > targetCount && i < len(candidates); i++ {
+		r.sessionsOut <- candidates[i].Aggregate
+		r.Remove(candidates[i].Aggregate)
 	}
 	return nil
 }
@@ -0,0 +1,199 @@
+package rammatch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/input"
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/protocols"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching"
+	"github.com/activecm/ipfix-rita/converter/stitching/matching/rammatch"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/stretchr/testify/require"
+)
+
+//testExporter is the exporter of the sessions created by newTestSession
+const testExporter = "3.3.3.3"
+
+//newTestSession creates a one sided session aggregate for
+//a flow of the given protocol ending at flowEnd
+func newTestSession(t *testing.T, protocol protocols.Identifier, flowEnd int64) *session.Aggregate {
+	flow := input.NewFlowMock()
+	flow.MockSourceIPAddress = "1.1.1.1"
+	flow.MockDestinationIPAddress = "2.2.2.2"
+	flow.MockProtocolIdentifier = protocol
+	flow.MockExporter = testExporter
+	flow.MockFlowStartMilliseconds = flowEnd - 1000
+	flow.MockFlowEndMilliseconds = flowEnd
+	sessAgg := new(session.Aggregate)
+	require.Nil(t, session.FromFlow(flow, sessAgg))
+	return sessAgg
+}
+
+func TestRAMMatcherIdleEviction(t *testing.T) {
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher := rammatch.NewRAMMatcher(
+		logging.NewTestLogger(t), sessionsOut, 10, 0.5,
+		matching.IdleTimeouts{UDP: time.Minute}, newPolicy(t, "Default"),
+	)
+	idleEvicter := matcher.(matching.IdleEvicter)
+
+	start := int64(1000 * 60 * 60 * 24)
+	udpSess := newTestSession(t, protocols.UDP, start)
+	tcpSess := newTestSession(t, protocols.TCP, start)
+	require.Nil(t, matcher.Insert(udpSess))
+	require.Nil(t, matcher.Insert(tcpSess))
+	idleEvicter.AdvanceEventTime(testExporter, start)
+
+	//the sessions haven't been idle long enough
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Flush())
+	require.Len(t, sessionsOut, 0)
+
+	//event time hasn't moved far enough to search again
+	idleEvicter.AdvanceEventTime(testExporter, start+10*1000)
+	shouldFlush, err = matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.False(t, shouldFlush)
+
+	//older flows don't move event time backwards
+	idleEvicter.AdvanceEventTime(testExporter, start+61*1000)
+	idleEvicter.AdvanceEventTime(testExporter, start)
+	shouldFlush, err = matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Flush())
+
+	//only the UDP session has an idle timeout
+	require.Len(t, sessionsOut, 1)
+	require.Equal(t, udpSess.MatcherID, (<-sessionsOut).MatcherID)
+	require.Equal(t, 0, countAll(t, matcher, &udpSess.AggregateQuery))
+	require.Equal(t, 1, countAll(t, matcher, &tcpSess.AggregateQuery))
+
+	require.Nil(t, matcher.Close())
+	require.Len(t, sessionsOut, 1)
+}
+
+func TestRAMMatcherIdleEvictionOtherProtocols(t *testing.T) {
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher := rammatch.NewRAMMatcher(
+		logging.NewTestLogger(t), sessionsOut, 10, 0.5,
+		matching.IdleTimeouts{Other: time.Minute}, newPolicy(t, "Default"),
+	)
+
+	start := int64(1000 * 60 * 60 * 24)
+	icmpSess := newTestSession(t, protocols.ICMP, start)
+	udpSess := newTestSession(t, protocols.UDP, start)
+	require.Nil(t, matcher.Insert(icmpSess))
+	require.Nil(t, matcher.Insert(udpSess))
+	matcher.(matching.IdleEvicter).AdvanceEventTime(testExporter, start+61*1000)
+
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Flush())
+
+	//the Other timeout covers ICMP, but not UDP
+	require.Len(t, sessionsOut, 1)
+	require.Equal(t, icmpSess.MatcherID, (<-sessionsOut).MatcherID)
+	require.Equal(t, 0, countAll(t, matcher, &icmpSess.AggregateQuery))
+	require.Equal(t, 1, countAll(t, matcher, &udpSess.AggregateQuery))
+}
+
+func TestRAMMatcherIdleEvictionFutureFlow(t *testing.T) {
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher := rammatch.NewRAMMatcher(
+		logging.NewTestLogger(t), sessionsOut, 10, 0.5,
+		matching.IdleTimeouts{UDP: time.Minute}, newPolicy(t, "Default"),
+	)
+	idleEvicter := matcher.(matching.IdleEvicter)
+
+	start := int64(1000 * 60 * 60 * 24)
+	sessAgg := newTestSession(t, protocols.UDP, start)
+	require.Nil(t, matcher.Insert(sessAgg))
+	idleEvicter.AdvanceEventTime(testExporter, start)
+	require.Nil(t, matcher.Flush())
+
+	//an exporter with a skewed clock sends a flow dated a year ahead
+	idleEvicter.AdvanceEventTime("4.4.4.4", start+1000*60*60*24*365)
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Flush())
+
+	//the other exporter's sessions aren't aged by the skewed clock
+	require.Len(t, sessionsOut, 0)
+	require.Equal(t, 1, countAll(t, matcher, &sessAgg.AggregateQuery))
+
+	//and still go idle by their own exporter's flows
+	idleEvicter.AdvanceEventTime(testExporter, start+61*1000)
+	require.Nil(t, matcher.Flush())
+	require.Len(t, sessionsOut, 1)
+	require.Equal(t, sessAgg.MatcherID, (<-sessionsOut).MatcherID)
+}
+
+func TestRAMMatcherIdleEvictionDisabled(t *testing.T) {
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher := rammatch.NewRAMMatcher(
+		logging.NewTestLogger(t), sessionsOut, 10, 0.5,
+		matching.IdleTimeouts{}, newPolicy(t, "Default"),
+	)
+
+	start := int64(1000 * 60 * 60 * 24)
+	require.Nil(t, matcher.Insert(newTestSession(t, protocols.UDP, start)))
+	matcher.(matching.IdleEvicter).AdvanceEventTime(testExporter, start+1000*60*60)
+
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.False(t, shouldFlush)
+}
+
+//countAll returns how many session aggregates match the query
+func countAll(t *testing.T, matcher matching.Matcher, query *session.AggregateQuery) int {
+	var count int
+	iter := matcher.Find(query)
+	var sessAgg session.Aggregate
+	for iter.Next(&sessAgg) {
+		count++
+	}
+	require.Nil(t, iter.Err())
+	return count
+}
+
+//newPolicy returns the named eviction policy
+func newPolicy(t *testing.T, name string) matching.EvictionPolicy {
+	policy, err := matching.NewEvictionPolicy(name)
+	require.Nil(t, err)
+	return policy
+}
+
+func TestRAMMatcherEvictionPolicy(t *testing.T) {
+	sessionsOut := make(chan *session.Aggregate, 10)
+	matcher := rammatch.NewRAMMatcher(
+		logging.NewTestLogger(t), sessionsOut, 2, 0.5,
+		matching.IdleTimeouts{}, newPolicy(t, "LRU"),
+	)
+
+	start := int64(1000 * 60 * 60 * 24)
+	sessA := newTestSession(t, protocols.UDP, start)
+	sessB := newTestSession(t, protocols.UDP, start)
+	sessC := newTestSession(t, protocols.UDP, start)
+	require.Nil(t, matcher.Insert(sessA))
+	require.Nil(t, matcher.Insert(sessB))
+	require.Nil(t, matcher.Insert(sessC))
+	//the oldest session is the most recently updated
+	require.Nil(t, matcher.Update(sessA))
+
+	shouldFlush, err := matcher.ShouldFlush()
+	require.Nil(t, err)
+	require.True(t, shouldFlush)
+	require.Nil(t, matcher.Flush())
+
+	require.Len(t, sessionsOut, 2)
+	require.Equal(t, sessB.MatcherID, (<-sessionsOut).MatcherID)
+	require.Equal(t, sessC.MatcherID, (<-sessionsOut).MatcherID)
+	require.Equal(t, 1, countAll(t, matcher, &sessA.AggregateQuery))
+}
@@ -0,0 +1,250 @@
+package rammatch
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/activecm/ipfix-rita/converter/logging"
+	"github.com/activecm/ipfix-rita/converter/stitching/session"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+//snapshotVersion is bumped whenever the layout of a snapshot changes
+const snapshotVersion = 1
+
+//maxSnapshotDocumentSize bounds the size of the BSON documents read from
+//a snapshot so a corrupt length can't exhaust the available memory
+const maxSnapshotDocumentSize = 16 * 1024 * 1024
+
+//snapshotHeader is the first document in a snapshot. The session
+//aggregates follow it, each stored as a separate BSON document.
+type snapshotHeader struct {
+	Version       int       `bson:"version"`
+	CreatedAt     time.Time `bson:"createdAt"`
+	InsertTracker uint64    `bson:"insertTracker"`
+	Count         uint64    `bson:"count"`
+}
+
+//SaveSnapshot writes the session aggregates held by the matcher and the
+//counter used to assign MatcherIDs to the file at path. Once the file
+//has been synced to disk, the aggregates are acknowledged and removed
+//from the matcher so Close doesn't flush them out.
+func (r *ramMatcher) SaveSnapshot(path string) error {
+	//write to a temporary file and swap it in so a crash
+	//can't leave a partially written snapshot behind
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not create snapshot file %s", tmpPath)
+	}
+
+	err = r.writeSnapshot(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "could not write snapshot file %s", tmpPath)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "could not replace snapshot file %s", path)
+	}
+
+	//the flows are safe on disk, the input may let them go
+	count := atomic.LoadUint64(&r.count)
+	r.matchMap.Range(func(aggQueryIface interface{}, aggListIface interface{}) bool {
+		aggList := aggListIface.(*list.List)
+		for iterNode := aggList.Front(); iterNode != nil; iterNode = iterNode.Next() {
+			iterNode.Value.(*ramEntry).sessAgg.Acknowledge()
+		}
+		r.matchMap.Delete(aggQueryIface)
+		return true
+	})
+	atomic.StoreUint64(&r.count, 0)
+
+	r.log.Info("saved session aggregate snapshot", logging.Fields{
+		"path":     path,
+		"sessions": count,
+	})
+	return nil
+}
+
+//writeSnapshot serializes the header and the session aggregates to w
+func (r *ramMatcher) writeSnapshot(w io.Writer) error {
+	buffer := bufio.NewWriter(w)
+
+	header, err := bson.Marshal(snapshotHeader{
+		Version:       snapshotVersion,
+		CreatedAt:     time.Now(),
+		InsertTracker: atomic.LoadUint64(&r.insertTracker),
+		Count:         atomic.LoadUint64(&r.count),
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not serialize snapshot header")
+	}
+	_, err = buffer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	r.matchMap.Range(func(_ interface{}, aggListIface interface{}) bool {
+		aggList := aggListIface.(*list.List)
+		for iterNode := aggList.Front(); iterNode != nil; iterNode = iterNode.Next() {
+			var document []byte
+			document, err = bson.Marshal(iterNode.Value.(*ramEntry).sessAgg)
+			if err != nil {
+				err = errors.Wrap(err, "could not serialize session aggregate")
+				return false
+			}
+			_, err = buffer.Write(document)
+			if err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return buffer.Flush()
+}
+
+//LoadSnapshot adds the session aggregates saved in the file at path
+//to the matcher. The aggregates keep their MatcherIDs and new
+//aggregates are assigned MatcherIDs after them. If the snapshot
+//is older than maxAge, the aggregates are written out instead
+//since they are unlikely to be stitched with new flows. The whole
+//snapshot is read before any aggregates are restored or written out,
+//so the matcher is left untouched if the snapshot is corrupt.
+func (r *ramMatcher) LoadSnapshot(path string, maxAge time.Duration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "could not open snapshot file %s", path)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var header snapshotHeader
+	err = readSnapshotDocument(reader, &header)
+	if err != nil {
+		return errors.Wrapf(err, "could not read snapshot header from %s", path)
+	}
+	if header.Version != snapshotVersion {
+		return errors.Errorf("snapshot file %s has unsupported version %d", path, header.Version)
+	}
+
+	age := time.Since(header.CreatedAt)
+	stale := age > maxAge
+
+	var sessAggs []*session.Aggregate
+	for {
+		sessAgg := new(session.Aggregate)
+		err = readSnapshotDocument(reader, sessAgg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not read session aggregate %d from %s", len(sessAggs), path)
+		}
+		//BSON has no unsigned integers, the ids come back as int64
+		id, ok := sessAgg.MatcherID.(int64)
+		if !ok {
+			return errors.Errorf("invalid MatcherID in snapshot file %s: %v", path, sessAgg.MatcherID)
+		}
+		sessAgg.MatcherID = uint64(id)
+		sessAggs = append(sessAggs, sessAgg)
+	}
+
+	loaded := uint64(len(sessAggs))
+	if loaded != header.Count {
+		return errors.Errorf("snapshot file %s holds %d session aggregates, expected %d", path, loaded, header.Count)
+	}
+
+	for _, sessAgg := range sessAggs {
+		if stale {
+			r.sessionsOut <- sessAgg
+			continue
+		}
+		r.restore(sessAgg)
+	}
+
+	//never hand out a MatcherID which is already in use, and
+	//treat updates after the restart as newer than any before it
+	if atomic.LoadUint64(&r.insertTracker) < header.InsertTracker {
+		atomic.StoreUint64(&r.insertTracker, header.InsertTracker)
+	}
+	if atomic.LoadUint64(&r.updateTracker) < header.InsertTracker {
+		atomic.StoreUint64(&r.updateTracker, header.InsertTracker)
+	}
+
+	if stale {
+		r.log.Warn("flushed stale session aggregate snapshot", logging.Fields{
+			"path":     path,
+			"age":      age.String(),
+			"sessions": loaded,
+		})
+		return nil
+	}
+	r.log.Info("loaded session aggregate snapshot", logging.Fields{
+		"path":     path,
+		"age":      age.String(),
+		"sessions": loaded,
+	})
+	return nil
+}
+
+//restore adds a session aggregate to the matcher without assigning
+//it a new MatcherID. Snapshots list the aggregates for each
+//AggregateQuery in the order they were inserted, so appending
+//keeps the lists ordered as long as the matcher started out empty.
+//Snapshots don't record when each aggregate was last updated,
+//so the insertion order stands in for it.
+func (r *ramMatcher) restore(sessAgg *session.Aggregate) {
+	entry := &ramEntry{
+		sessAgg:    sessAgg,
+		lastUpdate: sessAgg.MatcherID.(uint64),
+	}
+	newList := list.New()
+	newList.PushBack(entry)
+	existingList, loaded := r.matchMap.LoadOrStore(sessAgg.AggregateQuery, newList)
+	if loaded {
+		existingList.(*list.List).PushBack(entry)
+	}
+	atomic.AddUint64(&r.count, 1)
+}
+
+//readSnapshotDocument reads the next BSON document from a snapshot.
+//io.EOF is returned if there are no more documents.
+func readSnapshotDocument(reader *bufio.Reader, out interface{}) error {
+	lengthBytes, err := reader.Peek(4)
+	if err == io.EOF && len(lengthBytes) == 0 {
+		return io.EOF
+	}
+	if err != nil {
+		return errors.Wrap(err, "truncated snapshot document")
+	}
+	length := int(binary.LittleEndian.Uint32(lengthBytes))
+	if length < 5 || length > maxSnapshotDocumentSize {
+		return errors.Errorf("invalid snapshot document length %d", length)
+	}
+
+	document := make([]byte, length)
+	_, err = io.ReadFull(reader, document)
+	if err != nil {
+		return errors.Wrap(err, "truncated snapshot document")
+	}
+	return bson.Unmarshal(document, out)
+}
@@ -6,3 +6,32 @@ type Iterator interface {
 	Next(*Aggregate) bool
 	Err() error
 }
+
+//sliceIterator implements Iterator over a slice of Aggregates
+type sliceIterator struct {
+	data []Aggregate
+	err  error
+}
+
+//NewSliceIterator returns an Iterator over the given Aggregates.
+//The error, if any, is reported by Err once the Aggregates
+//have been iterated over.
+func NewSliceIterator(data []Aggregate, err error) Iterator {
+	return &sliceIterator{
+		data: data,
+		err:  err,
+	}
+}
+
+func (s *sliceIterator) Next(sessAgg *Aggregate) bool {
+	if len(s.data) == 0 {
+		return false
+	}
+	*sessAgg = s.data[0]
+	s.data = s.data[1:]
+	return true
+}
+
+func (s *sliceIterator) Err() error {
+	return s.err
+}
@@ -19,6 +19,9 @@ import (
 type Aggregate struct {
 	AggregateQuery `bson:",inline"`
 	MatcherID      AggregateID `bson:"_id,omitempty"`
+	//MatcherVersion is used by Matchers shared between processes
+	//to detect Aggregates which changed after they were found
+	MatcherVersion int64 `bson:"matcherVersion"`
 
 	FlowStartMillisecondsAB int64 `bson:"flowStartMillisecondsAB"`
 	FlowEndMillisecondsAB   int64 `bson:"flowEndMillisecondsAB"`
@@ -35,6 +38,30 @@ type Aggregate struct {
 
 	FilledFromSourceA bool `bson:"filledFromSourceA"`
 	FilledFromSourceB bool `bson:"filledFromSourceB"`
+
+	//Denied is set if a firewall reported blocking the session
+	Denied bool `bson:"denied"`
+
+	//The extended attributes are filled from flows which implement
+	//input.ExtendedFlow. The TCP flags seen in each direction are
+	//combined. The other attributes hold the first non-zero value seen.
+	TCPControlBitsAB uint16 `bson:"tcpControlBitsAB"`
+	TCPControlBitsBA uint16 `bson:"tcpControlBitsBA"`
+	ICMPTypeCode     uint16 `bson:"icmpTypeCode"`
+	//The interfaces traffic from A to B entered and left the exporter on
+	IngressInterfaceAB uint32 `bson:"ingressInterfaceAB"`
+	EgressInterfaceAB  uint32 `bson:"egressInterfaceAB"`
+	//The interfaces traffic from B to A entered and left the exporter on
+	IngressInterfaceBA uint32 `bson:"ingressInterfaceBA"`
+	EgressInterfaceBA  uint32 `bson:"egressInterfaceBA"`
+	VLANID             uint16 `bson:"vlanId"`
+	//The autonomous systems of IPAddressA and IPAddressB
+	ASA uint32 `bson:"asA"`
+	ASB uint32 `bson:"asB"`
+
+	//acks holds the flows merged into this aggregate which must be
+	//acknowledged once the aggregate has been written out
+	acks []input.Acknowledger
 }
 
 //AggregateID is a unique id given to Aggregates
@@ -56,8 +83,9 @@ type AggregateQuery struct {
 	Exporter string `bson:"exporter"`
 }
 
-//FromFlow fills a SessionAggregate from a Flow.
-//Note: MatcherID is unaffected by this function.
+//FromFlow fills a SessionAggregate from a Flow. Both sides of the
+//SessionAggregate are filled if the flow is a BidirectionalFlow.
+//Note: MatcherID and MatcherVersion are unaffected by this function.
 func FromFlow(flow input.Flow, sess *Aggregate) error {
 	flowSource := flow.SourceIPAddress()
 	flowDest := flow.DestinationIPAddress()
@@ -72,8 +100,27 @@ func FromFlow(flow input.Flow, sess *Aggregate) error {
 		return errors.Wrapf(err, "Could not parse flow end milliseconds")
 	}
 
+	//biflows fill both sides of the session if any
+	//traffic was sent in the reverse direction
+	var reverseStart int64
+	biflow, reverseOk := flow.(input.BidirectionalFlow)
+	reverseOk = reverseOk && biflow.IsBidirectional() &&
+		(biflow.ReversePacketTotalCount() > 0 || biflow.ReverseOctetTotalCount() > 0)
+	if reverseOk {
+		reverseStart, err = biflow.ReverseFlowStartMilliseconds()
+		if err != nil {
+			return errors.Wrapf(err, "Could not parse reverse flow start milliseconds")
+		}
+	}
+
 	sess.ProtocolIdentifier = flow.ProtocolIdentifier()
 	sess.Exporter = flow.Exporter()
+	sess.Denied = input.IsDenied(flow)
+
+	sess.acks = nil
+	if acknowledger, ok := flow.(input.Acknowledger); ok {
+		sess.acks = append(sess.acks, acknowledger)
+	}
 
 	if flowSource < flowDest {
 		//flowSource is IPAddressA
@@ -88,6 +135,28 @@ func FromFlow(flow input.Flow, sess *Aggregate) error {
 		sess.FlowEndReasonAB = flow.FlowEndReason()
 		sess.FlowEndReasonBA = input.NilEndReason
 		sess.FilledFromSourceA = true
+		if reverseOk {
+			sess.FlowStartMillisecondsBA = reverseStart
+			sess.FlowEndMillisecondsBA = flowEnd
+			sess.OctetTotalCountBA = biflow.ReverseOctetTotalCount()
+			sess.PacketTotalCountBA = biflow.ReversePacketTotalCount()
+			sess.FlowEndReasonBA = flow.FlowEndReason()
+			sess.FilledFromSourceB = true
+		}
+		if extended, ok := flow.(input.ExtendedFlow); ok {
+			sess.TCPControlBitsAB = extended.TCPControlBits()
+			sess.ICMPTypeCode = extended.ICMPTypeCode()
+			sess.IngressInterfaceAB = extended.IngressInterface()
+			sess.EgressInterfaceAB = extended.EgressInterface()
+			if reverseOk {
+				//the reverse traffic crosses the same interfaces backwards
+				sess.IngressInterfaceBA = extended.EgressInterface()
+				sess.EgressInterfaceBA = extended.IngressInterface()
+			}
+			sess.VLANID = extended.VLANID()
+			sess.ASA = extended.SourceAS()
+			sess.ASB = extended.DestinationAS()
+		}
 		return nil
 	}
 	//flowDest is IPAddressA
@@ -102,6 +171,28 @@ func FromFlow(flow input.Flow, sess *Aggregate) error {
 	sess.FlowEndReasonBA = flow.FlowEndReason()
 	sess.FlowEndReasonAB = input.NilEndReason
 	sess.FilledFromSourceB = true
+	if reverseOk {
+		sess.FlowStartMillisecondsAB = reverseStart
+		sess.FlowEndMillisecondsAB = flowEnd
+		sess.OctetTotalCountAB = biflow.ReverseOctetTotalCount()
+		sess.PacketTotalCountAB = biflow.ReversePacketTotalCount()
+		sess.FlowEndReasonAB = flow.FlowEndReason()
+		sess.FilledFromSourceA = true
+	}
+	if extended, ok := flow.(input.ExtendedFlow); ok {
+		sess.TCPControlBitsBA = extended.TCPControlBits()
+		sess.ICMPTypeCode = extended.ICMPTypeCode()
+		sess.IngressInterfaceBA = extended.IngressInterface()
+		sess.EgressInterfaceBA = extended.EgressInterface()
+		if reverseOk {
+			//the reverse traffic crosses the same interfaces backwards
+			sess.IngressInterfaceAB = extended.EgressInterface()
+			sess.EgressInterfaceAB = extended.IngressInterface()
+		}
+		sess.VLANID = extended.VLANID()
+		sess.ASA = extended.DestinationAS()
+		sess.ASB = extended.SourceAS()
+	}
 	return nil
 }
 
@@ -147,12 +238,43 @@ func (s *Aggregate) Merge(other *Aggregate) error {
 
 	s.FilledFromSourceA = s.FilledFromSourceA || other.FilledFromSourceA
 	s.FilledFromSourceB = s.FilledFromSourceB || other.FilledFromSourceB
+	s.Denied = s.Denied || other.Denied
+
+	s.TCPControlBitsAB |= other.TCPControlBitsAB
+	s.TCPControlBitsBA |= other.TCPControlBitsBA
+	s.ICMPTypeCode = firstNonZero16(s.ICMPTypeCode, other.ICMPTypeCode)
+	s.IngressInterfaceAB = firstNonZero32(s.IngressInterfaceAB, other.IngressInterfaceAB)
+	s.EgressInterfaceAB = firstNonZero32(s.EgressInterfaceAB, other.EgressInterfaceAB)
+	s.IngressInterfaceBA = firstNonZero32(s.IngressInterfaceBA, other.IngressInterfaceBA)
+	s.EgressInterfaceBA = firstNonZero32(s.EgressInterfaceBA, other.EgressInterfaceBA)
+	s.VLANID = firstNonZero16(s.VLANID, other.VLANID)
+	s.ASA = firstNonZero32(s.ASA, other.ASA)
+	s.ASB = firstNonZero32(s.ASB, other.ASB)
+
+	s.acks = append(s.acks, other.acks...)
 	return nil
 }
 
+//firstNonZero16 returns a if it is set and b otherwise
+func firstNonZero16(a, b uint16) uint16 {
+	if a != 0 {
+		return a
+	}
+	return b
+}
+
+//firstNonZero32 returns a if it is set and b otherwise
+func firstNonZero32(a, b uint32) uint32 {
+	if a != 0 {
+		return a
+	}
+	return b
+}
+
 //Clear sets an aggregate to its empty state
 func (s *Aggregate) Clear() {
 	s.MatcherID = nil
+	s.MatcherVersion = 0
 
 	s.IPAddressA = ""
 	s.PortA = 0
@@ -179,6 +301,31 @@ func (s *Aggregate) Clear() {
 
 	s.FilledFromSourceA = false
 	s.FilledFromSourceB = false
+
+	s.Denied = false
+
+	s.TCPControlBitsAB = 0
+	s.TCPControlBitsBA = 0
+	s.ICMPTypeCode = 0
+	s.IngressInterfaceAB = 0
+	s.EgressInterfaceAB = 0
+	s.IngressInterfaceBA = 0
+	s.EgressInterfaceBA = 0
+	s.VLANID = 0
+	s.ASA = 0
+	s.ASB = 0
+
+	s.acks = nil
+}
+
+//Acknowledge acknowledges each of the flows which were merged
+//into the aggregate. Acknowledge should be called once the aggregate
+//has been written out or dropped.
+func (s *Aggregate) Acknowledge() {
+	for i := range s.acks {
+		s.acks[i].Acknowledge()
+	}
+	s.acks = nil
 }
 
 //ToRITAConn fills a RITA Conn record with the data held by the session aggregate.
@@ -211,6 +358,8 @@ func (s *Aggregate) ToRITAConn(conn *parsetypes.Conn, localFunc func(string) boo
 		sessionEnd = s.FlowEndMillisecondsBA
 	}
 
+	var origFlags, respFlags uint16
+
 	//if a started sending data before b, then a is the source
 	if s.FlowStartMillisecondsAB != 0 &&
 		//AB started before BA
@@ -232,6 +381,7 @@ func (s *Aggregate) ToRITAConn(conn *parsetypes.Conn, localFunc func(string) boo
 		conn.RespPkts = int64(s.PacketTotalCountBA)
 		conn.OrigIPBytes = int64(s.OctetTotalCountAB)
 		conn.RespIPBytes = int64(s.OctetTotalCountBA)
+		origFlags, respFlags = s.TCPControlBitsAB, s.TCPControlBitsBA
 	} else {
 		//host b is source
 		sessionStart := s.FlowStartMillisecondsBA
@@ -248,6 +398,18 @@ func (s *Aggregate) ToRITAConn(conn *parsetypes.Conn, localFunc func(string) boo
 		conn.RespPkts = int64(s.PacketTotalCountAB)
 		conn.OrigIPBytes = int64(s.OctetTotalCountBA)
 		conn.RespIPBytes = int64(s.OctetTotalCountAB)
+		origFlags, respFlags = s.TCPControlBitsBA, s.TCPControlBitsAB
+	}
+
+	//only exporters which report TCP flags provide enough
+	//information to follow the connection's state
+	if s.ProtocolIdentifier == protocols.TCP && origFlags|respFlags != 0 {
+		conn.ConnState = tcpConnState(origFlags, respFlags)
+		conn.History = tcpHistory(origFlags, respFlags)
+	}
+	if s.Denied {
+		//Zeek marks connection attempts which were rejected as REJ
+		conn.ConnState = "REJ"
 	}
 }
 
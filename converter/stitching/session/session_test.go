@@ -53,6 +53,126 @@ func TestFromFlowBSource(t *testing.T) {
 	require.Equal(t, testFlow.FlowEndReason(), sess.FlowEndReasonBA)
 }
 
+func TestFromBiflow(t *testing.T) {
+	var sess session.Aggregate
+	testFlow := input.NewFlowMock()
+	testFlow.MockSourceIPAddress = "2.2.2.2"
+	testFlow.MockDestinationIPAddress = "1.1.1.1"
+	testFlow.MockBidirectional = true
+	testFlow.MockReverseFlowStartMilliseconds = testFlow.MockFlowStartMilliseconds + 5
+	testFlow.MockReverseOctetTotalCount = 300
+	testFlow.MockReversePacketTotalCount = 3
+	err := session.FromFlow(testFlow, &sess)
+	require.Nil(t, err)
+	require.True(t, sess.FilledFromSourceA)
+	require.True(t, sess.FilledFromSourceB)
+	require.Equal(t, testFlow.OctetTotalCount(), sess.OctetTotalCountBA)
+	require.Equal(t, testFlow.MockReverseOctetTotalCount, sess.OctetTotalCountAB)
+	require.Equal(t, testFlow.MockReversePacketTotalCount, sess.PacketTotalCountAB)
+	require.Equal(t, testFlow.MockReverseFlowStartMilliseconds, sess.FlowStartMillisecondsAB)
+	require.Equal(t, testFlow.MockFlowEndMilliseconds, sess.FlowEndMillisecondsAB)
+	require.Equal(t, testFlow.FlowEndReason(), sess.FlowEndReasonAB)
+
+	//the biflow's source started the connection
+	var conn parsetypes.Conn
+	sess.ToRITAConn(&conn, func(string) bool { return false })
+	require.Equal(t, "2.2.2.2", conn.Source)
+	require.Equal(t, testFlow.MockReverseOctetTotalCount, conn.RespIPBytes)
+
+	//biflows without reverse traffic only fill one side
+	sess.Clear()
+	testFlow.MockReverseOctetTotalCount = 0
+	testFlow.MockReversePacketTotalCount = 0
+	err = session.FromFlow(testFlow, &sess)
+	require.Nil(t, err)
+	require.False(t, sess.FilledFromSourceA)
+	require.True(t, sess.FilledFromSourceB)
+}
+
+func TestFromDeniedFlow(t *testing.T) {
+	var sess session.Aggregate
+	testFlow := input.NewFlowMock()
+	testFlow.MockDenied = true
+	err := session.FromFlow(testFlow, &sess)
+	require.Nil(t, err)
+	require.True(t, sess.Denied)
+
+	//merging keeps the session marked as denied
+	var sess2 session.Aggregate
+	testFlow.MockDenied = false
+	err = session.FromFlow(testFlow, &sess2)
+	require.Nil(t, err)
+	require.False(t, sess2.Denied)
+	require.Nil(t, sess2.Merge(&sess))
+	require.True(t, sess2.Denied)
+
+	var conn parsetypes.Conn
+	sess2.ToRITAConn(&conn, func(string) bool { return false })
+	require.Equal(t, "REJ", conn.ConnState)
+
+	sess2.Clear()
+	require.False(t, sess2.Denied)
+}
+
+func TestFromExtendedFlow(t *testing.T) {
+	testFlowA := input.NewFlowMock()
+	testFlowA.MockSourceIPAddress = "1.1.1.1"
+	testFlowA.MockDestinationIPAddress = "2.2.2.2"
+	testFlowA.MockTCPControlBits = 0x02
+	testFlowA.MockIngressInterface = 1
+	testFlowA.MockEgressInterface = 2
+	testFlowA.MockVLANID = 10
+	testFlowA.MockSourceAS = 100
+	testFlowA.MockDestinationAS = 200
+
+	testFlowB := input.NewFlowMock()
+	testFlowB.MockSourceIPAddress = testFlowA.MockDestinationIPAddress
+	testFlowB.MockSourcePort = testFlowA.MockDestinationPort
+	testFlowB.MockDestinationIPAddress = testFlowA.MockSourceIPAddress
+	testFlowB.MockDestinationPort = testFlowA.MockSourcePort
+	testFlowB.MockProtocolIdentifier = testFlowA.MockProtocolIdentifier
+	testFlowB.MockExporter = testFlowA.MockExporter
+	testFlowB.MockTCPControlBits = 0x12
+	testFlowB.MockIngressInterface = 2
+	testFlowB.MockEgressInterface = 1
+	testFlowB.MockSourceAS = 200
+	testFlowB.MockDestinationAS = 100
+
+	var sessA session.Aggregate
+	var sessB session.Aggregate
+	require.Nil(t, session.FromFlow(testFlowA, &sessA))
+	require.Nil(t, session.FromFlow(testFlowB, &sessB))
+	require.Equal(t, uint16(0x02), sessA.TCPControlBitsAB)
+	require.Equal(t, uint16(0x12), sessB.TCPControlBitsBA)
+	require.Equal(t, uint32(100), sessB.ASA)
+	require.Equal(t, uint32(200), sessB.ASB)
+
+	require.Nil(t, sessA.Merge(&sessB))
+	require.Equal(t, uint16(0x02), sessA.TCPControlBitsAB)
+	require.Equal(t, uint16(0x12), sessA.TCPControlBitsBA)
+	require.Equal(t, uint32(1), sessA.IngressInterfaceAB)
+	require.Equal(t, uint32(2), sessA.EgressInterfaceAB)
+	require.Equal(t, uint32(2), sessA.IngressInterfaceBA)
+	require.Equal(t, uint32(1), sessA.EgressInterfaceBA)
+	//the VLAN is filled in from whichever flow carried it
+	require.Equal(t, uint16(10), sessA.VLANID)
+	require.Equal(t, uint32(100), sessA.ASA)
+	require.Equal(t, uint32(200), sessA.ASB)
+
+	//flags seen later in the connection are combined
+	testFlowA.MockTCPControlBits = 0x11
+	sessB.Clear()
+	require.Nil(t, session.FromFlow(testFlowA, &sessB))
+	require.Nil(t, sessA.Merge(&sessB))
+	require.Equal(t, uint16(0x13), sessA.TCPControlBitsAB)
+
+	sessA.Clear()
+	require.Equal(t, uint16(0), sessA.TCPControlBitsAB)
+	require.Equal(t, uint16(0), sessA.TCPControlBitsBA)
+	require.Equal(t, uint32(0), sessA.IngressInterfaceAB)
+	require.Equal(t, uint32(0), sessA.ASA)
+}
+
 func TestClear(t *testing.T) {
 	var sess session.Aggregate
 	testFlow := input.NewFlowMock()
@@ -277,6 +397,42 @@ func TestMergeOppositeDirectionSequential(t *testing.T) {
 	require.Equal(t, testFlowB.FlowEndReason(), sessB.FlowEndReasonBA)
 }
 
+func TestAcknowledge(t *testing.T) {
+	testFlowA := input.NewAcknowledgedFlowMock()
+	testFlowB := input.NewAcknowledgedFlowMock()
+
+	testFlowA.MockSourceIPAddress = "1.1.1.1"
+	testFlowA.MockDestinationIPAddress = "2.2.2.2"
+	testFlowB.MockSourceIPAddress = "2.2.2.2"
+	testFlowB.MockDestinationIPAddress = "1.1.1.1"
+	testFlowB.MockSourcePort = testFlowA.MockDestinationPort
+	testFlowB.MockDestinationPort = testFlowA.MockSourcePort
+	testFlowB.MockProtocolIdentifier = testFlowA.MockProtocolIdentifier
+	testFlowB.MockExporter = testFlowA.MockExporter
+
+	var sessA session.Aggregate
+	var sessB session.Aggregate
+	require.Nil(t, session.FromFlow(testFlowA, &sessA))
+	require.Nil(t, session.FromFlow(testFlowB, &sessB))
+	require.Nil(t, sessA.Merge(&sessB))
+
+	//flows are acknowledged once the merged aggregate is acknowledged
+	require.Equal(t, 0, testFlowA.Acks)
+	require.Equal(t, 0, testFlowB.Acks)
+	sessA.Acknowledge()
+	require.Equal(t, 1, testFlowA.Acks)
+	require.Equal(t, 1, testFlowB.Acks)
+
+	//repeated calls don't acknowledge the flows again
+	sessA.Acknowledge()
+	require.Equal(t, 1, testFlowA.Acks)
+
+	//flows which don't need to be acknowledged are ignored
+	var sessC session.Aggregate
+	require.Nil(t, session.FromFlow(input.NewFlowMock(), &sessC))
+	sessC.Acknowledge()
+}
+
 //TODO: TestToRITASingleFlow
 
 func TestToRitaConnABSrcDest(t *testing.T) {
@@ -454,8 +610,75 @@ func TestToRITAProtos(t *testing.T) {
 	require.Equal(t, "unknown_transport", conn.Proto)
 }
 
+func TestToRITAConnState(t *testing.T) {
+	syn := protocols.TCPSYN
+	synAck := protocols.TCPSYN | protocols.TCPACK
+	ack := protocols.TCPACK
+	push := protocols.TCPPSH | protocols.TCPACK
+	fin := protocols.TCPFIN | protocols.TCPACK
+	rst := protocols.TCPRST
+
+	tests := []struct {
+		orig, resp uint16
+		connState  string
+		history    string
+	}{
+		{syn, 0, "S0", "S"},
+		{syn, rst | ack, "REJ", "Sar"},
+		{syn | rst, 0, "RSTOS0", "SR"},
+		{syn | ack, synAck, "S1", "ShA"},
+		{syn | push | fin, synAck | push | fin, "SF", "ShADadFf"},
+		{syn | push | fin, synAck | push, "S2", "ShADadF"},
+		{syn | push, synAck | fin, "S3", "ShADaf"},
+		{syn | push | rst, synAck | push, "RSTO", "ShADadR"},
+		{syn | push, synAck | rst, "RSTR", "ShADr"},
+		{0, synAck | rst, "RSTRH", "hr"},
+		{push, push, "OTH", "ADad"},
+	}
+
+	for _, test := range tests {
+		var conn parsetypes.Conn
+		sess := session.Aggregate{
+			FlowStartMillisecondsAB: 100,
+			FlowStartMillisecondsBA: 200,
+			TCPControlBitsAB:        test.orig,
+			TCPControlBitsBA:        test.resp,
+		}
+		sess.ProtocolIdentifier = protocols.TCP
+		sess.ToRITAConn(&conn, func(string) bool { return false })
+		require.Equal(t, test.connState, conn.ConnState)
+		require.Equal(t, test.history, conn.History)
+
+		//the flags follow the originator when B is the source
+		sess = session.Aggregate{
+			FlowStartMillisecondsAB: 200,
+			FlowStartMillisecondsBA: 100,
+			TCPControlBitsAB:        test.resp,
+			TCPControlBitsBA:        test.orig,
+		}
+		sess.ProtocolIdentifier = protocols.TCP
+		sess.ToRITAConn(&conn, func(string) bool { return false })
+		require.Equal(t, test.connState, conn.ConnState)
+		require.Equal(t, test.history, conn.History)
+	}
+
+	//sessions without flags are left blank
+	var conn parsetypes.Conn
+	sess := session.Aggregate{}
+	sess.ProtocolIdentifier = protocols.TCP
+	sess.ToRITAConn(&conn, func(string) bool { return false })
+	require.Equal(t, "", conn.ConnState)
+	require.Equal(t, "", conn.History)
+
+	//flags are ignored for other protocols
+	sess = session.Aggregate{TCPControlBitsAB: syn}
+	sess.ProtocolIdentifier = protocols.UDP
+	sess.ToRITAConn(&conn, func(string) bool { return false })
+	require.Equal(t, "", conn.ConnState)
+}
+
 /*
-//not needed since MongoMatch (a Matcher based on MongoDB) was removed
+//superseded by the tests in stitching/matching/mongomatch
 
 func TestMongoDBStorage(t *testing.T) {
 	//clear out the Sessions collection used by the MongoMatcher
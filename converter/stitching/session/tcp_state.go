@@ -0,0 +1,103 @@
+package session
+
+import (
+	"github.com/activecm/ipfix-rita/converter/protocols"
+)
+
+//tcpConnState derives a Zeek conn_state value from the TCP flags seen
+//from the originator and the responder of a connection. Since the flags
+//are OR'd together over each flow, the order the packets arrived in is
+//unknown and the result is a best effort.
+//See https://docs.zeek.org/en/current/scripts/base/protocols/conn/main.zeek.html
+func tcpConnState(orig, resp uint16) string {
+	origSYN := orig&protocols.TCPSYN != 0
+	respSYN := resp&protocols.TCPSYN != 0
+
+	switch {
+	case origSYN && respSYN:
+		//the connection was established
+		switch {
+		case orig&protocols.TCPRST != 0:
+			return "RSTO"
+		case resp&protocols.TCPRST != 0:
+			return "RSTR"
+		case orig&protocols.TCPFIN != 0 && resp&protocols.TCPFIN != 0:
+			return "SF"
+		case orig&protocols.TCPFIN != 0:
+			return "S2"
+		case resp&protocols.TCPFIN != 0:
+			return "S3"
+		default:
+			return "S1"
+		}
+	case origSYN:
+		//the originator tried to connect but the responder didn't accept
+		switch {
+		case resp&protocols.TCPRST != 0:
+			return "REJ"
+		case orig&protocols.TCPRST != 0:
+			return "RSTOS0"
+		case orig&protocols.TCPFIN != 0:
+			return "SH"
+		default:
+			return "S0"
+		}
+	case respSYN:
+		//the originator's SYN was not seen
+		switch {
+		case resp&protocols.TCPRST != 0:
+			return "RSTRH"
+		case resp&protocols.TCPFIN != 0:
+			return "SHR"
+		}
+	}
+	//midstream traffic
+	return "OTH"
+}
+
+//tcpHistory builds a Zeek style history string from the TCP flags seen
+//from the originator and the responder of a connection. Letters from the
+//originator are upper case and letters from the responder are lower case.
+//The letters are listed in the order they usually occur in since the
+//real order can't be recovered from the flags.
+func tcpHistory(orig, resp uint16) string {
+	history := make([]byte, 0, 10)
+	if orig&protocols.TCPSYN != 0 {
+		history = append(history, 'S')
+	}
+	if resp&protocols.TCPSYN != 0 {
+		if resp&protocols.TCPACK != 0 {
+			history = append(history, 'h')
+		} else {
+			history = append(history, 's')
+		}
+	}
+	if orig&protocols.TCPACK != 0 {
+		history = append(history, 'A')
+	}
+	if orig&protocols.TCPPSH != 0 {
+		history = append(history, 'D')
+	}
+	//a SYN-ACK already accounts for the responder's ACK flag unless
+	//the responder kept talking afterwards
+	if resp&protocols.TCPACK != 0 &&
+		(resp&protocols.TCPSYN == 0 || resp&(protocols.TCPPSH|protocols.TCPFIN) != 0) {
+		history = append(history, 'a')
+	}
+	if resp&protocols.TCPPSH != 0 {
+		history = append(history, 'd')
+	}
+	if orig&protocols.TCPFIN != 0 {
+		history = append(history, 'F')
+	}
+	if resp&protocols.TCPFIN != 0 {
+		history = append(history, 'f')
+	}
+	if orig&protocols.TCPRST != 0 {
+		history = append(history, 'R')
+	}
+	if resp&protocols.TCPRST != 0 {
+		history = append(history, 'r')
+	}
+	return string(history)
+}
@@ -24,6 +24,11 @@ var v4BroadcastIP = net.ParseIP("255.255.255.255")
 //in IPv6 multicast has completely replaced broadcast
 var _, v6MulticastNet, _ = net.ParseCIDR("FF00::/8")
 
+//maxStitchAttempts bounds how many times a flow is stitched when
+//other converters sharing the matcher keep changing the session
+//aggregates it matches
+const maxStitchAttempts = 5
+
 //stitcher is the main worker for stitching.Manager
 type stitcher struct {
 	id                   int
@@ -60,6 +65,8 @@ func (s *stitcher) run(stitcherDone *sync.WaitGroup) {
 		err := s.stitchFlow(inFlow)
 		if err != nil {
 			s.errs <- errors.Wrapf(err, "error stitching %+v", inFlow)
+			//the flow won't make it to the output
+			input.Acknowledge(inFlow)
 		}
 		s.inputDrained.Done()
 	}
@@ -88,12 +95,26 @@ func (s *stitcher) waitForFlush() {
 	s.inputDrained.Wait()
 }
 
-//stitchFlow implements the main stitching logic. The method
+//stitchFlow stitches the flow using the matcher. If another
+//converter sharing the matcher changes the session aggregate
+//the flow was matched with, the flow is stitched again.
+func (s *stitcher) stitchFlow(flow input.Flow) error {
+	var err error
+	for attempt := 0; attempt < maxStitchAttempts; attempt++ {
+		err = s.stitchFlowOnce(flow)
+		if errors.Cause(err) != matching.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+//stitchFlowOnce implements the main stitching logic. The method
 //uses the matcher as a lookup table to match flows
 //against each other. Once a flow has been matched in both
 //directions, the resulting session aggregate is sent to
 //the sessionsOut channel.
-func (s *stitcher) stitchFlow(flow input.Flow) error {
+func (s *stitcher) stitchFlowOnce(flow input.Flow) error {
 	//Create a session aggregate from the flow
 	var newSessAgg session.Aggregate
 	err := session.FromFlow(flow, &newSessAgg)
@@ -156,8 +177,9 @@ func (s *stitcher) stitchFlow(flow input.Flow) error {
 			//The merge happened on the same side of the connection
 			//The newly merged connection needs to replace the old connection in the matcher
 			//Merge doesn't carry the MatcherID through. We need to set the MatcherID
-			//so the Update method updates the right session aggregate.
+			//and MatcherVersion so the Update method updates the right session aggregate.
 			newSessAgg.MatcherID = matchAgg.MatcherID
+			newSessAgg.MatcherVersion = matchAgg.MatcherVersion
 			err := s.matcher.Update(&newSessAgg)
 			if err != nil {
 				return errors.Wrap(err, "could not update existing session aggregate")
@@ -201,6 +223,12 @@ func (s *stitcher) shouldSkipStitching(flow input.Flow) bool {
 		return true
 	}
 
+	//Biflows already describe both directions of the connection.
+	//The reverse flow will never arrive.
+	if input.IsBidirectional(flow) {
+		return true
+	}
+
 	//We only know how to stitch TCP and UDP
 	//If the protocol is something out, write it out without stitching
 	if flow.ProtocolIdentifier() != protocols.TCP && flow.ProtocolIdentifier() != protocols.UDP {
@@ -0,0 +1,3 @@
+module github.com/activecm/ipfix-rita/converter
+
+go 1.20